@@ -10,6 +10,13 @@ type Config struct {
 	Server    ServerConfig    `json:"server"`
 	Parser    ParserConfig    `json:"parser"`
 	WebSocket WebSocketConfig `json:"websocket"`
+	Auth      AuthConfig      `json:"auth"`
+	Versions  VersionsConfig  `json:"versions"`
+	Documents DocumentsConfig `json:"documents"`
+	Redis     RedisConfig     `json:"redis"`
+	LinkCheck LinkCheckConfig `json:"link_check"`
+	RateLimit RateLimitConfig `json:"rate_limit"`
+	Audit     AuditConfig     `json:"audit"`
 }
 
 // ServerConfig holds server configuration
@@ -17,22 +24,271 @@ type ServerConfig struct {
 	Port         string   `json:"port"`
 	Host         string   `json:"host"`
 	AllowOrigins []string `json:"allow_origins"`
+
+	// Time allowed on shutdown for requests and parses in flight to finish
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+
+	// How often the config file is checked for changes, whose CORS origins,
+	// rate limit, and parser settings are applied live (0 only reloads on
+	// SIGHUP)
+	ReloadIntervalSeconds int `json:"reload_interval_seconds"`
+
+	// Port of the gRPC service, served over cleartext HTTP/2 alongside the
+	// HTTP server; empty disables it
+	GRPCPort string `json:"grpc_port"`
+
+	// Proxies, as IPs or CIDRs, whose X-Forwarded-For header names the
+	// client; requests from anywhere else are known by their own address
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// HTTPS, for deployments not behind a proxy terminating TLS
+	TLS TLSConfig `json:"tls"`
+
+	// Serve net/http/pprof and runtime statistics under /admin/debug, to
+	// admins only once authentication is on
+	DebugEndpoints bool `json:"debug_endpoints"`
+}
+
+// TLSConfig holds how the server serves HTTPS: with a certificate from
+// files, or one obtained from Let's Encrypt for the configured hosts.
+// Without either, the server serves plain HTTP.
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// Hostnames to obtain certificates for, in place of CertFile and KeyFile;
+	// the certificates are kept in AutocertCacheDir across restarts
+	AutocertHosts    []string `json:"autocert_hosts,omitempty"`
+	AutocertEmail    string   `json:"autocert_email,omitempty"` // Told about problems with the certificates
+	AutocertCacheDir string   `json:"autocert_cache_dir"`
+
+	// Port of a plain HTTP server that redirects to HTTPS and answers
+	// Let's Encrypt's challenges; empty disables it
+	RedirectPort string `json:"redirect_port"`
 }
 
 // ParserConfig holds parser configuration
 type ParserConfig struct {
-	MaxContentSize int64 `json:"max_content_size"`
+	MaxContentSize int64 `json:"max_content_size"` // Bytes per document; 0 disables the limit
 	EnableGFM      bool  `json:"enable_gfm"`
 	EnableTables   bool  `json:"enable_tables"`
 	EnableAutolink bool  `json:"enable_autolink"`
+
+	// Normalize content to Unicode NFC before parsing, so text typed composed
+	// or decomposed parses to the same blocks; invalid UTF-8 is always
+	// rejected and control characters stripped
+	NormalizeNFC bool `json:"normalize_nfc"`
+
+	// Footnotes ([^1]) and definition lists (Term / : description)
+	EnableFootnotes       bool `json:"enable_footnotes"`
+	EnableDefinitionLists bool `json:"enable_definition_lists"`
+
+	// Syntax highlighting for fenced code blocks
+	HighlightTheme       string `json:"highlight_theme"`
+	HighlightLineNumbers bool   `json:"highlight_line_numbers"`
+
+	// Href template for [[Page]] wikilinks; {page} is replaced by the escaped page name
+	WikilinkHrefTemplate string `json:"wikilink_href_template"`
+
+	// ~subscript~ and ^superscript^ syntax; subscript takes single tildes from strikethrough
+	EnableSubscript   bool `json:"enable_subscript"`
+	EnableSuperscript bool `json:"enable_superscript"`
+
+	// Heading anchor IDs: strategy is "github" or "ascii"; prefix/suffix wrap every slug
+	HeadingIDStrategy string `json:"heading_id_strategy"`
+	HeadingIDPrefix   string `json:"heading_id_prefix"`
+	HeadingIDSuffix   string `json:"heading_id_suffix"`
+
+	// HTML sanitization: "none", "strict", "gfm", or "custom" (only the markup in sanitize)
+	SanitizePolicy string `json:"sanitize_policy"`
+
+	// Markup the gfm policy allows on top of its own, such as embedded
+	// videos, and all the custom policy allows
+	Sanitize SanitizeConfig `json:"sanitize"`
+
+	// Markdown dialect: "commonmark", "gfm", "notion", or empty for every extension
+	Dialect string `json:"dialect"`
+
+	// data-sourcepos="line:col-line:col" attributes on rendered blocks, for mapping preview clicks to source
+	SourcePositions bool `json:"source_positions"`
+
+	// Paragraph wrap width for canonical markdown output; 0 keeps existing line breaks
+	FormatWrapWidth int `json:"format_wrap_width"`
+
+	// LRU cache of parse results by content hash: entry count (0 disables) and lifetime (0 never expires)
+	ParseCacheSize       int `json:"parse_cache_size"`
+	ParseCacheTTLSeconds int `json:"parse_cache_ttl_seconds"`
+
+	// Parse worker pool shared by the API and WebSocket hub: workers (0 uses one per CPU) and queued jobs before rejecting
+	ParseWorkers    int `json:"parse_workers"`
+	ParseQueueDepth int `json:"parse_queue_depth"`
+
+	// Documents per batch parse request (0 disables the limit); the request body may hold this many documents of MaxContentSize
+	MaxBatchSize int `json:"max_batch_size"`
+
+	// Share parse results between instances through Redis, behind the in-process cache (needs redis.addr)
+	SharedParseCache bool `json:"shared_parse_cache"`
+
+	// Markdown lint rules for /api/lint and the lint parse option
+	Lint LintConfig `json:"lint"`
+}
+
+// LintConfig holds the markdown lint rules
+type LintConfig struct {
+	// Rules turned on or off by name: heading-increment, trailing-spaces,
+	// bare-url, duplicate-heading, missing-alt-text, line-length; rules not
+	// listed are on
+	Rules map[string]bool `json:"rules,omitempty"`
+
+	// Longest line the line-length rule accepts, in characters
+	MaxLineLength int `json:"max_line_length"`
+}
+
+// SanitizeConfig widens an HTML sanitization policy for deployments that
+// embed third-party content
+type SanitizeConfig struct {
+	// Tags allowed without attributes, such as details and summary
+	Elements []string `json:"elements,omitempty"`
+
+	// Tag → attributes allowed on it, the tag itself included; "*" for
+	// attributes allowed on every tag
+	Attributes map[string][]string `json:"attributes,omitempty"`
+
+	// URL schemes links and images may use besides the policy's own, such as tel
+	URLSchemes []string `json:"url_schemes,omitempty"`
+
+	// Hosts iframes may embed pages from over https, such as www.youtube.com;
+	// iframes are removed when empty
+	IframeHosts []string `json:"iframe_hosts,omitempty"`
 }
 
 // WebSocketConfig holds WebSocket configuration
 type WebSocketConfig struct {
+	// Event loops the hub spreads clients and documents over (0 uses one per CPU)
+	HubShards int `json:"hub_shards"`
+
 	MaxConnections    int   `json:"max_connections"`
-	MaxMessageSize    int64 `json:"max_message_size"`
+	MaxMessageSize    int64 `json:"max_message_size"` // Bytes per client message; 0 disables the limit
 	PingPeriodSeconds int   `json:"ping_period_seconds"`
 	PongWaitSeconds   int   `json:"pong_wait_seconds"`
+
+	// Window for coalescing a client's rapid parse_incremental messages; 0 parses every message
+	DebounceMillis int `json:"debounce_ms"`
+
+	// Session resume: recent changes kept per document for replay (0 always
+	// resyncs), and how long a disconnected client's session can be resumed
+	ResumeBuffer        int `json:"resume_buffer"`
+	ResumeWindowSeconds int `json:"resume_window_seconds"`
+
+	// Messages queued per client (0 uses 256), and the policy for broadcast
+	// updates beyond that: drop_oldest (the default) or close. A client that
+	// cannot keep up is closed with code 4000.
+	SendBuffer       int    `json:"send_buffer"`
+	SlowClientPolicy string `json:"slow_client_policy"`
+
+	// Lifetime of advisory block and document locks unless renewed (0 uses 30s)
+	LockTTLSeconds int `json:"lock_ttl_seconds"`
+
+	// Concurrent text editing: "ot" (the default) takes operation messages
+	// based on a revision, "crdt" takes crdt_sync messages that merge edits
+	// made offline
+	CollabMode string `json:"collab_mode"`
+
+	// Changes kept per document for undo and redo messages (0 uses 100)
+	UndoDepth int `json:"undo_depth"`
+}
+
+// VersionsConfig holds document version history configuration
+type VersionsConfig struct {
+	// How often the documents being edited are snapshotted if they changed (0 only snapshots on demand)
+	IntervalSeconds int `json:"interval_seconds"`
+
+	// Versions kept per document, dropping the oldest (0 keeps them all)
+	MaxVersions int `json:"max_versions"`
+}
+
+// DocumentsConfig holds document storage configuration
+type DocumentsConfig struct {
+	// Where documents are kept: "memory" (the default) or "redis", which
+	// shares them between instances and outlives restarts
+	Backend string `json:"backend"`
+
+	// How long a document is kept after its last change, unless it sets its
+	// own lifetime (0 keeps documents until deleted)
+	TTLSeconds int `json:"ttl_seconds"`
+
+	// Window after a document's first unsaved change in which edits made
+	// over WebSocket are saved together; 0 saves every change
+	AutosaveMillis int `json:"autosave_ms"`
+}
+
+// AuditConfig holds the audit log of operations on documents, kept in the
+// document backend
+type AuditConfig struct {
+	Enabled bool `json:"enabled"`
+
+	// Entries kept per document, dropping the oldest (0 keeps them all)
+	MaxEntries int `json:"max_entries"`
+
+	// Window in which a user's consecutive edits of a document are counted
+	// in one entry (0 records every edit)
+	MergeSeconds int `json:"merge_seconds"`
+}
+
+// RedisConfig holds the connection to Redis, used by the redis document
+// backend and the shared parse cache
+type RedisConfig struct {
+	Addr      string `json:"addr"` // host:port; empty disables Redis
+	Password  string `json:"password,omitempty"`
+	DB        int    `json:"db"`
+	KeyPrefix string `json:"key_prefix"` // Prepended to every key, so instances can share a database
+}
+
+// LinkCheckConfig holds how /api/check-links checks external URLs
+type LinkCheckConfig struct {
+	Concurrency    int `json:"concurrency"`     // URLs checked at once per request
+	TimeoutSeconds int `json:"timeout_seconds"` // Per URL, including redirects
+	MaxLinks       int `json:"max_links"`       // External URLs checked per request; the rest are skipped
+
+	// Check URLs on loopback, private, and link-local addresses, which are
+	// refused by default so documents cannot probe the server's network
+	AllowPrivate bool `json:"allow_private"`
+}
+
+// RateLimitConfig holds the per-client limit on REST requests, by IP
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `json:"requests_per_second"` // Sustained rate per client; 0 disables the limit
+	Burst             int     `json:"burst"`               // Requests a client may make at once
+}
+
+// AuthConfig holds authentication configuration for the API and WebSocket
+type AuthConfig struct {
+	// Require credentials on /api and /ws: an API key or a JWT
+	Enabled bool `json:"enabled"`
+
+	// API keys, sent in the X-API-Key header, as a bearer token, or as a WebSocket token;
+	// their usage is listed at /admin/api-keys
+	APIKeys []APIKeyConfig `json:"api_keys,omitempty"`
+
+	// HS256 secret for JWT bearer tokens, whose sub claim is the user ID and
+	// roles claim the user's roles; empty disables JWTs
+	JWTSecret string `json:"jwt_secret"`
+}
+
+// APIKeyConfig is an API key with the identity it grants
+type APIKeyConfig struct {
+	Key    string `json:"key,omitempty"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name,omitempty"`
+
+	// Hex SHA-256 of the key, in place of Key, so the config need not hold it
+	KeyHash string `json:"key_hash,omitempty"`
+
+	// Requests the key may make a minute on /api, all at once if need be (0 is unlimited)
+	RequestsPerMinute int `json:"requests_per_minute,omitempty"`
+
+	// Roles the key grants for document permissions, such as admin
+	Roles []string `json:"roles,omitempty"`
 }
 
 // DefaultConfig returns a default configuration
@@ -46,18 +302,72 @@ func DefaultConfig() *Config {
 				"http://localhost:3001",
 				"http://127.0.0.1:3000",
 			},
+			ShutdownTimeoutSeconds: 15,
+			ReloadIntervalSeconds:  10,
+			TLS: TLSConfig{
+				AutocertCacheDir: "certs",
+			},
 		},
 		Parser: ParserConfig{
-			MaxContentSize: 1024 * 1024, // 1MB
-			EnableGFM:      true,
-			EnableTables:   true,
-			EnableAutolink: true,
+			MaxContentSize:        1024 * 1024, // 1MB
+			NormalizeNFC:          true,
+			EnableGFM:             true,
+			EnableTables:          true,
+			EnableAutolink:        true,
+			EnableFootnotes:       true,
+			EnableDefinitionLists: true,
+			HighlightTheme:        "github",
+			WikilinkHrefTemplate:  "/pages/{page}",
+			EnableSubscript:       true,
+			EnableSuperscript:     true,
+			HeadingIDStrategy:     "github",
+			SanitizePolicy:        "gfm",
+			ParseCacheSize:        256,
+			ParseCacheTTLSeconds:  300,
+			ParseQueueDepth:       128,
+			MaxBatchSize:          100,
+			Lint: LintConfig{
+				MaxLineLength: 120,
+			},
 		},
 		WebSocket: WebSocketConfig{
 			MaxConnections:    1000,
 			MaxMessageSize:    512 * 1024, // 512KB
 			PingPeriodSeconds: 54,
 			PongWaitSeconds:   60,
+			DebounceMillis:    50,
+
+			ResumeBuffer:        64,
+			ResumeWindowSeconds: 120,
+
+			SendBuffer:       256,
+			SlowClientPolicy: "drop_oldest",
+
+			LockTTLSeconds: 30,
+
+			CollabMode: "ot",
+			UndoDepth:  100,
+		},
+		Versions: VersionsConfig{
+			IntervalSeconds: 300,
+			MaxVersions:     50,
+		},
+		Documents: DocumentsConfig{
+			Backend:        "memory",
+			AutosaveMillis: 1000,
+		},
+		Redis: RedisConfig{
+			KeyPrefix: "markdown-parser:",
+		},
+		Audit: AuditConfig{
+			Enabled:      true,
+			MaxEntries:   1000,
+			MergeSeconds: 60,
+		},
+		LinkCheck: LinkCheckConfig{
+			Concurrency:    8,
+			TimeoutSeconds: 10,
+			MaxLinks:       200,
 		},
 	}
 }
@@ -93,6 +403,36 @@ func LoadConfig(filepath string) (*Config, error) {
 	if len(config.Server.AllowOrigins) == 0 {
 		config.Server.AllowOrigins = defaultConfig.Server.AllowOrigins
 	}
+	if config.Server.TLS.AutocertCacheDir == "" {
+		config.Server.TLS.AutocertCacheDir = defaultConfig.Server.TLS.AutocertCacheDir
+	}
+	if config.Server.ShutdownTimeoutSeconds <= 0 {
+		config.Server.ShutdownTimeoutSeconds = defaultConfig.Server.ShutdownTimeoutSeconds
+	}
+	if config.Parser.HighlightTheme == "" {
+		config.Parser.HighlightTheme = defaultConfig.Parser.HighlightTheme
+	}
+	if config.Parser.WikilinkHrefTemplate == "" {
+		config.Parser.WikilinkHrefTemplate = defaultConfig.Parser.WikilinkHrefTemplate
+	}
+	if config.Parser.HeadingIDStrategy == "" {
+		config.Parser.HeadingIDStrategy = defaultConfig.Parser.HeadingIDStrategy
+	}
+	if config.Parser.SanitizePolicy == "" {
+		config.Parser.SanitizePolicy = defaultConfig.Parser.SanitizePolicy
+	}
+	if config.Parser.Lint.MaxLineLength <= 0 {
+		config.Parser.Lint.MaxLineLength = defaultConfig.Parser.Lint.MaxLineLength
+	}
+	if config.LinkCheck.Concurrency <= 0 {
+		config.LinkCheck.Concurrency = defaultConfig.LinkCheck.Concurrency
+	}
+	if config.LinkCheck.TimeoutSeconds <= 0 {
+		config.LinkCheck.TimeoutSeconds = defaultConfig.LinkCheck.TimeoutSeconds
+	}
+	if config.Documents.Backend == "" {
+		config.Documents.Backend = defaultConfig.Documents.Backend
+	}
 
 	return &config, nil
 }
@@ -105,4 +445,4 @@ func (c *Config) SaveConfig(filepath string) error {
 	}
 
 	return os.WriteFile(filepath, data, 0644)
-}
\ No newline at end of file
+}
@@ -0,0 +1,73 @@
+package configs
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// Validate reports, by JSON path, every setting the server would refuse,
+// misread, or quietly fall back from, such as a port out of range, an
+// unknown policy name, or the redis backend without redis.addr
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]interface{}{path}, args...)...))
+	}
+	oneOf := func(path, value string, allowed ...string) {
+		if !slices.Contains(allowed, value) {
+			invalid(path, "%q is not one of %q", value, allowed)
+		}
+	}
+	port := func(path, value string, optional bool) {
+		if value == "" && optional {
+			return
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+			invalid(path, "%q is not a port number", value)
+		}
+	}
+
+	port("server.port", c.Server.Port, false)
+	port("server.grpc_port", c.Server.GRPCPort, true)
+	port("server.tls.redirect_port", c.Server.TLS.RedirectPort, true)
+	if len(c.Server.TLS.AutocertHosts) == 0 && (c.Server.TLS.CertFile == "") != (c.Server.TLS.KeyFile == "") {
+		invalid("server.tls", "cert_file and key_file must be set together")
+	}
+	if c.Server.ReloadIntervalSeconds < 0 {
+		invalid("server.reload_interval_seconds", "%d is negative", c.Server.ReloadIntervalSeconds)
+	}
+
+	oneOf("parser.sanitize_policy", c.Parser.SanitizePolicy, "none", "strict", "gfm", "custom")
+	oneOf("parser.dialect", c.Parser.Dialect, "", "commonmark", "gfm", "notion")
+	oneOf("parser.heading_id_strategy", c.Parser.HeadingIDStrategy, "github", "ascii")
+	if c.Parser.MaxContentSize < 0 {
+		invalid("parser.max_content_size", "%d is negative", c.Parser.MaxContentSize)
+	}
+
+	oneOf("websocket.slow_client_policy", c.WebSocket.SlowClientPolicy, "", "drop_oldest", "close")
+	oneOf("websocket.collab_mode", c.WebSocket.CollabMode, "", "ot", "crdt")
+
+	oneOf("documents.backend", c.Documents.Backend, "", "memory", "redis")
+	if c.Documents.Backend == "redis" && c.Redis.Addr == "" {
+		invalid("documents.backend", "redis needs redis.addr")
+	}
+	if c.Parser.SharedParseCache && c.Redis.Addr == "" {
+		invalid("parser.shared_parse_cache", "needs redis.addr")
+	}
+
+	if c.RateLimit.RequestsPerSecond < 0 {
+		invalid("rate_limit.requests_per_second", "%g is negative", c.RateLimit.RequestsPerSecond)
+	}
+	if c.Auth.Enabled && len(c.Auth.APIKeys) == 0 && c.Auth.JWTSecret == "" {
+		invalid("auth", "enabled without api_keys or a jwt_secret, so every request is refused")
+	}
+	for i, key := range c.Auth.APIKeys {
+		if (key.Key == "") == (key.KeyHash == "") {
+			invalid("auth.api_keys["+strconv.Itoa(i)+"]", "needs exactly one of key and key_hash")
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,90 @@
+package configs
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"time"
+)
+
+// Watch reloads the configuration file at path when its modification time
+// changes, checked every interval (0 only reloads on request), or when a
+// value arrives on reload, such as SIGHUP. Each configuration that loads
+// is passed to apply; one that fails to is logged and skipped. Watch
+// returns when done is closed.
+func Watch(path string, interval time.Duration, reload <-chan os.Signal, done <-chan struct{}, apply func(*Config)) {
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	modTime := modificationTime(path)
+	for {
+		select {
+		case <-tick:
+			latest := modificationTime(path)
+			if latest.Equal(modTime) {
+				continue
+			}
+			modTime = latest
+		case <-reload:
+			modTime = modificationTime(path)
+		case <-done:
+			return
+		}
+
+		config, err := LoadConfig(path)
+		if err != nil {
+			log.Printf("WARN: Reloading %s: %v; keeping the current configuration", path, err)
+			continue
+		}
+		apply(config)
+	}
+}
+
+// modificationTime returns when a file last changed, or the zero time if
+// it cannot be read
+func modificationTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// RestartChanges lists the sections of next, by their JSON names, that
+// differ from c in settings only applied at startup, such as ports,
+// credentials, and storage
+func (c *Config) RestartChanges(next *Config) []string {
+	var changed []string
+	live := c.Server
+	live.AllowOrigins = next.Server.AllowOrigins
+	if !reflect.DeepEqual(live, next.Server) {
+		changed = append(changed, "server")
+	}
+	if c.Parser.MaxContentSize != next.Parser.MaxContentSize || c.Parser.MaxBatchSize != next.Parser.MaxBatchSize ||
+		c.Parser.ParseWorkers != next.Parser.ParseWorkers || c.Parser.ParseQueueDepth != next.Parser.ParseQueueDepth ||
+		c.Parser.SharedParseCache != next.Parser.SharedParseCache {
+		changed = append(changed, "parser")
+	}
+	sections := []struct {
+		name        string
+		old, latest interface{}
+	}{
+		{"websocket", c.WebSocket, next.WebSocket},
+		{"auth", c.Auth, next.Auth},
+		{"versions", c.Versions, next.Versions},
+		{"documents", c.Documents, next.Documents},
+		{"redis", c.Redis, next.Redis},
+		{"link_check", c.LinkCheck, next.LinkCheck},
+		{"audit", c.Audit, next.Audit},
+	}
+	for _, section := range sections {
+		if !reflect.DeepEqual(section.old, section.latest) {
+			changed = append(changed, section.name)
+		}
+	}
+	return changed
+}
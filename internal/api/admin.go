@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+)
+
+// HubStats reports the clients and documents of the WebSocket hub, such as
+// the websocket package's Hub
+type HubStats interface {
+	ConnectionStats() models.ConnectionStats
+	SubscriberStats() []models.DocumentSubscribers
+	DocumentIDs() []string
+	CacheStats() models.CacheStats
+}
+
+var hubStats HubStats
+
+// SetupAdminRoutes initializes the service statistics for operational
+// dashboards, only open to admins once authentication is on
+func SetupAdminRoutes(r *gin.Engine, config *configs.Config, hub HubStats) {
+	hubStats = hub
+
+	apiGroup(r, config).GET("/admin/stats", RequireAdmin(config), adminStats)
+}
+
+// adminStats reports the connected clients, who follows each document, the
+// documents and parse results held in memory, and the recent error rates
+func adminStats(c *gin.Context) {
+	c.JSON(http.StatusOK, models.AdminStatsResponse{
+		Connections:   hubStats.ConnectionStats(),
+		Subscribers:   hubStats.SubscriberStats(),
+		DocumentsHeld: len(hubStats.DocumentIDs()),
+		Caches: map[string]models.CacheStats{
+			"api":       CacheStats(),
+			"websocket": hubStats.CacheStats(),
+		},
+		ErrorRates: requestCounts.rates(time.Now()),
+		Success:    true,
+	})
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/audit"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/documents"
+	"markdown-parser/internal/models"
+)
+
+var auditLog *audit.Log
+
+// SetupAuditRoutes initializes the route for the audit logs of documents,
+// and records the operations of the document routes in log
+func SetupAuditRoutes(r *gin.Engine, config *configs.Config, log *audit.Log) {
+	auditLog = log
+
+	apiGroup(r, config).GET("/documents/:id/audit", documentAudit)
+}
+
+// recordAudit records an operation on a document by the request's user
+func recordAudit(c *gin.Context, documentID, action, detail string) {
+	user, _ := auth.UserFrom(c)
+	auditLog.Record(documentID, user.ID, action, detail)
+}
+
+// documentAudit lists the audit log of a document, optionally only the
+// entries of an action or user, those since a time, or the latest limit.
+// The log of a deleted document is only shown to admins once
+// authentication is on, as nobody else may read it.
+func documentAudit(c *gin.Context) {
+	documentID := c.Param("id")
+	if auditLog == nil {
+		c.JSON(http.StatusNotFound, models.AuditResponse{
+			DocumentID: documentID,
+			Success:    false,
+			Error:      "The audit log is not enabled",
+		})
+		return
+	}
+	if user, ok := auth.UserFrom(c); ok && documentStore != nil {
+		if _, err := documentStore.Get(documentID); errors.Is(err, documents.ErrUnknownDocument) && !slices.Contains(user.Roles, auth.RoleAdmin) {
+			c.JSON(http.StatusForbidden, models.AuditResponse{
+				DocumentID: documentID,
+				Success:    false,
+				Error:      "Only admins may read the audit log of a deleted document",
+			})
+			return
+		}
+	}
+
+	var since time.Time
+	if value := c.Query("since"); value != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, value); err != nil {
+			c.JSON(http.StatusBadRequest, models.AuditResponse{
+				DocumentID: documentID,
+				Success:    false,
+				Error:      "Invalid since time, use RFC 3339: " + strconv.Quote(value),
+			})
+			return
+		}
+	}
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			c.JSON(http.StatusBadRequest, models.AuditResponse{
+				DocumentID: documentID,
+				Success:    false,
+				Error:      "Invalid limit: " + strconv.Quote(value),
+			})
+			return
+		}
+	}
+
+	entries, err := auditLog.List(documentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.AuditResponse{
+			DocumentID: documentID,
+			Success:    false,
+			Error:      "Failed to read the audit log: " + err.Error(),
+		})
+		return
+	}
+	action, userID := c.Query("action"), c.Query("user")
+	entries = slices.DeleteFunc(entries, func(entry models.AuditEntry) bool {
+		latest := entry.Timestamp
+		if entry.LastAt != nil {
+			latest = *entry.LastAt
+		}
+		return (action != "" && entry.Action != action) || (userID != "" && entry.UserID != userID) ||
+			latest.Before(since)
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	c.JSON(http.StatusOK, models.AuditResponse{
+		DocumentID: documentID,
+		Entries:    entries,
+		Success:    true,
+	})
+}
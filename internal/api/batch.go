@@ -0,0 +1,68 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/internal/models"
+)
+
+// parseBatch parses several documents concurrently, returning each one's
+// result under its ID in request order. No more documents are in flight
+// than the worker pool has workers, so a batch does not fill the queue
+// other requests share; a document that still fails to get a worker, or to
+// parse, fails alone.
+func parseBatch(c *gin.Context) {
+	var req models.BatchParseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.BatchParseResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+	if maxBatchSize > 0 && len(req.Documents) > maxBatchSize {
+		c.JSON(http.StatusRequestEntityTooLarge, models.BatchParseResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Batch of %d documents exceeds the limit of %d", len(req.Documents), maxBatchSize),
+		})
+		return
+	}
+
+	results := make([]models.BatchResult, len(req.Documents))
+	slots := make(chan struct{}, parseJobs.Stats().Workers)
+	var wg sync.WaitGroup
+	for i, doc := range req.Documents {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			response, err := runParse(func() (*models.ParseResponse, error) {
+				return markdownParser.Load().ParseWithOptions(doc.Content, parseOptions(doc.ParseRequest))
+			})
+			if err != nil {
+				response = &models.ParseResponse{
+					Success: false,
+					Error:   "Failed to parse markdown: " + err.Error(),
+				}
+			}
+			results[i] = models.BatchResult{ID: doc.ID, ParseResponse: response}
+		}()
+	}
+	wg.Wait()
+
+	success := true
+	for _, result := range results {
+		success = success && result.Success
+	}
+	c.JSON(http.StatusOK, models.BatchParseResponse{
+		Results: results,
+		Success: success,
+	})
+}
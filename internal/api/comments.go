@@ -0,0 +1,146 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/comments"
+	"markdown-parser/internal/models"
+)
+
+var documentComments *comments.Store
+
+// SetupCommentRoutes initializes the routes for the comments on the blocks
+// of documents, kept in store
+func SetupCommentRoutes(r *gin.Engine, config *configs.Config, store *comments.Store) {
+	documentComments = store
+
+	documents := apiGroup(r, config).Group("/documents/:id")
+	{
+		documents.GET("/comments", listComments)
+		documents.POST("/comments", createComment)
+		documents.GET("/comments/:comment", getComment)
+		documents.PATCH("/comments/:comment", updateComment)
+		documents.DELETE("/comments/:comment", deleteComment)
+	}
+}
+
+// commentErrorStatus maps a comment store error to its HTTP status
+func commentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, comments.ErrUnknownDocument), errors.Is(err, comments.ErrUnknownComment):
+		return http.StatusNotFound
+	case errors.Is(err, comments.ErrUnknownBlock), errors.Is(err, comments.ErrEmptyBody):
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// listComments lists the comments on a document
+func listComments(c *gin.Context) {
+	documentID := c.Param("id")
+	list, err := documentComments.List(documentID)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), models.CommentsResponse{
+			DocumentID: documentID,
+			Success:    false,
+			Error:      "Unknown document: " + documentID,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.CommentsResponse{
+		DocumentID: documentID,
+		Comments:   list,
+		Success:    true,
+	})
+}
+
+// createComment adds a comment to a block of a document, by the
+// authenticated user if there is one
+func createComment(c *gin.Context) {
+	var req models.CommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.CommentResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+	author := req.Author
+	if user, ok := auth.UserFrom(c); ok {
+		author = user.ID
+	}
+
+	comment, err := documentComments.Create(c.Param("id"), author, req)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), models.CommentResponse{
+			Success: false,
+			Error:   "Failed to add comment: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, models.CommentResponse{
+		Comment: &comment,
+		Success: true,
+	})
+}
+
+// getComment returns a comment on a document
+func getComment(c *gin.Context) {
+	comment, err := documentComments.Get(c.Param("id"), c.Param("comment"))
+	if err != nil {
+		c.JSON(commentErrorStatus(err), models.CommentResponse{
+			Success: false,
+			Error:   "Unknown comment: " + c.Param("comment"),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.CommentResponse{
+		Comment: &comment,
+		Success: true,
+	})
+}
+
+// updateComment changes the body or resolution of a comment, or anchors it
+// to another block
+func updateComment(c *gin.Context) {
+	var req models.CommentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.CommentResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	comment, err := documentComments.Update(c.Param("id"), c.Param("comment"), req)
+	if err != nil {
+		c.JSON(commentErrorStatus(err), models.CommentResponse{
+			Success: false,
+			Error:   "Failed to update comment: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.CommentResponse{
+		Comment: &comment,
+		Success: true,
+	})
+}
+
+// deleteComment removes a comment from a document
+func deleteComment(c *gin.Context) {
+	if err := documentComments.Delete(c.Param("id"), c.Param("comment")); err != nil {
+		c.JSON(commentErrorStatus(err), models.CommentResponse{
+			Success: false,
+			Error:   "Unknown comment: " + c.Param("comment"),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.CommentResponse{Success: true})
+}
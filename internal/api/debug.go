@@ -0,0 +1,93 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/workpool"
+)
+
+// queueStatsTimeout bounds how long the runtime statistics wait for each
+// of the hub's event loops to report its queues
+const queueStatsTimeout = time.Second
+
+// HubInspector reports the connections and send queues of the WebSocket
+// hub, such as the websocket package's Hub
+type HubInspector interface {
+	ConnectionStats() models.ConnectionStats
+	QueueStats(ctx context.Context) []models.ShardQueueStats
+}
+
+// SetupDebugRoutes serves net/http/pprof at /debug/pprof/ and runtime
+// statistics at /debug/runtime on admin, the group behind the admin
+// credentials, when the debug endpoints are enabled. Once authentication
+// is on only admins may use them, since profiles reveal the process's
+// memory and command line.
+func SetupDebugRoutes(admin *gin.RouterGroup, config *configs.Config, hub HubInspector, jobs *workpool.Pool) {
+	if !config.Server.DebugEndpoints {
+		return
+	}
+	if !config.Auth.Enabled {
+		log.Printf("WARN: Debug endpoints are enabled without authentication; anyone can profile the server")
+	}
+
+	debug := admin.Group("/debug", RequireAdmin(config))
+	debug.GET("/pprof/", gin.WrapF(pprof.Index))
+	debug.GET("/pprof/:profile", profile)
+	debug.POST("/pprof/symbol", gin.WrapF(pprof.Symbol))
+	debug.GET("/runtime", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), queueStatsTimeout)
+		defer cancel()
+		c.JSON(http.StatusOK, runtimeStats(ctx, hub, jobs))
+	})
+}
+
+// profile serves one of net/http/pprof's profiles by name. pprof.Index
+// only finds them under /debug/pprof/ at the root, so they are looked up
+// here rather than through it.
+func profile(c *gin.Context) {
+	switch name := c.Param("profile"); name {
+	case "cmdline":
+		pprof.Cmdline(c.Writer, c.Request)
+	case "profile":
+		pprof.Profile(c.Writer, c.Request)
+	case "symbol":
+		pprof.Symbol(c.Writer, c.Request)
+	case "trace":
+		pprof.Trace(c.Writer, c.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// runtimeStats reports the goroutines, heap, and queues of the process
+func runtimeStats(ctx context.Context, hub HubInspector, jobs *workpool.Pool) models.RuntimeStats {
+	var memory runtime.MemStats
+	runtime.ReadMemStats(&memory)
+	workers := jobs.Stats()
+	return models.RuntimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		Heap: models.HeapStats{
+			AllocBytes:    memory.HeapAlloc,
+			InuseBytes:    memory.HeapInuse,
+			IdleBytes:     memory.HeapIdle,
+			ReleasedBytes: memory.HeapReleased,
+			SysBytes:      memory.HeapSys,
+			Objects:       memory.HeapObjects,
+			NextGCBytes:   memory.NextGC,
+			NumGC:         memory.NumGC,
+			PauseTotalMs:  float64(memory.PauseTotalNs) / float64(time.Millisecond),
+		},
+		Connections:        hub.ConnectionStats(),
+		Shards:             hub.QueueStats(ctx),
+		ParseQueued:        workers.Queued,
+		ParseQueueCapacity: workers.Capacity,
+	}
+}
@@ -0,0 +1,357 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/audit"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/documents"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/pkg/diff"
+)
+
+// DocumentEditor is told about documents changed through the API, such as
+// the WebSocket hub, whose subscribers see the changes
+type DocumentEditor interface {
+	UpdateDocument(documentID, content string) error
+	CloseDocument(documentID string)
+}
+
+var (
+	documentStore  *documents.Store
+	documentEditor DocumentEditor
+	maxContentSize int64
+)
+
+// SetupDocumentRoutes initializes the routes for creating, reading,
+// changing, and deleting the documents kept in store, telling editor about
+// changes
+func SetupDocumentRoutes(r *gin.Engine, config *configs.Config, store *documents.Store, editor DocumentEditor) {
+	documentStore = store
+	documentEditor = editor
+	maxContentSize = config.Parser.MaxContentSize
+
+	api := apiGroup(r, config)
+	{
+		api.GET("/documents", listDocuments)
+		api.POST("/documents", createDocument)
+		api.POST("/documents/import", importDocuments)
+		api.GET("/documents/:id", getDocument)
+		api.GET("/documents/:id/export", exportDocument)
+		api.GET("/documents/:id/outline", documentOutline)
+		api.GET("/documents/:id/blocks/:blockId", getDocumentBlock)
+		api.PATCH("/documents/:id/blocks", patchDocumentBlocks)
+		api.PUT("/documents/:id", updateDocument)
+		api.DELETE("/documents/:id", deleteDocument)
+	}
+}
+
+// documentErrorStatus maps a document store error to its HTTP status
+func documentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, documents.ErrUnknownDocument):
+		return http.StatusNotFound
+	case errors.Is(err, documents.ErrDocumentExists):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// bindDocument decodes a document request, replying with the error if it
+// is invalid or its content is larger than documents may be
+func bindDocument(c *gin.Context) (models.DocumentRequest, bool) {
+	var req models.DocumentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.DocumentResponse{
+			Success: false,
+			Error:   message,
+		})
+		return req, false
+	}
+	if req.Content != nil && maxContentSize > 0 && int64(len(*req.Content)) > maxContentSize {
+		c.JSON(http.StatusRequestEntityTooLarge, models.DocumentResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Content exceeds the limit of %d bytes", maxContentSize),
+		})
+		return req, false
+	}
+	if req.Content != nil {
+		content, err := markdownParser.Load().Clean(*req.Content)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, models.DocumentResponse{
+				Success: false,
+				Error:   err.Error(),
+			})
+			return req, false
+		}
+		req.Content = &content
+	}
+	return req, true
+}
+
+// listDocuments lists the documents kept, without their content
+func listDocuments(c *gin.Context) {
+	list, err := documentStore.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.DocumentsResponse{
+			Success: false,
+			Error:   "Failed to list documents: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.DocumentsResponse{
+		Documents: readableDocuments(c, list),
+		Success:   true,
+	})
+}
+
+// createDocument keeps a new document, owned by the user creating it
+func createDocument(c *gin.Context) {
+	req, ok := bindDocument(c)
+	if !ok {
+		return
+	}
+	if user, ok := auth.UserFrom(c); ok {
+		req.Owner = user.ID
+	}
+
+	doc, err := documentStore.Create(req)
+	if err != nil {
+		c.JSON(documentErrorStatus(err), models.DocumentResponse{
+			Success: false,
+			Error:   "Failed to create document: " + err.Error(),
+		})
+		return
+	}
+	recordAudit(c, doc.ID, audit.Create, "")
+	c.JSON(http.StatusCreated, models.DocumentResponse{
+		Document: &doc,
+		Success:  true,
+	})
+}
+
+// getDocument returns a document with its content
+func getDocument(c *gin.Context) {
+	doc, err := documentStore.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(documentErrorStatus(err), models.DocumentResponse{
+			Success: false,
+			Error:   "Failed to get document: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.DocumentResponse{
+		Document: &doc,
+		Success:  true,
+	})
+}
+
+// updateDocument changes the title or content of a document, sending
+// changed content to the document's subscribers
+func updateDocument(c *gin.Context) {
+	req, ok := bindDocument(c)
+	if !ok {
+		return
+	}
+
+	documentID := c.Param("id")
+	if user, ok := auth.UserFrom(c); ok && req.Permissions != nil {
+		if current, err := documentStore.Get(documentID); err == nil && !auth.CanManage(user, current) {
+			c.JSON(http.StatusForbidden, models.DocumentResponse{
+				Success: false,
+				Error:   "Only the document's owner and admins may change its permissions",
+			})
+			return
+		}
+	}
+	doc, err := documentStore.Update(documentID, req)
+	if err != nil {
+		c.JSON(documentErrorStatus(err), models.DocumentResponse{
+			Success: false,
+			Error:   "Failed to update document: " + err.Error(),
+		})
+		return
+	}
+	if req.Content != nil && documentEditor != nil {
+		if err := documentEditor.UpdateDocument(documentID, doc.Content); err != nil {
+			log.Printf("WARN: Document %s was saved but not sent to its subscribers: %v", documentID, err)
+		}
+	}
+	recordAudit(c, documentID, audit.Edit, "revision "+strconv.Itoa(doc.Revision))
+	c.JSON(http.StatusOK, models.DocumentResponse{
+		Document: &doc,
+		Success:  true,
+	})
+}
+
+// deleteDocument removes a document, telling its subscribers
+func deleteDocument(c *gin.Context) {
+	documentID := c.Param("id")
+	if user, ok := auth.UserFrom(c); ok {
+		if current, err := documentStore.Get(documentID); err == nil && !auth.CanManage(user, current) {
+			c.JSON(http.StatusForbidden, models.DocumentResponse{
+				Success: false,
+				Error:   "Only the document's owner and admins may delete it",
+			})
+			return
+		}
+	}
+	if err := documentStore.Delete(documentID); err != nil {
+		c.JSON(documentErrorStatus(err), models.DocumentResponse{
+			Success: false,
+			Error:   "Failed to delete document: " + err.Error(),
+		})
+		return
+	}
+	if documentEditor != nil {
+		documentEditor.CloseDocument(documentID)
+	}
+	recordAudit(c, documentID, audit.Delete, "")
+	c.JSON(http.StatusOK, models.DocumentResponse{Success: true})
+}
+
+// documentOutline returns the nested headings of a stored document
+func documentOutline(c *gin.Context) {
+	doc, err := documentStore.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(documentErrorStatus(err), models.OutlineResponse{
+			Success: false,
+			Error:   "Failed to get document: " + err.Error(),
+		})
+		return
+	}
+	recordAudit(c, doc.ID, audit.Parse, "outline")
+	writeOutline(c, doc.Content, parser.ParseOptions{})
+}
+
+// getDocumentBlock renders one block of a stored document, for clients that
+// render long documents lazily. The theme, line_numbers, sanitize, and
+// dialect query parameters override the configured rendering.
+func getDocumentBlock(c *gin.Context) {
+	doc, err := documentStore.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(documentErrorStatus(err), models.BlockResponse{
+			Success: false,
+			Error:   "Failed to get document: " + err.Error(),
+		})
+		return
+	}
+
+	opts := parser.ParseOptions{
+		HighlightTheme: c.Query("theme"),
+		SanitizePolicy: c.Query("sanitize"),
+		Dialect:        c.Query("dialect"),
+	}
+	if lineNumbers, err := strconv.ParseBool(c.Query("line_numbers")); err == nil {
+		opts.LineNumbers = &lineNumbers
+	}
+
+	var block *models.Block
+	_, err = runParse(func() (*models.ParseResponse, error) {
+		response, err := markdownParser.Load().Parse(doc.Content)
+		if err != nil {
+			return nil, err
+		}
+		if found, ok := response.Blocks[c.Param("blockId")]; ok {
+			block, err = markdownParser.Load().RenderBlock(found, opts)
+		}
+		return response, err
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.BlockResponse{
+			Success: false,
+			Error:   "Failed to render block: " + err.Error(),
+		})
+		return
+	}
+	if block == nil {
+		c.JSON(http.StatusNotFound, models.BlockResponse{
+			Success: false,
+			Error:   "Unknown block: " + c.Param("blockId"),
+		})
+		return
+	}
+
+	recordAudit(c, doc.ID, audit.Parse, "block "+block.ID)
+	c.JSON(http.StatusOK, models.BlockResponse{
+		Block:   block,
+		Success: true,
+	})
+}
+
+// patchDocumentBlocks applies structural block operations to a stored
+// document, such as converting a paragraph to a heading or reordering
+// blocks, and saves it rewritten in canonical markdown
+func patchDocumentBlocks(c *gin.Context) {
+	var req models.BlockPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.BlockPatchResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	documentID := c.Param("id")
+	doc, err := documentStore.Get(documentID)
+	if err != nil {
+		c.JSON(documentErrorStatus(err), models.BlockPatchResponse{
+			Success: false,
+			Error:   "Failed to get document: " + err.Error(),
+		})
+		return
+	}
+
+	var markdown string
+	result, err := runParse(func() (*models.ParseResponse, error) {
+		patched, err := markdownParser.Load().NewDocument(doc.Content)
+		if err != nil {
+			return nil, err
+		}
+		patched.Granularity = diff.GranularityBlock
+		response, err := patched.PatchBlocks(req.Operations)
+		markdown = patched.Content()
+		return response, err
+	})
+	if err != nil {
+		status := parseErrorStatus(err)
+		if errors.Is(err, parser.ErrBlockOperation) {
+			status = http.StatusUnprocessableEntity
+		}
+		c.JSON(status, models.BlockPatchResponse{
+			Success: false,
+			Error:   "Failed to patch blocks: " + err.Error(),
+		})
+		return
+	}
+
+	updated, err := documentStore.Update(documentID, models.DocumentRequest{Content: &markdown})
+	if err != nil {
+		c.JSON(documentErrorStatus(err), models.BlockPatchResponse{
+			Success: false,
+			Error:   "Failed to update document: " + err.Error(),
+		})
+		return
+	}
+	if documentEditor != nil {
+		if err := documentEditor.UpdateDocument(documentID, updated.Content); err != nil {
+			log.Printf("WARN: Document %s was saved but not sent to its subscribers: %v", documentID, err)
+		}
+	}
+	recordAudit(c, documentID, audit.Edit, "revision "+strconv.Itoa(updated.Revision)+", block operations")
+	c.JSON(http.StatusOK, models.BlockPatchResponse{
+		Markdown: updated.Content,
+		Changes:  result.Changes,
+		Document: &updated,
+		Success:  true,
+	})
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/internal/models"
+)
+
+// RequestIDHeader carries the ID of a request, from the client or made up
+// by the server, and is echoed on the response
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key of a request's ID
+const requestIDKey = "requestID"
+
+// errorCodes are the machine-readable codes of errors by HTTP status
+var errorCodes = map[int]string{
+	http.StatusBadRequest:            "invalid_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              "not_found",
+	http.StatusConflict:              "conflict",
+	http.StatusRequestEntityTooLarge: "payload_too_large",
+	http.StatusUnprocessableEntity:   "unprocessable",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusServiceUnavailable:    "unavailable",
+}
+
+// requestID takes the client's request ID, if it is a reasonable one, or
+// makes one up, and echoes it on the response
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 || strings.ContainsFunc(id, func(r rune) bool { return r < '!' || r > '~' }) {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// RequestIDFrom returns the ID of a request
+func RequestIDFrom(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// SetErrorCode sets the machine-readable code of the error a handler
+// replies with, in place of the one its HTTP status implies
+func SetErrorCode(c *gin.Context, code string) {
+	c.Set(errorCodeKey, code)
+}
+
+// errorCodeKey is the context key of SetErrorCode's code
+const errorCodeKey = "errorCode"
+
+// errorEnvelope replies to failed requests with the standard error
+// response. Handlers reply to errors with their own response types, whose
+// error is a message; the response is rewritten so the error becomes a
+// models.APIError and the other fields its details.
+func errorEnvelope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &envelopeWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		if w.status >= http.StatusBadRequest {
+			w.writeEnvelope(c)
+		}
+	}
+}
+
+// envelopeWriter holds back the body of error responses for errorEnvelope,
+// and passes others through
+type envelopeWriter struct {
+	gin.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *envelopeWriter) WriteHeader(code int) {
+	w.status = code
+	if code < http.StatusBadRequest {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *envelopeWriter) WriteHeaderNow() {
+	if w.status < http.StatusBadRequest {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *envelopeWriter) Write(data []byte) (int, error) {
+	if w.status >= http.StatusBadRequest {
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *envelopeWriter) WriteString(s string) (int, error) {
+	if w.status >= http.StatusBadRequest {
+		return w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *envelopeWriter) Status() int {
+	if w.status != 0 {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+func (w *envelopeWriter) Written() bool {
+	return w.status >= http.StatusBadRequest || w.ResponseWriter.Written()
+}
+
+func (w *envelopeWriter) Flush() {
+	if w.status < http.StatusBadRequest {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// writeEnvelope writes the held-back error response in the standard form
+func (w *envelopeWriter) writeEnvelope(c *gin.Context) {
+	apiError := models.APIError{
+		Code:      c.GetString(errorCodeKey),
+		RequestID: RequestIDFrom(c),
+	}
+	if apiError.Code == "" {
+		apiError.Code = errorCodes[w.status]
+		if apiError.Code == "" {
+			apiError.Code = "internal"
+		}
+	}
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.body.Bytes(), &body); err == nil {
+		apiError.Message, _ = body["error"].(string)
+		delete(body, "error")
+		delete(body, "success")
+		if len(body) > 0 {
+			apiError.Details = body
+		}
+	} else {
+		apiError.Message = strings.TrimSpace(w.body.String())
+	}
+	if apiError.Message == "" {
+		apiError.Message = http.StatusText(w.status)
+	}
+
+	data, _ := json.Marshal(models.ErrorResponse{Success: false, Error: apiError})
+	header := w.ResponseWriter.Header()
+	header.Set("Content-Type", "application/json; charset=utf-8")
+	header.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(data)
+}
+
+// deprecated marks responses from the legacy /api routes as deprecated,
+// pointing to their /api/v1 successors
+func deprecated() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		successor := "/api/v1" + strings.TrimPrefix(c.Request.URL.Path, "/api")
+		c.Header("Deprecation", "true")
+		c.Header("Link", "<"+successor+">; rel=\"successor-version\"")
+		c.Next()
+	}
+}
+
+// apiRoutes registers each route under /api/v1 and, as a deprecated alias
+// answering in the legacy form, under /api
+type apiRoutes []*gin.RouterGroup
+
+// Group returns the routes under a relative path
+func (a apiRoutes) Group(path string) apiRoutes {
+	groups := make(apiRoutes, len(a))
+	for i, group := range a {
+		groups[i] = group.Group(path)
+	}
+	return groups
+}
+
+// Handle registers a route for method
+func (a apiRoutes) Handle(method, path string, handlers ...gin.HandlerFunc) {
+	for _, group := range a {
+		group.Handle(method, path, handlers...)
+	}
+}
+
+func (a apiRoutes) GET(path string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodGet, path, handlers...)
+}
+
+func (a apiRoutes) POST(path string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodPost, path, handlers...)
+}
+
+func (a apiRoutes) PUT(path string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodPut, path, handlers...)
+}
+
+func (a apiRoutes) PATCH(path string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodPatch, path, handlers...)
+}
+
+func (a apiRoutes) DELETE(path string, handlers ...gin.HandlerFunc) {
+	a.Handle(http.MethodDelete, path, handlers...)
+}
@@ -0,0 +1,87 @@
+package api
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/internal/models"
+)
+
+// errorRateWindows are the recent spans, in minutes, that error rates are
+// reported over; the longest is how long requests are counted
+var errorRateWindows = []int{1, 5, 15}
+
+// minuteCounts are the requests answered during one minute
+type minuteCounts struct {
+	minute       int64 // Minutes since the Unix epoch
+	requests     int
+	clientErrors int
+	serverErrors int
+}
+
+// requestCounter counts the API's responses by minute, keeping as many
+// minutes as the longest error rate window
+type requestCounter struct {
+	mu      sync.Mutex
+	minutes []minuteCounts // Ring indexed by minute
+}
+
+// requestCounts counts every API route's responses
+var requestCounts = &requestCounter{
+	minutes: make([]minuteCounts, errorRateWindows[len(errorRateWindows)-1]),
+}
+
+// countRequests counts each response by its status once the handlers finish
+func countRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		requestCounts.add(c.Writer.Status(), time.Now())
+	}
+}
+
+// add counts a response with status answered at now
+func (r *requestCounter) add(status int, now time.Time) {
+	minute := now.Unix() / 60
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := &r.minutes[minute%int64(len(r.minutes))]
+	if counts.minute != minute {
+		*counts = minuteCounts{minute: minute}
+	}
+	counts.requests++
+	switch {
+	case status >= 500:
+		counts.serverErrors++
+	case status >= 400:
+		counts.clientErrors++
+	}
+}
+
+// rates reports the responses of each error rate window up to now; the
+// current minute counts toward every window, so they may run a little
+// long
+func (r *requestCounter) rates(now time.Time) []models.ErrorRate {
+	minute := now.Unix() / 60
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rates := make([]models.ErrorRate, len(errorRateWindows))
+	for i, window := range errorRateWindows {
+		rate := models.ErrorRate{WindowMinutes: window}
+		for _, counts := range r.minutes {
+			if counts.minute > minute-int64(window) && counts.minute <= minute {
+				rate.Requests += counts.requests
+				rate.ClientErrors += counts.clientErrors
+				rate.ServerErrors += counts.serverErrors
+			}
+		}
+		if rate.Requests > 0 {
+			rate.ClientErrorRate = float64(rate.ClientErrors) / float64(rate.Requests)
+			rate.ServerErrorRate = float64(rate.ServerErrors) / float64(rate.Requests)
+		}
+		rates[i] = rate
+	}
+	return rates
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+)
+
+// eventHeartbeat is how often an idle event stream gets a comment, so
+// proxies do not time it out
+const eventHeartbeat = 15 * time.Second
+
+// DocumentWatcher streams a document's updates, such as the WebSocket hub
+type DocumentWatcher interface {
+	Watch(documentID string) (<-chan models.WebSocketResponse, func())
+	Snapshot(documentID string) (*models.ParseResponse, uint64, error)
+}
+
+var documentWatcher DocumentWatcher
+
+// SetupEventRoutes initializes the Server-Sent Events route streaming the
+// updates watcher sees to read-only consumers
+func SetupEventRoutes(r *gin.Engine, config *configs.Config, watcher DocumentWatcher) {
+	documentWatcher = watcher
+
+	api := apiGroup(r, config)
+	{
+		api.GET("/documents/:id/events", streamDocumentEvents)
+	}
+}
+
+// streamDocumentEvents streams a document over Server-Sent Events: a
+// snapshot event with the whole document, then an event for each update
+// its WebSocket subscribers get, named after the update's type and with
+// its data as JSON. Document changes carry their sequence as the event ID.
+// The types query parameter limits the stream to a comma-separated list of
+// update types. The stream ends if the consumer falls behind, after which
+// it reconnects and starts over from a new snapshot.
+func streamDocumentEvents(c *gin.Context) {
+	documentID := c.Param("id")
+
+	// Watch before taking the snapshot so no update falls between them;
+	// updates already in the snapshot have a sequence no later than its own
+	events, stop := documentWatcher.Watch(documentID)
+	defer stop()
+	snapshot, sequence, err := documentWatcher.Snapshot(documentID)
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.ParseResponse{
+			Success: false,
+			Error:   "Failed to load document: " + err.Error(),
+		})
+		return
+	}
+	if snapshot == nil {
+		c.JSON(http.StatusNotFound, models.ParseResponse{
+			Success: false,
+			Error:   "Unknown document: " + documentID,
+		})
+		return
+	}
+
+	var types map[string]bool
+	if list := c.Query("types"); list != "" {
+		types = make(map[string]bool)
+		for _, name := range strings.Split(list, ",") {
+			types[strings.TrimSpace(name)] = true
+		}
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // Unbuffered through nginx
+	c.Status(http.StatusOK)
+
+	if err := writeEvent(c.Writer, "snapshot", sequence, snapshot); err != nil {
+		return
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case response, ok := <-events:
+			if !ok {
+				return
+			}
+			if types != nil && !types[response.Type] {
+				continue
+			}
+			if err := writeEvent(c.Writer, response.Type, response.Sequence, response.Data); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := io.WriteString(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-c.Request.Context().Done():
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeEvent writes one Server-Sent Event, with an ID unless sequence is 0
+func writeEvent(w io.Writer, name string, sequence uint64, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if sequence > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", sequence); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+	return err
+}
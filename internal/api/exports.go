@@ -0,0 +1,113 @@
+package api
+
+import (
+	"html"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/internal/audit"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/pkg/pdf"
+)
+
+// exportDocument returns a document as a file to download: its markdown
+// (format=md, the default), a standalone HTML page (format=html), or a PDF
+// of its text (format=pdf)
+func exportDocument(c *gin.Context) {
+	doc, err := documentStore.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(documentErrorStatus(err), models.DocumentResponse{
+			Success: false,
+			Error:   "Failed to get document: " + err.Error(),
+		})
+		return
+	}
+
+	var contentType, extension string
+	var data []byte
+	switch format := c.DefaultQuery("format", "md"); format {
+	case "md", "markdown":
+		contentType, extension = "text/markdown; charset=utf-8", ".md"
+		data = []byte(doc.Content)
+	case "html":
+		response, ok := exportParse(c, doc.Content, parser.ParseOptions{})
+		if !ok {
+			return
+		}
+		contentType, extension = "text/html; charset=utf-8", ".html"
+		data = []byte(htmlPage(documentTitle(doc), response.HTML))
+	case "pdf":
+		response, ok := exportParse(c, doc.Content, parser.ParseOptions{Format: parser.FormatText})
+		if !ok {
+			return
+		}
+		contentType, extension = "application/pdf", ".pdf"
+		data = pdf.FromText(doc.Title, response.Output)
+	default:
+		c.JSON(http.StatusBadRequest, models.DocumentResponse{
+			Success: false,
+			Error:   "Unknown export format " + format + "; use md, html, or pdf",
+		})
+		return
+	}
+
+	disposition := mime.FormatMediaType("attachment", map[string]string{
+		"filename": exportFilename(doc) + extension,
+	})
+	recordAudit(c, doc.ID, audit.Export, strings.TrimPrefix(extension, "."))
+	c.Header("Content-Disposition", disposition)
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// exportParse parses a document for export, replying with the error if it fails
+func exportParse(c *gin.Context, content string, opts parser.ParseOptions) (*models.ParseResponse, bool) {
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().ParseWithOptions(content, opts)
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.DocumentResponse{
+			Success: false,
+			Error:   "Failed to parse markdown: " + err.Error(),
+		})
+		return nil, false
+	}
+	return response, true
+}
+
+// documentTitle is a document's title, or its ID if it has none
+func documentTitle(doc models.Document) string {
+	if doc.Title != "" {
+		return doc.Title
+	}
+	return doc.ID
+}
+
+// exportFilename names an exported document after its title, keeping the
+// letters, digits, and a few separators so the name is safe on any system
+func exportFilename(doc models.Document) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_', r == '.':
+			return r
+		case unicode.IsSpace(r):
+			return '-'
+		}
+		return -1
+	}, strings.TrimSpace(doc.Title))
+	if name = strings.Trim(name, ".-"); name == "" {
+		return doc.ID
+	}
+	return name
+}
+
+// htmlPage wraps rendered markdown in a standalone HTML page
+func htmlPage(title, body string) string {
+	return "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n" +
+		"<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n" +
+		"<title>" + html.EscapeString(title) + "</title>\n</head>\n<body>\n" +
+		body + "</body>\n</html>\n"
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/internal/audit"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+)
+
+// importExtensions are the file types imported as documents
+var importExtensions = map[string]bool{
+	".md":       true,
+	".markdown": true,
+	".txt":      true,
+}
+
+// importDocuments creates a document from each uploaded markdown or text
+// file, and from each such file in uploaded zip archives, returning their
+// IDs and parse results. Files that cannot be imported are reported
+// alongside the others. Uploads of more files than a batch may hold are
+// refused before any is imported.
+func importDocuments(c *gin.Context) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.ImportResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	// Files are imported in the order of their form fields' names
+	fields := make([]string, 0, len(form.File))
+	for field := range form.File {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	if count := importCount(form); maxBatchSize > 0 && count > maxBatchSize {
+		c.JSON(http.StatusRequestEntityTooLarge, models.ImportResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Upload of %d files exceeds the limit of %d", count, maxBatchSize),
+		})
+		return
+	}
+
+	// Imported documents belong to whoever uploaded them
+	var owner string
+	if user, ok := auth.UserFrom(c); ok {
+		owner = user.ID
+	}
+
+	var files []models.ImportedFile
+	for _, field := range fields {
+		for _, header := range form.File[field] {
+			if strings.EqualFold(path.Ext(header.Filename), ".zip") {
+				files = append(files, importArchive(header, owner)...)
+			} else {
+				files = append(files, importUpload(header, owner))
+			}
+		}
+	}
+	if len(files) == 0 {
+		c.JSON(http.StatusBadRequest, models.ImportResponse{
+			Success: false,
+			Error:   "No files uploaded",
+		})
+		return
+	}
+
+	created := 0
+	for _, file := range files {
+		if file.ID != "" {
+			created++
+			recordAudit(c, file.ID, audit.Create, "imported from "+file.File)
+		}
+	}
+	if created == 0 {
+		c.JSON(http.StatusUnprocessableEntity, models.ImportResponse{
+			Files:   files,
+			Success: false,
+			Error:   "No files could be imported",
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, models.ImportResponse{
+		Files:   files,
+		Success: true,
+	})
+}
+
+// importUpload imports one uploaded file for owner
+func importUpload(header *multipart.FileHeader, owner string) models.ImportedFile {
+	if !importExtensions[strings.ToLower(path.Ext(header.Filename))] {
+		return models.ImportedFile{File: header.Filename, Error: "Unsupported file type; upload .md, .txt, or .zip files"}
+	}
+	file, err := header.Open()
+	if err != nil {
+		return models.ImportedFile{File: header.Filename, Error: "Failed to read file: " + err.Error()}
+	}
+	defer file.Close()
+	return importFile(header.Filename, file, owner)
+}
+
+// importArchive imports the markdown and text files of an uploaded zip
+// archive for owner, skipping other files
+func importArchive(header *multipart.FileHeader, owner string) []models.ImportedFile {
+	file, err := header.Open()
+	if err != nil {
+		return []models.ImportedFile{{File: header.Filename, Error: "Failed to read file: " + err.Error()}}
+	}
+	defer file.Close()
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		return []models.ImportedFile{{File: header.Filename, Error: "Invalid zip archive: " + err.Error()}}
+	}
+
+	var files []models.ImportedFile
+	for _, entry := range archive.File {
+		if !importable(entry) {
+			continue
+		}
+		name := entry.Name
+		content, err := entry.Open()
+		if err != nil {
+			files = append(files, models.ImportedFile{File: name, Error: "Failed to read file: " + err.Error()})
+			continue
+		}
+		files = append(files, importFile(name, content, owner))
+		content.Close()
+	}
+	if len(files) == 0 {
+		return []models.ImportedFile{{File: header.Filename, Error: "Archive holds no .md or .txt files"}}
+	}
+	return files
+}
+
+// importCount is the number of files an upload imports or reports on:
+// each uploaded file, or for zip archives the markdown and text files in
+// them. Only the archives' directories are read.
+func importCount(form *multipart.Form) int {
+	count := 0
+	for _, headers := range form.File {
+		for _, header := range headers {
+			count += archiveCount(header)
+		}
+	}
+	return count
+}
+
+// archiveCount is the number of files importArchive reports for an upload,
+// 1 for files that are not zip archives
+func archiveCount(header *multipart.FileHeader) int {
+	if !strings.EqualFold(path.Ext(header.Filename), ".zip") {
+		return 1
+	}
+	file, err := header.Open()
+	if err != nil {
+		return 1
+	}
+	defer file.Close()
+	archive, err := zip.NewReader(file, header.Size)
+	if err != nil {
+		return 1
+	}
+	count := 0
+	for _, entry := range archive.File {
+		if importable(entry) {
+			count++
+		}
+	}
+	return max(count, 1)
+}
+
+// importable reports whether an archive entry is imported: a markdown or
+// text file that is not hidden
+func importable(entry *zip.File) bool {
+	return !entry.FileInfo().IsDir() && !hiddenPath(entry.Name) && importExtensions[strings.ToLower(path.Ext(entry.Name))]
+}
+
+// hiddenPath reports whether a path in an archive is hidden, such as the
+// metadata macOS adds to archives it creates
+func hiddenPath(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+// importFile creates a document for owner titled after a file's name from
+// its content, reading no more than documents may hold, and parses it
+func importFile(name string, r io.Reader, owner string) models.ImportedFile {
+	imported := models.ImportedFile{File: name}
+
+	if maxContentSize > 0 {
+		r = io.LimitReader(r, maxContentSize+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		imported.Error = "Failed to read file: " + err.Error()
+		return imported
+	}
+	if maxContentSize > 0 && int64(len(data)) > maxContentSize {
+		imported.Error = fmt.Sprintf("Content exceeds the limit of %d bytes", maxContentSize)
+		return imported
+	}
+
+	content, err := markdownParser.Load().Clean(string(data))
+	if err != nil {
+		imported.Error = err.Error()
+		return imported
+	}
+	title := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	doc, err := documentStore.Create(models.DocumentRequest{Title: &title, Content: &content, Owner: owner})
+	if err != nil {
+		imported.Error = "Failed to create document: " + err.Error()
+		return imported
+	}
+	imported.ID, imported.Title = doc.ID, doc.Title
+
+	result, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().ParseWithOptions(content, parser.ParseOptions{})
+	})
+	if err != nil {
+		// The document is kept; only its preview is missing
+		imported.Error = "Failed to parse markdown: " + err.Error()
+		return imported
+	}
+	imported.Result = result
+	return imported
+}
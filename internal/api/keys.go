@@ -0,0 +1,55 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/models"
+)
+
+// Authenticator returns the authenticator of the API routes set up with a
+// configuration, or nil if authentication is disabled. Every route shares
+// it, so requests count against the same API key limits and usage.
+func Authenticator(config *configs.Config) *auth.Authenticator {
+	return sharedGuards(config).authenticator
+}
+
+// RequireAdmin refuses users without the admin role once authentication
+// is on. It goes after the authenticator's middleware.
+func RequireAdmin(config *configs.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.Auth.Enabled {
+			c.Next()
+			return
+		}
+		user, ok := auth.UserFrom(c)
+		if !ok || !slices.Contains(user.Roles, auth.RoleAdmin) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "Admin endpoints are only open to admins",
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// keyUsage returns the request counts of the API key the request was made
+// with, so clients can watch how close they are to its limit
+func keyUsage(c *gin.Context) {
+	usage, ok := auth.KeyUsageFrom(c)
+	if !ok {
+		c.JSON(http.StatusNotFound, models.APIKeyUsageResponse{
+			Success: false,
+			Error:   "Usage is only counted for requests made with an API key",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, models.APIKeyUsageResponse{
+		Usage:   &usage,
+		Success: true,
+	})
+}
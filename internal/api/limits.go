@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/ratelimit"
+)
+
+// guards are the authenticator and rate limiter shared by every API route
+// set up with a configuration
+type guards struct {
+	authenticator *auth.Authenticator // nil when authentication is disabled
+	limiter       *ratelimit.Limiter  // Allows every request when rate limiting is off
+}
+
+var (
+	guardsMu sync.Mutex
+	guardsBy = make(map[*configs.Config]*guards)
+)
+
+// sharedGuards returns the guards of a configuration's API routes
+func sharedGuards(config *configs.Config) *guards {
+	guardsMu.Lock()
+	defer guardsMu.Unlock()
+
+	g, ok := guardsBy[config]
+	if !ok {
+		g = &guards{
+			authenticator: auth.New(config.Auth),
+			limiter:       ratelimit.New(config.RateLimit.RequestsPerSecond, config.RateLimit.Burst),
+		}
+		guardsBy[config] = g
+	}
+	return g
+}
+
+// limitClients refuses requests from clients over their rate with 429 and
+// the seconds until they may try again. Clients are known by IP, as
+// forwarded by the engine's trusted proxies.
+func limitClients(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, wait := limiter.Allow(c.ClientIP(), time.Now()); !ok {
+			c.Header("Retry-After", strconv.Itoa(ratelimit.RetryAfter(wait)))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"success": false,
+				"error":   "Too many requests; try again in " + strconv.Itoa(ratelimit.RetryAfter(wait)) + " seconds",
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/internal/models"
+)
+
+// checkLinks checks every link of a document: anchors against the slugs of
+// its headings, and external URLs and images by requesting them, up to the
+// configured number per request
+func checkLinks(c *gin.Context) {
+	var req models.LinkCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.LinkCheckResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().Parse(req.Content)
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.LinkCheckResponse{
+			Success: false,
+			Error:   "Failed to parse markdown: " + err.Error(),
+		})
+		return
+	}
+
+	links := documentLinks(req.Content, response)
+	slugs := make(map[string]bool)
+	for _, block := range response.Blocks {
+		if block.Slug != "" {
+			slugs[block.Slug] = true
+		}
+	}
+
+	var external []string
+	for i := range links {
+		link := &links[i]
+		switch link.Kind {
+		case "anchor":
+			fragment, err := url.PathUnescape(strings.TrimPrefix(link.URL, "#"))
+			if err != nil {
+				fragment = strings.TrimPrefix(link.URL, "#")
+			}
+			if slugs[fragment] {
+				link.Status = "ok"
+			} else {
+				link.Status, link.Error = "broken", "No heading with the ID "+fragment
+			}
+		case "external", "image":
+			switch {
+			case req.SkipExternal:
+				link.Status, link.Error = "skipped", "External links were not checked"
+			case maxLinkChecks > 0 && len(external) >= maxLinkChecks:
+				link.Status, link.Error = "skipped", fmt.Sprintf("Only %d external links are checked per request", maxLinkChecks)
+			default:
+				external = append(external, link.URL)
+			}
+		default:
+			link.Status, link.Error = "skipped", "Only anchors and http(s) URLs are checked"
+		}
+	}
+
+	results := linkChecker.Check(c.Request.Context(), external)
+	broken := 0
+	for i := range links {
+		link := &links[i]
+		if link.Status == "" {
+			result := results[link.URL]
+			link.StatusCode = result.StatusCode
+			if result.OK() {
+				link.Status = "ok"
+			} else {
+				link.Status = "broken"
+				if result.Err != nil {
+					link.Error = result.Err.Error()
+				} else {
+					link.Error = http.StatusText(result.StatusCode)
+				}
+			}
+		}
+		if link.Status == "broken" {
+			broken++
+		}
+	}
+
+	c.JSON(http.StatusOK, models.LinkCheckResponse{
+		Links:   links,
+		Broken:  broken,
+		Success: true,
+	})
+}
+
+// documentLinks lists the links of a parsed document in source order,
+// followed by its images, classifying each by what can be checked
+func documentLinks(content string, response *models.ParseResponse) []models.LinkCheckResult {
+	var spans []models.Inline
+	for _, block := range response.Blocks {
+		for _, inline := range block.Inlines {
+			if inline.Type == "link" {
+				spans = append(spans, inline)
+			}
+		}
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Start < spans[j].Start })
+
+	links := []models.LinkCheckResult{}
+	for _, span := range spans {
+		line := 1
+		if span.Start <= len(content) {
+			line += strings.Count(content[:span.Start], "\n")
+		}
+		links = append(links, models.LinkCheckResult{
+			URL:  span.Href,
+			Text: span.Text,
+			Line: line,
+			Kind: linkKind(span.Href, "external"),
+		})
+	}
+	for _, image := range response.Images {
+		links = append(links, models.LinkCheckResult{
+			URL:  image.Src,
+			Text: image.Alt,
+			Kind: linkKind(image.Src, "image"),
+		})
+	}
+	return links
+}
+
+// linkKind classifies a link destination: an anchor within the document,
+// an http(s) URL of the given kind, or another link that is not checked
+func linkKind(href, httpKind string) string {
+	if strings.HasPrefix(href, "#") {
+		return "anchor"
+	}
+	if u, err := url.Parse(href); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+		return httpKind
+	}
+	return "other"
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/models"
+)
+
+// documentAccess refuses requests to a document's routes that the
+// authenticated user lacks permission for: reading for GET requests and
+// writing for the others. Unknown documents are left to the routes.
+func documentAccess() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		documentID := c.Param("id")
+		user, ok := auth.UserFrom(c)
+		if documentID == "" || !ok || documentStore == nil {
+			c.Next()
+			return
+		}
+		doc, err := documentStore.Get(documentID)
+		if err != nil {
+			c.Next()
+			return
+		}
+
+		permission, verb := auth.Write, "change"
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			permission, verb = auth.Read, "read"
+		}
+		if !auth.Allowed(user, doc, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"success": false,
+				"error":   "Not permitted to " + verb + " document " + documentID,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// readableDocuments leaves out of a list the documents the request's user
+// may not read
+func readableDocuments(c *gin.Context, list []models.Document) []models.Document {
+	user, ok := auth.UserFrom(c)
+	if !ok {
+		return list
+	}
+	readable := list[:0]
+	for _, doc := range list {
+		if auth.Allowed(user, doc, auth.Read) {
+			readable = append(readable, doc)
+		}
+	}
+	return readable
+}
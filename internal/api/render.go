@@ -0,0 +1,208 @@
+package api
+
+import (
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/internal/audit"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+)
+
+// pageTheme is a built-in look for rendered pages
+type pageTheme struct {
+	colors         string // CSS custom properties for the page's colors
+	highlightTheme string // Chroma style for fenced code that suits the colors
+}
+
+var pageThemes = map[string]pageTheme{
+	"light": {
+		colors:         "--bg:#ffffff;--fg:#1f2328;--muted:#59636e;--border:#d1d9e0;--link:#0969da;--code-bg:#f6f8fa;--sidebar-bg:#f6f8fa",
+		highlightTheme: "github",
+	},
+	"dark": {
+		colors:         "--bg:#0d1117;--fg:#e6edf3;--muted:#9198a1;--border:#3d444d;--link:#4493f8;--code-bg:#151b23;--sidebar-bg:#010409",
+		highlightTheme: "monokai",
+	},
+	"sepia": {
+		colors:         "--bg:#f8f1e3;--fg:#4b3a2a;--muted:#7d6b56;--border:#e0d3bb;--link:#8a4b08;--code-bg:#efe5d0;--sidebar-bg:#f1e7d3",
+		highlightTheme: "solarized-light",
+	},
+}
+
+// pageStyle lays out rendered pages; colors come from the theme
+const pageStyle = `*{box-sizing:border-box}
+body{margin:0;background:var(--bg);color:var(--fg);font:16px/1.6 -apple-system,BlinkMacSystemFont,"Segoe UI",Helvetica,Arial,sans-serif}
+a{color:var(--link)}
+.page{display:flex;align-items:flex-start;max-width:1200px;margin:0 auto}
+main{flex:1;min-width:0;max-width:860px;margin:0 auto;padding:32px 24px}
+nav.toc{position:sticky;top:0;flex:0 0 260px;max-height:100vh;overflow-y:auto;padding:32px 16px;background:var(--sidebar-bg);border-right:1px solid var(--border);font-size:14px}
+nav.toc ul{list-style:none;margin:0;padding:0}
+nav.toc li{margin:4px 0}
+nav.toc a{color:var(--muted);text-decoration:none}
+nav.toc a:hover{color:var(--link)}
+h1,h2{padding-bottom:.3em;border-bottom:1px solid var(--border)}
+img{max-width:100%}
+hr{border:0;border-top:1px solid var(--border)}
+blockquote{margin:0;padding:0 1em;color:var(--muted);border-left:4px solid var(--border)}
+table{border-collapse:collapse}
+th,td{padding:6px 13px;border:1px solid var(--border)}
+code{padding:.2em .4em;background:var(--code-bg);border-radius:6px;font:85% ui-monospace,SFMono-Regular,Menlo,Consolas,monospace}
+pre{padding:16px;overflow:auto;background:var(--code-bg);border-radius:6px;line-height:1.45}
+pre code{padding:0;background:none;font-size:85%}
+@media (max-width:800px){.page{display:block}nav.toc{position:static;max-height:none;border-right:0;border-bottom:1px solid var(--border)}}
+`
+
+// pageSecurityPolicy keeps rendered pages from running scripts or loading
+// anything but images and frames from the configured iframe hosts,
+// whatever the sanitization policy let through
+var pageSecurityPolicy atomic.Pointer[string]
+
+// setPageFrameHosts lets rendered pages frame the hosts the sanitizer
+// allows iframes from
+func setPageFrameHosts(hosts []string) {
+	policy := "default-src 'none'; style-src 'unsafe-inline'; img-src * data:"
+	if len(hosts) > 0 {
+		policy += "; frame-src https://" + strings.Join(hosts, " https://")
+	}
+	pageSecurityPolicy.Store(&policy)
+}
+
+// renderPage renders markdown as a complete HTML page, with a built-in
+// theme and optionally a table of contents, to serve as a shareable preview
+func renderPage(c *gin.Context) {
+	var req models.RenderRequest
+	var err error
+	if c.Request.Method == http.MethodGet {
+		err = c.ShouldBindQuery(&req)
+	} else {
+		err = c.ShouldBindJSON(&req)
+	}
+	if err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.ParseResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	if req.PageTheme == "" {
+		req.PageTheme = "light"
+	}
+	theme, ok := pageThemes[req.PageTheme]
+	if !ok {
+		c.JSON(http.StatusBadRequest, models.ParseResponse{
+			Success: false,
+			Error:   "Unknown page theme " + req.PageTheme + "; use light, dark, or sepia",
+		})
+		return
+	}
+
+	content, title := req.Content, req.Title
+	if req.DocumentID != "" {
+		if documentStore == nil {
+			c.JSON(http.StatusNotFound, models.ParseResponse{
+				Success: false,
+				Error:   "Documents are not available",
+			})
+			return
+		}
+		doc, err := documentStore.Get(req.DocumentID)
+		if err != nil {
+			c.JSON(documentErrorStatus(err), models.ParseResponse{
+				Success: false,
+				Error:   "Failed to get document: " + err.Error(),
+			})
+			return
+		}
+		if user, ok := auth.UserFrom(c); ok && !auth.Allowed(user, doc, auth.Read) {
+			c.JSON(http.StatusForbidden, models.ParseResponse{
+				Success: false,
+				Error:   "Not permitted to read document " + req.DocumentID,
+			})
+			return
+		}
+		content = doc.Content
+		if title == "" {
+			title = doc.Title
+		}
+	}
+
+	opts := parser.ParseOptions{
+		HighlightTheme: req.Theme,
+		LineNumbers:    req.LineNumbers,
+		SanitizePolicy: req.Sanitize,
+		Dialect:        req.Dialect,
+	}
+	if opts.HighlightTheme == "" {
+		opts.HighlightTheme = theme.highlightTheme
+	}
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().ParseWithOptions(content, opts)
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.ParseResponse{
+			Success: false,
+			Error:   "Failed to parse markdown: " + err.Error(),
+		})
+		return
+	}
+
+	headings := collectHeadings(response.Tree, nil)
+	if title == "" {
+		title = "Preview"
+		if len(headings) > 0 {
+			title = headings[0].Text
+		}
+	}
+
+	var page strings.Builder
+	page.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	page.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	page.WriteString("<title>" + html.EscapeString(title) + "</title>\n")
+	page.WriteString("<style>\n:root{" + theme.colors + "}\n" + pageStyle + "</style>\n")
+	page.WriteString("</head>\n<body>\n<div class=\"page\">\n")
+	if req.TOC && len(headings) > 0 {
+		writeTOC(&page, headings)
+	}
+	page.WriteString("<main>\n" + response.HTML + "</main>\n</div>\n</body>\n</html>\n")
+
+	if req.DocumentID != "" {
+		recordAudit(c, req.DocumentID, audit.Parse, "render")
+	}
+	c.Header("Content-Security-Policy", *pageSecurityPolicy.Load())
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page.String()))
+}
+
+// collectHeadings appends the heading blocks of tree in document order
+func collectHeadings(tree []*models.Block, headings []*models.Block) []*models.Block {
+	for _, block := range tree {
+		if parser.IsHeading(block) && block.Slug != "" {
+			headings = append(headings, block)
+		}
+		headings = collectHeadings(block.Children, headings)
+	}
+	return headings
+}
+
+// writeTOC writes a sidebar linking to each heading, indented by level
+// relative to the shallowest heading
+func writeTOC(page *strings.Builder, headings []*models.Block) {
+	top := headings[0].Level
+	for _, heading := range headings {
+		top = min(top, heading.Level)
+	}
+	page.WriteString("<nav class=\"toc\">\n<ul>\n")
+	for _, heading := range headings {
+		indent := strconv.Itoa(heading.Level - top)
+		page.WriteString("<li style=\"padding-left:" + indent + "em\"><a href=\"#" + html.EscapeString(heading.Slug) + "\">" +
+			html.EscapeString(heading.Text) + "</a></li>\n")
+	}
+	page.WriteString("</ul>\n</nav>\n")
+}
@@ -1,51 +1,545 @@
 package api
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/linkcheck"
 	"markdown-parser/internal/models"
 	"markdown-parser/internal/parser"
+	"markdown-parser/internal/workpool"
+	"markdown-parser/pkg/diff"
 )
 
-var markdownParser *parser.MarkdownParser
+var (
+	markdownParser atomic.Pointer[parser.MarkdownParser] // Replaced when the configuration is reloaded
+	sharedCache    parser.SharedCache
+	parseJobs      *workpool.Pool
+	maxBatchSize   int
+	linkChecker    *linkcheck.Checker
+	maxLinkChecks  int
+)
 
-// SetupRoutes initializes all API routes
-func SetupRoutes(r *gin.Engine) {
-	markdownParser = parser.NewMarkdownParser()
+// SetupRoutes initializes all API routes; parse work runs on the given worker pool
+func SetupRoutes(r *gin.Engine, config *configs.Config, jobs *workpool.Pool) {
+	markdownParser.Store(parser.NewMarkdownParserWithConfig(config.Parser))
+	parseJobs = jobs
+	linkChecker = linkcheck.New(config.LinkCheck)
+	maxLinkChecks = config.LinkCheck.MaxLinks
+	setPageFrameHosts(config.Parser.Sanitize.IframeHosts)
 
-	api := r.Group("/api")
+	api := apiGroup(r, config)
 	{
 		api.POST("/parse", parseMarkdown)
 		api.POST("/parse-incremental", parseIncremental)
+		api.POST("/format", formatMarkdown)
+		api.POST("/stats", documentStats)
+		api.POST("/lint", lintMarkdown)
+		api.POST("/check-links", checkLinks)
+		api.POST("/outline", outlineMarkdown)
+		api.POST("/locate", locateCaret)
+		api.GET("/usage", keyUsage)
+		api.POST("/diff", diffVersions)
+		api.POST("/merge", mergeVersions)
 		api.GET("/syntax-check/:syntax", checkSyntax)
+		api.POST("/syntax-check", checkLine)
+		api.GET("/render", renderPage)
+		api.POST("/render", renderPage)
+	}
+
+	// A batch may hold up to MaxBatchSize documents of MaxContentSize
+	maxBatchSize = config.Parser.MaxBatchSize
+	batchLimit := int64(0)
+	if maxBatchSize > 0 {
+		batchLimit = maxBodySize(config.Parser.MaxContentSize * int64(maxBatchSize))
+	}
+	limitedAPIGroup(r, config, batchLimit).POST("/parse/batch", parseBatch)
+}
+
+// SetSharedParseCache shares the API's parse results with other instances
+// through cache; it must be called after SetupRoutes
+func SetSharedParseCache(cache parser.SharedCache) {
+	sharedCache = cache
+	markdownParser.Load().SetSharedCache(cache)
+}
+
+// Reconfigure applies the settings of reloaded that are safe to change
+// live to the routes set up with config: the parser's, including its
+// sanitization policy and extensions, and the per-client rate limit.
+// Parses already running finish with the settings they started with.
+func Reconfigure(config, reloaded *configs.Config) {
+	p := parser.NewMarkdownParserWithConfig(reloaded.Parser)
+	if sharedCache != nil {
+		p.SetSharedCache(sharedCache)
 	}
+	markdownParser.Store(p)
+	setPageFrameHosts(reloaded.Parser.Sanitize.IframeHosts)
+
+	sharedGuards(config).limiter.SetRate(reloaded.RateLimit.RequestsPerSecond, reloaded.RateLimit.Burst)
+}
+
+// apiGroup returns the API's routes, behind authentication if enabled and
+// with request bodies limited to what documents may hold
+func apiGroup(r *gin.Engine, config *configs.Config) apiRoutes {
+	return limitedAPIGroup(r, config, maxBodySize(config.Parser.MaxContentSize))
+}
+
+// limitedAPIGroup returns the API's routes, under /api/v1 with the standard
+// error response and under /api as deprecated aliases, with their
+// responses counted for the error rates, behind the per-client rate limit,
+// authentication, and document permissions if enabled and with request
+// bodies limited to limit bytes (0 is unlimited)
+func limitedAPIGroup(r *gin.Engine, config *configs.Config, limit int64) apiRoutes {
+	v1 := r.Group("/api/v1", requestID(), countRequests(), errorEnvelope())
+	legacy := r.Group("/api", requestID(), countRequests(), deprecated())
+	guards := sharedGuards(config)
+	for _, api := range []*gin.RouterGroup{v1, legacy} {
+		api.Use(limitClients(guards.limiter))
+		if guards.authenticator != nil {
+			api.Use(guards.authenticator.Middleware(), documentAccess())
+		}
+		api.Use(limitRequestBody(limit))
+	}
+	return apiRoutes{v1, legacy}
+}
+
+// maxBodySize derives the request body limit from the content limit,
+// allowing for JSON escaping and the other request fields (0 means unlimited)
+func maxBodySize(maxContentSize int64) int64 {
+	if maxContentSize <= 0 {
+		return 0
+	}
+	return 2*maxContentSize + 64*1024
+}
+
+// limitRequestBody rejects request bodies larger than limit: at once with
+// 413 when their declared length is, or else while they are read, so no
+// oversized body is read into memory
+func limitRequestBody(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+				"success":    false,
+				"error":      fmt.Sprintf("Request body of %d bytes exceeds the limit of %d bytes", c.Request.ContentLength, limit),
+				"limit":      limit,
+				"body_bytes": c.Request.ContentLength,
+			})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// bindErrorStatus maps a request decoding error to its HTTP status
+func bindErrorStatus(err error) (int, string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the limit of %d bytes", tooLarge.Limit)
+	}
+	return http.StatusBadRequest, "Invalid request format: " + err.Error()
+}
+
+// parseErrorStatus maps a parser error to its HTTP status
+func parseErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, parser.ErrContentTooLarge), errors.Is(err, diff.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, parser.ErrInvalidText):
+		return http.StatusBadRequest
+	case errors.Is(err, workpool.ErrQueueFull):
+		return http.StatusTooManyRequests
+	case errors.Is(err, workpool.ErrShutdown):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// runParse runs a parse on the worker pool and returns its result
+func runParse(parse func() (*models.ParseResponse, error)) (*models.ParseResponse, error) {
+	var response *models.ParseResponse
+	err := parseJobs.Run(func() (err error) {
+		response, err = parse()
+		return err
+	})
+	return response, err
+}
+
+// CacheStats reports the parse result cache of the API's parser
+func CacheStats() models.CacheStats {
+	return markdownParser.Load().CacheStats()
 }
 
 // parseMarkdown handles bulk markdown parsing
 func parseMarkdown(c *gin.Context) {
 	var req models.ParseRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ParseResponse{
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.ParseResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	// Clients polling with unchanged content already hold the result
+	opts := parseOptions(req)
+	etag := markdownParser.Load().ETag(req.Content, opts)
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().ParseWithOptions(req.Content, opts)
+	})
+	if err != nil {
+		c.Writer.Header().Del("ETag")
+		c.JSON(parseErrorStatus(err), models.ParseResponse{
+			Success: false,
+			Error:   "Failed to parse markdown: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 asks
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOptions extracts per-request parser overrides from a parse request
+func parseOptions(req models.ParseRequest) parser.ParseOptions {
+	return parser.ParseOptions{
+		HighlightTheme:  req.Theme,
+		LineNumbers:     req.LineNumbers,
+		SanitizePolicy:  req.Sanitize,
+		Dialect:         req.Dialect,
+		Format:          req.Format,
+		WrapWidth:       req.WrapWidth,
+		SourcePositions: req.SourcePositions,
+		Stats:           req.Stats,
+		Lint:            req.Lint,
+	}
+}
+
+// documentStats counts the words, headings, links, and so on of markdown
+func documentStats(c *gin.Context) {
+	var req models.ParseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.StatsResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	opts := parseOptions(req)
+	opts.Stats = true
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().ParseWithOptions(req.Content, opts)
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.StatsResponse{
+			Success: false,
+			Error:   "Failed to parse markdown: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.StatsResponse{
+		Stats:   response.Stats,
+		Success: true,
+	})
+}
+
+// lintMarkdown checks markdown against the configured lint rules
+func lintMarkdown(c *gin.Context) {
+	var req models.ParseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.LintResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	opts := parseOptions(req)
+	opts.Lint = true
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().ParseWithOptions(req.Content, opts)
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.LintResponse{
+			Success: false,
+			Error:   "Failed to parse markdown: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.LintResponse{
+		Warnings: response.Warnings,
+		Success:  true,
+	})
+}
+
+// outlineMarkdown returns the nested headings of markdown, for navigation
+func outlineMarkdown(c *gin.Context) {
+	var req models.ParseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.OutlineResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+	writeOutline(c, req.Content, parseOptions(req))
+}
+
+// locateCaret finds the block enclosing a caret in markdown, so editors can
+// keep the caret in step with the document's blocks
+func locateCaret(c *gin.Context) {
+	var req models.LocateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.LocateResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	offset, err := caretOffset(req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.LocateResponse{
+			Success: false,
+			Error:   "Invalid caret: " + err.Error(),
+		})
+		return
+	}
+
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().Parse(req.Content)
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.LocateResponse{
 			Success: false,
-			Error:   "Invalid request format: " + err.Error(),
+			Error:   "Failed to parse markdown: " + err.Error(),
 		})
 		return
 	}
 
-	response, err := markdownParser.Parse(req.Content)
+	located := models.LocateResponse{
+		Offset:  offset,
+		Path:    []models.LocatedBlock{},
+		Success: true,
+	}
+	for _, block := range parser.Locate(response.Tree, offset) {
+		located.Path = append(located.Path, models.LocatedBlock{
+			BlockID:  block.ID,
+			Type:     block.Type,
+			Position: block.Position,
+		})
+	}
+	if n := len(located.Path); n > 0 {
+		innermost := located.Path[n-1]
+		located.BlockID, located.Type, located.Position = innermost.BlockID, innermost.Type, &innermost.Position
+	}
+	c.JSON(http.StatusOK, located)
+}
+
+// caretOffset resolves the caret of a locate request to a byte offset
+func caretOffset(req models.LocateRequest) (int, error) {
+	if req.Offset == nil {
+		if req.Line == 0 {
+			return 0, fmt.Errorf("offset or line is required")
+		}
+		if req.Column == 0 {
+			req.Column = 1
+		}
+		return parser.LineColumnOffset(req.Content, req.Line, req.Column)
+	}
+
+	switch req.Unit {
+	case "", "byte":
+		if *req.Offset < 0 || *req.Offset > len(req.Content) {
+			return 0, fmt.Errorf("offset %d is outside the content's %d bytes", *req.Offset, len(req.Content))
+		}
+		return *req.Offset, nil
+	case "character":
+		return parser.CharacterOffset(req.Content, *req.Offset)
+	}
+	return 0, fmt.Errorf("unknown unit %q; use byte or character", req.Unit)
+}
+
+// writeOutline parses content and replies with its outline
+func writeOutline(c *gin.Context, content string, opts parser.ParseOptions) {
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().ParseWithOptions(content, opts)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ParseResponse{
+		c.JSON(parseErrorStatus(err), models.OutlineResponse{
 			Success: false,
 			Error:   "Failed to parse markdown: " + err.Error(),
 		})
 		return
 	}
 
-	// Include AST if requested
-	if req.Format == "ast" {
-		// For now, we'll include block information as AST
-		response.AST = response.Blocks
+	c.JSON(http.StatusOK, models.OutlineResponse{
+		Outline: parser.Outline(response.Tree),
+		Success: true,
+	})
+}
+
+// formatMarkdown rewrites markdown in canonical form
+func formatMarkdown(c *gin.Context) {
+	var req models.ParseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.FormatResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	opts := parseOptions(req)
+	opts.Format = parser.FormatMarkdown
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().ParseWithOptions(req.Content, opts)
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.FormatResponse{
+			Success: false,
+			Error:   "Failed to format markdown: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FormatResponse{
+		Markdown: response.Output,
+		Changed:  response.Output != req.Content,
+		Success:  true,
+	})
+}
+
+// diffVersions compares two versions of a document
+func diffVersions(c *gin.Context) {
+	var req models.DiffRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.DiffResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+	if message := diffOptionsError(req); message != "" {
+		c.JSON(http.StatusBadRequest, models.DiffResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	response, err := compare(req)
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.DiffResponse{
+			Success: false,
+			Error:   "Failed to diff markdown: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// diffOptionsError describes what is wrong with the rendering and
+// granularity a diff request asks for, or returns ""
+func diffOptionsError(req models.DiffRequest) string {
+	if req.Render != "" && req.Render != diff.RenderUnified && req.Render != diff.RenderSideBySide {
+		return fmt.Sprintf("Unknown diff rendering %q, want %s or %s", req.Render, diff.RenderUnified, diff.RenderSideBySide)
+	}
+	if !diff.ValidGranularity(req.Granularity) {
+		return granularityError(req.Granularity)
+	}
+	if req.Render != "" && req.Granularity != "" && req.Granularity != diff.GranularityLine {
+		return "Rendered diffs show line changes and need line granularity"
+	}
+	return ""
+}
+
+// compare diffs the versions of a diff request on the worker pool,
+// rendering the changed lines if asked
+func compare(req models.DiffRequest) (*models.DiffResponse, error) {
+	var response *models.DiffResponse
+	err := parseJobs.Run(func() (err error) {
+		response, err = markdownParser.Load().Compare(req.OldContent, req.NewContent, parser.CompareOptions{
+			IgnoreWhitespace: req.IgnoreWhitespace,
+			Granularity:      req.Granularity,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Render != "" {
+		response.HTML, _ = diff.RenderHTML(response.Lines, req.Render)
+	}
+	return response, nil
+}
+
+// granularityError describes an unknown diff granularity
+func granularityError(granularity string) string {
+	return fmt.Sprintf("Unknown diff granularity %q, want %s, %s, or %s", granularity, diff.GranularityBlock, diff.GranularityLine, diff.GranularityWord)
+}
+
+// mergeVersions merges two edited versions of a document with their base
+func mergeVersions(c *gin.Context) {
+	var req models.MergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.MergeResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	var response *models.MergeResponse
+	err := parseJobs.Run(func() (err error) {
+		response, err = markdownParser.Load().Merge(req.Base, req.Ours, req.Theirs)
+		return err
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.MergeResponse{
+			Success: false,
+			Error:   "Failed to merge markdown: " + err.Error(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -55,25 +549,62 @@ func parseMarkdown(c *gin.Context) {
 func parseIncremental(c *gin.Context) {
 	var req models.ParseRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.ParseResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	if !diff.ValidGranularity(req.Granularity) {
 		c.JSON(http.StatusBadRequest, models.ParseResponse{
 			Success: false,
-			Error:   "Invalid request format: " + err.Error(),
+			Error:   granularityError(req.Granularity),
 		})
 		return
 	}
 
-	response, err := markdownParser.ParseIncremental(req.Content, req.BlockID)
+	// Without an edit there is nothing to reuse, so the content is parsed in
+	// full; when changes are asked for, that is an edit from an empty
+	// document, which reports every block as added
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		switch {
+		case req.Edit != nil:
+			return applyEdit(req.Content, *req.Edit, req.Granularity)
+		case req.ChangesOnly || req.Patch || req.Granularity != "":
+			return applyEdit("", models.Edit{Text: req.Content}, req.Granularity)
+		}
+		return markdownParser.Load().Parse(req.Content)
+	})
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ParseResponse{
+		c.JSON(parseErrorStatus(err), models.ParseResponse{
 			Success: false,
 			Error:   "Failed to parse markdown incrementally: " + err.Error(),
 		})
 		return
 	}
 
+	if req.ChangesOnly {
+		response = parser.ChangesOnly(response)
+	}
+	if req.Patch {
+		response = parser.PatchChanges(response)
+	}
 	c.JSON(http.StatusOK, response)
 }
 
+// applyEdit parses content and applies edit to it, reporting the changes at
+// the given granularity
+func applyEdit(content string, edit models.Edit, granularity string) (*models.ParseResponse, error) {
+	doc, err := markdownParser.Load().NewDocument(content)
+	if err != nil {
+		return nil, err
+	}
+	doc.Granularity = granularity
+	return doc.ApplyEdit(edit)
+}
+
 // checkSyntax checks if a given line matches Notion-style syntax
 func checkSyntax(c *gin.Context) {
 	syntax := c.Param("syntax")
@@ -84,11 +615,59 @@ func checkSyntax(c *gin.Context) {
 		return
 	}
 
-	detectedType := markdownParser.DetectNotionSyntax(syntax)
+	detectedType := markdownParser.Load().DetectNotionSyntax(syntax)
 	
 	c.JSON(http.StatusOK, gin.H{
 		"syntax":       syntax,
 		"detected_type": detectedType,
 		"is_block":     detectedType != "paragraph",
 	})
-}
\ No newline at end of file
+}
+
+// checkLine checks a line sent in the request body, which unlike the path
+// of checkSyntax may hold slashes, # and any whitespace, and suggests how to
+// finish the syntax before the cursor
+func checkLine(c *gin.Context) {
+	var req models.SyntaxCheckRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		status, message := bindErrorStatus(err)
+		c.JSON(status, models.SyntaxCheckResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	cursor := len(req.Line)
+	if req.Cursor != nil {
+		cursor = *req.Cursor
+	}
+	if cursor < 0 || cursor > len(req.Line) {
+		c.JSON(http.StatusBadRequest, models.SyntaxCheckResponse{
+			Success: false,
+			Error:   fmt.Sprintf("Cursor %d is outside the line (length %d)", cursor, len(req.Line)),
+		})
+		return
+	}
+
+	response, err := runParse(func() (*models.ParseResponse, error) {
+		return markdownParser.Load().Parse(req.Line)
+	})
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.SyntaxCheckResponse{
+			Success: false,
+			Error:   "Failed to parse markdown: " + err.Error(),
+		})
+		return
+	}
+
+	detectedType := markdownParser.Load().DetectNotionSyntax(req.Line)
+	c.JSON(http.StatusOK, models.SyntaxCheckResponse{
+		Line:         req.Line,
+		DetectedType: detectedType,
+		IsBlock:      detectedType != "paragraph",
+		Completion:   markdownParser.Load().SuggestCompletion(req.Line, cursor),
+		HTML:         response.HTML,
+		Success:      true,
+	})
+}
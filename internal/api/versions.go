@@ -0,0 +1,149 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/versions"
+)
+
+var documentVersions *versions.Store
+
+// SetupVersionRoutes initializes the routes for the version history of
+// documents, kept in store
+func SetupVersionRoutes(r *gin.Engine, config *configs.Config, store *versions.Store) {
+	documentVersions = store
+
+	documents := apiGroup(r, config).Group("/documents/:id")
+	{
+		documents.GET("/versions", listVersions)
+		documents.POST("/versions", snapshotVersion)
+		documents.GET("/versions/:version", getVersion)
+		documents.GET("/diff", diffDocumentVersions)
+	}
+}
+
+// listVersions lists the versions kept of a document
+func listVersions(c *gin.Context) {
+	documentID := c.Param("id")
+	list, err := documentVersions.List(documentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, models.VersionsResponse{
+			DocumentID: documentID,
+			Success:    false,
+			Error:      "Unknown document: " + documentID,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.VersionsResponse{
+		DocumentID: documentID,
+		Versions:   list,
+		Success:    true,
+	})
+}
+
+// snapshotVersion saves the current content of a document as a version
+func snapshotVersion(c *gin.Context) {
+	documentID := c.Param("id")
+	version, created, err := documentVersions.Snapshot(documentID)
+	if errors.Is(err, versions.ErrUnknownDocument) {
+		c.JSON(http.StatusNotFound, models.VersionResponse{
+			Success: false,
+			Error:   "Unknown document: " + documentID,
+		})
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, models.VersionResponse{
+		Version: &version,
+		Created: created,
+		Success: true,
+	})
+}
+
+// getVersion returns a version of a document with its content
+func getVersion(c *gin.Context) {
+	version, status, message := findVersion(c.Param("id"), c.Param("version"))
+	if status != http.StatusOK {
+		c.JSON(status, models.VersionResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.VersionResponse{
+		Version: &version,
+		Success: true,
+	})
+}
+
+// diffDocumentVersions compares the from and to versions of a document,
+// taking the options of /api/diff as query parameters
+func diffDocumentVersions(c *gin.Context) {
+	documentID := c.Param("id")
+	from, status, message := findVersion(documentID, c.Query("from"))
+	if status != http.StatusOK {
+		c.JSON(status, models.DiffResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+	to, status, message := findVersion(documentID, c.Query("to"))
+	if status != http.StatusOK {
+		c.JSON(status, models.DiffResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	req := models.DiffRequest{
+		OldContent:  from.Content,
+		NewContent:  to.Content,
+		Render:      c.Query("render"),
+		Granularity: c.Query("granularity"),
+	}
+	req.IgnoreWhitespace, _ = strconv.ParseBool(c.Query("ignore_whitespace"))
+	if message := diffOptionsError(req); message != "" {
+		c.JSON(http.StatusBadRequest, models.DiffResponse{
+			Success: false,
+			Error:   message,
+		})
+		return
+	}
+
+	response, err := compare(req)
+	if err != nil {
+		c.JSON(parseErrorStatus(err), models.DiffResponse{
+			Success: false,
+			Error:   "Failed to diff versions: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// findVersion looks up a version of a document by its number as given in a
+// request, returning the HTTP status and error message if there is none
+func findVersion(documentID, number string) (models.DocumentVersion, int, string) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return models.DocumentVersion{}, http.StatusBadRequest, "Invalid version number: " + strconv.Quote(number)
+	}
+	version, ok := documentVersions.Get(documentID, n)
+	if !ok {
+		return models.DocumentVersion{}, http.StatusNotFound, "Unknown version " + number + " of document " + documentID
+	}
+	return version, http.StatusOK, ""
+}
@@ -0,0 +1,117 @@
+package audit
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+)
+
+// Actions recorded in the audit log
+const (
+	Create = "create"
+	Parse  = "parse"
+	Edit   = "edit"
+	Delete = "delete"
+	Export = "export"
+)
+
+// Backend holds the audit logs of documents
+type Backend interface {
+	// Last returns the latest entry of a document's log, or false if it
+	// has none
+	Last(documentID string) (models.AuditEntry, bool, error)
+
+	// Append adds an entry to the end of a document's log, dropping the
+	// oldest beyond max entries unless it is 0
+	Append(entry models.AuditEntry, max int) error
+
+	// ReplaceLast replaces the latest entry of a document's log
+	ReplaceLast(entry models.AuditEntry) error
+
+	// List returns a document's log, oldest first
+	List(documentID string) ([]models.AuditEntry, error)
+}
+
+// Log records who did what to each document in a backend. Logs outlive
+// their documents, so deletions stay accountable.
+type Log struct {
+	backend    Backend
+	maxEntries int
+	merge      time.Duration
+	mu         sync.Mutex // Serializes merging edits into the latest entry
+}
+
+// NewLog creates an audit log kept in backend
+func NewLog(config configs.AuditConfig, backend Backend) *Log {
+	return &Log{
+		backend:    backend,
+		maxEntries: config.MaxEntries,
+		merge:      time.Duration(config.MergeSeconds) * time.Second,
+	}
+}
+
+// Record adds an operation by a user to a document's log, counting it in
+// the latest entry if it continues the same user's edits. Failures are
+// logged rather than returned, so they never fail the operation itself; a
+// nil log records nothing.
+func (l *Log) Record(documentID, userID, action, detail string) {
+	if l == nil || documentID == "" {
+		return
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if action == Edit && l.merge > 0 {
+		last, ok, err := l.backend.Last(documentID)
+		if err != nil {
+			log.Printf("WARN: Reading the audit log of document %s: %v", documentID, err)
+		} else if ok && l.continues(last, userID, detail, now) {
+			last.Count++
+			last.LastAt = &now
+			if err := l.backend.ReplaceLast(last); err != nil {
+				log.Printf("WARN: Recording %s of document %s in the audit log: %v", action, documentID, err)
+			}
+			return
+		}
+	}
+
+	entry := models.AuditEntry{
+		DocumentID: documentID,
+		Action:     action,
+		UserID:     userID,
+		Detail:     detail,
+		Count:      1,
+		Timestamp:  now,
+	}
+	if err := l.backend.Append(entry, l.maxEntries); err != nil {
+		log.Printf("WARN: Recording %s of document %s in the audit log: %v", action, documentID, err)
+	}
+}
+
+// continues reports whether an edit at now by a user continues the edits
+// counted in an entry
+func (l *Log) continues(entry models.AuditEntry, userID, detail string, now time.Time) bool {
+	latest := entry.Timestamp
+	if entry.LastAt != nil {
+		latest = *entry.LastAt
+	}
+	return entry.Action == Edit && entry.UserID == userID && entry.Detail == detail && now.Sub(latest) <= l.merge
+}
+
+// List returns a document's log, oldest first; documents never operated on
+// have an empty log
+func (l *Log) List(documentID string) ([]models.AuditEntry, error) {
+	entries, err := l.backend.List(documentID)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+	return entries, nil
+}
@@ -0,0 +1,120 @@
+package audit
+
+import (
+	"encoding/json"
+	"sync"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/redis"
+)
+
+// memoryBackend keeps audit logs in process memory
+type memoryBackend struct {
+	logs map[string][]models.AuditEntry
+	mu   sync.Mutex
+}
+
+// NewMemoryBackend creates a backend keeping audit logs in memory
+func NewMemoryBackend() Backend {
+	return &memoryBackend{logs: make(map[string][]models.AuditEntry)}
+}
+
+func (b *memoryBackend) Last(documentID string) (models.AuditEntry, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := b.logs[documentID]
+	if len(entries) == 0 {
+		return models.AuditEntry{}, false, nil
+	}
+	return entries[len(entries)-1], true, nil
+}
+
+func (b *memoryBackend) Append(entry models.AuditEntry, max int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entries := append(b.logs[entry.DocumentID], entry)
+	if max > 0 && len(entries) > max {
+		entries = append([]models.AuditEntry(nil), entries[len(entries)-max:]...)
+	}
+	b.logs[entry.DocumentID] = entries
+	return nil
+}
+
+func (b *memoryBackend) ReplaceLast(entry models.AuditEntry) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if entries := b.logs[entry.DocumentID]; len(entries) > 0 {
+		entries[len(entries)-1] = entry
+	}
+	return nil
+}
+
+func (b *memoryBackend) List(documentID string) ([]models.AuditEntry, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]models.AuditEntry(nil), b.logs[documentID]...), nil
+}
+
+// redisBackend keeps audit logs in Redis as lists of JSON entries, so
+// instances share them and they outlive restarts
+type redisBackend struct {
+	client *redis.Client
+}
+
+// NewRedisBackend creates a backend keeping audit logs in Redis
+func NewRedisBackend(client *redis.Client) Backend {
+	return &redisBackend{client: client}
+}
+
+// key is the Redis key of a document's audit log
+func (b *redisBackend) key(documentID string) string {
+	return b.client.Key("audit:", documentID)
+}
+
+func (b *redisBackend) Last(documentID string) (models.AuditEntry, bool, error) {
+	data, ok, err := b.client.Last(b.key(documentID))
+	if err != nil || !ok {
+		return models.AuditEntry{}, false, err
+	}
+	var entry models.AuditEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return models.AuditEntry{}, false, err
+	}
+	return entry, true, nil
+}
+
+func (b *redisBackend) Append(entry models.AuditEntry, max int) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return b.client.Append(b.key(entry.DocumentID), data, max)
+}
+
+func (b *redisBackend) ReplaceLast(entry models.AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return b.client.SetLast(b.key(entry.DocumentID), data)
+}
+
+func (b *redisBackend) List(documentID string) ([]models.AuditEntry, error) {
+	values, err := b.client.List(b.key(documentID))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]models.AuditEntry, 0, len(values))
+	for _, data := range values {
+		var entry models.AuditEntry
+		if err := json.Unmarshal(data, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/ratelimit"
+)
+
+// ErrUnauthorized is returned for missing, unknown, or invalid credentials
+var ErrUnauthorized = errors.New("unauthorized")
+
+// ErrRateLimited is returned by Admit for API keys over their rate limit
+var ErrRateLimited = errors.New("API key rate limit exceeded")
+
+// APIKeyHeader carries an API key on REST requests
+const APIKeyHeader = "X-API-Key"
+
+// Gin context keys of the authenticated identity and the API key it used
+const (
+	userKey   = "auth.user"
+	apiKeyKey = "auth.key"
+)
+
+// Authenticator validates API keys and HS256-signed JWTs, limiting and
+// counting the requests made with each key
+type Authenticator struct {
+	keys      []*apiKey
+	jwtSecret []byte
+}
+
+// apiKey is a configured API key with its rate limit and usage
+type apiKey struct {
+	config configs.APIKeyConfig
+	hash   []byte            // SHA-256 of the key, from KeyHash or Key
+	limit  *ratelimit.Bucket // nil if unlimited
+
+	mu          sync.Mutex
+	requests    uint64
+	rateLimited uint64
+	lastUsed    time.Time
+}
+
+// New creates an authenticator for the configured credentials, or returns
+// nil if authentication is disabled. Keys whose hash cannot be read are
+// left out.
+func New(config configs.AuthConfig) *Authenticator {
+	if !config.Enabled {
+		return nil
+	}
+	a := &Authenticator{jwtSecret: []byte(config.JWTSecret)}
+	for _, key := range config.APIKeys {
+		k := &apiKey{config: key}
+		switch {
+		case key.KeyHash != "":
+			hash, err := hex.DecodeString(key.KeyHash)
+			if err != nil || len(hash) != sha256.Size {
+				log.Printf("WARN: API key of %s has a key_hash that is not a hex SHA-256; ignoring it", key.UserID)
+				continue
+			}
+			k.hash = hash
+		case key.Key != "":
+			sum := sha256.Sum256([]byte(key.Key))
+			k.hash = sum[:]
+		default:
+			continue
+		}
+		if key.RequestsPerMinute > 0 {
+			k.limit = ratelimit.PerMinute(key.RequestsPerMinute)
+		}
+		a.keys = append(a.keys, k)
+	}
+	return a
+}
+
+// Authenticate returns the identity a token grants: a configured API key, or
+// a JWT signed with the configured secret whose sub claim is the user ID
+func (a *Authenticator) Authenticate(token string) (models.User, error) {
+	user, _, err := a.authenticate(token)
+	return user, err
+}
+
+// authenticate returns the identity a token grants and the API key it is,
+// nil for JWTs
+func (a *Authenticator) authenticate(token string) (models.User, *apiKey, error) {
+	if token == "" {
+		return models.User{}, nil, ErrUnauthorized
+	}
+	hash := sha256.Sum256([]byte(token))
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash) == 1 {
+			return models.User{ID: key.config.UserID, Name: key.config.Name, Roles: key.config.Roles}, key, nil
+		}
+	}
+	if len(a.jwtSecret) > 0 && strings.Count(token, ".") == 2 {
+		user, err := a.verifyJWT(token)
+		return user, nil, err
+	}
+	return models.User{}, nil, ErrUnauthorized
+}
+
+// jwtClaims are the registered and identity claims read from a JWT
+type jwtClaims struct {
+	Subject   string   `json:"sub"`
+	Name      string   `json:"name"`
+	Roles     []string `json:"roles"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+}
+
+// verifyJWT checks a JWT's HS256 signature and validity period
+func (a *Authenticator) verifyJWT(token string) (models.User, error) {
+	parts := strings.Split(token, ".")
+
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Algorithm != "HS256" {
+		return models.User{}, ErrUnauthorized
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return models.User{}, ErrUnauthorized
+	}
+	mac := hmac.New(sha256.New, a.jwtSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return models.User{}, ErrUnauthorized
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" {
+		return models.User{}, ErrUnauthorized
+	}
+	now := float64(time.Now().Unix())
+	if (claims.ExpiresAt != nil && now >= *claims.ExpiresAt) || (claims.NotBefore != nil && now < *claims.NotBefore) {
+		return models.User{}, ErrUnauthorized
+	}
+	return models.User{ID: claims.Subject, Name: claims.Name, Roles: claims.Roles}, nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a JWT
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// RequestToken returns the credentials of an HTTP request: the API key
+// header, a bearer token, or, for WebSocket upgrades from browsers, which
+// cannot set headers, the token query parameter
+func RequestToken(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return r.URL.Query().Get("token")
+}
+
+// Middleware rejects requests without valid credentials with 401, and
+// those over their API key's rate limit with 429, and attaches the identity
+// of the others, see UserFrom
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, key, err := a.authenticate(RequestToken(c.Request))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+				"success": false,
+				"error":   "Valid API key or bearer token required",
+			})
+			return
+		}
+		if key != nil {
+			if ok, wait := key.take(time.Now()); !ok {
+				c.Header("Retry-After", strconv.Itoa(ratelimit.RetryAfter(wait)))
+				c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+					"success": false,
+					"error":   fmt.Sprintf("API key rate limit of %d requests a minute exceeded", key.config.RequestsPerMinute),
+				})
+				return
+			}
+			c.Set(apiKeyKey, key)
+		}
+		c.Set(userKey, user)
+		c.Next()
+	}
+}
+
+// Admit authenticates a request made outside the REST API, such as a gRPC
+// call or a WebSocket connection, and counts it against its API key's
+// limit and usage as Middleware does. Over the limit it fails with
+// ErrRateLimited and how long to wait before the next request.
+func (a *Authenticator) Admit(token string) (models.User, time.Duration, error) {
+	user, key, err := a.authenticate(token)
+	if err != nil {
+		return models.User{}, 0, err
+	}
+	if key != nil {
+		if ok, wait := key.take(time.Now()); !ok {
+			return models.User{}, wait, fmt.Errorf("%w: %d requests a minute", ErrRateLimited, key.config.RequestsPerMinute)
+		}
+	}
+	return user, 0, nil
+}
+
+// take counts a request made with the key at now, if its limit allows it
+func (k *apiKey) take(now time.Time) (bool, time.Duration) {
+	ok, wait := true, time.Duration(0)
+	if k.limit != nil {
+		ok, wait = k.limit.Take(now)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if ok {
+		k.requests++
+		k.lastUsed = now
+	} else {
+		k.rateLimited++
+	}
+	return ok, wait
+}
+
+// usage returns the key's request counts
+func (k *apiKey) usage() models.APIKeyUsage {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	usage := models.APIKeyUsage{
+		Name:              k.config.Name,
+		UserID:            k.config.UserID,
+		Requests:          k.requests,
+		RateLimited:       k.rateLimited,
+		RequestsPerMinute: k.config.RequestsPerMinute,
+	}
+	if !k.lastUsed.IsZero() {
+		lastUsed := k.lastUsed
+		usage.LastUsed = &lastUsed
+	}
+	return usage
+}
+
+// Usage returns the request counts of every API key, in configured order
+func (a *Authenticator) Usage() []models.APIKeyUsage {
+	usage := make([]models.APIKeyUsage, len(a.keys))
+	for i, key := range a.keys {
+		usage[i] = key.usage()
+	}
+	return usage
+}
+
+// KeyUsageFrom returns the request counts of the API key Middleware let a
+// request through with, if it was made with one
+func KeyUsageFrom(c *gin.Context) (models.APIKeyUsage, bool) {
+	key, ok := c.Get(apiKeyKey)
+	if !ok {
+		return models.APIKeyUsage{}, false
+	}
+	k, ok := key.(*apiKey)
+	if !ok {
+		return models.APIKeyUsage{}, false
+	}
+	return k.usage(), true
+}
+
+// UserFrom returns the identity Middleware attached to a request
+func UserFrom(c *gin.Context) (models.User, bool) {
+	user, ok := c.Get(userKey)
+	if !ok {
+		return models.User{}, false
+	}
+	u, ok := user.(models.User)
+	return u, ok
+}
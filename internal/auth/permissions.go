@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+
+	"markdown-parser/internal/models"
+)
+
+// RoleAdmin grants every permission on every document
+const RoleAdmin = "admin"
+
+// Permission is something a user may be allowed to do with a document
+type Permission int
+
+const (
+	Read  Permission = iota + 1 // See the document and its changes
+	Write                       // Change the document, which includes reading it
+)
+
+// Allowed reports whether a user has a permission on a document. Documents
+// without permissions are open to everyone; otherwise the owner and admins
+// may do anything, writers may read and write, and readers may read.
+func Allowed(user models.User, doc models.Document, permission Permission) bool {
+	if doc.Permissions == nil || CanManage(user, doc) {
+		return true
+	}
+	if grants(user, doc.Permissions.Write) {
+		return true
+	}
+	return permission == Read && grants(user, doc.Permissions.Read)
+}
+
+// CanManage reports whether a user may change who has permissions on a
+// document: its owner and admins
+func CanManage(user models.User, doc models.Document) bool {
+	return (doc.Owner != "" && user.ID == doc.Owner) || slices.Contains(user.Roles, RoleAdmin)
+}
+
+// grants reports whether a list of permission entries includes a user,
+// by ID, by one of their roles, or as everyone
+func grants(user models.User, entries []string) bool {
+	for _, entry := range entries {
+		if entry == "*" || entry == user.ID {
+			return true
+		}
+		if role, ok := strings.CutPrefix(entry, "role:"); ok && slices.Contains(user.Roles, role) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"markdown-parser/configs"
+)
+
+// DefaultConfigPath is where the configuration is read from without --config
+const DefaultConfigPath = "configs/config.json"
+
+// ServeFunc runs the service with config, read from configPath, until it is
+// told to stop. A non-empty port overrides the configured one.
+type ServeFunc func(config *configs.Config, configPath, port string)
+
+const usage = `Usage: markdown-parser [command] [flags]
+
+Commands:
+  serve         Run the HTTP, WebSocket, and gRPC service (the default)
+  parse         Parse markdown files, or stdin, into HTML, JSON, or an export format
+  check-config  Validate a configuration file
+
+Run markdown-parser <command> -h for a command's flags.
+`
+
+// Run runs the command named by the first of args, or serve when args are
+// empty or start with a flag, and returns the process's exit status
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer, serve ServeFunc) int {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "serve":
+		return runServe(args, stderr, serve)
+	case "parse":
+		return runParse(args, stdin, stdout, stderr)
+	case "check-config":
+		return runCheckConfig(args, stdout, stderr)
+	case "help":
+		fmt.Fprint(stdout, usage)
+		return 0
+	default:
+		fmt.Fprintf(stderr, "Unknown command %q\n\n%s", command, usage)
+		return 2
+	}
+}
+
+// newFlags creates the flag set of a command, which reports its errors
+// and usage on stderr
+func newFlags(name, arguments string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage: markdown-parser %s [flags]%s\n\nFlags:\n", name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args into flags, returning the exit status to stop
+// with if they are wrong or help was asked for
+func parseFlags(flags *flag.FlagSet, args []string) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, false
+		}
+		return 2, false
+	}
+	return 0, true
+}
+
+// loadConfig loads the configuration at path. The default path may be
+// missing or broken, leaving the defaults in place as the server always
+// has; a path given with --config must load.
+func loadConfig(flags *flag.FlagSet, path string, stderr io.Writer) (*configs.Config, error) {
+	given := false
+	flags.Visit(func(f *flag.Flag) {
+		given = given || f.Name == "config"
+	})
+	if given {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+		return configs.LoadConfig(path)
+	}
+	config, err := configs.LoadConfig(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error loading config: %v, using defaults\n", err)
+		config = configs.DefaultConfig()
+	}
+	return config, nil
+}
+
+// runServe runs the service until it stops
+func runServe(args []string, stderr io.Writer, serve ServeFunc) int {
+	flags := newFlags("serve", "", stderr)
+	configPath := flags.String("config", DefaultConfigPath, "configuration file, reloaded as it changes")
+	port := flags.String("port", "", "port to listen on, in place of $PORT and server.port")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	config, err := loadConfig(flags, *configPath, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+	serve(config, *configPath, *port)
+	return 0
+}
+
+// runCheckConfig loads and validates a configuration file, listing every
+// problem found
+func runCheckConfig(args []string, stdout, stderr io.Writer) int {
+	flags := newFlags("check-config", " [file]", stderr)
+	configPath := flags.String("config", DefaultConfigPath, "configuration file to check")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+	path := *configPath
+	if flags.NArg() == 1 {
+		path = flags.Arg(0)
+	}
+
+	// Unlike the server, a missing file is an error here, since checking
+	// the defaults in its place would say nothing about it
+	if _, err := os.Stat(path); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	config, err := configs.LoadConfig(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	if err := config.Validate(); err != nil {
+		for _, problem := range strings.Split(err.Error(), "\n") {
+			fmt.Fprintf(stderr, "%s: %s\n", path, problem)
+		}
+		return 1
+	}
+	fmt.Fprintf(stdout, "%s: OK\n", path)
+	return 0
+}
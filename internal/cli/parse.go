@@ -0,0 +1,191 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/pkg/pdf"
+)
+
+// outputFormat is a way the parse command writes a parse result
+type outputFormat struct {
+	option    string // ParseOptions.Format that produces it
+	extension string // Of the files written to --out-dir
+	encode    func(response *models.ParseResponse, title string) ([]byte, error)
+}
+
+// outputFormats are the parse command's formats by name
+var outputFormats = map[string]outputFormat{
+	"json": {"", ".json", func(response *models.ParseResponse, _ string) ([]byte, error) {
+		return encodeJSON(response)
+	}},
+	"blocks": {"", ".json", func(response *models.ParseResponse, _ string) ([]byte, error) {
+		return encodeJSON(response.Tree)
+	}},
+	"ast": {parser.FormatAST, ".json", func(response *models.ParseResponse, _ string) ([]byte, error) {
+		return encodeJSON(response.AST)
+	}},
+	"html": {"", ".html", func(response *models.ParseResponse, _ string) ([]byte, error) {
+		return []byte(response.HTML), nil
+	}},
+	"pdf": {parser.FormatText, ".pdf", func(response *models.ParseResponse, title string) ([]byte, error) {
+		return pdf.FromText(title, response.Output), nil
+	}},
+	"text":     outputOf(parser.FormatText, ".txt"),
+	"markdown": outputOf(parser.FormatMarkdown, ".md"),
+	"slack":    outputOf(parser.FormatSlack, ".txt"),
+	"jira":     outputOf(parser.FormatJira, ".txt"),
+	"slides":   outputOf(parser.FormatSlides, ".html"),
+	"email":    outputOf(parser.FormatEmail, ".html"),
+}
+
+// outputOf is a format written as the parser's output in option
+func outputOf(option, extension string) outputFormat {
+	return outputFormat{option, extension, func(response *models.ParseResponse, _ string) ([]byte, error) {
+		return []byte(response.Output), nil
+	}}
+}
+
+// formatNames lists the parse command's formats
+func formatNames() string {
+	names := make([]string, 0, len(outputFormats))
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// encodeJSON encodes v as indented JSON, leaving HTML unescaped
+func encodeJSON(v interface{}) ([]byte, error) {
+	var data strings.Builder
+	encoder := json.NewEncoder(&data)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return []byte(data.String()), nil
+}
+
+// runParse parses markdown files, or stdin when none or "-" is given, with
+// the configured parser settings and writes each result in the chosen
+// format to stdout, to a file, or into a directory named after the inputs.
+// A file that fails is reported and the rest still written.
+func runParse(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := newFlags("parse", " [file ...]", stderr)
+	configPath := flags.String("config", DefaultConfigPath, "configuration file whose parser settings are used")
+	formatName := flags.String("format", "json", "output format: "+formatNames())
+	dialect := flags.String("dialect", "", "markdown dialect: commonmark, gfm, or notion; empty uses the configured one")
+	sanitize := flags.String("sanitize", "", "HTML sanitization policy: none, strict, gfm, or custom; empty uses the configured one")
+	out := flags.String("out", "", "file to write the result of a single input to, in place of stdout")
+	outDir := flags.String("out-dir", "", "directory to write each input's result to, named after the input")
+	if status, ok := parseFlags(flags, args); !ok {
+		return status
+	}
+	format, ok := outputFormats[*formatName]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown format %q; use %s\n", *formatName, formatNames())
+		return 2
+	}
+	inputs := flags.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+	if *out != "" && (*outDir != "" || len(inputs) > 1) {
+		fmt.Fprintln(stderr, "--out takes a single input and no --out-dir; use --out-dir for several")
+		return 2
+	}
+
+	config, err := loadConfig(flags, *configPath, stderr)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error loading config: %v\n", err)
+		return 1
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0o755); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	p := parser.NewMarkdownParserWithConfig(config.Parser)
+	opts := parser.ParseOptions{Format: format.option, Dialect: *dialect, SanitizePolicy: *sanitize}
+	status := 0
+	for _, input := range inputs {
+		data, err := parseInput(p, opts, format, input, stdin)
+		if err == nil {
+			err = writeOutput(data, outputPath(input, format, *out, *outDir), stdout)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", inputName(input), err)
+			status = 1
+		}
+	}
+	return status
+}
+
+// parseInput reads and parses one input, a file or "-" for stdin, and
+// encodes the result in format
+func parseInput(p *parser.MarkdownParser, opts parser.ParseOptions, format outputFormat, input string, stdin io.Reader) ([]byte, error) {
+	var content []byte
+	var err error
+	if input == "-" {
+		content, err = io.ReadAll(stdin)
+	} else {
+		content, err = os.ReadFile(input)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := p.ParseWithOptions(string(content), opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse markdown: %w", err)
+	}
+	title := "Document"
+	if input != "-" {
+		title = strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+	}
+	return format.encode(response, title)
+}
+
+// outputPath is where an input's result is written: out, a file in outDir
+// named after the input with the format's extension, or "" for stdout
+func outputPath(input string, format outputFormat, out, outDir string) string {
+	switch {
+	case out != "" && out != "-":
+		return out
+	case outDir != "":
+		name := "stdin"
+		if input != "-" {
+			name = strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		}
+		return filepath.Join(outDir, name+format.extension)
+	}
+	return ""
+}
+
+// writeOutput writes a result to path, or to stdout when path is empty
+func writeOutput(data []byte, path string, stdout io.Writer) error {
+	if path == "" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// inputName names an input in error messages
+func inputName(input string) string {
+	if input == "-" {
+		return "stdin"
+	}
+	return input
+}
@@ -0,0 +1,324 @@
+package comments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"markdown-parser/internal/models"
+)
+
+var (
+	// ErrUnknownDocument is returned for a document that is neither being
+	// edited nor has comments
+	ErrUnknownDocument = errors.New("unknown document")
+
+	// ErrUnknownBlock is returned for anchoring a comment to a block the
+	// document does not have
+	ErrUnknownBlock = errors.New("unknown block")
+
+	// ErrUnknownComment is returned for a comment the document does not have
+	ErrUnknownComment = errors.New("unknown comment")
+
+	// ErrEmptyBody is returned for a comment without text
+	ErrEmptyBody = errors.New("comment body is required")
+)
+
+// Source provides the documents being edited, such as the WebSocket hub's
+type Source interface {
+	DocumentContent(documentID string) (string, bool)
+	DocumentBlock(documentID, blockID string) (models.Block, bool)
+}
+
+// Notifier tells the subscribers of a document about its comments, such as
+// the WebSocket hub
+type Notifier interface {
+	BroadcastComment(documentID string, event models.CommentEvent)
+}
+
+// anchor is a comment and the source range of its block when last seen,
+// from which the block that replaced it is found
+type anchor struct {
+	comment    models.Comment
+	start, end int
+}
+
+// Store keeps the comments of documents in memory
+type Store struct {
+	source   Source
+	notifier Notifier
+
+	comments map[string][]*anchor // By document, oldest first
+	mu       sync.Mutex
+}
+
+// NewStore creates a store of comments on the documents of source, telling
+// notifier about every change to them
+func NewStore(source Source, notifier Notifier) *Store {
+	return &Store{
+		source:   source,
+		notifier: notifier,
+		comments: make(map[string][]*anchor),
+	}
+}
+
+// newCommentID returns a random ID for a comment
+func newCommentID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// List returns the comments on a document, oldest first
+func (s *Store) List(documentID string) ([]models.Comment, error) {
+	s.mu.Lock()
+	anchors, ok := s.comments[documentID]
+	list := make([]models.Comment, len(anchors))
+	for i, a := range anchors {
+		list[i] = a.comment
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		if _, editing := s.source.DocumentContent(documentID); !editing {
+			return nil, ErrUnknownDocument
+		}
+	}
+	return list, nil
+}
+
+// Get returns a comment on a document
+func (s *Store) Get(documentID, commentID string) (models.Comment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a := s.find(documentID, commentID)
+	if a == nil {
+		return models.Comment{}, ErrUnknownComment
+	}
+	return a.comment, nil
+}
+
+// Create adds a comment by author to a block of a document being edited
+func (s *Store) Create(documentID, author string, req models.CommentRequest) (models.Comment, error) {
+	if strings.TrimSpace(req.Body) == "" {
+		return models.Comment{}, ErrEmptyBody
+	}
+	block, err := s.block(documentID, req.BlockID)
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	now := time.Now()
+	a := &anchor{
+		comment: models.Comment{
+			ID:         newCommentID(),
+			DocumentID: documentID,
+			BlockID:    block.ID,
+			Author:     author,
+			Body:       req.Body,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		},
+		start: block.Position.Start,
+		end:   block.Position.End,
+	}
+	if req.Resolved != nil {
+		a.comment.Resolved = *req.Resolved
+	}
+
+	s.mu.Lock()
+	s.comments[documentID] = append(s.comments[documentID], a)
+	comment := a.comment
+	s.mu.Unlock()
+
+	s.notify("created", comment)
+	return comment, nil
+}
+
+// Update changes the body or resolution of a comment, or anchors it to
+// another block, leaving out what the request does
+func (s *Store) Update(documentID, commentID string, req models.CommentRequest) (models.Comment, error) {
+	var block models.Block
+	if req.BlockID != "" {
+		var err error
+		if block, err = s.block(documentID, req.BlockID); err != nil {
+			return models.Comment{}, err
+		}
+	}
+
+	s.mu.Lock()
+	a := s.find(documentID, commentID)
+	if a == nil {
+		s.mu.Unlock()
+		return models.Comment{}, ErrUnknownComment
+	}
+	event := "updated"
+	if req.Body != "" {
+		a.comment.Body = req.Body
+	}
+	if req.BlockID != "" {
+		a.comment.BlockID = block.ID
+		a.comment.Orphaned = false
+		a.start, a.end = block.Position.Start, block.Position.End
+	}
+	if req.Resolved != nil && *req.Resolved != a.comment.Resolved {
+		a.comment.Resolved = *req.Resolved
+		event = "reopened"
+		if a.comment.Resolved {
+			event = "resolved"
+		}
+	}
+	a.comment.UpdatedAt = time.Now()
+	comment := a.comment
+	s.mu.Unlock()
+
+	s.notify(event, comment)
+	return comment, nil
+}
+
+// Delete removes a comment
+func (s *Store) Delete(documentID, commentID string) error {
+	s.mu.Lock()
+	anchors := s.comments[documentID]
+	for i, a := range anchors {
+		if a.comment.ID == commentID {
+			s.comments[documentID] = append(anchors[:i:i], anchors[i+1:]...)
+			s.mu.Unlock()
+			s.notify("deleted", a.comment)
+			return nil
+		}
+	}
+	s.mu.Unlock()
+	return ErrUnknownComment
+}
+
+// Reanchor follows a change to a document, moving each comment whose block
+// was replaced to the block now holding what is left of it, and orphaning
+// those whose block was deleted. Comments keep blocks that were moved, as
+// moving keeps a block's ID. It suits the WebSocket hub's OnChange.
+func (s *Store) Reanchor(documentID string, operation models.TextOperation, blocks map[string]*models.Block) {
+	type change struct {
+		event   string
+		comment models.Comment
+	}
+	var changes []change
+
+	s.mu.Lock()
+	for _, a := range s.comments[documentID] {
+		if a.comment.Orphaned {
+			continue
+		}
+		if block, ok := blocks[a.comment.BlockID]; ok {
+			a.start, a.end = block.Position.Start, block.Position.End
+			continue
+		}
+
+		start, end := mapRange(operation, a.start, a.end)
+		block := replacement(blocks, start, end)
+		if block == nil {
+			a.comment.Orphaned = true
+			changes = append(changes, change{"orphaned", a.comment})
+			continue
+		}
+		a.comment.BlockID = block.ID
+		a.start, a.end = block.Position.Start, block.Position.End
+		changes = append(changes, change{"reanchored", a.comment})
+	}
+	s.mu.Unlock()
+
+	for _, c := range changes {
+		s.notify(c.event, c.comment)
+	}
+}
+
+// block looks up a block of a document being edited
+func (s *Store) block(documentID, blockID string) (models.Block, error) {
+	if _, ok := s.source.DocumentContent(documentID); !ok {
+		return models.Block{}, ErrUnknownDocument
+	}
+	block, ok := s.source.DocumentBlock(documentID, blockID)
+	if !ok {
+		return models.Block{}, ErrUnknownBlock
+	}
+	return block, nil
+}
+
+// find returns a comment on a document; the caller holds mu
+func (s *Store) find(documentID, commentID string) *anchor {
+	for _, a := range s.comments[documentID] {
+		if a.comment.ID == commentID {
+			return a
+		}
+	}
+	return nil
+}
+
+// notify tells the document's subscribers about a comment
+func (s *Store) notify(event string, comment models.Comment) {
+	if s.notifier != nil {
+		s.notifier.BroadcastComment(comment.DocumentID, models.CommentEvent{Event: event, Comment: comment})
+	}
+}
+
+// mapRange maps the range [start, end) of a text through an operation on
+// it. Text inserted at the range's edges counts as part of it, so a block
+// that was rewritten maps to the text that replaced it, and one that was
+// deleted maps to an empty range.
+func mapRange(operation models.TextOperation, start, end int) (int, int) {
+	newStart, newEnd := -1, -1
+	old, pos := 0, 0
+	for _, c := range operation {
+		if c.Insert != "" {
+			if newStart < 0 && start <= old {
+				newStart = pos
+			}
+			pos += len(c.Insert)
+			continue
+		}
+		if newEnd < 0 && end <= old {
+			newEnd = pos
+		}
+		n := c.Retain + c.Delete
+		if newStart < 0 && start < old+n {
+			newStart = pos
+			if c.Retain > 0 {
+				newStart += start - old
+			}
+		}
+		if newEnd < 0 && c.Retain > 0 && end < old+n {
+			newEnd = pos + end - old
+		}
+		old += n
+		pos += c.Retain
+	}
+	if newStart < 0 {
+		newStart = pos
+	}
+	if newEnd < 0 {
+		newEnd = pos
+	}
+	return newStart, max(newStart, newEnd)
+}
+
+// replacement picks the block that replaced one whose text now spans
+// [start, end): the block overlapping it most, preferring the innermost.
+// There is none when the range is empty.
+func replacement(blocks map[string]*models.Block, start, end int) *models.Block {
+	var best *models.Block
+	bestOverlap := 0
+	for _, block := range blocks {
+		overlap := min(end, block.Position.End) - max(start, block.Position.Start)
+		if overlap <= 0 {
+			continue
+		}
+		span := block.Position.End - block.Position.Start
+		if best == nil || overlap > bestOverlap || (overlap == bestOverlap && span < best.Position.End-best.Position.Start) {
+			best, bestOverlap = block, overlap
+		}
+	}
+	return best
+}
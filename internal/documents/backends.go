@@ -0,0 +1,132 @@
+package documents
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/redis"
+)
+
+// memoryBackend keeps documents in process memory, dropping expired ones
+// as they are next read
+type memoryBackend struct {
+	documents map[string]models.Document
+	mu        sync.Mutex
+}
+
+// NewMemoryBackend creates a backend keeping documents in memory
+func NewMemoryBackend() Backend {
+	return &memoryBackend{documents: make(map[string]models.Document)}
+}
+
+func (b *memoryBackend) Load(documentID string) (models.Document, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	doc, ok := b.documents[documentID]
+	if ok && expired(doc) {
+		delete(b.documents, documentID)
+		return models.Document{}, false, nil
+	}
+	return doc, ok, nil
+}
+
+func (b *memoryBackend) Save(doc models.Document, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.documents[doc.ID] = doc
+	return nil
+}
+
+func (b *memoryBackend) Remove(documentID string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	doc, ok := b.documents[documentID]
+	delete(b.documents, documentID)
+	return ok && !expired(doc), nil
+}
+
+func (b *memoryBackend) List() ([]models.Document, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	list := make([]models.Document, 0, len(b.documents))
+	for id, doc := range b.documents {
+		if expired(doc) {
+			delete(b.documents, id)
+			continue
+		}
+		list = append(list, doc)
+	}
+	return list, nil
+}
+
+// expired reports whether a document outlived its lifetime
+func expired(doc models.Document) bool {
+	return doc.ExpiresAt != nil && !time.Now().Before(*doc.ExpiresAt)
+}
+
+// redisBackend keeps documents in Redis as JSON, so instances share them
+// and Redis expires them
+type redisBackend struct {
+	client *redis.Client
+}
+
+// NewRedisBackend creates a backend keeping documents in Redis
+func NewRedisBackend(client *redis.Client) Backend {
+	return &redisBackend{client: client}
+}
+
+// key is the Redis key of a document
+func (b *redisBackend) key(documentID string) string {
+	return b.client.Key("doc:", documentID)
+}
+
+func (b *redisBackend) Load(documentID string) (models.Document, bool, error) {
+	data, ok, err := b.client.Get(b.key(documentID))
+	if err != nil || !ok {
+		return models.Document{}, false, err
+	}
+	var doc models.Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return models.Document{}, false, err
+	}
+	return doc, true, nil
+}
+
+func (b *redisBackend) Save(doc models.Document, ttl time.Duration) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return b.client.Set(b.key(doc.ID), data, ttl)
+}
+
+func (b *redisBackend) Remove(documentID string) (bool, error) {
+	return b.client.Del(b.key(documentID))
+}
+
+func (b *redisBackend) List() ([]models.Document, error) {
+	prefix := b.key("")
+	keys, err := b.client.Keys(prefix + "*")
+	if err != nil {
+		return nil, err
+	}
+	list := make([]models.Document, 0, len(keys))
+	for _, key := range keys {
+		// Documents may expire or be deleted between the scan and the read
+		doc, ok, err := b.Load(strings.TrimPrefix(key, prefix))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			list = append(list, doc)
+		}
+	}
+	return list, nil
+}
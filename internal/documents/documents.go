@@ -0,0 +1,239 @@
+package documents
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+)
+
+var (
+	// ErrUnknownDocument is returned for a document the store does not keep
+	ErrUnknownDocument = errors.New("unknown document")
+
+	// ErrDocumentExists is returned for creating a document under an ID
+	// already taken
+	ErrDocumentExists = errors.New("document already exists")
+)
+
+// Backend holds the documents of a store
+type Backend interface {
+	// Load returns a document, or false if it is not kept or expired
+	Load(documentID string) (models.Document, bool, error)
+
+	// Save keeps a document, expiring it after ttl unless that is 0
+	Save(doc models.Document, ttl time.Duration) error
+
+	// Remove deletes a document, reporting whether it was kept
+	Remove(documentID string) (bool, error)
+
+	// List returns every document kept
+	List() ([]models.Document, error)
+}
+
+// Store keeps documents by ID in a backend, counting their revisions and
+// renewing their lifetime with every change
+type Store struct {
+	backend Backend
+	ttl     time.Duration // Lifetime of documents that set none; 0 keeps them
+	mu      sync.Mutex    // Serializes read-modify-write of documents
+}
+
+// NewStore creates a store of the documents in backend
+func NewStore(config configs.DocumentsConfig, backend Backend) *Store {
+	return &Store{
+		backend: backend,
+		ttl:     time.Duration(config.TTLSeconds) * time.Second,
+	}
+}
+
+// newDocumentID returns a random ID for a document
+func newDocumentID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Create keeps a new document, under a random ID unless the request names one
+func (s *Store) Create(req models.DocumentRequest) (models.Document, error) {
+	now := time.Now()
+	doc := models.Document{
+		ID:          req.ID,
+		Revision:    1,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		Owner:       req.Owner,
+		Permissions: req.Permissions,
+	}
+	if doc.ID == "" {
+		doc.ID = newDocumentID()
+	}
+	if req.Title != nil {
+		doc.Title = *req.Title
+	}
+	if req.Content != nil {
+		doc.Content = *req.Content
+		doc.Size = len(doc.Content)
+	}
+	if req.TTLSeconds != nil {
+		doc.TTLSeconds = max(*req.TTLSeconds, 0)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok, err := s.backend.Load(doc.ID); err != nil {
+		return models.Document{}, err
+	} else if ok {
+		return models.Document{}, ErrDocumentExists
+	}
+	return s.save(doc)
+}
+
+// Get returns a document with its content
+func (s *Store) Get(documentID string) (models.Document, error) {
+	doc, ok, err := s.backend.Load(documentID)
+	if err != nil {
+		return models.Document{}, err
+	}
+	if !ok {
+		return models.Document{}, ErrUnknownDocument
+	}
+	return doc, nil
+}
+
+// List returns the documents kept, by ID, without their content
+func (s *Store) List() ([]models.Document, error) {
+	list, err := s.backend.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		list[i].Content = ""
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+// Update changes the title, content, lifetime, or permissions of a
+// document, leaving out what the request does
+func (s *Store) Update(documentID string, req models.DocumentRequest) (models.Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok, err := s.backend.Load(documentID)
+	if err != nil {
+		return models.Document{}, err
+	}
+	if !ok {
+		return models.Document{}, ErrUnknownDocument
+	}
+	if req.Title != nil {
+		doc.Title = *req.Title
+		doc.UpdatedAt = time.Now()
+	}
+	if req.Content != nil {
+		setContent(&doc, *req.Content)
+	}
+	if req.TTLSeconds != nil {
+		doc.TTLSeconds = max(*req.TTLSeconds, 0)
+	}
+	if req.Permissions != nil {
+		doc.Permissions = req.Permissions
+	}
+	return s.save(doc)
+}
+
+// Delete removes a document
+func (s *Store) Delete(documentID string) error {
+	ok, err := s.backend.Remove(documentID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrUnknownDocument
+	}
+	return nil
+}
+
+// DocumentIDs lists the documents kept
+func (s *Store) DocumentIDs() []string {
+	list, err := s.backend.List()
+	if err != nil {
+		log.Printf("WARN: Listing documents: %v", err)
+	}
+	ids := make([]string, len(list))
+	for i, doc := range list {
+		ids[i] = doc.ID
+	}
+	return ids
+}
+
+// DocumentContent returns the content of a document
+func (s *Store) DocumentContent(documentID string) (string, bool) {
+	doc, ok, err := s.backend.Load(documentID)
+	if err != nil {
+		log.Printf("WARN: Loading document %s: %v", documentID, err)
+	}
+	return doc.Content, ok
+}
+
+// SaveContent sets the content of a document, as the WebSocket hub does
+// when it autosaves, and returns its revision. Autosave never creates a
+// document, which would have no owner and so be open to everyone; unknown
+// documents fail with ErrUnknownDocument.
+func (s *Store) SaveContent(documentID, content string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc, ok, err := s.backend.Load(documentID)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, ErrUnknownDocument
+	}
+	if !setContent(&doc, content) {
+		return doc.Revision, nil
+	}
+	if _, err := s.save(doc); err != nil {
+		return 0, err
+	}
+	return doc.Revision, nil
+}
+
+// save keeps a document in the backend for its lifetime from now; the
+// caller holds mu
+func (s *Store) save(doc models.Document) (models.Document, error) {
+	ttl := s.ttl
+	if doc.TTLSeconds > 0 {
+		ttl = time.Duration(doc.TTLSeconds) * time.Second
+	}
+	doc.ExpiresAt = nil
+	if ttl > 0 {
+		expires := time.Now().Add(ttl)
+		doc.ExpiresAt = &expires
+	}
+	if err := s.backend.Save(doc, ttl); err != nil {
+		return models.Document{}, err
+	}
+	return doc, nil
+}
+
+// setContent changes the content of a document, counting a revision, and
+// reports whether it differed
+func setContent(doc *models.Document, content string) bool {
+	if content == doc.Content {
+		return false
+	}
+	doc.Content = content
+	doc.Size = len(content)
+	doc.Revision++
+	doc.UpdatedAt = time.Now()
+	return true
+}
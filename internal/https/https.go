@@ -0,0 +1,72 @@
+package https
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"markdown-parser/configs"
+)
+
+// Enabled reports whether the configuration serves HTTPS
+func Enabled(config configs.TLSConfig) bool {
+	return len(config.AutocertHosts) > 0 || config.CertFile != "" || config.KeyFile != ""
+}
+
+// Setup returns the TLS configuration of the HTTPS server, and the handler
+// of the plain HTTP server that redirects to it on httpsPort, which also
+// answers Let's Encrypt's challenges when certificates are obtained from it
+func Setup(config configs.TLSConfig, httpsPort string) (*tls.Config, http.Handler, error) {
+	redirect := Redirect(httpsPort)
+
+	if len(config.AutocertHosts) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertHosts...),
+			Cache:      autocert.DirCache(config.AutocertCacheDir),
+			Email:      config.AutocertEmail,
+		}
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, manager.HTTPHandler(redirect), nil
+	}
+
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, nil, fmt.Errorf("both cert_file and key_file are needed to serve HTTPS")
+	}
+	certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading the certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	return tlsConfig, redirect, nil
+}
+
+// Redirect permanently redirects requests to the same URL over HTTPS on
+// httpsPort. Only GET and HEAD requests are redirected, since clients
+// resend other requests without their bodies; the rest are refused.
+func Redirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Use HTTPS", http.StatusBadRequest)
+			return
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,144 @@
+package linkcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"syscall"
+	"time"
+
+	"markdown-parser/configs"
+)
+
+// maxRedirects is how many redirects a check follows
+const maxRedirects = 5
+
+// errPrivateAddress is returned for URLs resolving to addresses the
+// checker may not reach
+var errPrivateAddress = errors.New("address is private")
+
+// Result is the outcome of checking one URL
+type Result struct {
+	StatusCode int   // Final HTTP status, 0 if no response arrived
+	Err        error // Why no response arrived
+}
+
+// OK reports whether the URL answered with a success or redirect status
+func (r Result) OK() bool {
+	return r.Err == nil && r.StatusCode < http.StatusBadRequest
+}
+
+// Checker checks that external URLs answer, a limited number at a time.
+// Unless configured otherwise it refuses to connect to loopback, private,
+// and link-local addresses, so documents cannot probe the server's network.
+type Checker struct {
+	client      *http.Client
+	concurrency int
+}
+
+// New creates a checker from the link check configuration
+func New(config configs.LinkCheckConfig) *Checker {
+	dialer := &net.Dialer{Timeout: time.Duration(config.TimeoutSeconds) * time.Second}
+	if !config.AllowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPrivate(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, host)
+			}
+			return nil
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // The address check applies to the proxy, not the link
+	transport.DialContext = dialer.DialContext
+
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	return &Checker{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(config.TimeoutSeconds) * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				return nil
+			},
+		},
+		concurrency: concurrency,
+	}
+}
+
+// isPrivate reports whether ip is an address on the server's own network
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast()
+}
+
+// Check checks each URL, returning their results by URL. Each URL is checked
+// once however often it is listed.
+func (c *Checker) Check(ctx context.Context, urls []string) map[string]Result {
+	results := make(map[string]Result, len(urls))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, c.concurrency)
+
+	for _, url := range urls {
+		mu.Lock()
+		_, seen := results[url]
+		if !seen {
+			results[url] = Result{} // Claimed, so duplicates are skipped
+		}
+		mu.Unlock()
+		if seen {
+			continue
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(url string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result := c.check(ctx, url)
+			mu.Lock()
+			results[url] = result
+			mu.Unlock()
+		}(url)
+	}
+	wg.Wait()
+	return results
+}
+
+// check sends a HEAD request for url, retrying with GET for servers that
+// do not support HEAD
+func (c *Checker) check(ctx context.Context, url string) Result {
+	status, err := c.request(ctx, http.MethodHead, url)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = c.request(ctx, http.MethodGet, url)
+	}
+	return Result{StatusCode: status, Err: err}
+}
+
+// request sends one request and returns its status, discarding the body
+func (c *Checker) request(ctx context.Context, method, url string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", "markdown-parser-linkcheck/1.0")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
@@ -2,67 +2,934 @@ package models
 
 import (
 	"time"
-	"github.com/yuin/goldmark/ast"
 )
 
 // ParseRequest represents a request to parse markdown content
 type ParseRequest struct {
 	Content string `json:"content" binding:"required"`
 	BlockID string `json:"blockId,omitempty"`
-	Format  string `json:"format,omitempty"` // html, ast, preview
+	Format  string `json:"format,omitempty"` // html, ast, text, markdown, slack, jira, slides, email, preview
+
+	// Optional syntax highlighting overrides for fenced code blocks
+	Theme       string `json:"theme,omitempty"`
+	LineNumbers *bool  `json:"lineNumbers,omitempty"`
+
+	// Optional HTML sanitization policy override: none, strict, gfm, custom
+	Sanitize string `json:"sanitize,omitempty"`
+
+	// Optional markdown dialect: commonmark, gfm, notion
+	Dialect string `json:"dialect,omitempty"`
+
+	// Optional paragraph wrap width for markdown output, 0 keeps line breaks
+	WrapWidth *int `json:"wrapWidth,omitempty"`
+
+	// Optional data-sourcepos attributes on rendered blocks
+	SourcePositions *bool `json:"sourcePositions,omitempty"`
+
+	// Add document statistics to the response
+	Stats bool `json:"stats,omitempty"`
+
+	// Add lint warnings to the response
+	Lint bool `json:"lint,omitempty"`
+
+	// Optional edit applied to Content for incremental parsing
+	Edit *Edit `json:"edit,omitempty"`
+
+	// Return only the changed blocks from incremental parsing, without Blocks or HTML
+	ChangesOnly bool `json:"changesOnly,omitempty"`
+
+	// Express the changes from incremental parsing as a JSON Patch against Blocks
+	Patch bool `json:"patch,omitempty"`
+
+	// Optional granularity of the changes from incremental parsing: block, line, word
+	Granularity string `json:"granularity,omitempty"`
+}
+
+// RenderRequest asks for markdown rendered as a standalone HTML page, from
+// a JSON body or, for GET, the query string
+type RenderRequest struct {
+	Content    string `json:"content" form:"content"`
+	DocumentID string `json:"documentId,omitempty" form:"document"` // Render a stored document instead of Content
+	Title      string `json:"title,omitempty" form:"title"`         // Defaults to the document's title or first heading
+
+	// Page theme: light, dark, or sepia
+	PageTheme string `json:"pageTheme,omitempty" form:"page_theme"`
+
+	// Optional syntax highlighting overrides, defaulting to the page theme's style
+	Theme       string `json:"theme,omitempty" form:"theme"`
+	LineNumbers *bool  `json:"lineNumbers,omitempty" form:"line_numbers"`
+
+	Dialect  string `json:"dialect,omitempty" form:"dialect"`
+	Sanitize string `json:"sanitize,omitempty" form:"sanitize"`
+
+	// Add a sidebar with a table of contents of the headings
+	TOC bool `json:"toc,omitempty" form:"toc"`
+}
+
+// APIError describes why a request failed, in the standard error response
+// of the versioned API
+type APIError struct {
+	Code      string                 `json:"code"` // Machine-readable, such as not_found or payload_too_large
+	Message   string                 `json:"message"`
+	Details   map[string]interface{} `json:"details,omitempty"` // The rest of the failed response, such as per-file errors
+	RequestID string                 `json:"request_id,omitempty"`
+}
+
+// ErrorResponse is the standard error response of the versioned API
+type ErrorResponse struct {
+	Success bool     `json:"success"`
+	Error   APIError `json:"error"`
+}
+
+// BatchDocument is one document of a batch parse request, with its own
+// parse options
+type BatchDocument struct {
+	ID string `json:"id"`
+	ParseRequest
+}
+
+// BatchParseRequest asks for several documents to be parsed at once
+type BatchParseRequest struct {
+	Documents []BatchDocument `json:"documents" binding:"required,dive"`
+}
+
+// BatchResult is the parse result of one document of a batch, under its ID
+type BatchResult struct {
+	ID string `json:"id"`
+	*ParseResponse
+}
+
+// BatchParseResponse holds the results of a batch in request order;
+// success reports whether every document parsed
+type BatchParseResponse struct {
+	Results []BatchResult `json:"results"`
+	Success bool          `json:"success"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Edit replaces the bytes [Start, End) of a document with Text
+type Edit struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// FormatResponse represents the response from formatting markdown
+type FormatResponse struct {
+	Markdown string `json:"markdown"`
+	Changed  bool   `json:"changed"` // Whether the canonical form differs from the input
+	Success  bool   `json:"success"`
+	Error    string `json:"error,omitempty"`
+}
+
+// SyntaxCheckRequest asks what a line of markdown is, for an editor's
+// live preview
+type SyntaxCheckRequest struct {
+	Line   string `json:"line" binding:"required"`
+	Cursor *int   `json:"cursor,omitempty"` // Byte offset of the cursor in Line, defaulting to its end
+}
+
+// SyntaxCheckResponse reports what a line of markdown is
+type SyntaxCheckResponse struct {
+	Line         string `json:"line"`
+	DetectedType string `json:"detected_type"`        // h1-h6, checkbox, unordered_list, ordered_list, code_block, blockquote, or paragraph
+	IsBlock      bool   `json:"is_block"`             // Whether the line starts a block other than a paragraph
+	Completion   string `json:"completion,omitempty"` // Text to insert at the cursor to finish the syntax before it
+	HTML         string `json:"html"`                 // The line rendered on its own
+	Success      bool   `json:"success"`
+	Error        string `json:"error,omitempty"`
+}
+
+// CacheStats reports the size and effectiveness of the parse result cache
+type CacheStats struct {
+	Enabled   bool   `json:"enabled"`
+	Entries   int    `json:"entries"`
+	Capacity  int    `json:"capacity"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// ConnectionStats reports WebSocket connection counts
+type ConnectionStats struct {
+	Current    int    `json:"current"`
+	Peak       int    `json:"peak"`       // Most connected at once since startup
+	Max        int    `json:"max"`        // Configured limit, 0 for none
+	Rejected   uint64 `json:"rejected"`   // Connections refused at the limit
+	Dropped    uint64 `json:"dropped"`    // Broadcast updates dropped for slow clients
+	SlowClosed uint64 `json:"slowClosed"` // Connections closed for not keeping up
+	Shards     int    `json:"shards"`     // Event loops the hub spreads connections over
+}
+
+// DocumentSubscribers reports who is following a document the hub holds
+type DocumentSubscribers struct {
+	DocumentID string `json:"documentId"`
+	Clients    int    `json:"clients"`  // WebSocket clients subscribed to it
+	Watchers   int    `json:"watchers"` // Event streams following it
+}
+
+// ErrorRate reports the API's responses over a recent window, counting
+// 4xx responses as client errors and 5xx as server errors
+type ErrorRate struct {
+	WindowMinutes   int     `json:"windowMinutes"`
+	Requests        int     `json:"requests"`
+	ClientErrors    int     `json:"clientErrors"`
+	ServerErrors    int     `json:"serverErrors"`
+	ClientErrorRate float64 `json:"clientErrorRate"` // Fraction of the requests, 0 without any
+	ServerErrorRate float64 `json:"serverErrorRate"`
+}
+
+// AdminStatsResponse reports the service's load for operational dashboards
+type AdminStatsResponse struct {
+	Connections   ConnectionStats       `json:"connections"`
+	Subscribers   []DocumentSubscribers `json:"subscribers"`   // By document, the most followed first
+	DocumentsHeld int                   `json:"documentsHeld"` // Parsed documents the hub keeps in memory
+	Caches        map[string]CacheStats `json:"caches"`        // Parse result caches: api and websocket
+	ErrorRates    []ErrorRate           `json:"errorRates"`    // Over the last 1, 5, and 15 minutes
+	Success       bool                  `json:"success"`
+	Error         string                `json:"error,omitempty"`
+}
+
+// ShardQueueStats reports the send queues of the clients on one of the
+// hub's event loops
+type ShardQueueStats struct {
+	Shard   int  `json:"shard"`
+	Clients int  `json:"clients"`
+	Queued  int  `json:"queued"`  // Messages waiting across the shard's clients
+	Deepest int  `json:"deepest"` // Longest single client queue
+	Limit   int  `json:"limit"`   // Messages a client may have queued
+	Stalled bool `json:"stalled"` // The event loop did not answer in time, so the counts are unknown
+}
+
+// HeapStats reports the Go heap, from runtime.MemStats
+type HeapStats struct {
+	AllocBytes    uint64  `json:"allocBytes"`    // Live objects
+	InuseBytes    uint64  `json:"inuseBytes"`    // Spans holding at least one object
+	IdleBytes     uint64  `json:"idleBytes"`     // Spans held but unused
+	ReleasedBytes uint64  `json:"releasedBytes"` // Idle spans returned to the OS
+	SysBytes      uint64  `json:"sysBytes"`      // Obtained from the OS for the heap
+	Objects       uint64  `json:"objects"`
+	NextGCBytes   uint64  `json:"nextGcBytes"` // Heap size that triggers the next collection
+	NumGC         uint32  `json:"numGc"`
+	PauseTotalMs  float64 `json:"pauseTotalMs"`
+}
+
+// RuntimeStats reports the process's goroutines and heap with the queues
+// of the WebSocket hub and parse workers, for diagnosing memory growth
+type RuntimeStats struct {
+	Goroutines         int               `json:"goroutines"`
+	Heap               HeapStats         `json:"heap"`
+	Connections        ConnectionStats   `json:"connections"`
+	Shards             []ShardQueueStats `json:"shards"`
+	ParseQueued        int               `json:"parseQueued"` // Parse jobs waiting for a worker
+	ParseQueueCapacity int               `json:"parseQueueCapacity"`
 }
 
 // ParseResponse represents the response from parsing
 type ParseResponse struct {
-	HTML     string            `json:"html"`
-	AST      interface{}       `json:"ast,omitempty"`
-	Blocks   map[string]*Block `json:"blocks"`
-	Changes  []BlockChange     `json:"changes,omitempty"`
-	Success  bool              `json:"success"`
-	Error    string            `json:"error,omitempty"`
+	HTML        string                 `json:"html,omitempty"`
+	AST         interface{}            `json:"ast,omitempty"`
+	Blocks      map[string]*Block      `json:"blocks,omitzero"` // nil in changes-only responses
+	Tree        []*Block               `json:"tree,omitempty"`  // Top-level blocks in document order, nesting through Children
+	Changes     []BlockChange          `json:"changes,omitempty"`
+	Lines       []LineChange           `json:"lines,omitempty"` // Changes at line granularity
+	Words       []LineChange           `json:"words,omitempty"` // Changes at word granularity
+	Patch       []PatchOperation       `json:"patch,omitempty"` // Changes as RFC 6902 operations on {"blocks": ...}
+	Frontmatter map[string]interface{} `json:"frontmatter,omitempty"`
+	Links       *LinkIndex             `json:"links,omitempty"`
+	Footnotes   map[string]string      `json:"footnotes,omitempty"` // Footnote label → rendered content
+	Images      []Image                `json:"images,omitempty"`    // Every image, for prefetching or proxying
+	Output      string                 `json:"output,omitempty"`    // Document in the requested non-HTML format
+	Stats       *DocumentStats         `json:"stats,omitempty"`     // Counts for editors to display, when requested
+	Warnings    []LintWarning          `json:"warnings,omitempty"`  // Lint warnings, when requested
+	Success     bool                   `json:"success"`
+	Error       string                 `json:"error,omitempty"`
+
+	// The content parsed, when it differed from the content sent: control
+	// characters are stripped and it may be normalized to NFC, and
+	// positions refer to it
+	NormalizedContent string `json:"normalized_content,omitempty"`
+}
+
+// DocumentStats counts what editors display about a document
+type DocumentStats struct {
+	Words          int         `json:"words"`
+	Characters     int         `json:"characters"`      // Of the plain text, without markdown syntax
+	ReadingMinutes int         `json:"reading_minutes"` // At 200 words a minute, rounded up
+	Headings       map[int]int `json:"headings"`        // Count by level
+	CodeBlocks     int         `json:"code_blocks"`
+	Links          int         `json:"links"` // Including autolinks and wikilinks
+	Images         int         `json:"images"`
+}
+
+// StatsResponse reports a document's statistics
+type StatsResponse struct {
+	Stats   *DocumentStats `json:"stats,omitempty"`
+	Success bool           `json:"success"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// LintWarning is a problem a lint rule found in a document
+type LintWarning struct {
+	Rule    string `json:"rule"` // heading-increment, trailing-spaces, bare-url, duplicate-heading, missing-alt-text, line-length
+	Message string `json:"message"`
+	Line    int    `json:"line"`   // 1-based
+	Column  int    `json:"column"` // 1-based, in characters
+}
+
+// LintResponse reports the lint warnings of a document
+type LintResponse struct {
+	Warnings []LintWarning `json:"warnings"`
+	Success  bool          `json:"success"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// BlockResponse is one block of a document, rendered on demand
+type BlockResponse struct {
+	Block   *Block `json:"block,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// OutlineHeading is a heading in a document's outline, with the headings
+// of its section nested inside
+type OutlineHeading struct {
+	BlockID  string            `json:"blockId"`
+	Level    int               `json:"level"`
+	Text     string            `json:"text"`
+	Slug     string            `json:"slug"` // Anchor ID to jump to
+	Position Position          `json:"position"`
+	Children []*OutlineHeading `json:"children"`
+}
+
+// OutlineResponse is a document's heading outline
+type OutlineResponse struct {
+	Outline []*OutlineHeading `json:"outline"`
+	Success bool              `json:"success"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// LocateRequest asks which blocks enclose a caret, given as an offset or
+// as a 1-based line and column
+type LocateRequest struct {
+	Content string `json:"content"`
+	Offset  *int   `json:"offset,omitempty"`
+	Unit    string `json:"unit,omitempty"`   // What Offset counts: byte (the default) or character
+	Line    int    `json:"line,omitempty"`   // Used when Offset is left out
+	Column  int    `json:"column,omitempty"` // In characters; defaults to 1
+}
+
+// LocatedBlock is a block enclosing a caret
+type LocatedBlock struct {
+	BlockID  string   `json:"blockId"`
+	Type     string   `json:"type"`
+	Position Position `json:"position"`
+}
+
+// LocateResponse is the block enclosing a caret, empty if it is between
+// blocks, and the blocks containing that one
+type LocateResponse struct {
+	Offset   int            `json:"offset"` // Byte offset of the caret
+	BlockID  string         `json:"blockId,omitempty"`
+	Type     string         `json:"type,omitempty"`
+	Position *Position      `json:"position,omitempty"`
+	Path     []LocatedBlock `json:"path"` // From the top-level block down to the enclosing block
+	Success  bool           `json:"success"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// LinkCheckRequest asks for the links of a document to be checked
+type LinkCheckRequest struct {
+	Content      string `json:"content" binding:"required"`
+	SkipExternal bool   `json:"skipExternal,omitempty"` // Only check anchors within the document
+}
+
+// LinkCheckResult is the outcome of checking one link
+type LinkCheckResult struct {
+	URL        string `json:"url"`
+	Text       string `json:"text,omitempty"`
+	Line       int    `json:"line,omitempty"` // 1-based; 0 for images, whose position is not tracked
+	Kind       string `json:"kind"`           // external, anchor, image, or other
+	Status     string `json:"status"`         // ok, broken, or skipped
+	StatusCode int    `json:"status_code,omitempty"`
+	Error      string `json:"error,omitempty"` // Why the link is broken or was skipped
+}
+
+// LinkCheckResponse reports the links of a document and whether they work
+type LinkCheckResponse struct {
+	Links   []LinkCheckResult `json:"links"`
+	Broken  int               `json:"broken"`
+	Success bool              `json:"success"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// LinkIndex collects the links found in a document
+type LinkIndex struct {
+	Internal []InternalLink `json:"internal"` // [[Page]] wikilinks, for building backlinks
+}
+
+// InternalLink represents a [[Page|alias]] wikilink
+type InternalLink struct {
+	Target string `json:"target"`
+	Alias  string `json:"alias,omitempty"`
+	Href   string `json:"href"`
 }
 
 // Block represents a parsed markdown block
 type Block struct {
-	ID       string    `json:"id"`
-	Type     string    `json:"type"`     // heading, paragraph, list, code_block, etc.
-	Level    int       `json:"level"`    // For headings (1-6), list nesting level
-	Content  string    `json:"content"`  // Original markdown content
-	HTML     string    `json:"html"`     // Rendered HTML
-	Position Position  `json:"position"` // Position in source
-	Children []*Block  `json:"children,omitempty"`
+	ID          string       `json:"id"`
+	Index       int          `json:"index"`                 // Position in document order among all blocks
+	Type        string       `json:"type"`                  // heading, paragraph, list, code_block, etc.
+	Level       int          `json:"level"`                 // For headings (1-6), list nesting level
+	Content     string       `json:"content"`               // Original markdown content
+	HTML        string       `json:"html"`                  // Rendered HTML
+	Position    Position     `json:"position"`              // Position in source
+	Text        string       `json:"text,omitempty"`        // Plain-text content without markdown syntax, e.g. heading text
+	Slug        string       `json:"slug,omitempty"`        // Anchor ID of heading blocks, matching the HTML id
+	Checked     *bool        `json:"checked,omitempty"`     // Task state for checkbox blocks
+	Variant     string       `json:"variant,omitempty"`     // Callout variant (note, warning, ...)
+	Name        string       `json:"name,omitempty"`        // Container name for ::: blocks
+	Language    string       `json:"language,omitempty"`    // Language of fenced code blocks, from the info string
+	Info        string       `json:"info,omitempty"`        // Fence info string of fenced code blocks, verbatim
+	Code        string       `json:"code,omitempty"`        // Unrendered code of code blocks, without fences or indentation
+	Ref         string       `json:"ref,omitempty"`         // Footnote label for footnote blocks
+	Image       *Image       `json:"image,omitempty"`       // Source, alt text, and title for image blocks
+	Table       *Table       `json:"table,omitempty"`       // Structured rows/cells for table blocks
+	Definitions []Definition `json:"definitions,omitempty"` // Term/description pairs for definition lists
+	Inlines     []Inline     `json:"inlines,omitempty"`     // Formatted spans inside the block
+	ParentID    string       `json:"parentId,omitempty"`    // ID of the enclosing block, empty at the top level
+	Children    []*Block     `json:"children,omitempty"`    // Nested blocks in document order
+}
+
+// Inline represents a formatted span inside a block
+type Inline struct {
+	Type  string `json:"type"`  // bold, italic, code, link, highlight, strikethrough, subscript, superscript
+	Start int    `json:"start"` // Byte offset of the span's text in the source
+	End   int    `json:"end"`
+	Text  string `json:"text"`           // Plain text of the span
+	Href  string `json:"href,omitempty"` // Link destination, for links
+}
+
+// Image represents the metadata of an image
+type Image struct {
+	Src   string `json:"src"`
+	Alt   string `json:"alt"`
+	Title string `json:"title,omitempty"`
+}
+
+// Definition represents a term and its descriptions in a definition list
+type Definition struct {
+	Term         string   `json:"term"`
+	Descriptions []string `json:"descriptions"`
 }
 
-// Position represents the position of content in the source
+// Table represents the structured contents of a GFM table block
+type Table struct {
+	Alignments []string      `json:"alignments"` // left, center, right, none per column
+	Header     []TableCell   `json:"header"`
+	Rows       [][]TableCell `json:"rows"`
+}
+
+// TableCell represents a single table cell
+type TableCell struct {
+	Content string `json:"content"` // Original markdown content
+	HTML    string `json:"html"`    // Rendered inline HTML
+}
+
+// Position represents the position of content in the source. Start and End
+// are byte offsets; lines and columns are 1-based, with columns counted in
+// characters, and EndLine/EndColumn point just past the last character.
 type Position struct {
-	Start int `json:"start"`
-	End   int `json:"end"`
-	Line  int `json:"line"`
+	Start     int `json:"start"`
+	End       int `json:"end"`
+	Line      int `json:"line"`
+	Column    int `json:"column,omitempty"`
+	EndLine   int `json:"endLine,omitempty"`
+	EndColumn int `json:"endColumn,omitempty"`
 }
 
 // BlockChange represents a change in a block
 type BlockChange struct {
-	Type    string `json:"type"`    // added, modified, removed
-	BlockID string `json:"blockId"`
-	Block   *Block `json:"block,omitempty"`
+	Type     string `json:"type"`               // added, modified, moved, removed
+	BlockID  string `json:"blockId"`
+	Block    *Block `json:"block,omitempty"`
+	OldIndex *int   `json:"oldIndex,omitempty"` // Previous Index of a moved block
+	NewIndex *int   `json:"newIndex,omitempty"` // Current Index of a moved block
+}
+
+// LineChange represents a change in a line, or in a word or run of
+// whitespace for word-level diffs
+type LineChange struct {
+	Type    string `json:"type"`    // added, removed, unchanged
+	LineNum int    `json:"lineNum"` // Line in the new version, or in the old one for removed lines
+	Content string `json:"content"`
+}
+
+// DiffRequest asks for the differences between two versions of a document
+type DiffRequest struct {
+	OldContent string `json:"old_content"`
+	NewContent string `json:"new_content"`
+	Render     string `json:"render,omitempty"` // Optional diff HTML: unified, side-by-side
+
+	// Optional granularity: block, line, or word; by default blocks and lines
+	Granularity string `json:"granularity,omitempty"`
+
+	// Ignore trailing whitespace, line endings, and spacing between words
+	IgnoreWhitespace bool `json:"ignore_whitespace,omitempty"`
+}
+
+// DiffResponse reports the differences between two versions of a document
+type DiffResponse struct {
+	Changes []BlockChange `json:"changes,omitempty"`
+	Lines   []LineChange  `json:"lines,omitempty"`
+	Words   []LineChange  `json:"words,omitempty"`
+	HTML    string        `json:"html,omitempty"` // Rendered diff of the lines, if requested
+	Success bool          `json:"success"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// Document is a document kept by the server, the source of truth for
+// WebSocket editing, version history, and comments
+type Document struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title,omitempty"`
+	Content   string    `json:"content,omitempty"` // Left out of document lists
+	Size      int       `json:"size"`              // Bytes of content
+	Revision  int       `json:"revision"`          // Counts changes to the content, from 1 on creation
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Lifetime after the last change, for short-lived sessions; 0 uses the
+	// configured lifetime
+	TTLSeconds int        `json:"ttl_seconds,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // Unless changed again
+
+	// User who created the document, who may always read and change it and
+	// its permissions
+	Owner       string               `json:"owner,omitempty"`
+	Permissions *DocumentPermissions `json:"permissions,omitempty"` // Open to everyone if nil
+}
+
+// DocumentPermissions limits who may read and change a document when
+// authentication is enabled. Entries are user IDs, "role:<name>" for the
+// users with a role, or "*" for everyone.
+type DocumentPermissions struct {
+	Read  []string `json:"read,omitempty"`
+	Write []string `json:"write,omitempty"` // Writers may read too
+}
+
+// DocumentRequest creates a document, or changes one; fields left out of a
+// change stay as they are
+type DocumentRequest struct {
+	ID      string  `json:"id,omitempty"` // Chosen by the server if left out on creation
+	Title   *string `json:"title,omitempty"`
+	Content *string `json:"content,omitempty"`
+
+	// Lifetime after the last change; 0 uses the configured lifetime
+	TTLSeconds *int `json:"ttl_seconds,omitempty"`
+
+	// Who may read and change the document; only its owner and admins may
+	// change them
+	Permissions *DocumentPermissions `json:"permissions,omitempty"`
+
+	// Owner of a created document, set from the request's credentials
+	Owner string `json:"-"`
+}
+
+// DocumentsResponse lists the documents kept, by ID
+type DocumentsResponse struct {
+	Documents []Document `json:"documents"`
+	Success   bool       `json:"success"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// DocumentResponse holds one document
+type DocumentResponse struct {
+	Document *Document `json:"document,omitempty"`
+	Success  bool      `json:"success"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// ImportedFile is the outcome of importing one uploaded file, or one file
+// of an uploaded zip archive
+type ImportedFile struct {
+	File   string         `json:"file"` // Name as uploaded, with its path in the archive
+	ID     string         `json:"id,omitempty"`
+	Title  string         `json:"title,omitempty"`
+	Result *ParseResponse `json:"result,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// ImportResponse lists the documents created from uploaded files
+type ImportResponse struct {
+	Files   []ImportedFile `json:"files"`
+	Success bool           `json:"success"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// DocumentVersion is a snapshot of a document's content
+type DocumentVersion struct {
+	DocumentID string    `json:"document_id"`
+	Version    int       `json:"version"` // Numbered from 1 per document
+	CreatedAt  time.Time `json:"created_at"`
+	Size       int       `json:"size"`              // Bytes of content
+	Content    string    `json:"content,omitempty"` // Left out of version lists
+}
+
+// VersionsResponse lists the versions kept of a document, oldest first
+type VersionsResponse struct {
+	DocumentID string            `json:"document_id"`
+	Versions   []DocumentVersion `json:"versions"`
+	Success    bool              `json:"success"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// VersionResponse holds one version of a document
+type VersionResponse struct {
+	Version *DocumentVersion `json:"version,omitempty"`
+	Created bool             `json:"created,omitempty"` // A snapshot made a new version, rather than finding the content unchanged
+	Success bool             `json:"success"`
+	Error   string           `json:"error,omitempty"`
+}
+
+// AuditEntry is an operation on a document in its audit log. Consecutive
+// edits by the same user are counted in one entry, stamped with the first
+// and the last of them.
+type AuditEntry struct {
+	DocumentID string     `json:"document_id"`
+	Action     string     `json:"action"`            // create, parse, edit, delete, or export
+	UserID     string     `json:"user_id,omitempty"` // Empty without authentication
+	Detail     string     `json:"detail,omitempty"`
+	Count      int        `json:"count"`
+	Timestamp  time.Time  `json:"timestamp"`
+	LastAt     *time.Time `json:"last_at,omitempty"` // Latest of the operations counted, when more than one
+}
+
+// AuditResponse lists the audit log of a document, oldest first
+type AuditResponse struct {
+	DocumentID string       `json:"document_id"`
+	Entries    []AuditEntry `json:"entries"`
+	Success    bool         `json:"success"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// Comment is a comment anchored to a block of a document. Edits that change
+// the block move the comment to the block that replaced it; if there is
+// none, the comment is orphaned.
+type Comment struct {
+	ID         string    `json:"id"`
+	DocumentID string    `json:"document_id"`
+	BlockID    string    `json:"block_id"` // Block anchored to, or last anchored to if orphaned
+	Author     string    `json:"author,omitempty"`
+	Body       string    `json:"body"`
+	Resolved   bool      `json:"resolved"`
+	Orphaned   bool      `json:"orphaned"` // The block was removed
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// CommentRequest adds a comment to a block, or changes one; fields left out
+// of a change stay as they are
+type CommentRequest struct {
+	BlockID  string `json:"block_id,omitempty"` // Anchors an orphaned comment again when changing one
+	Body     string `json:"body,omitempty"`
+	Author   string `json:"author,omitempty"` // Ignored when authenticated, as the user is the author
+	Resolved *bool  `json:"resolved,omitempty"`
+}
+
+// CommentsResponse lists the comments on a document, oldest first
+type CommentsResponse struct {
+	DocumentID string    `json:"document_id"`
+	Comments   []Comment `json:"comments"`
+	Success    bool      `json:"success"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// CommentResponse holds one comment
+type CommentResponse struct {
+	Comment *Comment `json:"comment,omitempty"`
+	Success bool     `json:"success"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// CommentEvent tells the subscribers of a document about a comment
+type CommentEvent struct {
+	Event   string  `json:"event"` // created, updated, resolved, reopened, deleted, reanchored, orphaned
+	Comment Comment `json:"comment"`
+}
+
+// MergeRequest asks for a three-way merge of two edited versions of a document
+type MergeRequest struct {
+	Base   string `json:"base"`   // Common ancestor
+	Ours   string `json:"ours"`   // First edited version
+	Theirs string `json:"theirs"` // Second edited version
+}
+
+// MergeConflict is a region both versions changed differently
+type MergeConflict struct {
+	Line   int    `json:"line"` // Line of the <<<<<<< marker in the merged content
+	Base   string `json:"base"`
+	Ours   string `json:"ours"`
+	Theirs string `json:"theirs"`
+}
+
+// MergeResponse holds a merged document, with conflict markers around any
+// regions that could not be merged
+type MergeResponse struct {
+	Content   string          `json:"content"`
+	Conflicts []MergeConflict `json:"conflicts"`
+	Success   bool            `json:"success"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// PatchOperation is an RFC 6902 JSON Patch operation
+type PatchOperation struct {
+	Op    string `json:"op"`   // add, remove, replace
+	Path  string `json:"path"` // JSON Pointer, e.g. /blocks/<id>
+	Value *Block `json:"value,omitempty"`
 }
 
 // WebSocketMessage represents a WebSocket message
 type WebSocketMessage struct {
-	Type      string      `json:"type"`      // parse, subscribe, unsubscribe
+	ID        string      `json:"id,omitempty"`        // Chosen by the client and echoed in the responses to the message
+	Type      string      `json:"type"`      // handshake, auth, parse, parse_incremental, subscribe, unsubscribe, resume, snapshot, cursor, lock, unlock, insert_block, update_block, delete_block, move_block, convert_block, operation, crdt_sync, undo, redo, render_block, patch_blocks
 	DocumentID string     `json:"documentId,omitempty"`
 	Content   string      `json:"content,omitempty"`
 	BlockID   string      `json:"blockId,omitempty"`
+	AfterID   string      `json:"afterId,omitempty"`   // Block an inserted or moved block goes after; empty for the start
+	BlockType string      `json:"blockType,omitempty"` // Type a convert_block message turns its block into
+	Edit      *Edit       `json:"edit,omitempty"`
+	Operation TextOperation `json:"operation,omitempty"` // Text operation of an operation message, based on the document at Sequence
+	CRDTOperations []CRDTOperation `json:"crdtOperations,omitempty"` // Operations a crdt_sync message's replica made
+	BlockOperations []BlockOperation `json:"blockOperations,omitempty"` // Operations of a patch_blocks message, applied in order
+	Vector    map[string]uint64 `json:"vector,omitempty"`  // Latest clock a crdt_sync message's replica saw of each site
+	ChangesOnly bool      `json:"changesOnly,omitempty"` // Reply with only the changed blocks
+	Patch     bool        `json:"patch,omitempty"`     // Reply with the changes as a JSON Patch
+	Granularity string    `json:"granularity,omitempty"` // Granularity of the changes: block, line, word
+	User      *User       `json:"user,omitempty"`      // Identity announced to other subscribers on subscribe
+	Cursor    *Cursor     `json:"cursor,omitempty"`    // Caret and selection shared by cursor messages
+	Token     string      `json:"token,omitempty"`     // API key or JWT of an auth message
+	Encoding  string      `json:"encoding,omitempty"`  // Encoding a handshake message asks for: json, msgpack
+	Version   int         `json:"version,omitempty"`   // Protocol version a handshake message's client speaks
+	SessionID string      `json:"sessionId,omitempty"` // Session a resume message picks up, from the connected response
+	Sequence  uint64      `json:"sequence,omitempty"`  // Last change a resume message's client saw, or an operation message's revision
 	Timestamp time.Time   `json:"timestamp"`
 	Data      interface{} `json:"data,omitempty"`
 }
 
+// User identifies a collaborator to the other subscribers of a document
+type User struct {
+	ID    string `json:"id"`
+	Name  string `json:"name,omitempty"`
+	Color string `json:"color,omitempty"` // Suggested color for avatars and cursors
+
+	// Roles the user's credentials grant, for document permissions; kept
+	// from other users
+	Roles []string `json:"-"`
+}
+
+// APIKeyUsage counts the requests made with an API key
+type APIKeyUsage struct {
+	Name              string     `json:"name,omitempty"`
+	UserID            string     `json:"user_id"`
+	Requests          uint64     `json:"requests"`     // Requests let through
+	RateLimited       uint64     `json:"rate_limited"` // Requests refused for exceeding the key's limit
+	RequestsPerMinute int        `json:"requests_per_minute,omitempty"`
+	LastUsed          *time.Time `json:"last_used,omitempty"`
+}
+
+// APIKeyUsageResponse is the usage of one API key or of all of them
+type APIKeyUsageResponse struct {
+	Usage   *APIKeyUsage  `json:"usage,omitempty"`
+	Keys    []APIKeyUsage `json:"keys,omitempty"`
+	Success bool          `json:"success"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// PresenceEvent tells the subscribers of a document that someone joined or left it
+type PresenceEvent struct {
+	DocumentID string `json:"documentId"`
+	Event      string `json:"event"` // joined, left
+	User       User   `json:"user"`
+	Roster     []User `json:"roster"` // Everyone subscribed after the event, in order of joining
+}
+
+// Cursor is a collaborator's caret, and selection if any, within a block
+type Cursor struct {
+	Offset    int    `json:"offset"`              // Caret offset within the block
+	Selection *Range `json:"selection,omitempty"` // Selected range within the block
+}
+
+// Range is the range [Start, End) of offsets
+type Range struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// CursorEvent relays a collaborator's cursor to the other subscribers of a document
+type CursorEvent struct {
+	DocumentID string `json:"documentId"`
+	BlockID    string `json:"blockId"`
+	User       User   `json:"user"`
+	Cursor     Cursor `json:"cursor"`
+}
+
+// EditLock is an advisory lock telling other editors that someone is editing
+// a block or a whole document; edits are not refused because of it
+type EditLock struct {
+	DocumentID string    `json:"documentId"`
+	BlockID    string    `json:"blockId,omitempty"` // Empty for the whole document
+	User       User      `json:"user"`
+	ExpiresAt  time.Time `json:"expiresAt"` // Unless renewed by locking again
+}
+
+// LockEvent tells the subscribers of a document that a lock was taken or
+// renewed, released, or expired
+type LockEvent struct {
+	Event string   `json:"event"` // locked, unlocked, expired
+	Lock  EditLock `json:"lock"`
+}
+
+// SavedEvent tells the subscribers of a document that its content was
+// autosaved
+type SavedEvent struct {
+	DocumentID string `json:"documentId"`
+	Version    int    `json:"version"`  // Revision of the document saved
+	Sequence   uint64 `json:"sequence"` // Latest change included in the save
+}
+
+// SupersededAck tells a client that its parse_incremental message was
+// folded into a later one and will get no response of its own
+type SupersededAck struct {
+	DocumentID string    `json:"documentId,omitempty"`
+	Timestamp  time.Time `json:"timestamp"` // Timestamp of the superseded message
+}
+
+// BlockOperation edits a document one top-level block at a time
+type BlockOperation struct {
+	Type    string `json:"type"`              // insert_block, update_block, delete_block, move_block, convert_block
+	BlockID string `json:"blockId,omitempty"` // Block updated, deleted, moved, or converted
+	AfterID string `json:"afterId,omitempty"` // Block inserted or moved after; empty for the start of the document
+	Content string `json:"content,omitempty"` // Markdown of an inserted or updated block
+
+	// Type a convert_block operation turns its block into: paragraph, h1-h6,
+	// blockquote, unordered_list, ordered_list, checkbox, or fenced_code_block
+	BlockType string `json:"blockType,omitempty"`
+}
+
+// BlockPatchRequest applies block operations to a stored document in order
+type BlockPatchRequest struct {
+	Operations []BlockOperation `json:"operations" binding:"required,min=1"`
+}
+
+// BlockPatchResponse is a document after a block patch, rewritten in
+// canonical markdown
+type BlockPatchResponse struct {
+	Markdown string        `json:"markdown"`
+	Changes  []BlockChange `json:"changes,omitempty"` // Blocks the patch changed, including by rewriting
+	Document *Document     `json:"document,omitempty"`
+	Success  bool          `json:"success"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// BlockPatchEvent tells the subscribers of a document about a block patch,
+// with the blocks it changed and the document's canonical markdown
+type BlockPatchEvent struct {
+	DocumentID string           `json:"documentId"`
+	Operations []BlockOperation `json:"operations"`
+	User       *User            `json:"user,omitempty"` // Who made the change, if subscribed
+	Changes    []BlockChange    `json:"changes"`
+	Markdown   string           `json:"markdown"`
+}
+
+// BlockOperationEvent tells the subscribers of a document about a block
+// operation, with the blocks it changed and their rendered HTML
+type BlockOperationEvent struct {
+	DocumentID string         `json:"documentId"`
+	Operation  BlockOperation `json:"operation"`
+	User       *User          `json:"user,omitempty"` // Who made the change, if subscribed
+	Changes    []BlockChange  `json:"changes"`
+}
+
+// TextOperation is an operational transform operation: components that
+// together walk the whole text, keeping, inserting, or deleting as they go.
+// Lengths count bytes, like the offsets of an Edit.
+type TextOperation []TextComponent
+
+// TextComponent is one step of a TextOperation; exactly one field is set
+type TextComponent struct {
+	Retain int    `json:"retain,omitempty"` // Keep the next bytes
+	Insert string `json:"insert,omitempty"` // Insert text
+	Delete int    `json:"delete,omitempty"` // Delete the next bytes
+}
+
+// OperationEvent tells the subscribers of a document about a text operation,
+// transformed to apply to the document as they last saw it, with the blocks
+// it changed and their rendered HTML
+type OperationEvent struct {
+	DocumentID string        `json:"documentId"`
+	Operation  TextOperation `json:"operation"`
+	User       *User         `json:"user,omitempty"` // Who made the change, if subscribed
+	Changes    []BlockChange `json:"changes"`
+}
+
+// CRDTID identifies a character, or a deletion, of a CRDT document by the
+// replica that made it and its Lamport clock
+type CRDTID struct {
+	Site  string `json:"site"`
+	Clock uint64 `json:"clock"`
+}
+
+// CRDTOperation inserts characters into a CRDT document or deletes one
+type CRDTOperation struct {
+	Type string `json:"type"`           // insert, delete
+	ID   CRDTID `json:"id"`             // Of the first character inserted, numbered on from there, or of the deletion
+	Ref  CRDTID `json:"ref"`            // Character an insert goes after (zero for the start), or that a delete removes
+	Text string `json:"text,omitempty"` // Characters inserted
+}
+
+// CRDTSync carries the CRDT operations of a document a replica lacks, with
+// the blocks they changed and their rendered HTML
+type CRDTSync struct {
+	DocumentID string            `json:"documentId"`
+	Site       string            `json:"site,omitempty"` // The replica's own site, assigned by the server
+	Operations []CRDTOperation   `json:"operations"`
+	Vector     map[string]uint64 `json:"vector"`         // Latest clock seen of each site, to sync from next time
+	User       *User             `json:"user,omitempty"` // Who made the change, if subscribed
+	Changes    []BlockChange     `json:"changes"`
+}
+
+// UndoEvent tells the subscribers of a document that someone undid or redid
+// their last change, with the text operation that did it and the blocks it
+// changed
+type UndoEvent struct {
+	DocumentID string        `json:"documentId"`
+	Operation  TextOperation `json:"operation"`
+	User       User          `json:"user"` // Whose change was undone or redone
+	Changes    []BlockChange `json:"changes"`
+}
+
+// ResumeAck tells a reconnected client it is subscribed to a document again,
+// and whether the changes it missed follow or, being too old to replay, a
+// resync response with the whole document
+type ResumeAck struct {
+	DocumentID string `json:"documentId"`
+	SessionID  string `json:"sessionId"` // Session to resume after the next disconnect
+	User       User   `json:"user"`
+	Roster     []User `json:"roster"`
+	Sequence   uint64 `json:"sequence"` // Latest change of the document
+	Replayed   int    `json:"replayed"` // Missed changes sent as parsed_incremental responses
+	Resync     bool   `json:"resync"`
+}
+
 // WebSocketResponse represents a WebSocket response
 type WebSocketResponse struct {
-	Type      string      `json:"type"`      // parsed, error, connected
+	ID        string      `json:"id,omitempty"`       // ID of the message this responds to; empty for broadcasts
+	Type      string      `json:"type"`      // handshake, authenticated, parsed, parsed_incremental, block_operation, operation, operation_ack, crdt_sync, crdt_update, undo, redo, comment, deleted, saved, superseded, resumed, resync, snapshot, presence, lock, cursor, error, connected
 	Success   bool        `json:"success"`
 	Data      interface{} `json:"data,omitempty"`
 	Error     string      `json:"error,omitempty"`
+	Sequence  uint64      `json:"sequence,omitempty"` // Position of a document change in its history, for resume
 	Timestamp time.Time   `json:"timestamp"`
 }
 
@@ -75,11 +942,12 @@ type NotionBlock struct {
 	Parent   string                 `json:"parent,omitempty"`
 }
 
-// ASTNodeInfo represents information about an AST node
-type ASTNodeInfo struct {
-	Kind     ast.NodeKind `json:"kind"`
-	Type     string       `json:"type"`
-	Text     string       `json:"text,omitempty"`
-	Level    int          `json:"level,omitempty"`
-	Position Position     `json:"position"`
-}
\ No newline at end of file
+// ASTNode is a JSON-serializable goldmark syntax tree node
+type ASTNode struct {
+	Kind       string                 `json:"kind"`                 // goldmark node kind, e.g. Heading, Emphasis
+	Type       string                 `json:"type"`                 // document, block, or inline
+	Attributes map[string]interface{} `json:"attributes,omitempty"` // Kind-specific properties such as level or destination
+	Text       string                 `json:"text,omitempty"`       // Literal text of text, code, and raw HTML nodes
+	Position   Position               `json:"position"`
+	Children   []*ASTNode             `json:"children,omitempty"`
+}
@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+
+	"markdown-parser/internal/models"
+)
+
+// astToJSON converts a goldmark node and its descendants into a typed tree
+func astToJSON(node ast.Node, source []byte) *models.ASTNode {
+	return convertNode(node, source, newLineIndex(source))
+}
+
+// convertNode converts one node, resolving line numbers against the line index
+func convertNode(node ast.Node, source []byte, lines *lineIndex) *models.ASTNode {
+	start, end := nodeSpan(node)
+	if node.Kind() == ast.KindDocument {
+		start, end = 0, len(source)
+	}
+
+	result := &models.ASTNode{
+		Kind:       node.Kind().String(),
+		Type:       nodeTypeName(node),
+		Attributes: nodeAttributes(node, source),
+		Text:       nodeLiteral(node, source),
+		Position: models.Position{
+			Start: start,
+			End:   end,
+		},
+	}
+	lines.locate(&result.Position)
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		result.Children = append(result.Children, convertNode(child, source, lines))
+	}
+
+	return result
+}
+
+// nodeTypeName names a node's goldmark node type
+func nodeTypeName(node ast.Node) string {
+	switch node.Type() {
+	case ast.TypeDocument:
+		return "document"
+	case ast.TypeBlock:
+		return "block"
+	default:
+		return "inline"
+	}
+}
+
+// nodeLiteral returns the source text carried directly by leaf and raw nodes
+func nodeLiteral(node ast.Node, source []byte) string {
+	switch n := node.(type) {
+	case *ast.Text:
+		return string(n.Segment.Value(source))
+	case *ast.String:
+		return string(n.Value)
+	case *ast.RawHTML:
+		var buf bytes.Buffer
+		for i := 0; i < n.Segments.Len(); i++ {
+			segment := n.Segments.At(i)
+			buf.Write(segment.Value(source))
+		}
+		return buf.String()
+	case *ast.AutoLink:
+		return string(n.Label(source))
+	}
+
+	if node.Type() == ast.TypeBlock && node.IsRaw() {
+		var buf bytes.Buffer
+		lines := node.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			buf.Write(segment.Value(source))
+		}
+		return buf.String()
+	}
+	return ""
+}
+
+// nodeAttributes collects the kind-specific properties of a node
+func nodeAttributes(node ast.Node, source []byte) map[string]interface{} {
+	attrs := make(map[string]interface{})
+
+	switch n := node.(type) {
+	case *ast.Heading:
+		attrs["level"] = n.Level
+	case *ast.List:
+		attrs["ordered"] = n.IsOrdered()
+		attrs["marker"] = string(n.Marker)
+		attrs["tight"] = n.IsTight
+		if n.IsOrdered() {
+			attrs["start"] = n.Start
+		}
+	case *ast.FencedCodeBlock:
+		if language := n.Language(source); language != nil {
+			attrs["language"] = string(language)
+		}
+	case *ast.Emphasis:
+		attrs["level"] = n.Level
+	case *ast.Link:
+		attrs["destination"] = string(n.Destination)
+		if len(n.Title) > 0 {
+			attrs["title"] = string(n.Title)
+		}
+	case *ast.Image:
+		attrs["destination"] = string(n.Destination)
+		if len(n.Title) > 0 {
+			attrs["title"] = string(n.Title)
+		}
+	case *ast.AutoLink:
+		attrs["url"] = string(n.URL(source))
+	case *ast.Text:
+		if n.SoftLineBreak() {
+			attrs["softLineBreak"] = true
+		}
+		if n.HardLineBreak() {
+			attrs["hardLineBreak"] = true
+		}
+	case *east.TaskCheckBox:
+		attrs["checked"] = n.IsChecked
+	case *east.Table:
+		alignments := make([]string, len(n.Alignments))
+		for i, alignment := range n.Alignments {
+			alignments[i] = alignment.String()
+		}
+		attrs["alignments"] = alignments
+	case *east.TableCell:
+		attrs["alignment"] = n.Alignment.String()
+	case *east.FootnoteLink:
+		attrs["index"] = n.Index
+	case *east.Footnote:
+		attrs["ref"] = string(n.Ref)
+		attrs["index"] = n.Index
+	case *Callout:
+		attrs["variant"] = n.Variant
+		if n.Title != "" {
+			attrs["title"] = n.Title
+		}
+	case *Container:
+		attrs["name"] = n.Name
+		if n.Title != "" {
+			attrs["title"] = n.Title
+		}
+	case *WikiLink:
+		attrs["target"] = n.Target
+		attrs["destination"] = n.Destination
+		if n.Alias != "" {
+			attrs["alias"] = n.Alias
+		}
+	case *MathInline:
+		attrs["display"] = n.Display
+	}
+
+	// Attributes set on the node itself, such as heading IDs
+	for _, attr := range node.Attributes() {
+		switch value := attr.Value.(type) {
+		case []byte:
+			attrs[string(attr.Name)] = string(value)
+		default:
+			attrs[string(attr.Name)] = value
+		}
+	}
+
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
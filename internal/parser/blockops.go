@@ -0,0 +1,268 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"markdown-parser/internal/models"
+)
+
+// ErrBlockOperation is returned by PatchBlocks when one of its operations
+// cannot be applied to the document
+var ErrBlockOperation = errors.New("invalid block operation")
+
+// ApplyBlockOperation inserts, updates, deletes, moves, or converts a
+// top-level block and returns the updated document like ApplyEdit. The
+// operation becomes a single edit of the source, so only the blocks around
+// it are reparsed.
+func (d *Document) ApplyBlockOperation(op models.BlockOperation) (*models.ParseResponse, error) {
+	head, segments, ids := d.segments()
+	find := func(id string) (int, error) {
+		for i, segmentID := range ids {
+			if segmentID == id {
+				return i, nil
+			}
+		}
+		return -1, fmt.Errorf("block %q is not a top-level block of the document", id)
+	}
+	// after returns the segment an inserted block goes after, -1 for the start
+	after := func() (int, error) {
+		if op.AfterID == "" {
+			return -1, nil
+		}
+		return find(op.AfterID)
+	}
+	body := strings.TrimRight(op.Content, "\r\n")
+
+	switch op.Type {
+	case "insert_block":
+		if strings.TrimSpace(body) == "" {
+			return nil, fmt.Errorf("content is required to insert a block")
+		}
+		at, err := after()
+		if err != nil {
+			return nil, err
+		}
+		segments = insertSegment(segments, at, body)
+
+	case "update_block", "convert_block":
+		i, err := find(op.BlockID)
+		if err != nil {
+			return nil, err
+		}
+		if op.Type == "convert_block" {
+			block, _ := d.Block(op.BlockID)
+			if body, err = convertBlock(block, op.BlockType); err != nil {
+				return nil, err
+			}
+		}
+		if strings.TrimSpace(body) == "" {
+			return nil, fmt.Errorf("content is required to update a block; use delete_block to remove it")
+		}
+		// Keep the blank lines separating the block from the next
+		trimmed := strings.TrimRight(segments[i], "\r\n")
+		segments[i] = body + "\n" + strings.TrimPrefix(strings.TrimPrefix(segments[i][len(trimmed):], "\r"), "\n")
+
+	case "delete_block":
+		i, err := find(op.BlockID)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments[:i], segments[i+1:]...)
+
+	case "move_block":
+		i, err := find(op.BlockID)
+		if err != nil {
+			return nil, err
+		}
+		at, err := after()
+		if err != nil {
+			return nil, err
+		}
+		if at == i {
+			return nil, fmt.Errorf("block %q cannot be moved after itself", op.BlockID)
+		}
+		moved := strings.TrimRight(segments[i], "\r\n")
+		segments = append(segments[:i], segments[i+1:]...)
+		if at > i {
+			at--
+		}
+		segments = insertSegment(segments, at, moved)
+
+	default:
+		return nil, fmt.Errorf("unknown block operation %q", op.Type)
+	}
+
+	content := head + strings.Join(segments, "")
+	return d.ApplyEdit(DiffEdit(d.content, content))
+}
+
+// PatchBlocks applies block operations in order to a copy of the document,
+// rewrites the result in canonical markdown, and applies that to the
+// document as one edit, so the changes it returns span every operation.
+// Each operation names blocks as they are after the operations before it;
+// none is applied unless all of them succeed.
+func (d *Document) PatchBlocks(ops []models.BlockOperation) (*models.ParseResponse, error) {
+	scratch, err := d.parser.NewDocument(d.content)
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range ops {
+		if _, err := scratch.ApplyBlockOperation(op); err != nil {
+			return nil, fmt.Errorf("%w %d: %v", ErrBlockOperation, i+1, err)
+		}
+	}
+	canonical, err := d.parser.Format(scratch.Content(), d.parser.config.FormatWrapWidth)
+	if err != nil {
+		return nil, err
+	}
+	return d.ApplyEdit(DiffEdit(d.content, canonical))
+}
+
+// convertBlock rewrites a block's markdown as another type of block,
+// keeping its text and inline formatting
+func convertBlock(block *models.Block, blockType string) (string, error) {
+	lines := blockLines(block)
+	if lines == nil {
+		return "", fmt.Errorf("a %s block cannot be converted", block.Type)
+	}
+
+	var out strings.Builder
+	switch blockType {
+	case "paragraph":
+		out.WriteString(strings.Join(lines, "\n"))
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		level := int(blockType[1] - '0')
+		out.WriteString(strings.Repeat("#", level) + " " + strings.Join(lines, " "))
+	case "blockquote":
+		for i, line := range lines {
+			if i > 0 {
+				out.WriteByte('\n')
+			}
+			out.WriteString(strings.TrimRight("> "+line, " "))
+		}
+	case "unordered_list", "ordered_list", "checkbox":
+		n := 0
+		for _, line := range lines {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			if n++; n > 1 {
+				out.WriteByte('\n')
+			}
+			switch blockType {
+			case "unordered_list":
+				out.WriteString("- " + line)
+			case "ordered_list":
+				out.WriteString(strconv.Itoa(n) + ". " + line)
+			default:
+				out.WriteString("- [ ] " + line)
+			}
+		}
+	case "fenced_code_block":
+		code := strings.Join(lines, "\n")
+		fence := "```"
+		for strings.Contains(code, fence) {
+			fence += "`"
+		}
+		out.WriteString(fence + "\n" + code + "\n" + fence)
+	default:
+		return "", fmt.Errorf("cannot convert a block to %q; use paragraph, h1-h6, blockquote, unordered_list, ordered_list, checkbox, or fenced_code_block", blockType)
+	}
+	return out.String(), nil
+}
+
+var (
+	// atxHeadingPattern matches the markup around the text of an ATX heading
+	atxHeadingPattern = regexp.MustCompile(`^ {0,3}#{1,6}(?:[ \t]+|$)|[ \t]+#+[ \t]*$`)
+
+	// quoteMarkerPattern matches the marker starting a blockquote line
+	quoteMarkerPattern = regexp.MustCompile(`^ {0,3}> ?`)
+
+	// listMarkerPattern matches the marker and checkbox starting a list item
+	listMarkerPattern = regexp.MustCompile(`^[ \t]*(?:[-*+]|\d{1,9}[.)])(?:[ \t]+\[[ xX]\])?(?:[ \t]+|$)`)
+)
+
+// blockLines returns the lines of a block's text without its block markup,
+// or nil for blocks whose text cannot be taken out of them
+func blockLines(block *models.Block) []string {
+	content := strings.TrimRight(strings.ReplaceAll(block.Content, "\r\n", "\n"), "\n")
+	lines := strings.Split(content, "\n")
+
+	switch block.Type {
+	case "paragraph":
+		for i, line := range lines {
+			lines[i] = strings.TrimSpace(line)
+		}
+	case "h1", "h2", "h3", "h4", "h5", "h6", "heading":
+		if setextUnderlinePattern.MatchString(lines[len(lines)-1]) && len(lines) > 1 {
+			lines = lines[:len(lines)-1]
+		}
+		for i, line := range lines {
+			lines[i] = strings.TrimSpace(atxHeadingPattern.ReplaceAllString(line, ""))
+		}
+	case "blockquote":
+		for i, line := range lines {
+			lines[i] = quoteMarkerPattern.ReplaceAllString(line, "")
+		}
+	case "unordered_list", "ordered_list", "list_item", "checkbox":
+		for i, line := range lines {
+			lines[i] = strings.TrimSpace(listMarkerPattern.ReplaceAllString(line, ""))
+		}
+	case "code_block", "fenced_code_block":
+		return strings.Split(strings.TrimSuffix(block.Code, "\n"), "\n")
+	default:
+		return nil
+	}
+	return lines
+}
+
+// segments splits the content into what precedes the first top-level block
+// and one segment per top-level block running to the start of the next,
+// with the IDs of the blocks
+func (d *Document) segments() (string, []string, []string) {
+	var starts []int
+	var ids []string
+	for _, c := range d.chunks {
+		for _, cb := range c.blocks {
+			if cb.block.ParentID == "" {
+				starts = append(starts, c.start)
+				ids = append(ids, cb.block.ID)
+				break
+			}
+		}
+	}
+	if len(starts) == 0 {
+		return d.content, nil, nil
+	}
+
+	segments := make([]string, len(starts))
+	for i, start := range starts {
+		end := len(d.content)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		segments[i] = d.content[start:end]
+	}
+	return d.content[:starts[0]], segments, ids
+}
+
+// insertSegment inserts a block after segment at (-1 for the start),
+// separated from its neighbours by blank lines
+func insertSegment(segments []string, at int, body string) []string {
+	segment := body + "\n\n"
+	if at == len(segments)-1 {
+		segment = body + "\n"
+		if at >= 0 {
+			prev := strings.TrimRight(segments[at], "\r\n")
+			segments[at] = prev + "\n\n"
+		}
+	}
+	segments = append(segments, "")
+	copy(segments[at+2:], segments[at+1:])
+	segments[at+1] = segment
+	return segments
+}
@@ -0,0 +1,27 @@
+package parser
+
+import (
+	"strings"
+
+	"markdown-parser/internal/models"
+)
+
+// RenderBlock renders one block's markdown on its own with opts, returning
+// a copy of the block with the new HTML, so clients of long documents can
+// render blocks as they come into view. Headings keep their anchor ID;
+// otherwise constructs that depend on the rest of the document, such as
+// reference links and footnotes, render as in a document of the block alone.
+func (p *MarkdownParser) RenderBlock(block *models.Block, opts ParseOptions) (*models.Block, error) {
+	opts.Format, opts.Stats, opts.Lint = "", false, false
+	response, err := p.ParseWithOptions(block.Content, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := *block
+	rendered.HTML = response.HTML
+	if block.Slug != "" && len(response.Tree) > 0 && response.Tree[0].Slug != "" && response.Tree[0].Slug != block.Slug {
+		rendered.HTML = strings.Replace(rendered.HTML, `id="`+response.Tree[0].Slug+`"`, `id="`+block.Slug+`"`, 1)
+	}
+	return &rendered, nil
+}
@@ -0,0 +1,222 @@
+package parser
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+)
+
+// cacheKey identifies a parse result: the content hash plus every option
+// that changes the output
+type cacheKey struct {
+	content   [sha256.Size]byte
+	config    string // Fingerprint of the parser's configuration
+	settings  renderSettings
+	format    string
+	wrapWidth int
+	stats     bool
+	lint      bool
+}
+
+// cacheKey returns the key of the result of parsing content with opts
+func (p *MarkdownParser) cacheKey(content string, opts ParseOptions) cacheKey {
+	return cacheKey{
+		content:   sha256.Sum256([]byte(content)),
+		config:    p.fingerprint,
+		settings:  p.resolveSettings(opts),
+		format:    opts.Format,
+		wrapWidth: p.wrapWidth(opts),
+		stats:     opts.Stats,
+		lint:      opts.Lint,
+	}
+}
+
+// configFingerprint hashes a parser configuration in full, so results of
+// parsers configured differently, such as instances sharing a cache while
+// a config change rolls out, never share a key or an entity tag
+func configFingerprint(config configs.ParserConfig) string {
+	// Maps encode with sorted keys, so equal configurations hash equally
+	data, err := json.Marshal(config)
+	if err != nil {
+		data = fmt.Appendf(nil, "%+v", config)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// ETag returns an HTTP entity tag for the result of parsing content with
+// opts, without parsing it: results are equal when their tags are
+func (p *MarkdownParser) ETag(content string, opts ParseOptions) string {
+	return `"` + p.cacheKey(content, opts).sharedKey() + `"`
+}
+
+// cacheEntry is one cached parse result
+type cacheEntry struct {
+	key      cacheKey
+	response *models.ParseResponse
+	expires  time.Time // Zero when entries never expire
+}
+
+// parseCache is a least-recently-used cache of parse results, so repeated
+// parses of the same content skip goldmark entirely. A nil *parseCache is
+// a disabled cache.
+type parseCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[cacheKey]*list.Element
+	order    *list.List // Front is the most recently used
+
+	hits, misses, evictions uint64
+}
+
+// newParseCache creates a cache holding up to capacity results for ttl (0
+// keeps them until evicted), or nil when capacity disables caching
+func newParseCache(capacity int, ttl time.Duration) *parseCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &parseCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[cacheKey]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// get returns a copy of the cached result for key, if present and fresh
+func (c *parseCache) get(key cacheKey) (*models.ParseResponse, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if ok {
+		entry := element.Value.(*cacheEntry)
+		if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+			c.remove(element)
+			ok = false
+		} else {
+			c.order.MoveToFront(element)
+			c.hits++
+			response := *entry.response
+			return &response, true
+		}
+	}
+
+	c.misses++
+	return nil, false
+}
+
+// add stores a copy of response under key, evicting the least recently used result when full
+func (c *parseCache) add(key cacheKey, response *models.ParseResponse) {
+	if c == nil {
+		return
+	}
+
+	// Callers may set fields such as Changes on their result; the cache keeps its own
+	stored := *response
+	entry := &cacheEntry{key: key, response: &stored}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+// remove drops an entry; the caller must hold mu
+func (c *parseCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// stats reports the cache's size and hit counters
+func (c *parseCache) stats() models.CacheStats {
+	if c == nil {
+		return models.CacheStats{}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return models.CacheStats{
+		Enabled:   true,
+		Entries:   c.order.Len(),
+		Capacity:  c.capacity,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+	}
+}
+
+// SharedCache holds parse results shared between instances, such as in
+// Redis, behind each instance's own cache
+type SharedCache interface {
+	Get(key string) ([]byte, bool, error)
+	Set(key string, value []byte, ttl time.Duration) error
+}
+
+// sharedKey is the shared cache key of a parse result, naming the content
+// hash and options as a string
+func (k cacheKey) sharedKey() string {
+	options := sha256.Sum256(fmt.Appendf(nil, "%s|%+v|%s|%d|%t|%t", k.config, k.settings, k.format, k.wrapWidth, k.stats, k.lint))
+	return hex.EncodeToString(k.content[:]) + ":" + hex.EncodeToString(options[:8])
+}
+
+// getShared returns the result for key from the shared cache, if present.
+// Failures of the shared cache are logged and treated as misses, so a
+// Redis outage only costs parse time.
+func (p *MarkdownParser) getShared(key cacheKey) (*models.ParseResponse, bool) {
+	data, ok, err := p.shared.Get(key.sharedKey())
+	if err != nil {
+		log.Printf("WARN: Reading the shared parse cache: %v", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	var response models.ParseResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		log.Printf("WARN: Decoding a shared parse result: %v", err)
+		return nil, false
+	}
+	return &response, true
+}
+
+// addShared stores the result for key in the shared cache for the parse
+// cache's TTL
+func (p *MarkdownParser) addShared(key cacheKey, response *models.ParseResponse) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("WARN: Encoding a shared parse result: %v", err)
+		return
+	}
+	ttl := time.Duration(p.config.ParseCacheTTLSeconds) * time.Second
+	if err := p.shared.Set(key.sharedKey(), data, ttl); err != nil {
+		log.Printf("WARN: Writing the shared parse cache: %v", err)
+	}
+}
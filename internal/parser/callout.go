@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindCallout is the node kind for callout/admonition blocks
+var KindCallout = ast.NewNodeKind("Callout")
+
+// Callout represents a GitHub/Obsidian-style > [!NOTE] blockquote
+type Callout struct {
+	ast.BaseBlock
+	Variant string // Lowercased marker, e.g. note, warning, tip
+	Title   string // Optional custom title following the marker
+}
+
+// Kind implements ast.Node.Kind
+func (n *Callout) Kind() ast.NodeKind {
+	return KindCallout
+}
+
+// Dump implements ast.Node.Dump
+func (n *Callout) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Variant": n.Variant,
+		"Title":   n.Title,
+	}, nil)
+}
+
+// calloutMarker matches the [!TYPE] marker opening a callout, with an optional title
+var calloutMarker = regexp.MustCompile(`^\[!([A-Za-z]+)\][+-]?[ \t]*(.*)$`)
+
+// calloutTransformer converts blockquotes starting with a [!TYPE] marker into callouts
+type calloutTransformer struct{}
+
+// Transform implements parser.ASTTransformer
+func (t *calloutTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var quotes []*ast.Blockquote
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if quote, ok := n.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, quote)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, quote := range quotes {
+		t.transformBlockquote(quote, source)
+	}
+}
+
+// transformBlockquote replaces a single blockquote with a callout if it carries a marker
+func (t *calloutTransformer) transformBlockquote(quote *ast.Blockquote, source []byte) {
+	paragraph, ok := quote.FirstChild().(*ast.Paragraph)
+	if !ok || paragraph.Lines().Len() == 0 {
+		return
+	}
+
+	firstLine := paragraph.Lines().At(0)
+	match := calloutMarker.FindSubmatch(bytes.TrimRight(firstLine.Value(source), " \t\r\n"))
+	if match == nil {
+		return
+	}
+
+	callout := &Callout{
+		Variant: strings.ToLower(string(match[1])),
+		Title:   string(match[2]),
+	}
+
+	// Drop the inline nodes making up the marker line
+	for child := paragraph.FirstChild(); child != nil; {
+		next := child.NextSibling()
+		if start, _ := nodeSpan(child); start >= firstLine.Stop {
+			break
+		}
+		paragraph.RemoveChild(paragraph, child)
+		child = next
+	}
+
+	for child := quote.FirstChild(); child != nil; {
+		next := child.NextSibling()
+		if child != paragraph || paragraph.HasChildren() {
+			callout.AppendChild(callout, child)
+		}
+		child = next
+	}
+
+	quote.Parent().ReplaceChild(quote.Parent(), quote, callout)
+}
+
+// calloutHTMLRenderer renders callouts as styled divs
+type calloutHTMLRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer.RegisterFuncs
+func (r *calloutHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindCallout, r.renderCallout)
+}
+
+func (r *calloutHTMLRenderer) renderCallout(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*Callout)
+	if !entering {
+		_, _ = w.WriteString("</div>\n")
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString(`<div class="callout callout-`)
+	_, _ = w.Write(util.EscapeHTML([]byte(n.Variant)))
+	_, _ = w.WriteString(`"`)
+	if n.Attributes() != nil {
+		html.RenderAttributes(w, n, html.GlobalAttributeFilter)
+	}
+	_, _ = w.WriteString(">\n")
+	if n.Title != "" {
+		_, _ = w.WriteString(`<p class="callout-title">`)
+		_, _ = w.Write(util.EscapeHTML([]byte(n.Title)))
+		_, _ = w.WriteString("</p>\n")
+	}
+
+	return ast.WalkContinue, nil
+}
+
+// calloutExtension adds > [!NOTE] callout support
+type calloutExtension struct{}
+
+// CalloutExtension is a goldmark extension for GitHub/Obsidian-style callouts
+var CalloutExtension = &calloutExtension{}
+
+// Extend implements goldmark.Extender
+func (e *calloutExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&calloutTransformer{}, 500),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&calloutHTMLRenderer{}, 500),
+	))
+}
@@ -0,0 +1,56 @@
+package parser
+
+import (
+	"markdown-parser/internal/models"
+	"markdown-parser/pkg/diff"
+)
+
+// CompareOptions adjusts how two versions of a document are compared
+type CompareOptions struct {
+	// IgnoreWhitespace hides changes to trailing whitespace, line endings,
+	// and spacing between words, see diff.NormalizeWhitespace
+	IgnoreWhitespace bool
+
+	// Granularity of the changes reported, see diff.GranularityBlock and
+	// friends; by default both block and line changes are reported
+	Granularity string
+}
+
+// Compare reports the changes between two versions of a document, without
+// any state carried over from earlier parses
+func (p *MarkdownParser) Compare(oldContent, newContent string, opts CompareOptions) (*models.DiffResponse, error) {
+	oldResult, err := p.Parse(oldContent)
+	if err != nil {
+		return nil, err
+	}
+	newResult, err := p.Parse(newContent)
+	if err != nil {
+		return nil, err
+	}
+
+	granularities := []string{opts.Granularity}
+	if opts.Granularity == "" {
+		granularities = []string{diff.GranularityBlock, diff.GranularityLine}
+	}
+
+	response := &models.DiffResponse{Success: true}
+	for _, granularity := range granularities {
+		differ := diff.NewDiffer()
+		differ.SetIgnoreWhitespace(opts.IgnoreWhitespace)
+		differ.Diff(diff.GranularityBlock, oldContent, oldResult.Blocks)
+		changes, err := differ.Diff(granularity, newContent, newResult.Blocks)
+		if err != nil {
+			return nil, err
+		}
+
+		switch granularity {
+		case diff.GranularityLine:
+			response.Lines = changes.Lines
+		case diff.GranularityWord:
+			response.Words = changes.Words
+		default:
+			response.Changes = changes.Blocks
+		}
+	}
+	return response, nil
+}
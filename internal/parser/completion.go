@@ -0,0 +1,62 @@
+package parser
+
+import "strings"
+
+// closingPairs are inline delimiters closed by SuggestCompletion, longest
+// first so ** is not taken for two *
+var closingPairs = []struct{ open, close string }{
+	{"[[", "]]"},
+	{"**", "**"},
+	{"~~", "~~"},
+	{"`", "`"},
+}
+
+// SuggestCompletion returns the text an editor could insert at byte offset
+// cursor of line to finish the syntax typed before it, or "" when there is
+// nothing to finish: the space after a block marker such as "##" or "-",
+// the rest of a task checkbox, the closing fence of a code block, or the
+// closing delimiter of an unfinished inline span.
+func (p *MarkdownParser) SuggestCompletion(line string, cursor int) string {
+	before := line[:cursor]
+	trimmed := strings.TrimLeft(before, " \t")
+	after := line[cursor:]
+
+	// Block markers typed up to the cursor
+	if after == "" {
+		switch {
+		case trimmed == "":
+			return ""
+		case strings.Trim(trimmed, "#") == "" && len(trimmed) <= 6:
+			return " "
+		case trimmed == "-" || trimmed == "*" || trimmed == "+" || trimmed == ">":
+			return " "
+		case trimmed == "- [" || trimmed == "* [":
+			return " ] "
+		case trimmed == "- [ " || trimmed == "- [x" || trimmed == "- [X" || trimmed == "* [ " || trimmed == "* [x":
+			return "] "
+		case strings.HasSuffix(trimmed, ".") && strings.Trim(trimmed[:len(trimmed)-1], "0123456789") == "" && len(trimmed) > 1:
+			return " "
+		case strings.HasPrefix(trimmed, "```") && !strings.Contains(trimmed[3:], "`"):
+			return "\n```"
+		case trimmed == ":::" || strings.HasPrefix(trimmed, "::: "):
+			return "\n:::"
+		}
+	}
+
+	// Inline spans opened before the cursor and not closed on the line
+	if strings.HasPrefix(trimmed, "```") {
+		return ""
+	}
+	for _, pair := range closingPairs {
+		if pair.open == pair.close {
+			if strings.Count(before, pair.open)%2 == 1 && !strings.Contains(after, pair.close) {
+				return pair.close
+			}
+			continue
+		}
+		if open := strings.LastIndex(before, pair.open); open >= 0 && !strings.Contains(before[open:], pair.close) && !strings.Contains(after, pair.close) {
+			return pair.close
+		}
+	}
+	return ""
+}
@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindContainer is the node kind for ::: custom container blocks
+var KindContainer = ast.NewNodeKind("Container")
+
+// Container represents a fenced ::: name block holding arbitrary markdown
+type Container struct {
+	ast.BaseBlock
+	Name  string // Container name, e.g. tip, details
+	Title string // Optional text following the name
+	fence int    // Length of the opening colon run
+}
+
+// Kind implements ast.Node.Kind
+func (n *Container) Kind() ast.NodeKind {
+	return KindContainer
+}
+
+// Dump implements ast.Node.Dump
+func (n *Container) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Name":  n.Name,
+		"Title": n.Title,
+	}, nil)
+}
+
+// containerParser parses ::: fenced container blocks
+type containerParser struct{}
+
+func (b *containerParser) Trigger() []byte {
+	return []byte{':'}
+}
+
+func (b *containerParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, segment := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 {
+		return nil, parser.NoChildren
+	}
+
+	fence := colonRun(line[pos:])
+	if fence < 3 {
+		return nil, parser.NoChildren
+	}
+
+	name, title, _ := bytes.Cut(bytes.TrimSpace(line[pos+fence:]), []byte(" "))
+	if len(name) == 0 {
+		return nil, parser.NoChildren
+	}
+
+	skipLine(reader, line, segment)
+	return &Container{
+		Name:  string(name),
+		Title: string(bytes.TrimSpace(title)),
+		fence: fence,
+	}, parser.HasChildren
+}
+
+func (b *containerParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	trimmed := util.TrimLeftSpace(line)
+
+	fence := colonRun(trimmed)
+	if fence >= node.(*Container).fence && util.IsBlank(trimmed[fence:]) {
+		skipLine(reader, line, segment)
+		return parser.Close
+	}
+
+	return parser.Continue | parser.HasChildren
+}
+
+func (b *containerParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *containerParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *containerParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// skipLine advances the reader to the end of the current line, leaving its newline
+func skipLine(reader text.Reader, line []byte, segment text.Segment) {
+	newline := 1
+	if len(line) == 0 || line[len(line)-1] != '\n' {
+		newline = 0
+	}
+	reader.Advance(segment.Len() - newline)
+}
+
+// colonRun returns the number of leading ':' characters in a line
+func colonRun(line []byte) int {
+	i := 0
+	for i < len(line) && line[i] == ':' {
+		i++
+	}
+	return i
+}
+
+// containerHTMLRenderer renders containers as classed divs
+type containerHTMLRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer.RegisterFuncs
+func (r *containerHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindContainer, r.renderContainer)
+}
+
+func (r *containerHTMLRenderer) renderContainer(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*Container)
+	if !entering {
+		_, _ = w.WriteString("</div>\n")
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString(`<div class="container-`)
+	_, _ = w.Write(util.EscapeHTML([]byte(n.Name)))
+	_, _ = w.WriteString(`"`)
+	if n.Attributes() != nil {
+		html.RenderAttributes(w, n, html.GlobalAttributeFilter)
+	}
+	_, _ = w.WriteString(">\n")
+	if n.Title != "" {
+		_, _ = w.WriteString(`<p class="container-title">`)
+		_, _ = w.Write(util.EscapeHTML([]byte(n.Title)))
+		_, _ = w.WriteString("</p>\n")
+	}
+
+	return ast.WalkContinue, nil
+}
+
+// containerExtension adds ::: custom container support
+type containerExtension struct{}
+
+// ContainerExtension is a goldmark extension for ::: name fenced containers
+var ContainerExtension = &containerExtension{}
+
+// Extend implements goldmark.Extender
+func (e *containerExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithBlockParsers(
+		util.Prioritized(&containerParser{}, 720),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&containerHTMLRenderer{}, 500),
+	))
+}
@@ -0,0 +1,126 @@
+package parser
+
+import (
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// Markdown dialects supported by ParserConfig.Dialect. An empty dialect
+// enables every extension this parser ships with.
+const (
+	DialectCommonMark = "commonmark" // Strict CommonMark, no extensions
+	DialectGFM        = "gfm"        // GitHub: tables, task lists, footnotes, math, alerts
+	DialectNotion     = "notion"     // Notion: callouts, toggles, highlights, page links
+)
+
+// isDialect reports whether name is a known dialect
+func isDialect(name string) bool {
+	switch name {
+	case DialectCommonMark, DialectGFM, DialectNotion:
+		return true
+	}
+	return false
+}
+
+// dialectExtensions returns the goldmark extensions enabled for the settings' dialect
+func dialectExtensions(settings renderSettings) []goldmark.Extender {
+	extensions := dialectSyntax(settings)
+	if settings.sourcePositions {
+		extensions = append(extensions, SourcePosExtension)
+	}
+	return extensions
+}
+
+// dialectSyntax returns the syntax extensions of the settings' dialect
+func dialectSyntax(settings renderSettings) []goldmark.Extender {
+	highlighter := highlighting.NewHighlighting( // Syntax highlighting for fenced code
+		highlighting.WithStyle(settings.highlightTheme),
+		highlighting.WithFormatOptions(
+			chromahtml.WithLineNumbers(settings.lineNumbers),
+		),
+	)
+
+	switch settings.dialect {
+	case DialectCommonMark:
+		return nil
+	case DialectGFM:
+		extensions := gfmExtensions(settings)
+		if settings.footnotes {
+			extensions = append(extensions, extension.Footnote)
+		}
+		return append(extensions,
+			Math,
+			FrontmatterExtension,
+			CalloutExtension,
+			highlighter,
+		)
+	case DialectNotion:
+		return append(gfmExtensions(settings),
+			Math,
+			CalloutExtension,
+			MarkExtension,
+			ContainerExtension,
+			NewWikilinkExtension(settings.wikilinkTemplate),
+			highlighter,
+		)
+	default:
+		extensions := gfmExtensions(settings)
+		if settings.footnotes {
+			extensions = append(extensions, extension.Footnote)
+		}
+		if settings.definitionLists {
+			extensions = append(extensions, extension.DefinitionList)
+		}
+		return append(extensions,
+			Math,                 // $inline$ and $$ display math
+			FrontmatterExtension, // YAML frontmatter
+			CalloutExtension,     // > [!NOTE] callouts
+			MarkExtension,        // ==highlighted== text
+			NewScriptExtension(settings.subscript, settings.superscript), // ~sub~ and ^sup^
+			ContainerExtension, // ::: name custom containers
+			NewWikilinkExtension(settings.wikilinkTemplate), // [[Page]] wikilinks
+			highlighter,
+		)
+	}
+}
+
+// gfmExtensions returns the GitHub Flavored Markdown extensions enabled by the
+// feature flags. Tables and autolinks can be toggled on their own; EnableGFM
+// covers the rest of GFM (strikethrough and task lists).
+func gfmExtensions(settings renderSettings) []goldmark.Extender {
+	var extensions []goldmark.Extender
+	if settings.gfm {
+		extensions = append(extensions, extension.Strikethrough, extension.TaskList)
+	}
+	if settings.tables {
+		extensions = append(extensions, extension.Table)
+	}
+	if settings.autolink {
+		extensions = append(extensions, extension.Linkify)
+	}
+	return extensions
+}
+
+// dialectOptions returns parser and renderer options for the settings' dialect.
+// CommonMark output follows the spec exactly: no heading IDs and soft line breaks.
+func dialectOptions(settings renderSettings) ([]parser.Option, []renderer.Option) {
+	rendererOptions := []renderer.Option{
+		html.WithXHTML(),  // Use XHTML-style output
+		html.WithUnsafe(), // Allow raw HTML
+	}
+	if settings.dialect == DialectCommonMark {
+		return nil, rendererOptions
+	}
+
+	parserOptions := []parser.Option{
+		parser.WithAutoHeadingID(), // Auto-generate heading IDs
+	}
+	rendererOptions = append(rendererOptions, html.WithHardWraps()) // Convert line breaks to <br>
+
+	return parserOptions, rendererOptions
+}
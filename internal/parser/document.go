@@ -0,0 +1,618 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/pkg/diff"
+)
+
+var (
+	// referencePattern matches link reference and footnote definitions, which
+	// change how the rest of the document parses and force a full reparse
+	referencePattern = regexp.MustCompile(`(?m)^ {0,3}\[[^\]]+\]:`)
+
+	// closingFencePatterns match the closing line of each fenced construct
+	closingFencePatterns = map[ast.NodeKind]*regexp.Regexp{
+		ast.KindFencedCodeBlock: regexp.MustCompile("^ {0,3}(`{3,}|~{3,})[ \t]*\r?$"),
+		KindMathBlock:           regexp.MustCompile(`^ {0,3}\$\$[ \t]*\r?$`),
+		KindContainer:           regexp.MustCompile(`^ {0,3}:{3,}[ \t]*\r?$`),
+		KindFrontmatter:         regexp.MustCompile(`^---[ \t]*\r?$`),
+	}
+
+	// setextUnderlinePattern matches the underline of a setext heading
+	setextUnderlinePattern = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+
+	// dedupeSuffixPattern matches the -N suffix added to repeated heading slugs
+	dedupeSuffixPattern = regexp.MustCompile(`-\d+$`)
+)
+
+// Document is a parsed markdown document that can be edited in place. An
+// edit reparses only the top-level blocks around the changed range and
+// splices them back; blocks and HTML elsewhere are reused and only shifted.
+// Constructs that affect the whole document (link reference definitions,
+// footnotes, raw HTML, unclosed fences, colliding heading slugs) fall back
+// to a full parse. A Document is not safe for concurrent use.
+type Document struct {
+	parser    *MarkdownParser
+	content   string
+	chunks    []*chunk
+	footnotes map[string]string
+	global    bool   // Document has references, footnotes, raw HTML, or out-of-order nodes, or HTML carries source positions; every edit reparses fully
+	html      string // Whole-document HTML of a global document, which can't be split into chunks
+	differ    *diff.Differ
+
+	// Granularity of the changes ApplyEdit reports, see diff.GranularityBlock
+	Granularity string
+}
+
+// chunk is the parsed form of one top-level node
+type chunk struct {
+	start, end  int // Byte range of the node's source lines
+	kind        ast.NodeKind
+	html        string
+	blocks      []chunkBlock // Blocks of the node's subtree, in document order
+	links       []models.InternalLink
+	images      []models.Image
+	slugs       []string // Heading IDs
+	frontmatter map[string]interface{}
+	open        bool // Contains a fenced construct without a closing line
+	raw         bool // Contains raw HTML, which sanitizes differently once split
+}
+
+// chunkBlock pairs a block with the node kind its ID is derived from
+type chunkBlock struct {
+	block *models.Block
+	kind  ast.NodeKind
+}
+
+// NewDocument parses content into a document that can be updated with ApplyEdit
+func (p *MarkdownParser) NewDocument(content string) (*Document, error) {
+	if err := p.checkSize(len(content)); err != nil {
+		return nil, err
+	}
+	content, err := p.Clean(content)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Document{
+		parser: p,
+		differ: diff.NewDiffer(),
+	}
+	d.rebuild(content)
+	d.differ.Diff(diff.GranularityBlock, d.content, d.blocks())
+	return d, nil
+}
+
+// Content returns the current markdown source
+func (d *Document) Content() string {
+	return d.content
+}
+
+// Response assembles the parse result for the current content
+func (d *Document) Response() *models.ParseResponse {
+	var html strings.Builder
+	response := &models.ParseResponse{
+		Blocks:    d.blocks(),
+		Links:     &models.LinkIndex{Internal: []models.InternalLink{}},
+		Footnotes: d.footnotes,
+		Success:   true,
+	}
+
+	for _, c := range d.chunks {
+		for _, cb := range c.blocks {
+			if cb.block.ParentID == "" {
+				response.Tree = append(response.Tree, cb.block)
+			}
+		}
+		html.WriteString(c.html)
+		response.Links.Internal = append(response.Links.Internal, c.links...)
+		response.Images = append(response.Images, c.images...)
+		if c.frontmatter != nil {
+			response.Frontmatter = c.frontmatter
+		}
+	}
+	response.HTML = html.String()
+	if d.global {
+		response.HTML = d.html
+	}
+
+	return response
+}
+
+// ApplyEdit replaces the bytes [edit.Start, edit.End) of the content with
+// edit.Text and returns the updated document, with Changes listing the
+// blocks that were added, modified, moved, or removed, or with Lines or
+// Words listing the text changes, depending on Granularity. An edit whose
+// text changes are too large to compute fails with diff.ErrTooLarge and
+// leaves the document as it was.
+func (d *Document) ApplyEdit(edit models.Edit) (*models.ParseResponse, error) {
+	if edit.Start < 0 || edit.End < edit.Start || edit.End > len(d.content) {
+		return nil, fmt.Errorf("edit range [%d, %d) is outside the document (length %d)", edit.Start, edit.End, len(d.content))
+	}
+
+	if err := d.parser.checkSize(len(d.content) - (edit.End - edit.Start) + len(edit.Text)); err != nil {
+		return nil, err
+	}
+
+	before := d.content
+	content := d.content[:edit.Start] + edit.Text + d.content[edit.End:]
+	if err := checkEdit(content, edit); err != nil {
+		return nil, err
+	}
+	if d.global || len(d.chunks) == 0 || !d.splice(edit, content) {
+		d.rebuild(content)
+	}
+
+	response := d.Response()
+	changes, err := d.differ.Diff(d.Granularity, d.content, response.Blocks)
+	if err != nil {
+		d.rebuild(before)
+		return nil, err
+	}
+	setChanges(response, changes)
+	return response, nil
+}
+
+// setChanges stores changes at any granularity in a response
+func setChanges(response *models.ParseResponse, changes diff.Changes) {
+	response.Changes = changes.Blocks
+	response.Lines = changes.Lines
+	response.Words = changes.Words
+}
+
+// ChangesOnly reduces a response to its changes for clients that keep their
+// own copy of the document: Blocks, HTML, and the document-wide indexes are
+// dropped, and removed blocks are reported by ID alone
+func ChangesOnly(response *models.ParseResponse) *models.ParseResponse {
+	changes := make([]models.BlockChange, len(response.Changes))
+	for i, change := range response.Changes {
+		if change.Type == "removed" {
+			change.Block = nil
+		}
+		changes[i] = change
+	}
+	return &models.ParseResponse{
+		Changes: changes,
+		Lines:   response.Lines,
+		Words:   response.Words,
+		Success: response.Success,
+		Error:   response.Error,
+	}
+}
+
+// PatchChanges replaces a response's Changes with the equivalent JSON Patch
+// against its Blocks, for clients and stores that apply RFC 6902 patches
+func PatchChanges(response *models.ParseResponse) *models.ParseResponse {
+	patched := *response
+	patched.Patch = diff.JSONPatch(response.Changes)
+	patched.Changes = nil
+	return &patched
+}
+
+// DiffEdit returns the single edit that turns old into new, spanning
+// everything between their common prefix and common suffix. The edit
+// starts and ends at character boundaries, so a changed character is
+// replaced whole even when its encodings share bytes.
+func DiffEdit(old, new string) models.Edit {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	for prefix > 0 && !(runeBoundary(old, prefix) && runeBoundary(new, prefix)) {
+		prefix--
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	for suffix > 0 && !(runeBoundary(old, len(old)-suffix) && runeBoundary(new, len(new)-suffix)) {
+		suffix--
+	}
+
+	return models.Edit{
+		Start: prefix,
+		End:   len(old) - suffix,
+		Text:  new[prefix : len(new)-suffix],
+	}
+}
+
+// runeBoundary reports whether offset i of s falls between characters
+func runeBoundary(s string, i int) bool {
+	return i == len(s) || utf8.RuneStart(s[i])
+}
+
+// Block returns the block with the given ID
+func (d *Document) Block(blockID string) (*models.Block, bool) {
+	for _, c := range d.chunks {
+		for _, cb := range c.blocks {
+			if cb.block.ID == blockID {
+				return cb.block, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// blocks collects the blocks of every chunk keyed by ID
+func (d *Document) blocks() map[string]*models.Block {
+	blocks := make(map[string]*models.Block)
+	for _, c := range d.chunks {
+		for _, cb := range c.blocks {
+			blocks[cb.block.ID] = cb.block
+		}
+	}
+	return blocks
+}
+
+// rebuild parses the whole content from scratch
+func (d *Document) rebuild(content string) {
+	p := d.parser
+	source := []byte(content)
+	doc := p.parseAST(source, p.newHeadingIDs())
+
+	d.content = content
+	d.chunks = p.chunks(doc, source, 0)
+	d.footnotes = p.extractFootnotes(doc, source)
+	d.global = d.footnotes != nil || referencePattern.MatchString(content) || strings.Contains(content, "[^")
+	d.global = d.global || !inOrder(d.chunks) || p.config.SourcePositions
+	for _, c := range d.chunks {
+		d.global = d.global || c.raw
+	}
+
+	d.html = ""
+	if d.global {
+		d.html = p.renderNodeToHTML(doc, source)
+	}
+}
+
+// splice reparses the chunks around an edit and splices them into the
+// document, reporting false when the edit needs a full reparse instead
+func (d *Document) splice(edit models.Edit, content string) bool {
+	first, last := d.affectedChunks(edit)
+	regionStart, regionEnd := 0, len(d.content)
+	if first > 0 {
+		regionStart = d.chunks[first].start
+	}
+	if last < len(d.chunks)-1 {
+		regionEnd = d.chunks[last].end
+	}
+
+	delta := len(edit.Text) - (edit.End - edit.Start)
+	region := content[regionStart : regionEnd+delta]
+	if referencePattern.MatchString(region) || strings.Contains(region, "[^") {
+		return false
+	}
+
+	// Headings before the region keep their IDs; the region continues their deduplication
+	p := d.parser
+	ids := p.newHeadingIDs()
+	for _, c := range d.chunks[:first] {
+		for _, slug := range c.slugs {
+			ids.Put([]byte(slug))
+		}
+	}
+
+	// A leading newline keeps the region from being read as document-start frontmatter
+	offset := regionStart
+	source := []byte(region)
+	if regionStart > 0 {
+		source = append([]byte("\n"), source...)
+		offset--
+	}
+	doc := p.parseAST(source, ids)
+	replacement := p.chunks(doc, source, offset)
+
+	// An unclosed fence at the end of the region would swallow the blocks after it
+	if n := len(replacement); n > 0 && replacement[n-1].open && regionEnd < len(d.content) {
+		return false
+	}
+	if !inOrder(replacement) {
+		return false
+	}
+	for _, c := range replacement {
+		if c.raw {
+			return false
+		}
+	}
+	if slugsCollide(d.chunks[first:last+1], replacement, append(append([]*chunk{}, d.chunks[:first]...), d.chunks[last+1:]...), p.config.HeadingIDSuffix) {
+		return false
+	}
+
+	after := d.chunks[last+1:]
+	for _, c := range after {
+		c.shift(delta)
+	}
+
+	chunks := make([]*chunk, 0, first+len(replacement)+len(after))
+	chunks = append(chunks, d.chunks[:first]...)
+	chunks = append(chunks, replacement...)
+	chunks = append(chunks, after...)
+
+	d.content = content
+	d.chunks = chunks
+	d.renumber()
+	d.relocate()
+	return true
+}
+
+// affectedChunks returns the range of chunks to reparse for an edit: the
+// chunks it touches plus one neighbour on each side, extended over chunks
+// that are not separated by a blank line, adjacent lists (which merge
+// across blank lines), and indented chunks (which may continue a list)
+func (d *Document) affectedChunks(edit models.Edit) (int, int) {
+	first, last := 0, len(d.chunks)-1
+	for i, c := range d.chunks {
+		if c.start <= edit.Start {
+			first = i
+		}
+	}
+	for i := len(d.chunks) - 1; i >= 0; i-- {
+		if d.chunks[i].end >= edit.End {
+			last = i
+		}
+	}
+	if last < first {
+		last = first
+	}
+
+	if first > 0 {
+		first--
+	}
+	if last < len(d.chunks)-1 {
+		last++
+	}
+
+	for first > 0 && d.joined(first-1, first) {
+		first--
+	}
+	for last < len(d.chunks)-1 && d.joined(last, last+1) {
+		last++
+	}
+
+	return first, last
+}
+
+// joined reports whether two consecutive chunks may parse differently when
+// one of them changes, so they must be reparsed together
+func (d *Document) joined(i, j int) bool {
+	prev, next := d.chunks[i], d.chunks[j]
+	if prev.kind == ast.KindList || next.kind == ast.KindList {
+		return true
+	}
+	if next.start < len(d.content) && (d.content[next.start] == ' ' || d.content[next.start] == '\t') {
+		return true
+	}
+
+	gap := d.content[prev.end:next.start]
+	return !strings.Contains(gap, "\n") || strings.TrimSpace(gap) != ""
+}
+
+// shift moves a chunk and its blocks' positions and inline spans by delta bytes
+func (c *chunk) shift(delta int) {
+	if delta == 0 {
+		return
+	}
+	c.start += delta
+	c.end += delta
+	for _, cb := range c.blocks {
+		block := cb.block
+		if block.Position.Start == 0 && block.Position.End == 0 {
+			continue // No source segments, so no position to move
+		}
+		block.Position.Start += delta
+		block.Position.End += delta
+		for i := range block.Inlines {
+			block.Inlines[i].Start += delta
+			block.Inlines[i].End += delta
+		}
+	}
+}
+
+// renumber reassigns block IDs and indexes across the whole document after
+// a splice, since the ordinals that keep duplicate blocks distinct are
+// document-wide
+func (d *Document) renumber() {
+	ids := make(blockIDs)
+	index := 0
+	for _, c := range d.chunks {
+		for _, cb := range c.blocks {
+			cb.block.ID = ids.next(cb.kind, cb.block.Content)
+			cb.block.Index = index
+			index++
+		}
+	}
+	for _, c := range d.chunks {
+		for _, cb := range c.blocks {
+			for _, child := range cb.block.Children {
+				child.ParentID = cb.block.ID
+			}
+		}
+	}
+}
+
+// relocate recomputes block lines and columns after a splice, since an edit
+// that adds or removes lines moves every block after it
+func (d *Document) relocate() {
+	lines := newLineIndex([]byte(d.content))
+	for _, c := range d.chunks {
+		for _, cb := range c.blocks {
+			lines.locate(&cb.block.Position)
+		}
+	}
+}
+
+// chunks splits a parsed document into one chunk per top-level node. Block
+// positions are made absolute by adding offset.
+func (p *MarkdownParser) chunks(doc ast.Node, source []byte, offset int) []*chunk {
+	_, nodeBlocks := p.extractBlocks(doc, source)
+
+	var chunks []*chunk
+	prevEnd := 0
+	for node := doc.FirstChild(); node != nil; node = node.NextSibling() {
+		c := &chunk{
+			kind:   node.Kind(),
+			html:   p.renderNodeToHTML(node, source),
+			links:  p.extractLinks(node).Internal,
+			images: p.extractImages(node, source),
+		}
+		c.start, c.end = nodeExtent(node, nodeBlocks[node], source, prevEnd)
+		prevEnd = c.end
+
+		ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			if !entering {
+				return ast.WalkContinue, nil
+			}
+			if block, ok := nodeBlocks[n]; ok {
+				c.blocks = append(c.blocks, chunkBlock{block: block, kind: n.Kind()})
+			}
+			switch n.(type) {
+			case *ast.HTMLBlock, *ast.RawHTML:
+				c.raw = true
+			case *ast.FencedCodeBlock, *MathBlock, *Container, *Frontmatter:
+				if block, ok := nodeBlocks[n]; ok && !fenceClosed(n, source, block.Position.Start) {
+					c.open = true
+				}
+			}
+			if heading, ok := n.(*ast.Heading); ok {
+				if id, ok := heading.AttributeString("id"); ok {
+					c.slugs = append(c.slugs, string(id.([]byte)))
+				}
+			}
+			return ast.WalkContinue, nil
+		})
+		if frontmatter, ok := node.(*Frontmatter); ok {
+			c.frontmatter, _ = frontmatter.Data(source)
+		}
+
+		c.shift(offset)
+		chunks = append(chunks, c)
+	}
+
+	return chunks
+}
+
+// nodeExtent returns the byte range of the source lines making up a
+// top-level node, from the span of its block
+func nodeExtent(node ast.Node, block *models.Block, source []byte, prevEnd int) (int, int) {
+	var start, end int
+	if block != nil {
+		start, end = block.Position.Start, block.Position.End
+	} else {
+		start, end = blockSpan(node, source, prevEnd)
+	}
+	if end <= start {
+		end = start + 1
+	}
+	start, end = lineStart(source, start), lineEnd(source, end-1)
+
+	if n, ok := node.(*ast.HTMLBlock); ok && n.HasClosure() {
+		end = lineEnd(source, n.ClosureLine.Stop-1)
+	}
+
+	return start, end
+}
+
+// lineStart returns the offset of the start of the line containing pos
+func lineStart(source []byte, pos int) int {
+	if pos > len(source) {
+		pos = len(source)
+	}
+	return bytes.LastIndexByte(source[:pos], '\n') + 1
+}
+
+// lineEnd returns the offset just past the newline ending the line containing pos
+func lineEnd(source []byte, pos int) int {
+	if pos >= len(source) {
+		return len(source)
+	}
+	if i := bytes.IndexByte(source[pos:], '\n'); i >= 0 {
+		return pos + i + 1
+	}
+	return len(source)
+}
+
+// nextNonBlankLine returns the start of the first non-blank line at or after pos
+func nextNonBlankLine(source []byte, pos int) int {
+	for pos < len(source) {
+		end := lineEnd(source, pos)
+		if len(bytes.TrimSpace(source[pos:end])) > 0 {
+			return pos
+		}
+		pos = end
+	}
+	return len(source)
+}
+
+// previousNonBlankLine returns the start of the last non-blank line before pos, not before limit
+func previousNonBlankLine(source []byte, pos, limit int) int {
+	for pos > limit {
+		start := lineStart(source, pos-1)
+		if len(bytes.TrimSpace(source[start:pos])) > 0 {
+			return start
+		}
+		pos = start
+	}
+	return pos
+}
+
+// inOrder reports whether chunks follow each other in the source. Tables
+// split out of a paragraph can precede the rest of it in the AST, which
+// leaves the chunks overlapping.
+func inOrder(chunks []*chunk) bool {
+	for i := 1; i < len(chunks); i++ {
+		if chunks[i].start < chunks[i-1].end {
+			return false
+		}
+	}
+	return true
+}
+
+// slugsCollide reports whether heading IDs in the old or new region could
+// change the deduplicated IDs of headings outside it
+func slugsCollide(old, replacement, outside []*chunk, suffix string) bool {
+	stem := func(slug string) string {
+		return dedupeSuffixPattern.ReplaceAllString(strings.TrimSuffix(slug, suffix), "")
+	}
+
+	region := make(map[string]bool)
+	for _, chunks := range [][]*chunk{old, replacement} {
+		for _, c := range chunks {
+			for _, slug := range c.slugs {
+				region[stem(slug)] = true
+			}
+		}
+	}
+	if len(region) == 0 {
+		return false
+	}
+
+	for _, c := range outside {
+		for _, slug := range c.slugs {
+			if region[stem(slug)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newHeadingIDs creates the heading ID generator configured for this parser
+func (p *MarkdownParser) newHeadingIDs() parser.IDs {
+	return newHeadingIDs(p.config.HeadingIDStrategy, p.config.HeadingIDPrefix, p.config.HeadingIDSuffix)
+}
+
+// parseAST parses source into a goldmark AST using the given heading ID generator
+func (p *MarkdownParser) parseAST(source []byte, ids parser.IDs) ast.Node {
+	ctx := parser.NewContext(parser.WithIDs(ids))
+	return p.goldmark.Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+}
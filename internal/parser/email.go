@@ -0,0 +1,147 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// emailStyles are the inline styles applied per tag, since most email
+// clients ignore <style> blocks and never load external stylesheets
+var emailStyles = map[string]string{
+	"h1":         "margin:24px 0 16px;font-size:28px;font-weight:600;line-height:1.25;",
+	"h2":         "margin:24px 0 16px;font-size:22px;font-weight:600;line-height:1.25;",
+	"h3":         "margin:24px 0 16px;font-size:18px;font-weight:600;line-height:1.25;",
+	"h4":         "margin:24px 0 16px;font-size:16px;font-weight:600;line-height:1.25;",
+	"h5":         "margin:24px 0 16px;font-size:14px;font-weight:600;line-height:1.25;",
+	"h6":         "margin:24px 0 16px;font-size:13px;font-weight:600;line-height:1.25;color:#57606a;",
+	"p":          "margin:0 0 16px;",
+	"a":          "color:#0969da;text-decoration:underline;",
+	"blockquote": "margin:0 0 16px;padding:0 16px;border-left:4px solid #d0d7de;color:#57606a;",
+	"ul":         "margin:0 0 16px;padding-left:32px;",
+	"ol":         "margin:0 0 16px;padding-left:32px;",
+	"li":         "margin:4px 0;",
+	"pre":        "margin:0 0 16px;padding:16px;background-color:#f6f8fa;border-radius:6px;overflow:auto;font-size:13px;line-height:1.45;",
+	"code":       "font-family:SFMono-Regular,Consolas,'Liberation Mono',Menlo,monospace;font-size:85%;",
+	"table":      "margin:0 0 16px;border-collapse:collapse;",
+	"th":         "padding:6px 13px;border:1px solid #d0d7de;font-weight:600;background-color:#f6f8fa;",
+	"td":         "padding:6px 13px;border:1px solid #d0d7de;",
+	"hr":         "height:1px;margin:24px 0;border:0;background-color:#d0d7de;",
+	"img":        "max-width:100%;height:auto;border:0;",
+	"mark":       "background-color:#fff8c5;",
+	"dt":         "font-weight:600;",
+	"dd":         "margin:0 0 16px 16px;",
+}
+
+// emailClassStyles add styles for the classed containers produced by extensions
+var emailClassStyles = map[string]string{
+	"callout":          "margin:0 0 16px;padding:8px 16px;border-left:4px solid #0969da;background-color:#f6f8fa;",
+	"callout-warning":  "border-left-color:#9a6700;",
+	"callout-caution":  "border-left-color:#cf222e;",
+	"callout-tip":      "border-left-color:#1a7f37;",
+	"callout-title":    "font-weight:600;",
+	"footnotes":        "font-size:12px;color:#57606a;",
+	"math-display":     "display:block;margin:0 0 16px;text-align:center;",
+	"footnote-backref": "text-decoration:none;",
+}
+
+// emailDroppedElements are removed with their content: scripts, styles,
+// and embedded content that email clients block or strip
+var emailDroppedElements = map[string]bool{
+	"script": true, "style": true, "link": true, "meta": true,
+	"iframe": true, "object": true, "embed": true, "form": true,
+}
+
+// emailBodyStyle is applied to the wrapper around the whole document
+const emailBodyStyle = "font-family:-apple-system,'Segoe UI',Helvetica,Arial,sans-serif;font-size:16px;line-height:1.5;color:#24292f;max-width:640px;"
+
+var whitespaceRun = regexp.MustCompile(`\s+`)
+
+// renderEmailHTML rewrites rendered HTML for transactional emails: styles
+// are inlined per element, scripts, stylesheets, and event handlers are
+// removed, and whitespace outside <pre> is collapsed
+func renderEmailHTML(document string) string {
+	var buf strings.Builder
+	buf.WriteString(`<div style="` + emailBodyStyle + `">`)
+
+	tokenizer := html.NewTokenizer(strings.NewReader(document))
+	dropping, preDepth := "", 0
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			break
+		}
+		token := tokenizer.Token()
+
+		if dropping != "" {
+			if tokenType == html.EndTagToken && token.Data == dropping {
+				dropping = ""
+			}
+			continue
+		}
+
+		switch tokenType {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if emailDroppedElements[token.Data] {
+				if tokenType == html.StartTagToken && token.Data != "link" && token.Data != "meta" {
+					dropping = token.Data
+				}
+				continue
+			}
+			if token.Data == "pre" && tokenType == html.StartTagToken {
+				preDepth++
+			}
+			token.Attr = emailAttributes(token.Data, token.Attr)
+			buf.WriteString(token.String())
+		case html.EndTagToken:
+			if emailDroppedElements[token.Data] {
+				continue
+			}
+			if token.Data == "pre" && preDepth > 0 {
+				preDepth--
+			}
+			buf.WriteString(token.String())
+		case html.TextToken:
+			text := token.String()
+			if preDepth == 0 {
+				text = whitespaceRun.ReplaceAllString(text, " ")
+			}
+			buf.WriteString(text)
+		case html.CommentToken, html.DoctypeToken:
+			// Dropped to keep the output minimal
+		}
+	}
+
+	buf.WriteString("</div>")
+	return buf.String()
+}
+
+// emailAttributes removes event handlers and script URLs and prepends the
+// element's email styles to any inline style it already has
+func emailAttributes(tag string, attrs []html.Attribute) []html.Attribute {
+	style, existing := emailStyles[tag], ""
+	var kept []html.Attribute
+	for _, attr := range attrs {
+		switch {
+		case strings.HasPrefix(attr.Key, "on"):
+			continue
+		case (attr.Key == "href" || attr.Key == "src") && strings.HasPrefix(strings.ToLower(strings.TrimSpace(attr.Val)), "javascript:"):
+			continue
+		case attr.Key == "class":
+			for _, class := range strings.Fields(attr.Val) {
+				style += emailClassStyles[class]
+			}
+		case attr.Key == "style":
+			existing = attr.Val
+			continue
+		}
+		kept = append(kept, attr)
+	}
+
+	style += existing
+	if style != "" {
+		kept = append(kept, html.Attribute{Key: "style", Val: style})
+	}
+	return kept
+}
@@ -0,0 +1,52 @@
+package parser
+
+import (
+	"github.com/yuin/goldmark/ast"
+
+	"markdown-parser/internal/models"
+)
+
+// Output formats supported by ParseOptions.Format
+const (
+	FormatHTML     = "html"     // Rendered HTML only (the default)
+	FormatText     = "text"     // Plain text with all markdown syntax stripped
+	FormatAST      = "ast"      // Typed syntax tree in ParseResponse.AST
+	FormatMarkdown = "markdown" // Canonical markdown, see formatMarkdown
+	FormatSlack    = "slack"    // Slack mrkdwn for bot messages
+	FormatJira     = "jira"     // Jira/Confluence wiki markup
+	FormatSlides   = "slides"   // Self-contained HTML presentation, one slide per --- section
+	FormatEmail    = "email"    // Minified HTML with inline styles and no CSS/JS, for emails
+)
+
+// applyFormat fills the format-specific parts of a parse response
+func (p *MarkdownParser) applyFormat(opts ParseOptions, doc ast.Node, source []byte, nodeBlocks map[ast.Node]*models.Block, response *models.ParseResponse) {
+	switch opts.Format {
+	case FormatAST:
+		response.AST = astToJSON(doc, source)
+	case FormatText:
+		response.Output = plainTextDocument(doc, source)
+		for node, block := range nodeBlocks {
+			if block.Text == "" {
+				block.Text = plainTextDocument(node, source)
+			}
+		}
+	case FormatMarkdown:
+		response.Output = formatMarkdown(doc, source, p.wrapWidth(opts))
+	case FormatSlack:
+		response.Output = renderSlack(doc, source)
+	case FormatJira:
+		response.Output = renderJira(doc, source)
+	case FormatSlides:
+		response.Output = p.renderSlides(doc, source, slidesTitle(doc, source, response.Frontmatter))
+	case FormatEmail:
+		response.Output = renderEmailHTML(response.HTML)
+	}
+}
+
+// wrapWidth resolves the paragraph wrap width for canonical markdown output
+func (p *MarkdownParser) wrapWidth(opts ParseOptions) int {
+	if opts.WrapWidth != nil {
+		return *opts.WrapWidth
+	}
+	return p.config.FormatWrapWidth
+}
@@ -0,0 +1,118 @@
+package parser
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"gopkg.in/yaml.v3"
+)
+
+// KindFrontmatter is the node kind for YAML frontmatter blocks
+var KindFrontmatter = ast.NewNodeKind("Frontmatter")
+
+// Frontmatter represents a --- delimited YAML block at the top of a document
+type Frontmatter struct {
+	ast.BaseBlock
+}
+
+// Kind implements ast.Node.Kind
+func (n *Frontmatter) Kind() ast.NodeKind {
+	return KindFrontmatter
+}
+
+// IsRaw implements ast.Node.IsRaw
+func (n *Frontmatter) IsRaw() bool {
+	return true
+}
+
+// Dump implements ast.Node.Dump
+func (n *Frontmatter) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Data decodes the YAML contents of the frontmatter block
+func (n *Frontmatter) Data(source []byte) (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	if err := yaml.Unmarshal(n.Lines().Value(source), &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// frontmatterParser parses YAML frontmatter on the first line of a document
+type frontmatterParser struct{}
+
+func (b *frontmatterParser) Trigger() []byte {
+	return []byte{'-'}
+}
+
+func (b *frontmatterParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	lineNum, _ := reader.Position()
+	if lineNum != 0 || parent.Kind() != ast.KindDocument {
+		return nil, parser.NoChildren
+	}
+
+	line, _ := reader.PeekLine()
+	if !isFrontmatterFence(line) {
+		return nil, parser.NoChildren
+	}
+
+	return &Frontmatter{}, parser.NoChildren
+}
+
+func (b *frontmatterParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	if isFrontmatterFence(line) || bytes.Equal(util.TrimRightSpace(line), []byte("...")) {
+		reader.Advance(segment.Len())
+		return parser.Close
+	}
+
+	node.Lines().Append(segment)
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *frontmatterParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *frontmatterParser) CanInterruptParagraph() bool {
+	return false
+}
+
+func (b *frontmatterParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// isFrontmatterFence reports whether a line consists solely of a --- fence
+func isFrontmatterFence(line []byte) bool {
+	return bytes.Equal(util.TrimRightSpace(line), []byte("---"))
+}
+
+// frontmatterHTMLRenderer omits frontmatter from rendered HTML
+type frontmatterHTMLRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer.RegisterFuncs
+func (r *frontmatterHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindFrontmatter, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// frontmatterExtension adds YAML frontmatter support
+type frontmatterExtension struct{}
+
+// FrontmatterExtension is a goldmark extension for --- delimited YAML frontmatter
+var FrontmatterExtension = &frontmatterExtension{}
+
+// Extend implements goldmark.Extender
+func (e *frontmatterExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithBlockParsers(
+		util.Prioritized(&frontmatterParser{}, 0),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&frontmatterHTMLRenderer{}, 500),
+	))
+}
@@ -2,126 +2,424 @@ package parser
 
 import (
 	"bytes"
-	"crypto/md5"
+	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/microcosm-cc/bluemonday"
 	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
+	east "github.com/yuin/goldmark/extension/ast"
 	"github.com/yuin/goldmark/text"
 
+	"markdown-parser/configs"
 	"markdown-parser/internal/models"
+	"markdown-parser/pkg/hashing"
 )
 
-// MarkdownParser wraps Goldmark with additional functionality
+// ErrContentTooLarge is returned when content exceeds the configured MaxContentSize
+var ErrContentTooLarge = errors.New("content too large")
+
+// MarkdownParser wraps Goldmark with additional functionality.
+//
+// A MarkdownParser is safe for concurrent use and is meant to be shared.
+// The goldmark instance and sanitization policy are immutable once built;
+// everything a parse mutates (the AST, heading ID generator, and render
+// buffers, which come from a pool) belongs to that call. Variants built
+// for per-request options are cached under mu.
 type MarkdownParser struct {
-	goldmark goldmark.Markdown
+	goldmark  goldmark.Markdown
+	config    configs.ParserConfig
+	sanitizer *bluemonday.Policy
+
+	mu          sync.Mutex
+	variants    map[renderSettings]*MarkdownParser
+	cache       *parseCache // Shared by all variants; nil when disabled
+	shared      SharedCache // Behind cache; nil when not set
+	fingerprint string      // Of config, part of every cache key
+}
+
+// ParseOptions holds per-request overrides of the parser configuration
+type ParseOptions struct {
+	HighlightTheme string // Chroma style name, empty uses the configured theme
+	LineNumbers    *bool  // Line numbers in highlighted code, nil uses the config
+	SanitizePolicy string // none, strict, gfm, or custom; empty uses the configured policy
+	Dialect        string // commonmark, gfm, or notion; empty uses the configured dialect
+	Format         string // Additional output format: text, ast, markdown, slack, jira, slides, or email
+	WrapWidth      *int   // Paragraph wrap width for markdown output, nil uses the config
+
+	// data-sourcepos attributes on rendered blocks, nil uses the config
+	SourcePositions *bool
+
+	Stats bool // Add document statistics to the response
+	Lint  bool // Add lint warnings to the response
+}
+
+// renderSettings identifies a fully resolved goldmark configuration
+type renderSettings struct {
+	highlightTheme   string
+	lineNumbers      bool
+	wikilinkTemplate string
+	subscript        bool
+	superscript      bool
+	sanitizePolicy   string
+	dialect          string
+	gfm              bool
+	tables           bool
+	autolink         bool
+	footnotes        bool
+	definitionLists  bool
+	sourcePositions  bool
 }
 
 // NewMarkdownParser creates a new parser with GitHub Flavored Markdown extensions
 func NewMarkdownParser() *MarkdownParser {
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,           // GitHub Flavored Markdown
-			extension.Footnote,      // Footnote support
-			extension.DefinitionList, // Definition list support
-		),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(), // Auto-generate heading IDs
-		),
-		goldmark.WithRendererOptions(
-			html.WithHardWraps(),     // Convert line breaks to <br>
-			html.WithXHTML(),         // Use XHTML-style output
-			html.WithUnsafe(),        // Allow raw HTML
-		),
+	return NewMarkdownParserWithConfig(configs.DefaultConfig().Parser)
+}
+
+// NewMarkdownParserWithConfig creates a new parser using the given parser configuration
+func NewMarkdownParserWithConfig(config configs.ParserConfig) *MarkdownParser {
+	p := &MarkdownParser{
+		config:      config,
+		variants:    make(map[renderSettings]*MarkdownParser),
+		cache:       newParseCache(config.ParseCacheSize, time.Duration(config.ParseCacheTTLSeconds)*time.Second),
+		fingerprint: configFingerprint(config),
+	}
+	settings := p.resolveSettings(ParseOptions{})
+	p.goldmark = newGoldmark(settings)
+	p.sanitizer = newSanitizer(settings.sanitizePolicy, config)
+
+	return p
+}
+
+// newGoldmark builds a goldmark instance for the given settings
+func newGoldmark(settings renderSettings) goldmark.Markdown {
+	parserOptions, rendererOptions := dialectOptions(settings)
+	return goldmark.New(
+		goldmark.WithExtensions(dialectExtensions(settings)...),
+		goldmark.WithParserOptions(parserOptions...),
+		goldmark.WithRendererOptions(rendererOptions...),
 	)
+}
 
-	return &MarkdownParser{
-		goldmark: md,
+// resolveSettings merges per-request options over the parser configuration
+func (p *MarkdownParser) resolveSettings(opts ParseOptions) renderSettings {
+	settings := renderSettings{
+		highlightTheme:   p.config.HighlightTheme,
+		lineNumbers:      p.config.HighlightLineNumbers,
+		wikilinkTemplate: p.config.WikilinkHrefTemplate,
+		subscript:        p.config.EnableSubscript,
+		superscript:      p.config.EnableSuperscript,
+		sanitizePolicy:   p.config.SanitizePolicy,
+		dialect:          p.config.Dialect,
+		gfm:              p.config.EnableGFM,
+		tables:           p.config.EnableTables,
+		autolink:         p.config.EnableAutolink,
+		footnotes:        p.config.EnableFootnotes,
+		definitionLists:  p.config.EnableDefinitionLists,
+		sourcePositions:  p.config.SourcePositions,
 	}
+
+	if opts.HighlightTheme != "" && styles.Registry[opts.HighlightTheme] != nil {
+		settings.highlightTheme = opts.HighlightTheme
+	}
+	if opts.LineNumbers != nil {
+		settings.lineNumbers = *opts.LineNumbers
+	}
+	switch opts.SanitizePolicy {
+	case SanitizeNone, SanitizeStrict, SanitizeGFM, SanitizeCustom:
+		settings.sanitizePolicy = opts.SanitizePolicy
+	}
+	if isDialect(opts.Dialect) {
+		settings.dialect = opts.Dialect
+	}
+	if opts.SourcePositions != nil {
+		settings.sourcePositions = *opts.SourcePositions
+	}
+
+	return settings
+}
+
+// variant returns a parser configured for the given options, reusing cached instances
+func (p *MarkdownParser) variant(opts ParseOptions) *MarkdownParser {
+	settings := p.resolveSettings(opts)
+	if settings == p.resolveSettings(ParseOptions{}) {
+		return p
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if v, ok := p.variants[settings]; ok {
+		return v
+	}
+
+	v := &MarkdownParser{
+		goldmark:  newGoldmark(settings),
+		config:    p.config,
+		sanitizer: newSanitizer(settings.sanitizePolicy, p.config),
+	}
+	p.variants[settings] = v
+
+	return v
 }
 
 // Parse converts markdown to HTML and extracts block information
 func (p *MarkdownParser) Parse(content string) (*models.ParseResponse, error) {
+	return p.ParseWithOptions(content, ParseOptions{})
+}
+
+// parse converts markdown to HTML plus any additional output format requested in opts
+func (p *MarkdownParser) parse(content string, opts ParseOptions) (*models.ParseResponse, error) {
+	if err := p.checkSize(len(content)); err != nil {
+		return nil, err
+	}
+	cleaned, err := p.Clean(content)
+	if err != nil {
+		return nil, err
+	}
+	var normalized string // Reported when cleaning changed the content, as positions refer to it
+	if cleaned != content {
+		normalized, content = cleaned, cleaned
+	}
 	if content == "" {
-		return &models.ParseResponse{
+		response := &models.ParseResponse{
 			HTML:    "",
 			Blocks:  make(map[string]*models.Block),
 			Success: true,
-		}, nil
+		}
+		if opts.Stats {
+			response.Stats = &models.DocumentStats{Headings: make(map[int]int)}
+		}
+		if opts.Lint {
+			response.Warnings = []models.LintWarning{}
+		}
+		return response, nil
 	}
 
 	// Parse to HTML
-	var htmlBuf bytes.Buffer
 	source := []byte(content)
 	
-	doc := p.goldmark.Parser().Parse(text.NewReader(source))
-	if err := p.goldmark.Renderer().Render(&htmlBuf, source, doc); err != nil {
+	doc := p.parseAST(source, p.newHeadingIDs())
+	html, err := p.render(doc, source)
+	if err != nil {
 		return nil, fmt.Errorf("failed to render HTML: %w", err)
 	}
 
 	// Extract blocks from AST
-	blocks := p.extractBlocks(doc, source)
+	blocks, nodeBlocks := p.extractBlocks(doc, source)
 
-	return &models.ParseResponse{
-		HTML:    htmlBuf.String(),
-		Blocks:  blocks,
-		Success: true,
-	}, nil
+	response := &models.ParseResponse{
+		HTML:        html,
+		Blocks:      blocks,
+		Tree:        blockTree(blocks),
+		Frontmatter: p.extractFrontmatter(doc, source),
+		Links:       p.extractLinks(doc),
+		Footnotes:   p.extractFootnotes(doc, source),
+		Images:      p.extractImages(doc, source),
+		Success:     true,
+
+		NormalizedContent: normalized,
+	}
+	p.applyFormat(opts, doc, source, nodeBlocks, response)
+	if opts.Stats {
+		response.Stats = documentStats(doc, source)
+	}
+	if opts.Lint {
+		response.Warnings = p.lint(doc, source)
+	}
+
+	return response, nil
+}
+
+// checkSize rejects content longer than the configured MaxContentSize (0 means unlimited)
+func (p *MarkdownParser) checkSize(size int) error {
+	if limit := p.config.MaxContentSize; limit > 0 && int64(size) > limit {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", ErrContentTooLarge, size, limit)
+	}
+	return nil
 }
 
-// ParseIncremental performs incremental parsing for real-time updates
-func (p *MarkdownParser) ParseIncremental(content string, blockID string) (*models.ParseResponse, error) {
-	// For now, we'll parse the entire content
-	// In a production system, you'd implement proper incremental parsing
-	return p.Parse(content)
+// extractFrontmatter decodes the document's YAML frontmatter, if present and valid
+func (p *MarkdownParser) extractFrontmatter(doc ast.Node, source []byte) map[string]interface{} {
+	frontmatter, ok := doc.FirstChild().(*Frontmatter)
+	if !ok {
+		return nil
+	}
+
+	data, err := frontmatter.Data(source)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// ParseWithOptions parses markdown using per-request overrides of the configuration.
+// Results are cached by content hash and options; a cached response is a
+// copy whose blocks are shared with the cache and must not be modified.
+func (p *MarkdownParser) ParseWithOptions(content string, opts ParseOptions) (*models.ParseResponse, error) {
+	if p.cache == nil && p.shared == nil {
+		return p.variant(opts).parse(content, opts)
+	}
+
+	key := p.cacheKey(content, opts)
+	if response, ok := p.cache.get(key); ok {
+		return response, nil
+	}
+	if p.shared != nil {
+		if response, ok := p.getShared(key); ok {
+			p.cache.add(key, response)
+			return response, nil
+		}
+	}
+
+	response, err := p.variant(opts).parse(content, opts)
+	if err != nil {
+		return nil, err
+	}
+	p.cache.add(key, response)
+	if p.shared != nil {
+		p.addShared(key, response)
+	}
+	return response, nil
 }
 
-// extractBlocks walks the AST and extracts block information
-func (p *MarkdownParser) extractBlocks(doc ast.Node, source []byte) map[string]*models.Block {
+// SetSharedCache sets a cache of parse results shared with other
+// instances, consulted after the parser's own cache misses. Shared results
+// are copies decoded from JSON, so their blocks are not linked into a tree
+// and suit responses rather than incremental parsing. It must be set
+// before the parser is used.
+func (p *MarkdownParser) SetSharedCache(cache SharedCache) {
+	p.shared = cache
+}
+
+// CacheStats reports the parse result cache's size and hit counters
+func (p *MarkdownParser) CacheStats() models.CacheStats {
+	return p.cache.stats()
+}
+
+// Format rewrites markdown in canonical form, wrapping paragraphs at width (0 keeps line breaks)
+func (p *MarkdownParser) Format(content string, width int) (string, error) {
+	response, err := p.parse(content, ParseOptions{Format: FormatMarkdown, WrapWidth: &width})
+	if err != nil {
+		return "", err
+	}
+	return response.Output, nil
+}
+
+// ParseIncremental applies an edit to previously parsed content, reparsing
+// only the blocks around the edit. Callers that edit the same content
+// repeatedly should keep a Document from NewDocument instead.
+func (p *MarkdownParser) ParseIncremental(content string, edit models.Edit) (*models.ParseResponse, error) {
+	doc, err := p.NewDocument(content)
+	if err != nil {
+		return nil, err
+	}
+	return doc.ApplyEdit(edit)
+}
+
+// extractBlocks walks the AST and extracts block information, keyed by ID and by source node
+func (p *MarkdownParser) extractBlocks(doc ast.Node, source []byte) (map[string]*models.Block, map[ast.Node]*models.Block) {
 	blocks := make(map[string]*models.Block)
+	nodeBlocks := make(map[ast.Node]*models.Block)
+	ids := make(blockIDs)
+	lines := newLineIndex(source)
+	positions := blockPositions(doc, source, lines)
 	
 	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
 		if !entering {
 			return ast.WalkContinue, nil
 		}
 
-		block := p.nodeToBlock(n, source)
+		block := p.nodeToBlock(n, source, positions[n])
 		if block != nil {
+			if positions[n] == nil {
+				lines.locate(&block.Position)
+			}
+			block.Index = len(blocks)
+			block.ID = ids.next(n.Kind(), block.Content)
 			blocks[block.ID] = block
+			nodeBlocks[n] = block
+
+			// Attach to the nearest ancestor that produced a block
+			for ancestor := n.Parent(); ancestor != nil; ancestor = ancestor.Parent() {
+				if parent, ok := nodeBlocks[ancestor]; ok {
+					block.ParentID = parent.ID
+					parent.Children = append(parent.Children, block)
+					break
+				}
+			}
 		}
 
 		return ast.WalkContinue, nil
 	})
 
-	return blocks
+	return blocks, nodeBlocks
+}
+
+// blockTree returns the top-level blocks in document order
+func blockTree(blocks map[string]*models.Block) []*models.Block {
+	var tree []*models.Block
+	for _, block := range blocks {
+		if block.ParentID == "" {
+			tree = append(tree, block)
+		}
+	}
+	sort.Slice(tree, func(i, j int) bool {
+		return tree[i].Index < tree[j].Index
+	})
+	return tree
 }
 
-// nodeToBlock converts an AST node to a Block
-func (p *MarkdownParser) nodeToBlock(node ast.Node, source []byte) *models.Block {
-	// Only process block-level elements
-	kind := node.Kind()
-	if kind == ast.KindText || kind == ast.KindString {
+// extractLinks collects the wikilinks referenced by a document
+func (p *MarkdownParser) extractLinks(doc ast.Node) *models.LinkIndex {
+	links := &models.LinkIndex{
+		Internal: []models.InternalLink{},
+	}
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if wikilink, ok := n.(*WikiLink); ok && entering {
+			links.Internal = append(links.Internal, models.InternalLink{
+				Target: wikilink.Target,
+				Alias:  wikilink.Alias,
+				Href:   wikilink.Destination,
+			})
+		}
+		return ast.WalkContinue, nil
+	})
+
+	return links
+}
+
+// nodeToBlock converts an AST node at position to a Block
+func (p *MarkdownParser) nodeToBlock(node ast.Node, source []byte, position *models.Position) *models.Block {
+	// Only process block-level elements (footnote references and images are surfaced too)
+	if node.Type() != ast.TypeBlock && !isSurfacedInline(node) {
+		return nil
+	}
+
+	// Table sections are exposed through the structured table of their parent
+	switch node.(type) {
+	case *east.TableHeader, *east.TableRow, *east.TableCell:
 		return nil
 	}
 
-	var startPos, endPos int
-	if hasSegment, ok := node.(interface{ Segment() *text.Segment }); ok {
-		segment := hasSegment.Segment()
-		startPos = segment.Start
-		endPos = segment.Stop
+	// Block positions come from blockPositions; inline ones are located here
+	if position == nil {
+		start, end := nodeSpan(node)
+		position = &models.Position{Start: start, End: end}
 	}
+	startPos, endPos := position.Start, position.End
 
 	block := &models.Block{
-		ID:       p.generateBlockID(node, source),
-		Position: models.Position{
-			Start: startPos,
-			End:   endPos,
-		},
+		Position: *position,
 	}
 
 	// Extract content from source
@@ -149,6 +447,12 @@ func (p *MarkdownParser) nodeToBlock(node ast.Node, source []byte) *models.Block
 			block.Type = "heading"
 		}
 		block.Level = n.Level
+		block.Text = strings.TrimSpace(plainText(n, source))
+		if id, ok := n.AttributeString("id"); ok {
+			if slug, ok := id.([]byte); ok {
+				block.Slug = string(slug)
+			}
+		}
 		block.HTML = p.renderNodeToHTML(node, source)
 	case *ast.Paragraph:
 		block.Type = "paragraph"
@@ -162,12 +466,24 @@ func (p *MarkdownParser) nodeToBlock(node ast.Node, source []byte) *models.Block
 		block.HTML = p.renderNodeToHTML(node, source)
 	case *ast.ListItem:
 		block.Type = "list_item"
+		if checkbox := taskCheckBox(n); checkbox != nil {
+			checked := checkbox.IsChecked
+			block.Type = "checkbox"
+			block.Checked = &checked
+			block.Text = strings.TrimSpace(plainText(n.FirstChild(), source))
+		}
 		block.HTML = p.renderNodeToHTML(node, source)
 	case *ast.CodeBlock:
 		block.Type = "code_block"
+		block.Code = string(n.Lines().Value(source))
 		block.HTML = p.renderNodeToHTML(node, source)
 	case *ast.FencedCodeBlock:
 		block.Type = "fenced_code_block"
+		block.Language = string(n.Language(source))
+		if n.Info != nil {
+			block.Info = string(n.Info.Segment.Value(source))
+		}
+		block.Code = string(n.Lines().Value(source))
 		block.HTML = p.renderNodeToHTML(node, source)
 	case *ast.Blockquote:
 		block.Type = "blockquote"
@@ -175,40 +491,445 @@ func (p *MarkdownParser) nodeToBlock(node ast.Node, source []byte) *models.Block
 	case *ast.ThematicBreak:
 		block.Type = "thematic_break"
 		block.HTML = p.renderNodeToHTML(node, source)
+	case *Frontmatter:
+		block.Type = "frontmatter"
+	case *Callout:
+		block.Type = "callout"
+		block.Variant = n.Variant
+		block.HTML = p.renderNodeToHTML(node, source)
+	case *Container:
+		block.Type = "container"
+		block.Name = n.Name
+		block.HTML = p.renderNodeToHTML(node, source)
+	case *MathBlock:
+		block.Type = "math"
+		block.HTML = p.renderNodeToHTML(node, source)
+	case *east.Footnote:
+		block.Type = "footnote_definition"
+		block.Ref = string(n.Ref)
+		block.HTML = p.renderNodeToHTML(node, source)
+	case *ast.Image:
+		block.Type = "image"
+		block.Image = imageInfo(n, source)
+		block.HTML = p.renderNodeToHTML(node, source)
+	case *east.FootnoteLink:
+		block.Type = "footnote_reference"
+		block.Ref = footnoteRef(n)
+		block.HTML = p.renderNodeToHTML(node, source)
+	case *east.DefinitionList:
+		block.Type = "definition_list"
+		block.Definitions = extractDefinitions(n, source)
+		block.HTML = p.renderNodeToHTML(node, source)
+	case *east.DefinitionTerm:
+		block.Type = "definition_term"
+		block.Text = plainText(n, source)
+		block.HTML = p.renderNodeToHTML(node, source)
+	case *east.DefinitionDescription:
+		block.Type = "definition_description"
+		block.Text = strings.TrimSpace(plainText(n, source))
+		block.HTML = p.renderNodeToHTML(node, source)
+	case *east.Table:
+		block.Type = "table"
+		block.Table = p.extractTable(n, source)
+		block.HTML = p.renderNodeToHTML(node, source)
 	default:
 		block.Type = "unknown"
 		block.HTML = p.renderNodeToHTML(node, source)
 	}
 
+	block.Inlines = extractInlines(node, source)
+
 	return block
 }
 
-// renderNodeToHTML renders a single AST node to HTML
-func (p *MarkdownParser) renderNodeToHTML(node ast.Node, source []byte) string {
-	var buf bytes.Buffer
-	if err := p.goldmark.Renderer().Render(&buf, source, node); err != nil {
+// extractInlines lists the formatted spans directly inside a block, excluding nested blocks
+func extractInlines(node ast.Node, source []byte) []models.Inline {
+	var inlines []models.Inline
+
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if n != node && n.Type() == ast.TypeBlock {
+			return ast.WalkSkipChildren, nil
+		}
+
+		var spanType, href string
+		switch v := n.(type) {
+		case *ast.Emphasis:
+			spanType = "italic"
+			if v.Level >= 2 {
+				spanType = "bold"
+			}
+		case *ast.CodeSpan:
+			spanType = "code"
+		case *ast.Link:
+			spanType = "link"
+			href = string(v.Destination)
+		case *ast.AutoLink:
+			if inline, ok := autoLinkInline(v, source); ok {
+				inlines = append(inlines, inline)
+			}
+			return ast.WalkContinue, nil
+		case *Mark:
+			spanType = "highlight"
+		case *east.Strikethrough:
+			spanType = "strikethrough"
+		case *Subscript:
+			spanType = "subscript"
+		case *Superscript:
+			spanType = "superscript"
+		default:
+			return ast.WalkContinue, nil
+		}
+
+		start, end := segmentSpan(n)
+		inlines = append(inlines, models.Inline{
+			Type:  spanType,
+			Start: start,
+			End:   end,
+			Text:  plainText(n, source),
+			Href:  href,
+		})
+		return ast.WalkContinue, nil
+	})
+
+	return inlines
+}
+
+// autoLinkInline describes an autolink, which carries no text segments, by
+// finding its URL between the neighbouring inline nodes
+func autoLinkInline(link *ast.AutoLink, source []byte) (models.Inline, bool) {
+	label := link.Label(source)
+	start, end := siblingSpan(link)
+	offset := bytes.Index(source[start:end], label)
+	if offset < 0 {
+		return models.Inline{}, false
+	}
+
+	start += offset
+	return models.Inline{
+		Type:  "link",
+		Start: start,
+		End:   start + len(label),
+		Text:  string(label),
+		Href:  string(link.URL(source)),
+	}, true
+}
+
+// extractDefinitions pairs each term of a definition list with its descriptions
+func extractDefinitions(list *east.DefinitionList, source []byte) []models.Definition {
+	definitions := []models.Definition{}
+
+	for child := list.FirstChild(); child != nil; child = child.NextSibling() {
+		switch n := child.(type) {
+		case *east.DefinitionTerm:
+			definitions = append(definitions, models.Definition{
+				Term:         plainText(n, source),
+				Descriptions: []string{},
+			})
+		case *east.DefinitionDescription:
+			if len(definitions) == 0 {
+				continue
+			}
+			last := &definitions[len(definitions)-1]
+			last.Descriptions = append(last.Descriptions, strings.TrimSpace(plainText(n, source)))
+		}
+	}
+
+	return definitions
+}
+
+// footnoteBacklinkPattern matches the back-reference anchors appended to footnote content
+var footnoteBacklinkPattern = regexp.MustCompile(`(&#160;)?<a href="#fnref[^"]*" class="footnote-backref"[^>]*>.*?</a>`)
+
+// extractFootnotes maps each footnote label to its rendered content, without back-references
+func (p *MarkdownParser) extractFootnotes(doc ast.Node, source []byte) map[string]string {
+	footnotes := make(map[string]string)
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		footnote, ok := n.(*east.Footnote)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+
+		buf := getBuffer()
+		defer putBuffer(buf)
+		for child := footnote.FirstChild(); child != nil; child = child.NextSibling() {
+			if err := p.goldmark.Renderer().Render(buf, source, child); err != nil {
+				break
+			}
+		}
+		content := footnoteBacklinkPattern.ReplaceAllString(buf.String(), "")
+		footnotes[string(footnote.Ref)] = strings.TrimSpace(p.sanitize(content))
+
+		return ast.WalkSkipChildren, nil
+	})
+
+	if len(footnotes) == 0 {
+		return nil
+	}
+	return footnotes
+}
+
+// footnoteRef returns the label of the footnote a reference points to
+func footnoteRef(link *east.FootnoteLink) string {
+	doc := link.OwnerDocument()
+	if doc == nil {
 		return ""
 	}
+
+	for list := doc.LastChild(); list != nil; list = list.PreviousSibling() {
+		if _, ok := list.(*east.FootnoteList); !ok {
+			continue
+		}
+		for def := list.FirstChild(); def != nil; def = def.NextSibling() {
+			if footnote, ok := def.(*east.Footnote); ok && footnote.Index == link.Index {
+				return string(footnote.Ref)
+			}
+		}
+	}
+	return ""
+}
+
+// isSurfacedInline reports whether an inline node is exposed as its own block
+func isSurfacedInline(node ast.Node) bool {
+	switch node.(type) {
+	case *east.FootnoteLink, *ast.Image:
+		return true
+	}
+	return false
+}
+
+// extractImages collects metadata for every image in a document
+func (p *MarkdownParser) extractImages(doc ast.Node, source []byte) []models.Image {
+	var images []models.Image
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if image, ok := n.(*ast.Image); ok && entering {
+			images = append(images, *imageInfo(image, source))
+		}
+		return ast.WalkContinue, nil
+	})
+
+	return images
+}
+
+// imageInfo extracts the source, alt text, and title of an image node
+func imageInfo(image *ast.Image, source []byte) *models.Image {
+	return &models.Image{
+		Src:   string(image.Destination),
+		Alt:   plainText(image, source),
+		Title: string(image.Title),
+	}
+}
+
+// siblingSpan locates an inline node that carries no source segments of its own
+// (footnote references, images) between its neighbouring inline nodes
+func siblingSpan(node ast.Node) (int, int) {
+	start, end := -1, -1
+	if prev := node.PreviousSibling(); prev != nil {
+		if _, prevEnd := segmentSpan(prev); prevEnd > 0 {
+			start = prevEnd
+		}
+	}
+	if next := node.NextSibling(); next != nil {
+		if nextStart, nextEnd := segmentSpan(next); nextEnd > 0 {
+			end = nextStart
+		}
+	}
+
+	if parent := node.Parent(); parent != nil && (start < 0 || end < 0) {
+		parentStart, parentEnd := segmentSpan(parent)
+		if start < 0 {
+			start = parentStart
+		}
+		if end < 0 {
+			end = parentEnd
+		}
+	}
+
+	if start < 0 || end < start {
+		return 0, 0
+	}
+	return start, end
+}
+
+// taskCheckBox returns the GFM task checkbox opening a list item, if any
+func taskCheckBox(item *ast.ListItem) *east.TaskCheckBox {
+	if item.FirstChild() == nil {
+		return nil
+	}
+	checkbox, _ := item.FirstChild().FirstChild().(*east.TaskCheckBox)
+	return checkbox
+}
+
+// plainText concatenates the text content of a node and its descendants
+func plainText(node ast.Node, source []byte) string {
+	var buf bytes.Buffer
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch v := n.(type) {
+		case *ast.Text:
+			buf.Write(v.Segment.Value(source))
+			if v.SoftLineBreak() || v.HardLineBreak() {
+				buf.WriteByte('\n')
+			}
+		case *ast.String:
+			buf.Write(v.Value)
+		}
+		return ast.WalkContinue, nil
+	})
 	return buf.String()
 }
 
-// generateBlockID generates a unique ID for a block based on its content and position
-func (p *MarkdownParser) generateBlockID(node ast.Node, source []byte) string {
-	var startPos, endPos int
-	content := ""
-	
-	if hasSegment, ok := node.(interface{ Segment() *text.Segment }); ok {
-		segment := hasSegment.Segment()
-		startPos = segment.Start
-		endPos = segment.Stop
-		if startPos < len(source) && endPos <= len(source) && endPos > startPos {
-			content = string(source[startPos:endPos])
+// extractTable converts a GFM table node into structured header and rows
+func (p *MarkdownParser) extractTable(table *east.Table, source []byte) *models.Table {
+	result := &models.Table{
+		Alignments: make([]string, len(table.Alignments)),
+		Header:     []models.TableCell{},
+		Rows:       [][]models.TableCell{},
+	}
+	for i, alignment := range table.Alignments {
+		result.Alignments[i] = alignment.String()
+	}
+
+	for child := table.FirstChild(); child != nil; child = child.NextSibling() {
+		var cells []models.TableCell
+		for cell := child.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, p.extractTableCell(cell, source))
+		}
+
+		if _, ok := child.(*east.TableHeader); ok {
+			result.Header = cells
+		} else {
+			result.Rows = append(result.Rows, cells)
 		}
 	}
-	
-	// Create a hash of content + position for uniqueness
-	hash := md5.Sum([]byte(fmt.Sprintf("%s-%d-%d", content, startPos, endPos)))
-	return fmt.Sprintf("%x", hash)[:8]
+
+	return result
+}
+
+// extractTableCell extracts the source and inline HTML of a table cell
+func (p *MarkdownParser) extractTableCell(cell ast.Node, source []byte) models.TableCell {
+	var result models.TableCell
+	start, end := nodeSpan(cell)
+	if end > start {
+		result.Content = string(source[start:end])
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	for child := cell.FirstChild(); child != nil; child = child.NextSibling() {
+		if err := p.goldmark.Renderer().Render(buf, source, child); err != nil {
+			break
+		}
+	}
+	result.HTML = p.sanitize(buf.String())
+
+	return result
+}
+
+// nodeSpan returns the byte range in source covered by a node and its descendants
+func nodeSpan(node ast.Node) (int, int) {
+	if isSurfacedInline(node) {
+		return siblingSpan(node)
+	}
+	return segmentSpan(node)
+}
+
+// segmentSpan returns the byte range covered by the source segments of a node's subtree
+func segmentSpan(node ast.Node) (int, int) {
+	start, end := -1, -1
+	extend := func(segment text.Segment) {
+		if start == -1 || segment.Start < start {
+			start = segment.Start
+		}
+		if segment.Stop > end {
+			end = segment.Stop
+		}
+	}
+
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch v := n.(type) {
+		case *ast.Text:
+			extend(v.Segment)
+		case *ast.RawHTML:
+			for i := 0; i < v.Segments.Len(); i++ {
+				extend(v.Segments.At(i))
+			}
+		default:
+			if n.Type() == ast.TypeBlock {
+				lines := n.Lines()
+				for i := 0; i < lines.Len(); i++ {
+					extend(lines.At(i))
+				}
+			}
+		}
+
+		return ast.WalkContinue, nil
+	})
+
+	if start == -1 {
+		return 0, 0
+	}
+	return start, end
+}
+
+// renderNodeToHTML renders a single AST node to HTML
+func (p *MarkdownParser) renderNodeToHTML(node ast.Node, source []byte) string {
+	html, err := p.render(node, source)
+	if err != nil {
+		return ""
+	}
+	return html
+}
+
+// render renders an AST node to sanitized HTML
+func (p *MarkdownParser) render(node ast.Node, source []byte) (string, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	if err := p.goldmark.Renderer().Render(buf, source, node); err != nil {
+		return "", err
+	}
+	return p.sanitize(buf.String()), nil
+}
+
+// sanitize applies the configured HTML sanitization policy, if any
+func (p *MarkdownParser) sanitize(html string) string {
+	if p.sanitizer == nil {
+		return html
+	}
+	return p.sanitizer.Sanitize(html)
+}
+
+// blockIDs assigns position-independent block IDs: a hash of the block's kind
+// and content plus an ordinal counting earlier blocks with the same kind and
+// content, so duplicates stay distinct. Inserting or editing a block leaves
+// the IDs of unrelated blocks unchanged, even when their positions move.
+type blockIDs map[blockKey]int
+
+type blockKey struct {
+	kind    ast.NodeKind
+	content string
+}
+
+// next returns the ID for the next block of kind with content, in document order
+func (ids blockIDs) next(kind ast.NodeKind, content string) string {
+	key := blockKey{kind, content}
+	ordinal := ids[key]
+	ids[key]++
+
+	hash := hashing.Sum64(kind.String(), content, strconv.Itoa(ordinal))
+	return fmt.Sprintf("%08x", uint32(hash^hash>>32))
 }
 
 // DetectNotionSyntax detects Notion-style syntax patterns
@@ -0,0 +1,88 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+
+	"markdown-parser/internal/models"
+)
+
+// ErrInvalidText is returned for content that is not valid UTF-8, and for
+// edits that would break its encoding or insert control characters
+var ErrInvalidText = errors.New("invalid text")
+
+// Clean validates content as UTF-8 and strips NUL and the other control
+// characters but tab, line feed, and carriage return, then normalizes it
+// to NFC if configured, so the same text hashes to the same blocks and
+// positions whichever client sent it. Clean content is returned as is.
+func (p *MarkdownParser) Clean(content string) (string, error) {
+	if i := invalidUTF8(content); i >= 0 {
+		return "", fmt.Errorf("%w: content is not valid UTF-8 at byte %d", ErrInvalidText, i)
+	}
+	if strings.IndexFunc(content, isControl) >= 0 {
+		content = strings.Map(func(r rune) rune {
+			if isControl(r) {
+				return -1
+			}
+			return r
+		}, content)
+	}
+	if p.config.NormalizeNFC && !norm.NFC.IsNormalString(content) {
+		content = norm.NFC.String(content)
+	}
+	return content, nil
+}
+
+// checkEdit rejects an edit to content that leaves a character of the
+// edited content torn, or that inserts control characters. Edits are not
+// cleaned like whole content, since rewriting them would move the
+// positions of every later change from the editing client.
+func checkEdit(content string, edit models.Edit) error {
+	// Whole characters around the inserted text, where an edit landing
+	// inside a multi-byte character shows as invalid UTF-8
+	start, end := edit.Start, edit.Start+len(edit.Text)
+	for start > 0 && edit.Start-start < utf8.UTFMax && (start == len(content) || !utf8.RuneStart(content[start])) {
+		start--
+	}
+	for end < len(content) && end-edit.Start-len(edit.Text) < utf8.UTFMax && !utf8.RuneStart(content[end]) {
+		end++
+	}
+	if i := invalidUTF8(content[start:end]); i >= 0 {
+		return fmt.Errorf("%w: edit leaves invalid UTF-8 at byte %d", ErrInvalidText, start+i)
+	}
+	if i := strings.IndexFunc(edit.Text, isControl); i >= 0 {
+		r, _ := utf8.DecodeRuneInString(edit.Text[i:])
+		return fmt.Errorf("%w: edit inserts control character %U", ErrInvalidText, r)
+	}
+	return nil
+}
+
+// invalidUTF8 returns the byte offset of the first invalid UTF-8 sequence
+// in s, or -1 if it is valid
+func invalidUTF8(s string) int {
+	if utf8.ValidString(s) {
+		return -1
+	}
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			return i
+		}
+		i += size
+	}
+	return -1
+}
+
+// isControl reports whether r is a C0 or C1 control character or DEL,
+// other than the tab, line feed, and carriage return markdown uses
+func isControl(r rune) bool {
+	switch r {
+	case '\t', '\n', '\r':
+		return false
+	}
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f)
+}
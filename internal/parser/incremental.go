@@ -1,27 +1,39 @@
 package parser
 
 import (
-	"crypto/md5"
 	"fmt"
 	"strings"
+	"sync"
+	"unicode/utf8"
 
 	"markdown-parser/internal/models"
 	"markdown-parser/pkg/diff"
+	"markdown-parser/pkg/hashing"
 )
 
-// IncrementalParser handles real-time parsing with diff detection
+// IncrementalParser handles real-time parsing with diff detection. It is
+// safe for concurrent use, but diffs are computed against whichever content
+// was parsed last, so each editing session should have its own.
 type IncrementalParser struct {
 	baseParser *MarkdownParser
-	differ     *diff.BlockDiffer
-	lineDiffer *diff.LineDiffer
+	differ     *diff.Differ
+	mu         sync.Mutex // Guards the differ's previous state
+
+	// ChangesOnly makes ParseWithDiff return only Changes, see ChangesOnly
+	ChangesOnly bool
+
+	// Patch makes ParseWithDiff return Changes as a JSON Patch, see PatchChanges
+	Patch bool
+
+	// Granularity of the changes ParseWithDiff reports, see diff.GranularityBlock
+	Granularity string
 }
 
 // NewIncrementalParser creates a new incremental parser
 func NewIncrementalParser() *IncrementalParser {
 	return &IncrementalParser{
 		baseParser: NewMarkdownParser(),
-		differ:     diff.NewBlockDiffer(),
-		lineDiffer: diff.NewLineDiffer(),
+		differ:     diff.NewDiffer(),
 	}
 }
 
@@ -34,9 +46,20 @@ func (ip *IncrementalParser) ParseWithDiff(content string) (*models.ParseRespons
 	}
 
 	// Compute block-level differences
-	changes := ip.differ.ComputeDiff(result.Blocks)
-	result.Changes = changes
+	ip.mu.Lock()
+	changes, err := ip.differ.Diff(ip.Granularity, content, result.Blocks)
+	ip.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	setChanges(result, changes)
 
+	if ip.ChangesOnly {
+		result = ChangesOnly(result)
+	}
+	if ip.Patch {
+		result = PatchChanges(result)
+	}
 	return result, nil
 }
 
@@ -55,19 +78,27 @@ func (ip *IncrementalParser) ParseLine(line string, lineNumber int) *models.Bloc
 		ID:      generateLineID(line, lineNumber),
 		Type:    syntaxType,
 		Content: line,
-		HTML:    ip.renderLineToHTML(line, syntaxType),
+		HTML:    ip.baseParser.sanitize(ip.renderLineToHTML(line, syntaxType)),
 		Position: models.Position{
-			Line:  lineNumber,
-			Start: 0,
-			End:   len(line),
+			Line:      lineNumber,
+			Column:    1,
+			EndLine:   lineNumber,
+			EndColumn: utf8.RuneCountInString(line) + 1,
+			Start:     0,
+			End:       len(line),
 		},
 	}
 
 	// Try to parse with goldmark and update if we get better results
 	result, err := ip.baseParser.Parse(line)
 	if err == nil && len(result.Blocks) > 0 {
-		// Use goldmark's result but keep our detected type if it's more specific
+		// Use goldmark's result but keep our detected type if it's more
+		// specific, taking the first matching block in document order
+		ordered := make([]*models.Block, len(result.Blocks))
 		for _, goldmarkBlock := range result.Blocks {
+			ordered[goldmarkBlock.Index] = goldmarkBlock
+		}
+		for _, goldmarkBlock := range ordered {
 			if goldmarkBlock.Type != "unknown" && 
 			   goldmarkBlock.HTML != "" && 
 			   (syntaxType == "paragraph" || syntaxType == goldmarkBlock.Type) {
@@ -147,5 +178,5 @@ func generateLineID(line string, lineNumber int) string {
 	if content == "" {
 		content = "empty"
 	}
-	return fmt.Sprintf("line_%d_%x", lineNumber, md5.Sum([]byte(content)))[:12]
+	return fmt.Sprintf("line_%d_%x", lineNumber, hashing.Sum64(content))[:12]
 }
\ No newline at end of file
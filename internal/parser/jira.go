@@ -0,0 +1,238 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+)
+
+// jiraEscaper escapes characters that open Jira wiki markup
+var jiraEscaper = strings.NewReplacer(
+	"{", `\{`, "}", `\}`, "[", `\[`, "]", `\]`,
+	"*", `\*`, "_", `\_`, "|", `\|`, "^", `\^`, "~", `\~`,
+)
+
+// jiraPanels maps callout variants to Jira's built-in panel macros
+var jiraPanels = map[string]string{
+	"note":      "info",
+	"info":      "info",
+	"tip":       "tip",
+	"important": "note",
+	"warning":   "warning",
+	"caution":   "warning",
+	"danger":    "warning",
+}
+
+// jiraRenderer renders an AST as Jira/Confluence wiki markup
+type jiraRenderer struct {
+	source    []byte
+	footnotes map[int]string // Footnote index → label
+}
+
+// renderJira converts a parsed document to Jira wiki markup
+func renderJira(doc ast.Node, source []byte) string {
+	r := &jiraRenderer{
+		source:    source,
+		footnotes: footnoteLabels(doc),
+	}
+	return r.children(doc, "\n\n")
+}
+
+// children renders the block children of a node joined by separator
+func (r *jiraRenderer) children(node ast.Node, separator string) string {
+	var parts []string
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if part := r.block(child, ""); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, separator)
+}
+
+// block renders a single block node. markers holds the enclosing list
+// markers, since Jira nests lists by repeating them (** or #*).
+func (r *jiraRenderer) block(node ast.Node, markers string) string {
+	switch n := node.(type) {
+	case *ast.Heading:
+		return fmt.Sprintf("h%d. ", n.Level) + r.inlines(n)
+	case *ast.Paragraph, *ast.TextBlock:
+		return r.inlines(n)
+	case *ast.ThematicBreak:
+		return "----"
+	case *ast.FencedCodeBlock:
+		macro := "{code}"
+		if language := n.Language(r.source); language != nil {
+			macro = "{code:" + string(language) + "}"
+		}
+		return macro + "\n" + withNewline(n.Lines().Value(r.source)) + "{code}"
+	case *ast.CodeBlock:
+		return "{code}\n" + withNewline(n.Lines().Value(r.source)) + "{code}"
+	case *MathBlock:
+		return "{noformat}\n" + withNewline(n.Lines().Value(r.source)) + "{noformat}"
+	case *ast.HTMLBlock, *Frontmatter:
+		return ""
+	case *ast.Blockquote:
+		return "{quote}\n" + r.children(n, "\n\n") + "\n{quote}"
+	case *Callout:
+		panel, ok := jiraPanels[n.Variant]
+		if !ok {
+			panel = "info"
+		}
+		macro := "{" + panel
+		if n.Title != "" {
+			macro += ":title=" + n.Title
+		}
+		return macro + "}\n" + r.children(n, "\n\n") + "\n{" + panel + "}"
+	case *Container:
+		title := n.Name
+		if n.Title != "" {
+			title = n.Title
+		}
+		return "{panel:title=" + title + "}\n" + r.children(n, "\n\n") + "\n{panel}"
+	case *ast.List:
+		return r.list(n, markers)
+	case *east.Table:
+		return r.table(n)
+	case *east.DefinitionList:
+		var lines []string
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			if term, ok := child.(*east.DefinitionTerm); ok {
+				lines = append(lines, "*"+r.inlines(term)+"*")
+			} else {
+				lines = append(lines, "bq. "+r.children(child, " "))
+			}
+		}
+		return strings.Join(lines, "\n")
+	case *east.FootnoteList:
+		return "----\n" + r.children(n, "\n")
+	case *east.Footnote:
+		return "^" + string(n.Ref) + "^ " + r.children(n, " ")
+	default:
+		return r.children(n, "\n\n")
+	}
+}
+
+// list renders list items, repeating the parent markers for nested lists
+func (r *jiraRenderer) list(list *ast.List, markers string) string {
+	marker := "*"
+	if list.IsOrdered() {
+		marker = "#"
+	}
+	markers += marker
+
+	var lines []string
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		var text []string
+		var nested []string
+		for child := item.FirstChild(); child != nil; child = child.NextSibling() {
+			if _, ok := child.(*ast.List); ok {
+				nested = append(nested, r.block(child, markers))
+			} else if part := r.block(child, markers); part != "" {
+				text = append(text, part)
+			}
+		}
+		lines = append(lines, markers+" "+strings.Join(text, "\n"))
+		lines = append(lines, nested...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// table renders a table with a || header row
+func (r *jiraRenderer) table(table *east.Table) string {
+	var lines []string
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		separator := "|"
+		if _, ok := row.(*east.TableHeader); ok {
+			separator = "||"
+		}
+
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			content := r.inlines(cell)
+			if content == "" {
+				content = " "
+			}
+			cells = append(cells, content)
+		}
+		lines = append(lines, separator+strings.Join(cells, separator)+separator)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// inlines renders the inline children of a node
+func (r *jiraRenderer) inlines(node ast.Node) string {
+	var buf strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		r.inline(&buf, child)
+	}
+	return buf.String()
+}
+
+// inline renders a single inline node and its children
+func (r *jiraRenderer) inline(buf *strings.Builder, node ast.Node) {
+	wrapped := func(delimiter string) {
+		buf.WriteString(delimiter + r.inlines(node) + delimiter)
+	}
+
+	switch n := node.(type) {
+	case *ast.Text:
+		buf.WriteString(jiraEscaper.Replace(string(n.Segment.Value(r.source))))
+		switch {
+		case n.HardLineBreak():
+			buf.WriteString("\\\\\n")
+		case n.SoftLineBreak():
+			buf.WriteString("\n")
+		}
+	case *ast.String:
+		buf.WriteString(jiraEscaper.Replace(string(n.Value)))
+	case *ast.Emphasis:
+		if n.Level >= 2 {
+			wrapped("*")
+		} else {
+			wrapped("_")
+		}
+	case *east.Strikethrough:
+		wrapped("-")
+	case *Subscript:
+		wrapped("~")
+	case *Superscript:
+		wrapped("^")
+	case *ast.CodeSpan, *MathInline:
+		buf.WriteString("{{" + inlineSource(n, r.source) + "}}")
+	case *ast.Link:
+		buf.WriteString(jiraLink(r.inlines(n), string(n.Destination)))
+	case *ast.Image:
+		buf.WriteString("!" + string(n.Destination) + "!")
+	case *ast.AutoLink:
+		buf.WriteString(jiraLink("", string(n.URL(r.source))))
+	case *WikiLink:
+		label := n.Target
+		if n.Alias != "" {
+			label = n.Alias
+		}
+		buf.WriteString(jiraLink(jiraEscaper.Replace(label), n.Destination))
+	case *east.TaskCheckBox:
+		if n.IsChecked {
+			buf.WriteString("(/) ")
+		} else {
+			buf.WriteString("(x) ")
+		}
+	case *east.FootnoteLink:
+		buf.WriteString("^" + r.footnotes[n.Index] + "^")
+	case *ast.RawHTML, *east.FootnoteBacklink:
+		// No wiki markup equivalent
+	default:
+		buf.WriteString(r.inlines(n))
+	}
+}
+
+// jiraLink formats a [label|url] link, or [url] when the label is empty
+func jiraLink(label, url string) string {
+	url = strings.NewReplacer("|", "%7C", "]", "%5D").Replace(url)
+	if label == "" {
+		return "[" + url + "]"
+	}
+	return "[" + label + "|" + url + "]"
+}
@@ -0,0 +1,196 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+)
+
+// Lint rules, each on unless turned off in the lint config
+const (
+	LintHeadingIncrement = "heading-increment" // Headings go down one level at a time
+	LintTrailingSpaces   = "trailing-spaces"   // No whitespace at line ends, but for a two-space hard break
+	LintBareURL          = "bare-url"          // URLs are written as links or in <angle brackets>
+	LintDuplicateHeading = "duplicate-heading" // No two headings have the same text
+	LintMissingAltText   = "missing-alt-text"  // Images have alt text
+	LintLineLength       = "line-length"       // Lines outside code fit MaxLineLength
+)
+
+// linter collects the warnings of the enabled rules over one document
+type linter struct {
+	config   configs.LintConfig
+	source   []byte
+	lines    *lineIndex
+	warnings []models.LintWarning
+}
+
+// lint checks a parsed document against the enabled lint rules, returning
+// its warnings in source order
+func (p *MarkdownParser) lint(doc ast.Node, source []byte) []models.LintWarning {
+	l := &linter{
+		config:   p.config.Lint,
+		source:   source,
+		lines:    newLineIndex(source),
+		warnings: []models.LintWarning{},
+	}
+	l.checkNodes(doc)
+	l.checkLines(doc)
+
+	sort.SliceStable(l.warnings, func(i, j int) bool {
+		a, b := l.warnings[i], l.warnings[j]
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return l.warnings
+}
+
+// enabled reports whether a rule is on
+func (l *linter) enabled(rule string) bool {
+	on, ok := l.config.Rules[rule]
+	return !ok || on
+}
+
+// warn adds a warning for rule at a source offset
+func (l *linter) warn(rule string, offset int, format string, args ...interface{}) {
+	line, column := l.lines.lineColumn(offset)
+	l.warnings = append(l.warnings, models.LintWarning{
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+		Line:    line,
+		Column:  column,
+	})
+}
+
+// checkNodes runs the rules about headings, images, and URLs
+func (l *linter) checkNodes(doc ast.Node) {
+	previousLevel := 0
+	headings := make(map[string]int) // Text → line of its first heading
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch v := n.(type) {
+		case *ast.Heading:
+			offset := l.blockOffset(v)
+			if l.enabled(LintHeadingIncrement) && previousLevel > 0 && v.Level > previousLevel+1 {
+				l.warn(LintHeadingIncrement, offset, "Heading level %d follows level %d; use level %d", v.Level, previousLevel, previousLevel+1)
+			}
+			previousLevel = v.Level
+
+			text := strings.TrimSpace(plainText(v, l.source))
+			if first, ok := headings[text]; ok && text != "" {
+				if l.enabled(LintDuplicateHeading) {
+					l.warn(LintDuplicateHeading, offset, "Heading %q repeats the heading on line %d", text, first)
+				}
+			} else {
+				headings[text], _ = l.lines.lineColumn(offset)
+			}
+		case *ast.Image:
+			if l.enabled(LintMissingAltText) && strings.TrimSpace(plainText(v, l.source)) == "" {
+				start, _ := siblingSpan(v)
+				l.warn(LintMissingAltText, start, "Image %s has no alt text", v.Destination)
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.AutoLink:
+			// Linkify turns bare URLs into autolinks; written ones are in <angle brackets>
+			if inline, ok := autoLinkInline(v, l.source); ok && l.enabled(LintBareURL) &&
+				v.AutoLinkType == ast.AutoLinkURL && (inline.Start == 0 || l.source[inline.Start-1] != '<') {
+				l.warn(LintBareURL, inline.Start, "Bare URL %s; write it as <%s> or a link", inline.Text, inline.Text)
+			}
+		case *ast.Text:
+			// Without linkify, bare URLs stay text
+			if !l.enabled(LintBareURL) {
+				break
+			}
+			value := v.Segment.Value(l.source)
+			for _, scheme := range [][]byte{[]byte("https://"), []byte("http://")} {
+				if i := bytes.Index(value, scheme); i >= 0 {
+					url := value[i:]
+					if end := bytes.IndexAny(url, " \t\n"); end >= 0 {
+						url = url[:end]
+					}
+					l.warn(LintBareURL, v.Segment.Start+i, "Bare URL %s; write it as <%s> or a link", url, url)
+					break
+				}
+			}
+		case *ast.Link, *ast.CodeSpan, *ast.RawHTML, *WikiLink:
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// blockOffset is where a block's markup starts, such as a heading's #
+func (l *linter) blockOffset(node ast.Node) int {
+	start, end := segmentSpan(node)
+	if end <= 0 {
+		start, _ = siblingSpan(node)
+		return start
+	}
+	return markupStart(node, l.source, start)
+}
+
+// checkLines runs the rules about the text of each line
+func (l *linter) checkLines(doc ast.Node) {
+	trailing, length := l.enabled(LintTrailingSpaces), l.enabled(LintLineLength) && l.config.MaxLineLength > 0
+	if !trailing && !length {
+		return
+	}
+
+	// Code may be as long as it needs to be
+	code := make(map[int]bool)
+	if length {
+		ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+			switch n.(type) {
+			case *ast.FencedCodeBlock, *ast.CodeBlock, *MathBlock:
+				if entering {
+					lines := n.Lines()
+					for i := 0; i < lines.Len(); i++ {
+						line, _ := l.lines.lineColumn(lines.At(i).Start)
+						code[line] = true
+					}
+				}
+				return ast.WalkSkipChildren, nil
+			}
+			return ast.WalkContinue, nil
+		})
+	}
+
+	for i, start := range l.lines.starts {
+		end := len(l.source)
+		if i+1 < len(l.lines.starts) {
+			end = l.lines.starts[i+1] - 1
+		}
+		line := bytes.TrimSuffix(l.source[start:end], []byte("\r"))
+
+		if trailing {
+			content := bytes.TrimRight(line, " \t")
+			if spaces := line[len(content):]; len(spaces) > 0 && (len(content) == 0 || string(spaces) != "  ") {
+				l.warn(LintTrailingSpaces, start+len(content), "Line ends with %d whitespace characters", len(spaces))
+			}
+		}
+
+		if length && !code[i+1] && utf8.RuneCount(line) > l.config.MaxLineLength {
+			// Lines that only overflow with one long word, such as a URL, cannot be wrapped
+			overflow := line
+			for n := 0; n < l.config.MaxLineLength; n++ {
+				_, size := utf8.DecodeRune(overflow)
+				overflow = overflow[size:]
+			}
+			if bytes.ContainsAny(overflow, " \t") {
+				l.warn(LintLineLength, start, "Line is %d characters long; the limit is %d", utf8.RuneCount(line), l.config.MaxLineLength)
+			}
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package parser
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"markdown-parser/internal/models"
+)
+
+// Locate returns the blocks of a parsed document's block tree that enclose
+// a byte offset, from the top-level block down to the innermost one. A
+// caret just past a block's last character is within the block; one on the
+// blank lines between blocks is within none.
+func Locate(tree []*models.Block, offset int) []*models.Block {
+	var path []*models.Block
+	for blocks := tree; len(blocks) > 0; {
+		// Later blocks win at a boundary, so a caret between two blocks that
+		// touch starts the next one rather than ending the previous one
+		var enclosing *models.Block
+		for _, block := range blocks {
+			if block.Position.Start <= offset && offset <= block.Position.End {
+				enclosing = block
+			}
+		}
+		if enclosing == nil {
+			break
+		}
+		path = append(path, enclosing)
+		blocks = enclosing.Children
+	}
+	return path
+}
+
+// CharacterOffset converts an offset in characters into a byte offset of
+// content, for editors that count characters
+func CharacterOffset(content string, characters int) (int, error) {
+	if characters < 0 {
+		return 0, fmt.Errorf("offset %d is negative", characters)
+	}
+	offset := 0
+	for n := 0; n < characters; n++ {
+		if offset >= len(content) {
+			return 0, fmt.Errorf("offset %d is past the end of the content's %d characters", characters, n)
+		}
+		_, size := utf8.DecodeRuneInString(content[offset:])
+		offset += size
+	}
+	return offset, nil
+}
+
+// LineColumnOffset converts a 1-based line and character column into a byte
+// offset of content. The column may be one past the line's last character.
+func LineColumnOffset(content string, line, column int) (int, error) {
+	if line < 1 || column < 1 {
+		return 0, fmt.Errorf("line %d, column %d is not a position; both start at 1", line, column)
+	}
+	lines := newLineIndex([]byte(content))
+	if line > len(lines.starts) {
+		return 0, fmt.Errorf("line %d is past the end of the content's %d lines", line, len(lines.starts))
+	}
+
+	start, end := lines.starts[line-1], len(content)
+	if line < len(lines.starts) {
+		end = lines.starts[line] - 1
+	}
+	text := content[start:end]
+	if len(text) > 0 && text[len(text)-1] == '\r' {
+		text = text[:len(text)-1]
+	}
+	offset, err := CharacterOffset(text, column-1)
+	if err != nil {
+		return 0, fmt.Errorf("column %d is past the end of line %d", column, line)
+	}
+	return start + offset, nil
+}
@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindMark is the node kind for ==highlighted== text
+var KindMark = ast.NewNodeKind("Mark")
+
+// Mark represents highlighted text delimited by ==
+type Mark struct {
+	ast.BaseInline
+}
+
+// Kind implements ast.Node.Kind
+func (n *Mark) Kind() ast.NodeKind {
+	return KindMark
+}
+
+// Dump implements ast.Node.Dump
+func (n *Mark) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// markDelimiterProcessor matches == delimiter runs
+type markDelimiterProcessor struct{}
+
+func (p *markDelimiterProcessor) IsDelimiter(b byte) bool {
+	return b == '='
+}
+
+func (p *markDelimiterProcessor) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char
+}
+
+func (p *markDelimiterProcessor) OnMatch(consumes int) ast.Node {
+	return &Mark{}
+}
+
+// markParser parses ==highlighted== spans
+type markParser struct{}
+
+func (s *markParser) Trigger() []byte {
+	return []byte{'='}
+}
+
+func (s *markParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+	node := parser.ScanDelimiter(line, before, 2, &markDelimiterProcessor{})
+	if node == nil || node.OriginalLength != 2 || before == '=' {
+		return nil
+	}
+
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+func (s *markParser) CloseBlock(parent ast.Node, pc parser.Context) {}
+
+// markHTMLRenderer renders highlighted text as <mark>
+type markHTMLRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer.RegisterFuncs
+func (r *markHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMark, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString("<mark>")
+		} else {
+			_, _ = w.WriteString("</mark>")
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// markExtension adds ==highlight== support
+type markExtension struct{}
+
+// MarkExtension is a goldmark extension rendering ==text== as <mark>
+var MarkExtension = &markExtension{}
+
+// Extend implements goldmark.Extender
+func (e *markExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&markParser{}, 500),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&markHTMLRenderer{}, 500),
+	))
+}
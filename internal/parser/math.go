@@ -0,0 +1,211 @@
+package parser
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindMathInline is the node kind for inline math spans
+var KindMathInline = ast.NewNodeKind("MathInline")
+
+// KindMathBlock is the node kind for display math blocks
+var KindMathBlock = ast.NewNodeKind("MathBlock")
+
+// MathInline represents LaTeX math delimited by $...$ (or $$...$$ within a line)
+type MathInline struct {
+	ast.BaseInline
+	Display bool
+}
+
+// Kind implements ast.Node.Kind
+func (n *MathInline) Kind() ast.NodeKind {
+	return KindMathInline
+}
+
+// Dump implements ast.Node.Dump
+func (n *MathInline) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// MathBlock represents LaTeX display math fenced by $$ lines
+type MathBlock struct {
+	ast.BaseBlock
+}
+
+// Kind implements ast.Node.Kind
+func (n *MathBlock) Kind() ast.NodeKind {
+	return KindMathBlock
+}
+
+// IsRaw implements ast.Node.IsRaw
+func (n *MathBlock) IsRaw() bool {
+	return true
+}
+
+// Dump implements ast.Node.Dump
+func (n *MathBlock) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// mathInlineParser parses $...$ spans
+type mathInlineParser struct{}
+
+func (s *mathInlineParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (s *mathInlineParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, segment := block.PeekLine()
+
+	opener := 0
+	for ; opener < len(line) && line[opener] == '$'; opener++ {
+	}
+	if opener > 2 || opener >= len(line) {
+		return nil
+	}
+
+	// Opening delimiter must be followed by non-space so "$5 and $10" stays text
+	if util.IsSpace(line[opener]) {
+		return nil
+	}
+
+	delimiter := line[:opener]
+	closer := bytes.Index(line[opener:], delimiter)
+	if closer <= 0 {
+		return nil
+	}
+	closer += opener
+
+	// Closing delimiter must not be preceded by a space or followed by a digit
+	if util.IsSpace(line[closer-1]) {
+		return nil
+	}
+	if opener == 1 && closer+1 < len(line) && (line[closer+1] == '$' || util.IsNumeric(line[closer+1])) {
+		return nil
+	}
+
+	node := &MathInline{Display: opener == 2}
+	node.AppendChild(node, ast.NewRawTextSegment(text.NewSegment(segment.Start+opener, segment.Start+closer)))
+	block.Advance(closer + opener)
+
+	return node
+}
+
+// mathBlockParser parses display math fenced by $$ lines
+type mathBlockParser struct{}
+
+func (b *mathBlockParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (b *mathBlockParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	pos := pc.BlockOffset()
+	if pos < 0 || !isMathFence(line[pos:]) {
+		return nil, parser.NoChildren
+	}
+
+	return &MathBlock{}, parser.NoChildren
+}
+
+func (b *mathBlockParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	line, segment := reader.PeekLine()
+	if isMathFence(util.TrimLeftSpace(line)) {
+		skipLine(reader, line, segment)
+		return parser.Close
+	}
+
+	node.Lines().Append(segment)
+	return parser.Continue | parser.NoChildren
+}
+
+func (b *mathBlockParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (b *mathBlockParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (b *mathBlockParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// isMathFence reports whether a line consists solely of a $$ fence
+func isMathFence(line []byte) bool {
+	return bytes.HasPrefix(line, []byte("$$")) && util.IsBlank(line[2:])
+}
+
+// mathHTMLRenderer renders math nodes as KaTeX/MathJax-friendly markup
+type mathHTMLRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer.RegisterFuncs
+func (r *mathHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMathInline, r.renderMathInline)
+	reg.Register(KindMathBlock, r.renderMathBlock)
+}
+
+func (r *mathHTMLRenderer) renderMathInline(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	if node.(*MathInline).Display {
+		_, _ = w.WriteString(`<span class="math math-display">`)
+	} else {
+		_, _ = w.WriteString(`<span class="math math-inline">`)
+	}
+	for c := node.FirstChild(); c != nil; c = c.NextSibling() {
+		segment := c.(*ast.Text).Segment
+		_, _ = w.Write(util.EscapeHTML(segment.Value(source)))
+	}
+	_, _ = w.WriteString("</span>")
+
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *mathHTMLRenderer) renderMathBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	_, _ = w.WriteString(`<div class="math math-display"`)
+	if node.Attributes() != nil {
+		html.RenderAttributes(w, node, html.GlobalAttributeFilter)
+	}
+	_, _ = w.WriteString(">")
+	lines := node.Lines()
+	for i := 0; i < lines.Len(); i++ {
+		line := lines.At(i)
+		_, _ = w.Write(util.EscapeHTML(line.Value(source)))
+	}
+	_, _ = w.WriteString("</div>\n")
+
+	return ast.WalkContinue, nil
+}
+
+// mathExtension adds $...$ inline and $$ block math support
+type mathExtension struct{}
+
+// Math is a goldmark extension for LaTeX math rendered client-side with KaTeX or MathJax
+var Math = &mathExtension{}
+
+// Extend implements goldmark.Extender
+func (e *mathExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithBlockParsers(
+			util.Prioritized(&mathBlockParser{}, 750),
+		),
+		parser.WithInlineParsers(
+			util.Prioritized(&mathInlineParser{}, 150),
+		),
+	)
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&mathHTMLRenderer{}, 500),
+	))
+}
@@ -0,0 +1,409 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+)
+
+// blockStartPattern matches words that would open a new block if wrapped onto a line of their own
+var blockStartPattern = regexp.MustCompile(`^(#{1,6}|[-+*]|=+|-+|\d+[.)]|[>|<].*|\[\^.*|~~~.*|:::.*|\$\$.*|` + "```.*" + `)$`)
+
+// markdownFormatter renders an AST back to canonical markdown: ATX headings,
+// "-" bullets, sequentially numbered ordered lists, ``` fences, and pipe tables
+type markdownFormatter struct {
+	source    []byte
+	width     int            // Wrap width for paragraphs, 0 keeps existing line breaks
+	footnotes map[int]string // Footnote index → label
+}
+
+// formatMarkdown converts a parsed document to canonical markdown
+func formatMarkdown(doc ast.Node, source []byte, width int) string {
+	f := &markdownFormatter{
+		source:    source,
+		width:     width,
+		footnotes: footnoteLabels(doc),
+	}
+
+	return f.children(doc, width, false) + "\n"
+}
+
+// children formats the block children of a node, separated by blank lines unless tight
+func (f *markdownFormatter) children(node ast.Node, width int, tight bool) string {
+	var parts []string
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if part := f.block(child, width); part != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	separator := "\n\n"
+	if tight {
+		separator = "\n"
+	}
+	return strings.Join(parts, separator)
+}
+
+// block formats a single block node without a trailing newline
+func (f *markdownFormatter) block(node ast.Node, width int) string {
+	switch n := node.(type) {
+	case *ast.Heading:
+		return strings.Repeat("#", n.Level) + " " + f.inlines(n, 0)
+	case *ast.Paragraph, *ast.TextBlock:
+		return f.wrap(f.inlines(n, width), width)
+	case *ast.ThematicBreak:
+		return "---"
+	case *ast.CodeBlock:
+		return f.fence(nil, n.Lines().Value(f.source))
+	case *ast.FencedCodeBlock:
+		return f.fence(n.Language(f.source), n.Lines().Value(f.source))
+	case *ast.HTMLBlock:
+		content := n.Lines().Value(f.source)
+		if n.HasClosure() {
+			content = append(content, n.ClosureLine.Value(f.source)...)
+		}
+		return strings.TrimRight(string(content), "\n")
+	case *ast.Blockquote:
+		return prefixLines(f.children(n, width-2, false), "> ", "> ")
+	case *ast.List:
+		return f.list(n, width)
+	case *east.Table:
+		return f.table(n)
+	case *east.DefinitionList:
+		return f.definitionList(n, width)
+	case *east.FootnoteList:
+		return f.children(n, width, false)
+	case *east.Footnote:
+		marker := "[^" + string(n.Ref) + "]: "
+		return prefixLines(f.children(n, width-4, false), marker, "    ")
+	case *Frontmatter:
+		return "---\n" + withNewline(n.Lines().Value(f.source)) + "---"
+	case *MathBlock:
+		return "$$\n" + withNewline(n.Lines().Value(f.source)) + "$$"
+	case *Callout:
+		header := "[!" + strings.ToUpper(n.Variant) + "]"
+		if n.Title != "" {
+			header += " " + n.Title
+		}
+		body := f.children(n, width-2, false)
+		if body != "" {
+			header += "\n" + body
+		}
+		return prefixLines(header, "> ", "> ")
+	case *Container:
+		fence := strings.Repeat(":", 3+containerDepth(n))
+		header := fence + " " + n.Name
+		if n.Title != "" {
+			header += " " + n.Title
+		}
+		if body := f.children(n, width, false); body != "" {
+			header += "\n" + body
+		}
+		return header + "\n" + fence
+	default:
+		return f.children(n, width, false)
+	}
+}
+
+// list formats a list with canonical markers, indenting item content under the marker
+func (f *markdownFormatter) list(list *ast.List, width int) string {
+	var items []string
+	number := list.Start
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "- "
+		if list.IsOrdered() {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+
+		indent := strings.Repeat(" ", len(marker))
+		content := f.children(item, width-len(marker), list.IsTight)
+		items = append(items, prefixLines(content, marker, indent))
+	}
+
+	separator := "\n\n"
+	if list.IsTight {
+		separator = "\n"
+	}
+	return strings.Join(items, separator)
+}
+
+// table formats a GFM table with padded columns
+func (f *markdownFormatter) table(table *east.Table) string {
+	var rows [][]string
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, f.inlines(cell, 0))
+		}
+		rows = append(rows, cells)
+	}
+
+	widths := make([]int, len(table.Alignments))
+	for _, cells := range rows {
+		for i, cell := range cells {
+			if i < len(widths) && len([]rune(cell)) > widths[i] {
+				widths[i] = len([]rune(cell))
+			}
+		}
+	}
+	for i := range widths {
+		if widths[i] < 3 {
+			widths[i] = 3
+		}
+	}
+
+	delimiter := make([]string, len(widths))
+	for i, alignment := range table.Alignments {
+		dashes := strings.Repeat("-", widths[i])
+		switch alignment {
+		case east.AlignLeft:
+			dashes = ":" + dashes[1:]
+		case east.AlignRight:
+			dashes = dashes[1:] + ":"
+		case east.AlignCenter:
+			dashes = ":" + dashes[2:] + ":"
+		}
+		delimiter[i] = dashes
+	}
+
+	var lines []string
+	for i, cells := range rows {
+		padded := make([]string, len(widths))
+		for j := range widths {
+			cell := ""
+			if j < len(cells) {
+				cell = cells[j]
+			}
+			padded[j] = cell + strings.Repeat(" ", widths[j]-len([]rune(cell)))
+		}
+		lines = append(lines, "| "+strings.Join(padded, " | ")+" |")
+		if i == 0 {
+			lines = append(lines, "| "+strings.Join(delimiter, " | ")+" |")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// definitionList formats terms followed by ": description" entries
+func (f *markdownFormatter) definitionList(list *east.DefinitionList, width int) string {
+	var parts []string
+	for child := list.FirstChild(); child != nil; child = child.NextSibling() {
+		switch n := child.(type) {
+		case *east.DefinitionTerm:
+			parts = append(parts, f.inlines(n, 0))
+		case *east.DefinitionDescription:
+			content := f.children(n, width-2, n.IsTight)
+			parts = append(parts, prefixLines(content, ": ", "  "))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// fence formats code as a ``` fenced block, lengthening the fence past any run inside
+func (f *markdownFormatter) fence(language []byte, code []byte) string {
+	fence := "```"
+	for bytes.Contains(code, []byte(fence)) {
+		fence += "`"
+	}
+	return fence + string(language) + "\n" + withNewline(code) + fence
+}
+
+// withNewline returns raw block content ending in a newline, unless it is empty
+func withNewline(content []byte) string {
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		return string(content) + "\n"
+	}
+	return string(content)
+}
+
+// inlines formats the inline children of a node. With a wrap width, soft
+// line breaks become spaces so the paragraph can be re-wrapped.
+func (f *markdownFormatter) inlines(node ast.Node, width int) string {
+	var buf strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		f.inline(&buf, child, width)
+	}
+	return buf.String()
+}
+
+// inline formats a single inline node and its children
+func (f *markdownFormatter) inline(buf *strings.Builder, node ast.Node, width int) {
+	wrapped := func(delimiter string) {
+		buf.WriteString(delimiter + f.inlines(node, width) + delimiter)
+	}
+
+	switch n := node.(type) {
+	case *ast.Text:
+		buf.Write(n.Segment.Value(f.source))
+		switch {
+		case n.HardLineBreak():
+			buf.WriteString("\\\n")
+		case n.SoftLineBreak() && width > 0:
+			buf.WriteString(" ")
+		case n.SoftLineBreak():
+			buf.WriteString("\n")
+		}
+	case *ast.String:
+		buf.Write(n.Value)
+	case *ast.Emphasis:
+		wrapped(strings.Repeat("*", n.Level))
+	case *ast.CodeSpan:
+		buf.WriteString(codeSpan(inlineSource(n, f.source)))
+	case *ast.Link:
+		buf.WriteString("[" + f.inlines(n, width) + "](" + linkTarget(n.Destination, n.Title) + ")")
+	case *ast.Image:
+		buf.WriteString("![" + f.inlines(n, width) + "](" + linkTarget(n.Destination, n.Title) + ")")
+	case *ast.AutoLink:
+		label, url := n.Label(f.source), n.URL(f.source)
+		if bytes.Equal(label, url) {
+			buf.WriteString("<" + string(url) + ">")
+		} else {
+			buf.Write(label)
+		}
+	case *ast.RawHTML:
+		for i := 0; i < n.Segments.Len(); i++ {
+			segment := n.Segments.At(i)
+			buf.Write(segment.Value(f.source))
+		}
+	case *east.Strikethrough:
+		wrapped("~~")
+	case *east.TaskCheckBox:
+		if n.IsChecked {
+			buf.WriteString("[x] ")
+		} else {
+			buf.WriteString("[ ] ")
+		}
+	case *east.FootnoteLink:
+		buf.WriteString("[^" + f.footnotes[n.Index] + "]")
+	case *east.FootnoteBacklink:
+		// Added by the footnote renderer, not part of the source
+	case *Mark:
+		wrapped("==")
+	case *Subscript:
+		wrapped("~")
+	case *Superscript:
+		wrapped("^")
+	case *MathInline:
+		delimiter := "$"
+		if n.Display {
+			delimiter = "$$"
+		}
+		buf.WriteString(delimiter + inlineSource(n, f.source) + delimiter)
+	case *WikiLink:
+		buf.WriteString("[[" + n.Target)
+		if n.Alias != "" {
+			buf.WriteString("|" + n.Alias)
+		}
+		buf.WriteString("]]")
+	default:
+		buf.WriteString(f.inlines(n, width))
+	}
+}
+
+// inlineSource returns the raw source text of a node's text children
+func inlineSource(node ast.Node, source []byte) string {
+	var buf bytes.Buffer
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if text, ok := child.(*ast.Text); ok {
+			buf.Write(text.Segment.Value(source))
+		}
+	}
+	return buf.String()
+}
+
+// wrap re-flows text to the given width, keeping hard breaks and never
+// starting a line with a word that would open a new block
+func (f *markdownFormatter) wrap(text string, width int) string {
+	if f.width <= 0 || width <= 0 {
+		return text
+	}
+
+	var out []string
+	for _, hardLine := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(hardLine) {
+			switch {
+			case line == "":
+				line = word
+			case len(line)+1+len(word) > width && !blockStartPattern.MatchString(word):
+				out = append(out, line)
+				line = word
+			default:
+				line += " " + word
+			}
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// codeSpan wraps code in a backtick run longer than any it contains
+func codeSpan(code string) string {
+	fence := "`"
+	for strings.Contains(code, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		code = " " + code + " "
+	}
+	return fence + code + fence
+}
+
+// linkTarget formats a link destination and optional title
+func linkTarget(destination, title []byte) string {
+	target := string(destination)
+	if target == "" || strings.ContainsAny(target, " ()") {
+		target = "<" + target + ">"
+	}
+	if len(title) > 0 {
+		target += ` "` + strings.ReplaceAll(string(title), `"`, `\"`) + `"`
+	}
+	return target
+}
+
+// footnoteLabels maps footnote indexes, as referenced by footnote links, to their labels
+func footnoteLabels(doc ast.Node) map[int]string {
+	labels := make(map[int]string)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if footnote, ok := n.(*east.Footnote); ok && entering {
+			labels[footnote.Index] = string(footnote.Ref)
+		}
+		return ast.WalkContinue, nil
+	})
+	return labels
+}
+
+// containerDepth counts the containers nested inside a container, so outer fences can be longer
+func containerDepth(node ast.Node) int {
+	depth := 0
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if _, ok := child.(*Container); ok {
+			if d := containerDepth(child) + 1; d > depth {
+				depth = d
+			}
+		}
+	}
+	return depth
+}
+
+// prefixLines prefixes the first line of text with first and the rest with
+// rest, leaving blank lines free of trailing whitespace
+func prefixLines(text, first, rest string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line == "" {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+		lines[i] = prefix + line
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/pkg/diff"
+)
+
+// Conflict markers written around unmerged regions, as git writes them
+const (
+	conflictOurs   = "<<<<<<< ours\n"
+	conflictSep    = "=======\n"
+	conflictTheirs = ">>>>>>> theirs\n"
+)
+
+// Merge combines two edited versions of a document with their common base.
+// Versions are first merged block by block, so edits to different blocks
+// never conflict; blocks changed on both sides are merged line by line, and
+// lines changed differently on both sides are left between conflict markers
+// and reported as conflicts.
+func (p *MarkdownParser) Merge(base, ours, theirs string) (*models.MergeResponse, error) {
+	var chunks [3][]string
+	for i, content := range []string{base, ours, theirs} {
+		result, err := p.Parse(content)
+		if err != nil {
+			return nil, err
+		}
+		chunks[i] = blockChunks(content, result.Tree)
+	}
+
+	regions, err := diff.Merge3(chunks[0], chunks[1], chunks[2])
+	if err != nil {
+		return nil, err
+	}
+
+	var merged strings.Builder
+	conflicts := []models.MergeConflict{}
+	for _, region := range regions {
+		if !region.Conflict {
+			merged.WriteString(strings.Join(region.Merged, ""))
+			continue
+		}
+
+		lines := func(chunks []string) []string {
+			return strings.SplitAfter(strings.Join(chunks, ""), "\n")
+		}
+		lineRegions, err := diff.Merge3(lines(region.Base), lines(region.Ours), lines(region.Theirs))
+		if err != nil {
+			return nil, err
+		}
+		for _, lineRegion := range lineRegions {
+			if !lineRegion.Conflict {
+				merged.WriteString(strings.Join(lineRegion.Merged, ""))
+				continue
+			}
+
+			conflict := models.MergeConflict{
+				Line:   strings.Count(merged.String(), "\n") + 1,
+				Base:   strings.Join(lineRegion.Base, ""),
+				Ours:   strings.Join(lineRegion.Ours, ""),
+				Theirs: strings.Join(lineRegion.Theirs, ""),
+			}
+			if s := merged.String(); s != "" && !strings.HasSuffix(s, "\n") {
+				merged.WriteString("\n")
+				conflict.Line++
+			}
+			merged.WriteString(conflictOurs + withNewline([]byte(conflict.Ours)) + conflictSep + withNewline([]byte(conflict.Theirs)) + conflictTheirs)
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	return &models.MergeResponse{
+		Content:   merged.String(),
+		Conflicts: conflicts,
+		Success:   true,
+	}, nil
+}
+
+// blockChunks splits content at the lines its top-level blocks start on, so
+// each chunk holds one block with the blank lines after it
+func blockChunks(content string, tree []*models.Block) []string {
+	source := []byte(content)
+	var starts []int
+	for _, block := range tree {
+		if block.Position.Start > 0 && block.Position.Start <= len(source) {
+			starts = append(starts, lineStart(source, block.Position.Start))
+		}
+	}
+	sort.Ints(starts)
+
+	var chunks []string
+	prev := 0
+	for _, start := range starts {
+		if start > prev {
+			chunks = append(chunks, content[prev:start])
+			prev = start
+		}
+	}
+	if prev < len(content) {
+		chunks = append(chunks, content[prev:])
+	}
+	return chunks
+}
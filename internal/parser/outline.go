@@ -0,0 +1,41 @@
+package parser
+
+import "markdown-parser/internal/models"
+
+// Outline nests the headings of a parsed document's block tree by level:
+// each heading holds the deeper headings that follow it until the next
+// heading at its level or above. Skipped levels nest under the nearest
+// shallower heading.
+func Outline(tree []*models.Block) []*models.OutlineHeading {
+	outline := []*models.OutlineHeading{}
+	var open []*models.OutlineHeading // Path from the top level to the last heading
+
+	var visit func(blocks []*models.Block)
+	visit = func(blocks []*models.Block) {
+		for _, block := range blocks {
+			if IsHeading(block) {
+				heading := &models.OutlineHeading{
+					BlockID:  block.ID,
+					Level:    block.Level,
+					Text:     block.Text,
+					Slug:     block.Slug,
+					Position: block.Position,
+					Children: []*models.OutlineHeading{},
+				}
+				for len(open) > 0 && open[len(open)-1].Level >= heading.Level {
+					open = open[:len(open)-1]
+				}
+				if len(open) == 0 {
+					outline = append(outline, heading)
+				} else {
+					parent := open[len(open)-1]
+					parent.Children = append(parent.Children, heading)
+				}
+				open = append(open, heading)
+			}
+			visit(block.Children)
+		}
+	}
+	visit(tree)
+	return outline
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+)
+
+// plainTextDocument strips all markdown syntax from a subtree, keeping one
+// line per leaf block and a blank line between top-level blocks. Raw HTML,
+// frontmatter, and footnote references are dropped; images keep their alt text.
+func plainTextDocument(node ast.Node, source []byte) string {
+	var buf bytes.Buffer
+	writePlainText(&buf, node, source)
+	return strings.TrimSpace(buf.String())
+}
+
+// writePlainText appends the plain text of a block node and its descendants
+func writePlainText(buf *bytes.Buffer, node ast.Node, source []byte) {
+	switch n := node.(type) {
+	case *ast.HTMLBlock, *Frontmatter, *ast.ThematicBreak:
+		return
+	case *ast.CodeBlock, *ast.FencedCodeBlock, *MathBlock:
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			segment := lines.At(i)
+			buf.Write(segment.Value(source))
+		}
+		ensureNewline(buf)
+		return
+	case *east.TableHeader, *east.TableRow:
+		for cell := n.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			if cell != n.FirstChild() {
+				buf.WriteByte('\t')
+			}
+			writeInlineText(buf, cell, source)
+		}
+		buf.WriteByte('\n')
+		return
+	case *Callout:
+		if n.Title != "" {
+			buf.WriteString(n.Title + "\n")
+		}
+	case *Container:
+		if n.Title != "" {
+			buf.WriteString(n.Title + "\n")
+		}
+	}
+
+	if node.Type() == ast.TypeBlock && (node.FirstChild() == nil || node.FirstChild().Type() == ast.TypeInline) {
+		writeInlineText(buf, node, source)
+		ensureNewline(buf)
+		return
+	}
+
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if child.Type() != ast.TypeBlock {
+			continue
+		}
+		if node.Kind() == ast.KindDocument && buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		writePlainText(buf, child, source)
+	}
+}
+
+// writeInlineText appends the text of a node's inline descendants
+func writeInlineText(buf *bytes.Buffer, node ast.Node, source []byte) {
+	ast.Walk(node, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+
+		switch v := n.(type) {
+		case *ast.Text:
+			buf.Write(v.Segment.Value(source))
+			if v.SoftLineBreak() || v.HardLineBreak() {
+				buf.WriteByte('\n')
+			}
+		case *ast.String:
+			buf.Write(v.Value)
+		case *ast.AutoLink:
+			buf.Write(v.Label(source))
+		case *WikiLink:
+			if v.Alias != "" {
+				buf.WriteString(v.Alias)
+			} else {
+				buf.WriteString(v.Target)
+			}
+		case *ast.RawHTML, *east.FootnoteLink, *east.TaskCheckBox:
+			return ast.WalkSkipChildren, nil
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// ensureNewline terminates the buffer's last line
+func ensureNewline(buf *bytes.Buffer) {
+	if buf.Len() > 0 && buf.Bytes()[buf.Len()-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+}
@@ -0,0 +1,33 @@
+package parser
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBuffer caps the capacity of buffers returned to the pool, so one
+// very large document doesn't keep its render buffer alive indefinitely
+const maxPooledBuffer = 1 << 20
+
+// bufferPool recycles render buffers across parses and goroutines
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer takes an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns a buffer to the pool. The caller must not use it, or
+// any slice of its bytes, afterwards.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufferPool.Put(buf)
+}
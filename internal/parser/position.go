@@ -0,0 +1,292 @@
+package parser
+
+import (
+	"bytes"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+
+	"markdown-parser/internal/models"
+)
+
+// lineIndex holds the offset at which each line of a source starts
+type lineIndex struct {
+	source []byte
+	starts []int
+}
+
+// newLineIndex indexes the line starts of source
+func newLineIndex(source []byte) *lineIndex {
+	starts := []int{0}
+	for i, c := range source {
+		if c == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return &lineIndex{source: source, starts: starts}
+}
+
+// lineColumn returns the 1-based line and character column of offset
+func (li *lineIndex) lineColumn(offset int) (int, int) {
+	line := sort.SearchInts(li.starts, offset+1)
+	return line, utf8.RuneCount(li.source[li.starts[line-1]:offset]) + 1
+}
+
+// locate fills in the lines and columns of a position from its offsets
+func (li *lineIndex) locate(pos *models.Position) {
+	if pos.Start < 0 || pos.End < pos.Start || pos.End > len(li.source) {
+		return
+	}
+	pos.Line, pos.Column = li.lineColumn(pos.Start)
+	pos.EndLine, pos.EndColumn = pos.Line, pos.Column
+	if pos.End > pos.Start {
+		// Just past the last character, rather than the start of the next line
+		pos.EndLine = sort.SearchInts(li.starts, pos.End)
+		pos.EndColumn = utf8.RuneCount(li.source[li.starts[pos.EndLine-1]:pos.End]) + 1
+	}
+}
+
+// blockPositions computes the source position of every block node under doc
+// (table sections aside, which are part of their table). Containers are
+// extended over their children, whose last fence their own segments may
+// stop short of.
+func blockPositions(doc ast.Node, source []byte, lines *lineIndex) map[ast.Node]*models.Position {
+	positions := make(map[ast.Node]*models.Position)
+
+	// Where the next block can start, for locating blocks without segments
+	cursor := 0
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if n.Type() != ast.TypeBlock {
+			return ast.WalkContinue, nil
+		}
+		switch n.(type) {
+		case *east.TableHeader, *east.TableRow, *east.TableCell:
+			return ast.WalkSkipChildren, nil
+		}
+
+		if entering {
+			start, end := blockSpan(n, source, cursor)
+			positions[n] = &models.Position{Start: start, End: end}
+			if start > cursor {
+				cursor = start
+			}
+			return ast.WalkContinue, nil
+		}
+
+		pos := positions[n]
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			if childPos, ok := positions[child]; ok && childPos.End > pos.End {
+				pos.End = childPos.End
+			}
+		}
+		if pos.End > cursor {
+			cursor = pos.End
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, pos := range positions {
+		lines.locate(pos)
+	}
+	return positions
+}
+
+// blockSpan returns the byte range of a block's full source, including the
+// markup its segments leave out: heading, list, quote, and definition
+// markers, opening and closing fences, setext underlines, and table pipes.
+// Blocks without segments of their own (thematic breaks, empty fences) are
+// located on the first non-blank line at or after cursor. Containers are
+// extended over their children by blockPositions.
+func blockSpan(node ast.Node, source []byte, cursor int) (int, int) {
+	start, end := segmentSpan(node)
+	if end <= start {
+		start = openingMarkup(node, source, skipIndent(source, nextNonBlankLine(source, cursor)))
+		end = lineEnd(source, start)
+	} else {
+		start = markupStart(node, source, start)
+	}
+	end = trimNewline(source, start, end)
+
+	switch node.(type) {
+	case *ast.FencedCodeBlock, *MathBlock, *Container, *Frontmatter:
+		if fence := closingFence(node, source, start, end); fence > end {
+			end = fence
+		}
+	case *ast.Heading:
+		line := trimNewline(source, end, lineEnd(source, end))
+		atx := bytes.HasPrefix(source[start:], []byte("#"))
+		if atx {
+			// Closing sequence of #s
+			end = start + len(bytes.TrimRight(source[start:line], " \t"))
+		} else if next := lineEnd(source, end); next < len(source) && setextUnderlinePattern.Match(bytes.TrimRight(source[next:lineEnd(source, next)], "\r\n")) {
+			end = trimNewline(source, next, lineEnd(source, next))
+		}
+	case *east.Table:
+		// A table without rows ends with its delimiter row, which has no segments
+		if _, headerOnly := node.LastChild().(*east.TableHeader); headerOnly {
+			end = lineEnd(source, end)
+		}
+		end = trimNewline(source, end, lineEnd(source, end))
+		end = start + len(bytes.TrimRight(source[start:end], " \t"))
+	}
+
+	return start, end
+}
+
+// markupStart moves the start of a node's first segment back over the
+// opening markup of the node and of its first descendants on the same line,
+// e.g. "> - ## " before the heading text of a quoted list item
+func markupStart(node ast.Node, source []byte, start int) int {
+	var chain []ast.Node
+	for n := node; n != nil && n.Type() == ast.TypeBlock; n = n.FirstChild() {
+		chain = append(chain, n)
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		n := chain[i]
+		switch n.(type) {
+		case *ast.FencedCodeBlock, *MathBlock, *Container, *Frontmatter:
+			start = openingFence(n, source, start)
+		case *ast.Heading:
+			if at := skipBack(source, start, " \t"); at > 0 && source[at-1] == '#' {
+				start = skipBack(source, at, "#")
+			}
+		case *ast.ListItem:
+			at := skipBack(source, start, " \t")
+			if at > 0 && bytes.IndexByte([]byte("-*+"), source[at-1]) >= 0 {
+				start = at - 1
+			} else if at > 0 && (source[at-1] == '.' || source[at-1] == ')') {
+				start = skipBack(source, at-1, "0123456789")
+			}
+		case *ast.Blockquote, *Callout:
+			if at := skipBack(source, start, " \t"); at > 0 && source[at-1] == '>' {
+				start = at - 1
+			}
+		case *east.Table:
+			if at := skipBack(source, start, " \t"); at > 0 && source[at-1] == '|' {
+				start = at - 1
+			}
+		case *east.DefinitionDescription:
+			if at := skipBack(source, start, " \t"); at > 0 && source[at-1] == ':' {
+				start = at - 1
+			}
+		}
+	}
+	return start
+}
+
+// openingFence returns the start of the fence opening a fenced node whose
+// content, or a nested fence, starts at start
+func openingFence(node ast.Node, source []byte, start int) int {
+	line := previousNonBlankLine(source, lineStart(source, start), 0)
+	marker := map[ast.NodeKind]string{
+		ast.KindFencedCodeBlock: "`~",
+		KindMathBlock:           "$",
+		KindContainer:           ":",
+		KindFrontmatter:         "-",
+	}[node.Kind()]
+	if i := bytes.IndexAny(source[line:lineEnd(source, line)], marker); i >= 0 {
+		return line + i
+	}
+	return line
+}
+
+// closingFence returns the end of the line closing a fenced node that
+// opens at start and whose content ends at end, or -1 if it is unclosed.
+// Inside quotes the closing line carries the same number of > markers as
+// the opening one.
+func closingFence(node ast.Node, source []byte, start, end int) int {
+	next := nextNonBlankLine(source, lineEnd(source, end))
+	if next >= len(source) {
+		return -1
+	}
+	line := source[next:trimNewline(source, next, lineEnd(source, next))]
+
+	prefix := source[lineStart(source, start):start]
+	quotes := bytes.Count(prefix, []byte(">"))
+	for i := 0; i < quotes; i++ {
+		trimmed := bytes.TrimLeft(line, " \t")
+		if !bytes.HasPrefix(trimmed, []byte(">")) {
+			return -1
+		}
+		line = trimmed[1:]
+	}
+	if quotes > 0 || len(prefix) > 0 {
+		line = bytes.TrimLeft(line, " \t")
+	}
+
+	if !closingFencePatterns[node.Kind()].Match(line) {
+		return -1
+	}
+	return trimNewline(source, next, lineEnd(source, next))
+}
+
+// fenceClosed reports whether a fenced node opening at start has a closing line
+func fenceClosed(node ast.Node, source []byte, start int) bool {
+	end := trimNewline(source, start, lineEnd(source, start))
+	if _, contentEnd := segmentSpan(node); contentEnd > end {
+		end = trimNewline(source, start, contentEnd)
+	}
+	return closingFence(node, source, start, end) >= 0
+}
+
+// openingMarkup skips forward from the start of a line over the list and
+// quote markers of the containers a segmentless node is the first child of
+func openingMarkup(node ast.Node, source []byte, pos int) int {
+	var containers []ast.Node
+	for n := node; n.Parent() != nil && n.Parent().FirstChild() == n; n = n.Parent() {
+		containers = append(containers, n.Parent())
+	}
+
+	for i := len(containers) - 1; i >= 0; i-- {
+		switch containers[i].(type) {
+		case *ast.ListItem:
+			at := skipForward(source, pos, "0123456789")
+			if at > pos && at < len(source) && (source[at] == '.' || source[at] == ')') {
+				pos = at + 1
+			} else if pos < len(source) && bytes.IndexByte([]byte("-*+"), source[pos]) >= 0 {
+				pos++
+			}
+		case *ast.Blockquote, *Callout:
+			if pos < len(source) && source[pos] == '>' {
+				pos++
+			}
+		default:
+			continue
+		}
+		pos = skipForward(source, pos, " \t")
+	}
+	return pos
+}
+
+// skipForward returns the offset after the run of bytes from set starting at pos
+func skipForward(source []byte, pos int, set string) int {
+	for pos < len(source) && bytes.IndexByte([]byte(set), source[pos]) >= 0 {
+		pos++
+	}
+	return pos
+}
+
+// skipBack returns the offset before the run of bytes from set ending at pos
+func skipBack(source []byte, pos int, set string) int {
+	for pos > 0 && bytes.IndexByte([]byte(set), source[pos-1]) >= 0 {
+		pos--
+	}
+	return pos
+}
+
+// skipIndent returns the offset of the first non-space byte of the line at pos
+func skipIndent(source []byte, pos int) int {
+	return skipForward(source, pos, " \t")
+}
+
+// trimNewline drops the line ending from the end of the range [start, end)
+func trimNewline(source []byte, start, end int) int {
+	for end > start && (source[end-1] == '\n' || source[end-1] == '\r') {
+		end--
+	}
+	return end
+}
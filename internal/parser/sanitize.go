@@ -0,0 +1,110 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+
+	"markdown-parser/configs"
+)
+
+// HTML sanitization policies supported by ParserConfig.SanitizePolicy
+const (
+	SanitizeNone   = "none"   // Raw HTML passes through untouched
+	SanitizeStrict = "strict" // All markup is stripped, leaving text only
+	SanitizeGFM    = "gfm"    // User-generated content plus the markup this parser emits
+	SanitizeCustom = "custom" // Only the markup in the sanitize config
+)
+
+// iframeAttributes are the attributes embedded players use, allowed on
+// iframes from the configured hosts
+var iframeAttributes = []string{"width", "height", "title", "allow", "allowfullscreen", "frameborder", "loading", "referrerpolicy"}
+
+var (
+	// classPattern restricts class attributes to plain class name lists
+	classPattern = regexp.MustCompile(`^[\w\- ]+$`)
+
+	// idPattern allows heading slugs (including Unicode ones) and footnote anchors
+	idPattern = regexp.MustCompile(`^[\p{L}\p{N}\-_:.]+$`)
+)
+
+// newSanitizer builds the bluemonday policy for a policy name, or nil when
+// HTML is left as-is. The gfm policy is widened by the sanitize config,
+// which the custom policy starts empty and allows.
+func newSanitizer(policy string, config configs.ParserConfig) *bluemonday.Policy {
+	var p *bluemonday.Policy
+	switch policy {
+	case SanitizeStrict:
+		return bluemonday.StrictPolicy()
+	case SanitizeGFM:
+		p = gfmPolicy()
+	case SanitizeCustom:
+		p = bluemonday.NewPolicy()
+	default:
+		return nil
+	}
+	allowConfigured(p, config.Sanitize)
+	return p
+}
+
+// allowConfigured adds the configured tags, attributes, URL schemes, and
+// iframe hosts to a policy
+func allowConfigured(p *bluemonday.Policy, config configs.SanitizeConfig) {
+	if len(config.Elements) > 0 {
+		p.AllowElements(config.Elements...)
+	}
+	for tag, attrs := range config.Attributes {
+		if tag != "*" {
+			p.AllowElements(tag)
+		}
+		if len(attrs) == 0 {
+			continue
+		}
+		if tag == "*" {
+			p.AllowAttrs(attrs...).Globally()
+		} else {
+			p.AllowAttrs(attrs...).OnElements(tag)
+		}
+	}
+	if len(config.URLSchemes) > 0 {
+		p.AllowURLSchemes(config.URLSchemes...)
+	}
+
+	if len(config.IframeHosts) > 0 {
+		hosts := make([]string, len(config.IframeHosts))
+		for i, host := range config.IframeHosts {
+			hosts[i] = regexp.QuoteMeta(strings.ToLower(host))
+		}
+		p.AllowURLSchemes("https")
+		p.AllowAttrs("src").Matching(regexp.MustCompile(`^https://(?:` + strings.Join(hosts, "|") + `)(?:/|$)`)).OnElements("iframe")
+		p.AllowAttrs(iframeAttributes...).OnElements("iframe")
+	}
+}
+
+// gfmPolicy allows GitHub-style user content plus the classes, ids, and
+// inline styles produced by this parser's extensions and syntax highlighter
+func gfmPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.RequireNoFollowOnLinks(false) // Keep wikilink and footnote anchors unchanged
+
+	p.AllowAttrs("class").Matching(classPattern).Globally()
+	p.AllowAttrs("id").Matching(idPattern).Globally()
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-[a-z]+$`)).Globally()
+	p.AllowAttrs(sourcePosAttribute).Matching(regexp.MustCompile(`^\d+:\d+-\d+:\d+$`)).Globally()
+
+	// Task list checkboxes
+	p.AllowElements("input")
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+
+	// Highlight, subscript/superscript, and chroma's inline styles
+	p.AllowElements("mark", "sub", "sup")
+	p.AllowAttrs("tabindex").OnElements("pre")
+	p.AllowStyles(
+		"color", "background-color", "font-weight", "font-style", "text-decoration",
+		"display", "white-space", "user-select", "margin-right", "padding",
+	).OnElements("pre", "span")
+
+	return p
+}
@@ -0,0 +1,173 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+)
+
+// slackEscaper escapes the characters Slack treats as control sequences
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// slackRenderer renders an AST as Slack mrkdwn. Slack has no headings,
+// lists, or tables, so headings become bold lines, list items get text
+// bullets, and tables are laid out inside a code block.
+type slackRenderer struct {
+	source    []byte
+	footnotes map[int]string // Footnote index → label
+}
+
+// renderSlack converts a parsed document to Slack mrkdwn
+func renderSlack(doc ast.Node, source []byte) string {
+	r := &slackRenderer{
+		source:    source,
+		footnotes: footnoteLabels(doc),
+	}
+	return r.children(doc, "\n\n")
+}
+
+// children renders the block children of a node joined by separator
+func (r *slackRenderer) children(node ast.Node, separator string) string {
+	var parts []string
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		if part := r.block(child); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, separator)
+}
+
+// block renders a single block node
+func (r *slackRenderer) block(node ast.Node) string {
+	switch n := node.(type) {
+	case *ast.Heading:
+		return "*" + r.inlines(n) + "*"
+	case *ast.Paragraph, *ast.TextBlock:
+		return r.inlines(n)
+	case *ast.ThematicBreak:
+		return "———"
+	case *ast.CodeBlock, *ast.FencedCodeBlock, *MathBlock:
+		return "```\n" + withNewline(n.Lines().Value(r.source)) + "```"
+	case *ast.HTMLBlock, *Frontmatter:
+		return ""
+	case *ast.Blockquote:
+		return prefixLines(r.children(n, "\n"), "> ", "> ")
+	case *Callout:
+		header := "*" + strings.ToUpper(n.Variant[:1]) + n.Variant[1:] + "*"
+		if n.Title != "" {
+			header += " " + slackEscaper.Replace(n.Title)
+		}
+		if body := r.children(n, "\n"); body != "" {
+			header += "\n" + body
+		}
+		return prefixLines(header, "> ", "> ")
+	case *Container:
+		header := "*" + slackEscaper.Replace(n.Name) + "*"
+		if n.Title != "" {
+			header = "*" + slackEscaper.Replace(n.Title) + "*"
+		}
+		return header + "\n" + r.children(n, "\n")
+	case *ast.List:
+		return r.list(n)
+	case *east.Table:
+		return "```\n" + plainTextDocument(n, r.source) + "\n```"
+	case *east.DefinitionList:
+		var lines []string
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			if term, ok := child.(*east.DefinitionTerm); ok {
+				lines = append(lines, "*"+r.inlines(term)+"*")
+			} else {
+				lines = append(lines, prefixLines(r.children(child, "\n"), "    ", "    "))
+			}
+		}
+		return strings.Join(lines, "\n")
+	case *east.FootnoteList:
+		return r.children(n, "\n")
+	case *east.Footnote:
+		return "[" + string(n.Ref) + "] " + r.children(n, " ")
+	default:
+		return r.children(n, "\n\n")
+	}
+}
+
+// list renders list items with bullets or numbers, indenting nested content
+func (r *slackRenderer) list(list *ast.List) string {
+	var items []string
+	number := list.Start
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "• "
+		if list.IsOrdered() {
+			marker = fmt.Sprintf("%d. ", number)
+			number++
+		}
+		items = append(items, prefixLines(r.children(item, "\n"), marker, "    "))
+	}
+	return strings.Join(items, "\n")
+}
+
+// inlines renders the inline children of a node
+func (r *slackRenderer) inlines(node ast.Node) string {
+	var buf strings.Builder
+	for child := node.FirstChild(); child != nil; child = child.NextSibling() {
+		r.inline(&buf, child)
+	}
+	return buf.String()
+}
+
+// inline renders a single inline node and its children
+func (r *slackRenderer) inline(buf *strings.Builder, node ast.Node) {
+	switch n := node.(type) {
+	case *ast.Text:
+		buf.WriteString(slackEscaper.Replace(string(n.Segment.Value(r.source))))
+		if n.SoftLineBreak() || n.HardLineBreak() {
+			buf.WriteString("\n")
+		}
+	case *ast.String:
+		buf.WriteString(slackEscaper.Replace(string(n.Value)))
+	case *ast.Emphasis:
+		delimiter := "_"
+		if n.Level >= 2 {
+			delimiter = "*"
+		}
+		buf.WriteString(delimiter + r.inlines(n) + delimiter)
+	case *east.Strikethrough:
+		buf.WriteString("~" + r.inlines(n) + "~")
+	case *ast.CodeSpan, *MathInline:
+		buf.WriteString("`" + slackEscaper.Replace(inlineSource(n, r.source)) + "`")
+	case *ast.Link:
+		buf.WriteString(slackLink(string(n.Destination), r.inlines(n)))
+	case *ast.Image:
+		buf.WriteString(slackLink(string(n.Destination), r.inlines(n)))
+	case *ast.AutoLink:
+		buf.WriteString(slackLink(string(n.URL(r.source)), slackEscaper.Replace(string(n.Label(r.source)))))
+	case *WikiLink:
+		label := n.Target
+		if n.Alias != "" {
+			label = n.Alias
+		}
+		buf.WriteString(slackEscaper.Replace(label))
+	case *east.TaskCheckBox:
+		if n.IsChecked {
+			buf.WriteString("☑ ")
+		} else {
+			buf.WriteString("☐ ")
+		}
+	case *east.FootnoteLink:
+		buf.WriteString("[" + r.footnotes[n.Index] + "]")
+	case *ast.RawHTML, *east.FootnoteBacklink:
+		// No Slack equivalent
+	default:
+		buf.WriteString(r.inlines(n))
+	}
+}
+
+// slackLink formats a <url|label> link, or a bare <url> when the label is empty
+func slackLink(url, label string) string {
+	url = strings.NewReplacer("<", "%3C", ">", "%3E", "|", "%7C").Replace(url)
+	if label == "" {
+		return "<" + url + ">"
+	}
+	return "<" + url + "|" + label + ">"
+}
@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// slidesStyle and slidesScript make the presentation usable without
+// reveal.js: one slide is shown at a time and arrow keys, space, or a
+// click move between slides. The markup follows reveal.js's
+// .reveal > .slides > section layout, so the file can also be opened
+// with the real library by adding its stylesheet and script.
+const slidesStyle = `html, body { margin: 0; height: 100%; font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; background: #fff; color: #222; }
+.reveal, .slides { height: 100%; }
+.slides > section { display: none; box-sizing: border-box; height: 100%; padding: 6vh 8vw; font-size: 1.4rem; overflow: auto; }
+.slides > section.present { display: block; }
+.slides pre { padding: 1em; overflow: auto; }
+.slides img { max-width: 100%; }
+.progress { position: fixed; bottom: 1rem; right: 1.5rem; color: #888; font-size: 0.9rem; }`
+
+const slidesScript = `(function () {
+  var slides = document.querySelectorAll(".slides > section");
+  var progress = document.querySelector(".progress");
+  var current = 0;
+  function show(index) {
+    current = Math.max(0, Math.min(slides.length - 1, index));
+    for (var i = 0; i < slides.length; i++) {
+      slides[i].classList.toggle("present", i === current);
+    }
+    progress.textContent = (current + 1) + " / " + slides.length;
+    history.replaceState(null, "", "#/" + current);
+  }
+  document.addEventListener("keydown", function (e) {
+    if (e.key === "ArrowRight" || e.key === "ArrowDown" || e.key === " " || e.key === "PageDown") { show(current + 1); }
+    if (e.key === "ArrowLeft" || e.key === "ArrowUp" || e.key === "PageUp") { show(current - 1); }
+    if (e.key === "Home") { show(0); }
+    if (e.key === "End") { show(slides.length - 1); }
+  });
+  document.addEventListener("click", function (e) {
+    if (e.target.closest("a")) { return; }
+    show(e.clientX < window.innerWidth / 3 ? current - 1 : current + 1);
+  });
+  show(parseInt((location.hash.match(/^#\/(\d+)/) || [])[1] || "0", 10));
+})();`
+
+// renderSlides splits a document on top-level thematic breaks (---) and
+// returns a self-contained HTML presentation with one <section> per slide
+func (p *MarkdownParser) renderSlides(doc ast.Node, source []byte, title string) string {
+	var slides []string
+	var current strings.Builder
+
+	for child := doc.FirstChild(); child != nil; child = child.NextSibling() {
+		switch child.(type) {
+		case *Frontmatter:
+			continue
+		case *ast.ThematicBreak:
+			slides = append(slides, current.String())
+			current.Reset()
+			continue
+		}
+		current.WriteString(p.renderNodeToHTML(child, source))
+	}
+	slides = append(slides, current.String())
+
+	var buf strings.Builder
+	buf.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	buf.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	fmt.Fprintf(&buf, "<title>%s</title>\n", html.EscapeString(title))
+	buf.WriteString("<style>\n" + slidesStyle + "\n</style>\n</head>\n<body>\n")
+	buf.WriteString("<div class=\"reveal\">\n<div class=\"slides\">\n")
+	for _, slide := range slides {
+		if strings.TrimSpace(slide) == "" {
+			continue
+		}
+		buf.WriteString("<section>\n" + slide + "</section>\n")
+	}
+	buf.WriteString("</div>\n</div>\n<div class=\"progress\"></div>\n")
+	buf.WriteString("<script>\n" + slidesScript + "\n</script>\n</body>\n</html>\n")
+
+	return buf.String()
+}
+
+// slidesTitle picks a presentation title: frontmatter title, then the first heading
+func slidesTitle(doc ast.Node, source []byte, frontmatter map[string]interface{}) string {
+	if title, ok := frontmatter["title"].(string); ok && title != "" {
+		return title
+	}
+
+	var title string
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if heading, ok := n.(*ast.Heading); ok && entering {
+			title = plainText(heading, source)
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+	if title == "" {
+		return "Slides"
+	}
+	return title
+}
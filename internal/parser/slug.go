@@ -0,0 +1,106 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"markdown-parser/internal/models"
+)
+
+// Heading ID strategies supported by ParserConfig.HeadingIDStrategy
+const (
+	SlugStrategyGitHub = "github" // Unicode-aware, punctuation stripped, like GitHub anchors
+	SlugStrategyASCII  = "ascii"  // ASCII letters and digits only, like goldmark's default
+)
+
+// headingIDs generates heading anchor IDs for a single document. Repeated
+// slugs are deduplicated deterministically with -1, -2, ... suffixes in
+// document order, so the same source always yields the same IDs.
+type headingIDs struct {
+	strategy string
+	prefix   string
+	suffix   string
+	used     map[string]bool
+}
+
+// newHeadingIDs creates an ID generator for one parse
+func newHeadingIDs(strategy, prefix, suffix string) parser.IDs {
+	return &headingIDs{
+		strategy: strategy,
+		prefix:   prefix,
+		suffix:   suffix,
+		used:     make(map[string]bool),
+	}
+}
+
+// Generate implements parser.IDs
+func (ids *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	var slug string
+	switch ids.strategy {
+	case SlugStrategyASCII:
+		slug = asciiSlug(string(value))
+	default:
+		slug = githubSlug(string(value))
+	}
+
+	if slug == "" {
+		if kind == ast.KindHeading {
+			slug = "heading"
+		} else {
+			slug = "id"
+		}
+	}
+
+	id := ids.prefix + slug + ids.suffix
+	for i := 1; ids.used[id]; i++ {
+		id = fmt.Sprintf("%s%s-%d%s", ids.prefix, slug, i, ids.suffix)
+	}
+	ids.used[id] = true
+
+	return []byte(id)
+}
+
+// Put implements parser.IDs
+func (ids *headingIDs) Put(value []byte) {
+	ids.used[string(value)] = true
+}
+
+// githubSlug lowercases text, drops punctuation, and turns spaces into hyphens
+func githubSlug(value string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(value) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r) || r == '-' || r == '_':
+			b.WriteRune(unicode.ToLower(r))
+		case r == ' ':
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// asciiSlug keeps ASCII letters and digits, mapping whitespace, '-' and '_' to hyphens
+func asciiSlug(value string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimSpace(value) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(unicode.ToLower(r))
+		case unicode.IsSpace(r) || r == '-' || r == '_':
+			b.WriteRune('-')
+		}
+	}
+	return b.String()
+}
+
+// IsHeading reports whether a block is a heading, whose type names its level
+func IsHeading(block *models.Block) bool {
+	switch block.Type {
+	case "h1", "h2", "h3", "h4", "h5", "h6", "heading":
+		return true
+	}
+	return false
+}
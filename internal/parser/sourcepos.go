@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// sourcePosAttribute is the attribute carrying a block's source range in the
+// rendered HTML, as "line:column-endLine:endColumn" like cmark's --sourcepos
+const sourcePosAttribute = "data-sourcepos"
+
+// sourcePosTransformer annotates block nodes with their source positions
+type sourcePosTransformer struct{}
+
+// Transform implements parser.ASTTransformer
+func (t *sourcePosTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	for node, pos := range blockPositions(doc, source, newLineIndex(source)) {
+		node.SetAttributeString(sourcePosAttribute, []byte(fmt.Sprintf("%d:%d-%d:%d",
+			pos.Line, pos.Column, pos.EndLine, pos.EndColumn)))
+	}
+}
+
+// sourcePosExtension adds data-sourcepos attributes to rendered blocks
+type sourcePosExtension struct{}
+
+// SourcePosExtension is a goldmark extension that emits data-sourcepos on
+// block elements, so a preview can be mapped back to the markdown source.
+// Fenced and indented code blocks render without attributes.
+var SourcePosExtension = &sourcePosExtension{}
+
+// Extend implements goldmark.Extender
+func (e *sourcePosExtension) Extend(m goldmark.Markdown) {
+	// Transformers run in ascending priority; this one must see the final
+	// tree, after callouts and footnotes (999) have been rewritten
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(&sourcePosTransformer{}, 1000),
+	))
+}
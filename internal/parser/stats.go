@@ -0,0 +1,44 @@
+package parser
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+
+	"markdown-parser/internal/models"
+)
+
+// wordsPerMinute is the reading speed behind DocumentStats.ReadingMinutes
+const wordsPerMinute = 200
+
+// documentStats counts what editors show about a document: words and
+// characters of its plain text, and its headings, code blocks, links, and
+// images
+func documentStats(doc ast.Node, source []byte) *models.DocumentStats {
+	text := plainTextDocument(doc, source)
+	stats := &models.DocumentStats{
+		Words:      len(strings.Fields(text)),
+		Characters: utf8.RuneCountInString(text),
+		Headings:   make(map[int]int),
+	}
+	stats.ReadingMinutes = (stats.Words + wordsPerMinute - 1) / wordsPerMinute
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch v := n.(type) {
+		case *ast.Heading:
+			stats.Headings[v.Level]++
+		case *ast.FencedCodeBlock, *ast.CodeBlock:
+			stats.CodeBlocks++
+		case *ast.Link, *ast.AutoLink, *WikiLink:
+			stats.Links++
+		case *ast.Image:
+			stats.Images++
+		}
+		return ast.WalkContinue, nil
+	})
+	return stats
+}
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindSubscript is the node kind for ~subscript~ text
+var KindSubscript = ast.NewNodeKind("Subscript")
+
+// KindSuperscript is the node kind for ^superscript^ text
+var KindSuperscript = ast.NewNodeKind("Superscript")
+
+// Subscript represents text delimited by single tildes
+type Subscript struct {
+	ast.BaseInline
+}
+
+// Kind implements ast.Node.Kind
+func (n *Subscript) Kind() ast.NodeKind {
+	return KindSubscript
+}
+
+// Dump implements ast.Node.Dump
+func (n *Subscript) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// Superscript represents text delimited by single carets
+type Superscript struct {
+	ast.BaseInline
+}
+
+// Kind implements ast.Node.Kind
+func (n *Superscript) Kind() ast.NodeKind {
+	return KindSuperscript
+}
+
+// Dump implements ast.Node.Dump
+func (n *Superscript) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// scriptDelimiterProcessor matches single-character delimiter runs
+type scriptDelimiterProcessor struct {
+	char    byte
+	newNode func() ast.Node
+}
+
+func (p *scriptDelimiterProcessor) IsDelimiter(b byte) bool {
+	return b == p.char
+}
+
+func (p *scriptDelimiterProcessor) CanOpenCloser(opener, closer *parser.Delimiter) bool {
+	return opener.Char == closer.Char && opener.Length == 1 && closer.Length == 1
+}
+
+func (p *scriptDelimiterProcessor) OnMatch(consumes int) ast.Node {
+	return p.newNode()
+}
+
+// scriptParser parses spans delimited by a single character, leaving
+// longer runs (such as ~~strikethrough~~) to other parsers
+type scriptParser struct {
+	processor *scriptDelimiterProcessor
+}
+
+func (s *scriptParser) Trigger() []byte {
+	return []byte{s.processor.char}
+}
+
+func (s *scriptParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	before := block.PrecendingCharacter()
+	line, segment := block.PeekLine()
+	node := parser.ScanDelimiter(line, before, 1, s.processor)
+	if node == nil || node.OriginalLength != 1 || before == rune(s.processor.char) {
+		return nil
+	}
+
+	node.Segment = segment.WithStop(segment.Start + node.OriginalLength)
+	block.Advance(node.OriginalLength)
+	pc.PushDelimiter(node)
+	return node
+}
+
+func (s *scriptParser) CloseBlock(parent ast.Node, pc parser.Context) {}
+
+// scriptHTMLRenderer renders subscript and superscript nodes
+type scriptHTMLRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer.RegisterFuncs
+func (r *scriptHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindSubscript, renderTag("sub"))
+	reg.Register(KindSuperscript, renderTag("sup"))
+}
+
+// renderTag returns a renderer wrapping a node's children in the given tag
+func renderTag(tag string) renderer.NodeRendererFunc {
+	return func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			_, _ = w.WriteString("<" + tag + ">")
+		} else {
+			_, _ = w.WriteString("</" + tag + ">")
+		}
+		return ast.WalkContinue, nil
+	}
+}
+
+// scriptExtension adds ~sub~ and/or ^sup^ support
+type scriptExtension struct {
+	subscript   bool
+	superscript bool
+}
+
+// NewScriptExtension returns an extension for ~subscript~ and ^superscript^ syntax
+func NewScriptExtension(subscript, superscript bool) goldmark.Extender {
+	return &scriptExtension{subscript: subscript, superscript: superscript}
+}
+
+// Extend implements goldmark.Extender
+func (e *scriptExtension) Extend(m goldmark.Markdown) {
+	if e.subscript {
+		// Runs before strikethrough so single tildes become subscript
+		m.Parser().AddOptions(parser.WithInlineParsers(
+			util.Prioritized(&scriptParser{&scriptDelimiterProcessor{
+				char:    '~',
+				newNode: func() ast.Node { return &Subscript{} },
+			}}, 499),
+		))
+	}
+	if e.superscript {
+		m.Parser().AddOptions(parser.WithInlineParsers(
+			util.Prioritized(&scriptParser{&scriptDelimiterProcessor{
+				char:    '^',
+				newNode: func() ast.Node { return &Superscript{} },
+			}}, 500),
+		))
+	}
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&scriptHTMLRenderer{}, 500),
+	))
+}
@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// KindWikiLink is the node kind for [[page]] wikilinks
+var KindWikiLink = ast.NewNodeKind("WikiLink")
+
+// WikiLink represents an internal [[Page]] or [[Page|alias]] link
+type WikiLink struct {
+	ast.BaseInline
+	Target      string
+	Alias       string
+	Destination string
+}
+
+// Kind implements ast.Node.Kind
+func (n *WikiLink) Kind() ast.NodeKind {
+	return KindWikiLink
+}
+
+// Dump implements ast.Node.Dump
+func (n *WikiLink) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{
+		"Target":      n.Target,
+		"Alias":       n.Alias,
+		"Destination": n.Destination,
+	}, nil)
+}
+
+// Label returns the link text shown to readers
+func (n *WikiLink) Label() string {
+	if n.Alias != "" {
+		return n.Alias
+	}
+	return n.Target
+}
+
+// wikilinkHref expands the {page} placeholder of an href template
+func wikilinkHref(template, target string) string {
+	return strings.ReplaceAll(template, "{page}", url.PathEscape(target))
+}
+
+// wikilinkParser parses [[Page]] and [[Page|alias]] spans
+type wikilinkParser struct {
+	hrefTemplate string
+}
+
+func (s *wikilinkParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (s *wikilinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("[[")) {
+		return nil
+	}
+
+	closer := bytes.Index(line, []byte("]]"))
+	if closer < 0 {
+		return nil
+	}
+
+	inner := string(line[2:closer])
+	if strings.ContainsAny(inner, "[]\n") {
+		return nil
+	}
+
+	target, alias, _ := strings.Cut(inner, "|")
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return nil
+	}
+
+	block.Advance(closer + 2)
+	return &WikiLink{
+		Target:      target,
+		Alias:       strings.TrimSpace(alias),
+		Destination: wikilinkHref(s.hrefTemplate, target),
+	}
+}
+
+// wikilinkHTMLRenderer renders wikilinks as anchors
+type wikilinkHTMLRenderer struct{}
+
+// RegisterFuncs implements renderer.NodeRenderer.RegisterFuncs
+func (r *wikilinkHTMLRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindWikiLink, r.renderWikiLink)
+}
+
+func (r *wikilinkHTMLRenderer) renderWikiLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+
+	n := node.(*WikiLink)
+	_, _ = w.WriteString(`<a class="wikilink" href="`)
+	_, _ = w.Write(util.EscapeHTML(util.URLEscape([]byte(n.Destination), true)))
+	_, _ = w.WriteString(`">`)
+	_, _ = w.Write(util.EscapeHTML([]byte(n.Label())))
+	_, _ = w.WriteString("</a>")
+
+	return ast.WalkSkipChildren, nil
+}
+
+// wikilinkExtension adds [[page]] wikilink support
+type wikilinkExtension struct {
+	hrefTemplate string
+}
+
+// NewWikilinkExtension returns a wikilink extension; {page} in the template is replaced by the link target
+func NewWikilinkExtension(hrefTemplate string) goldmark.Extender {
+	return &wikilinkExtension{hrefTemplate: hrefTemplate}
+}
+
+// Extend implements goldmark.Extender
+func (e *wikilinkExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&wikilinkParser{hrefTemplate: e.hrefTemplate}, 199),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&wikilinkHTMLRenderer{}, 500),
+	))
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Bucket is a token bucket: it holds up to burst tokens, refills at rate
+// tokens a second, and each allowed request takes one
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket creates a full bucket
+func NewBucket(rate float64, burst int) *Bucket {
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+	}
+}
+
+// PerMinute creates a full bucket allowing n requests a minute, all of
+// which may come at once
+func PerMinute(n int) *Bucket {
+	return NewBucket(float64(n)/60, n)
+}
+
+// Take takes a token at now, or reports how long until one is available
+func (b *Bucket) Take(now time.Time) (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.last.IsZero() {
+		b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	if b.rate <= 0 {
+		return false, time.Duration(math.MaxInt64)
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// RetryAfter rounds a wait up to whole seconds, for the Retry-After header
+func RetryAfter(wait time.Duration) int {
+	return int(math.Ceil(wait.Seconds()))
+}
+
+// sweepInterval is how often a limiter forgets idle clients
+const sweepInterval = time.Minute
+
+// Limiter keeps a token bucket for each client, forgetting clients idle
+// long enough for their bucket to have refilled
+type Limiter struct {
+	rate      float64
+	burst     int
+	mu        sync.Mutex
+	buckets   map[string]*Bucket
+	lastSweep time.Time
+}
+
+// New creates a limiter allowing each client rate requests a second, and
+// burst at once; without a rate it allows every request
+func New(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   max(burst, 1),
+		buckets: make(map[string]*Bucket),
+	}
+}
+
+// Allow takes a token from a client's bucket at now, or reports how long
+// until one is available
+func (l *Limiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return true, 0
+	}
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = NewBucket(l.rate, l.burst)
+		l.buckets[client] = bucket
+	}
+	l.mu.Unlock()
+
+	return bucket.Take(now)
+}
+
+// SetRate changes the rate and burst allowed each client, such as when the
+// configuration is reloaded. Clients start over with full buckets.
+func (l *Limiter) SetRate(rate float64, burst int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate, l.burst = rate, max(burst, 1)
+	clear(l.buckets)
+}
+
+// sweep forgets the clients whose buckets are full again; the caller holds mu
+func (l *Limiter) sweep(now time.Time) {
+	refill := time.Duration(float64(l.burst) / l.rate * float64(time.Second))
+	for client, bucket := range l.buckets {
+		bucket.mu.Lock()
+		idle := now.Sub(bucket.last) >= refill
+		bucket.mu.Unlock()
+		if idle {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
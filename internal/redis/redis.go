@@ -0,0 +1,334 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"markdown-parser/configs"
+)
+
+// timeout bounds dialing and each command's round trip
+const timeout = 5 * time.Second
+
+// idleConns is the number of connections kept open between commands
+const idleConns = 8
+
+// Error is an error reply from Redis
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// errUnexpectedReply is returned for a reply of the wrong type for a command
+var errUnexpectedReply = errors.New("redis: unexpected reply")
+
+// Client sends commands to a Redis server over a small pool of
+// connections. It speaks just enough RESP for the commands used here and is
+// safe for concurrent use.
+type Client struct {
+	addr     string
+	password string
+	db       int
+	prefix   string
+	idle     chan *conn
+}
+
+// conn is a connection with its buffered reader
+type conn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// New creates a client for the configured server, or returns nil if Redis
+// is not configured. Connections are made when first needed.
+func New(config configs.RedisConfig) *Client {
+	if config.Addr == "" {
+		return nil
+	}
+	return &Client{
+		addr:     config.Addr,
+		password: config.Password,
+		db:       config.DB,
+		prefix:   config.KeyPrefix,
+		idle:     make(chan *conn, idleConns),
+	}
+}
+
+// Key prefixes a key with the configured prefix
+func (c *Client) Key(parts ...string) string {
+	key := c.prefix
+	for _, part := range parts {
+		key += part
+	}
+	return key
+}
+
+// Do sends a command and returns its reply: a string for simple strings,
+// an int64 for integers, a []byte or nil for bulk strings, and an
+// []interface{} for arrays. Error replies are returned as Error.
+func (c *Client) Do(args ...string) (interface{}, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		cn.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Ping checks that the server answers
+func (c *Client) Ping() error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// Get returns the value of a key, or false if it is not set
+func (c *Client) Get(key string) ([]byte, bool, error) {
+	reply, err := c.Do("GET", key)
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, errUnexpectedReply
+	}
+	return value, true, nil
+}
+
+// Set sets the value of a key, expiring after ttl unless it is 0
+func (c *Client) Set(key string, value []byte, ttl time.Duration) error {
+	args := []string{"SET", key, string(value)}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	}
+	_, err := c.Do(args...)
+	return err
+}
+
+// Del deletes a key, reporting whether it was set
+func (c *Client) Del(key string) (bool, error) {
+	reply, err := c.Do("DEL", key)
+	if err != nil {
+		return false, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return false, errUnexpectedReply
+	}
+	return n > 0, nil
+}
+
+// Keys lists the keys matching a glob pattern, scanning the keyspace a
+// batch at a time rather than blocking the server with KEYS
+func (c *Client) Keys(pattern string) ([]string, error) {
+	var keys []string
+	cursor := "0"
+	for {
+		reply, err := c.Do("SCAN", cursor, "MATCH", pattern, "COUNT", "100")
+		if err != nil {
+			return nil, err
+		}
+		parts, ok := reply.([]interface{})
+		if !ok || len(parts) != 2 {
+			return nil, errUnexpectedReply
+		}
+		next, ok := parts[0].([]byte)
+		batch, ok2 := parts[1].([]interface{})
+		if !ok || !ok2 {
+			return nil, errUnexpectedReply
+		}
+		for _, key := range batch {
+			if key, ok := key.([]byte); ok {
+				keys = append(keys, string(key))
+			}
+		}
+		if cursor = string(next); cursor == "0" {
+			return keys, nil
+		}
+	}
+}
+
+// Append adds a value to the end of the list at a key, trimming the list
+// to its last max values unless max is 0
+func (c *Client) Append(key string, value []byte, max int) error {
+	if _, err := c.Do("RPUSH", key, string(value)); err != nil {
+		return err
+	}
+	if max > 0 {
+		_, err := c.Do("LTRIM", key, strconv.Itoa(-max), "-1")
+		return err
+	}
+	return nil
+}
+
+// Last returns the last value of the list at a key, or false if it is empty
+func (c *Client) Last(key string) ([]byte, bool, error) {
+	reply, err := c.Do("LINDEX", key, "-1")
+	if err != nil || reply == nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, errUnexpectedReply
+	}
+	return value, true, nil
+}
+
+// SetLast replaces the last value of the list at a key
+func (c *Client) SetLast(key string, value []byte) error {
+	_, err := c.Do("LSET", key, "-1", string(value))
+	return err
+}
+
+// List returns the values of the list at a key, first to last
+func (c *Client) List(key string) ([][]byte, error) {
+	reply, err := c.Do("LRANGE", key, "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, errUnexpectedReply
+	}
+	values := make([][]byte, 0, len(items))
+	for _, item := range items {
+		if value, ok := item.([]byte); ok {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
+
+// get takes an idle connection or dials a new one, authenticating and
+// selecting the database
+func (c *Client) get() (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	netConn, err := net.DialTimeout("tcp", c.addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	if c.password != "" {
+		if _, err := cn.do([]string{"AUTH", c.password}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := cn.do([]string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// put returns a connection to the pool, closing it if the pool is full
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		cn.Close()
+	}
+}
+
+// do writes a command as an array of bulk strings and reads its reply
+func (cn *conn) do(args []string) (interface{}, error) {
+	cn.SetDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 0, 64)
+	buf = fmt.Appendf(buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		buf = fmt.Appendf(buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := cn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readReply(cn.reader)
+}
+
+// readReply reads one RESP reply
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, Error(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				var replyErr Error
+				if !errors.As(err, &replyErr) {
+					return nil, err
+				}
+				items[i] = replyErr
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// Namespace is a view of a client whose keys all share a prefix after the
+// configured one, such as the shared parse cache's
+type Namespace struct {
+	client *Client
+	prefix string
+}
+
+// Namespace returns a view of the client keeping its keys under name
+func (c *Client) Namespace(name string) *Namespace {
+	return &Namespace{client: c, prefix: c.Key(name, ":")}
+}
+
+// Get returns the value of a key in the namespace, or false if it is not set
+func (n *Namespace) Get(key string) ([]byte, bool, error) {
+	return n.client.Get(n.prefix + key)
+}
+
+// Set sets the value of a key in the namespace, expiring after ttl unless
+// it is 0
+func (n *Namespace) Set(key string, value []byte, ttl time.Duration) error {
+	return n.client.Set(n.prefix+key, value, ttl)
+}
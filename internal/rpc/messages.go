@@ -0,0 +1,434 @@
+package rpc
+
+import (
+	"encoding/json"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"markdown-parser/internal/models"
+)
+
+// The messages of markdown.proto, encoded and decoded field by field to and
+// from the models the REST API uses. Fields decoders do not know are
+// skipped, so clients built from a newer markdown.proto still work.
+
+// StreamRequest is a StreamDocumentUpdatesRequest
+type StreamRequest struct {
+	DocumentID string
+	Types      []string
+}
+
+// DocumentUpdate is one update of a StreamDocumentUpdates stream
+type DocumentUpdate struct {
+	Type      string
+	Sequence  uint64
+	Data      json.RawMessage
+	Timestamp time.Time
+}
+
+// value is a decoded field: varints in number, length-delimited fields in
+// bytes
+type value struct {
+	number uint64
+	bytes  []byte
+}
+
+// decode calls field with each field of a message
+func decode(b []byte, field func(num protowire.Number, v value) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v value
+		switch typ {
+		case protowire.VarintType:
+			v.number, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			v.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := field(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendString appends a string field unless it is empty, as proto3 does
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendBytes appends a bytes or message field unless it is empty
+func appendBytes(b []byte, num protowire.Number, data []byte) []byte {
+	if len(data) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, data)
+}
+
+// appendMessage appends a message field, even if it has no fields set
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+// appendInt appends an int32, int64, or uint64 field unless it is 0
+func appendInt(b []byte, num protowire.Number, n int64) []byte {
+	if n == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(n))
+}
+
+// appendOptionalInt appends an optional int32 field if it is set
+func appendOptionalInt(b []byte, num protowire.Number, n *int) []byte {
+	if n == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(*n)))
+}
+
+// appendOptionalBool appends an optional bool field if it is set
+func appendOptionalBool(b []byte, num protowire.Number, v *bool) []byte {
+	if v == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(*v))
+}
+
+// intPtr and boolPtr decode optional fields
+func intPtr(v value) *int {
+	n := int(int32(v.number))
+	return &n
+}
+
+func boolPtr(v value) *bool {
+	b := protowire.DecodeBool(v.number)
+	return &b
+}
+
+// MarshalParseRequest encodes a ParseRequest
+func MarshalParseRequest(req models.ParseRequest) []byte {
+	b := appendString(nil, 1, req.Content)
+	return appendMessage(b, 2, marshalOptions(req))
+}
+
+// UnmarshalParseRequest decodes a ParseRequest
+func UnmarshalParseRequest(b []byte) (models.ParseRequest, error) {
+	var req models.ParseRequest
+	err := decode(b, func(num protowire.Number, v value) error {
+		switch num {
+		case 1:
+			req.Content = string(v.bytes)
+		case 2:
+			return unmarshalOptions(v.bytes, &req)
+		}
+		return nil
+	})
+	return req, err
+}
+
+// marshalOptions encodes the ParseOptions of a request
+func marshalOptions(req models.ParseRequest) []byte {
+	b := appendString(nil, 1, req.Format)
+	b = appendString(b, 2, req.Theme)
+	b = appendOptionalBool(b, 3, req.LineNumbers)
+	b = appendString(b, 4, req.Sanitize)
+	b = appendString(b, 5, req.Dialect)
+	b = appendOptionalInt(b, 6, req.WrapWidth)
+	return appendOptionalBool(b, 7, req.SourcePositions)
+}
+
+// unmarshalOptions decodes ParseOptions into a request
+func unmarshalOptions(b []byte, req *models.ParseRequest) error {
+	return decode(b, func(num protowire.Number, v value) error {
+		switch num {
+		case 1:
+			req.Format = string(v.bytes)
+		case 2:
+			req.Theme = string(v.bytes)
+		case 3:
+			req.LineNumbers = boolPtr(v)
+		case 4:
+			req.Sanitize = string(v.bytes)
+		case 5:
+			req.Dialect = string(v.bytes)
+		case 6:
+			req.WrapWidth = intPtr(v)
+		case 7:
+			req.SourcePositions = boolPtr(v)
+		}
+		return nil
+	})
+}
+
+// MarshalParseIncrementalRequest encodes a ParseIncrementalRequest
+func MarshalParseIncrementalRequest(req models.ParseRequest) []byte {
+	b := appendString(nil, 1, req.Content)
+	if req.Edit != nil {
+		edit := appendInt(nil, 1, int64(req.Edit.Start))
+		edit = appendInt(edit, 2, int64(req.Edit.End))
+		edit = appendString(edit, 3, req.Edit.Text)
+		b = appendMessage(b, 2, edit)
+	}
+	b = appendString(b, 3, req.Granularity)
+	if req.ChangesOnly {
+		b = appendInt(b, 4, 1)
+	}
+	return b
+}
+
+// UnmarshalParseIncrementalRequest decodes a ParseIncrementalRequest
+func UnmarshalParseIncrementalRequest(b []byte) (models.ParseRequest, error) {
+	var req models.ParseRequest
+	err := decode(b, func(num protowire.Number, v value) error {
+		switch num {
+		case 1:
+			req.Content = string(v.bytes)
+		case 2:
+			req.Edit = &models.Edit{}
+			return decode(v.bytes, func(num protowire.Number, v value) error {
+				switch num {
+				case 1:
+					req.Edit.Start = int(int32(v.number))
+				case 2:
+					req.Edit.End = int(int32(v.number))
+				case 3:
+					req.Edit.Text = string(v.bytes)
+				}
+				return nil
+			})
+		case 3:
+			req.Granularity = string(v.bytes)
+		case 4:
+			req.ChangesOnly = protowire.DecodeBool(v.number)
+		}
+		return nil
+	})
+	return req, err
+}
+
+// MarshalParseResponse encodes a ParseResponse
+func MarshalParseResponse(response *models.ParseResponse) ([]byte, error) {
+	b := appendString(nil, 1, response.HTML)
+	for _, block := range response.Tree {
+		b = appendMessage(b, 2, marshalBlock(block))
+	}
+	for _, change := range response.Changes {
+		c := appendString(nil, 1, change.Type)
+		c = appendString(c, 2, change.BlockID)
+		if change.Block != nil {
+			c = appendMessage(c, 3, marshalBlock(change.Block))
+		}
+		c = appendOptionalInt(c, 4, change.OldIndex)
+		c = appendOptionalInt(c, 5, change.NewIndex)
+		b = appendMessage(b, 3, c)
+	}
+	b = appendString(b, 4, response.Output)
+	if response.Frontmatter != nil {
+		frontmatter, err := json.Marshal(response.Frontmatter)
+		if err != nil {
+			return nil, err
+		}
+		b = appendBytes(b, 5, frontmatter)
+	}
+	return b, nil
+}
+
+// UnmarshalParseResponse decodes a ParseResponse
+func UnmarshalParseResponse(b []byte) (*models.ParseResponse, error) {
+	response := &models.ParseResponse{Success: true}
+	err := decode(b, func(num protowire.Number, v value) error {
+		switch num {
+		case 1:
+			response.HTML = string(v.bytes)
+		case 2:
+			block, err := unmarshalBlock(v.bytes)
+			if err != nil {
+				return err
+			}
+			response.Tree = append(response.Tree, block)
+		case 3:
+			var change models.BlockChange
+			err := decode(v.bytes, func(num protowire.Number, v value) (err error) {
+				switch num {
+				case 1:
+					change.Type = string(v.bytes)
+				case 2:
+					change.BlockID = string(v.bytes)
+				case 3:
+					change.Block, err = unmarshalBlock(v.bytes)
+				case 4:
+					change.OldIndex = intPtr(v)
+				case 5:
+					change.NewIndex = intPtr(v)
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			response.Changes = append(response.Changes, change)
+		case 4:
+			response.Output = string(v.bytes)
+		case 5:
+			return json.Unmarshal(v.bytes, &response.Frontmatter)
+		}
+		return nil
+	})
+	return response, err
+}
+
+// marshalBlock encodes a Block with its children
+func marshalBlock(block *models.Block) []byte {
+	b := appendString(nil, 1, block.ID)
+	b = appendInt(b, 2, int64(block.Index))
+	b = appendString(b, 3, block.Type)
+	b = appendInt(b, 4, int64(block.Level))
+	b = appendString(b, 5, block.Content)
+	b = appendString(b, 6, block.HTML)
+
+	p := block.Position
+	position := appendInt(nil, 1, int64(p.Start))
+	position = appendInt(position, 2, int64(p.End))
+	position = appendInt(position, 3, int64(p.Line))
+	position = appendInt(position, 4, int64(p.Column))
+	position = appendInt(position, 5, int64(p.EndLine))
+	position = appendInt(position, 6, int64(p.EndColumn))
+	b = appendMessage(b, 7, position)
+
+	b = appendString(b, 8, block.Text)
+	b = appendString(b, 9, block.ParentID)
+	for _, child := range block.Children {
+		b = appendMessage(b, 10, marshalBlock(child))
+	}
+	return b
+}
+
+// unmarshalBlock decodes a Block with its children
+func unmarshalBlock(b []byte) (*models.Block, error) {
+	block := &models.Block{}
+	err := decode(b, func(num protowire.Number, v value) error {
+		switch num {
+		case 1:
+			block.ID = string(v.bytes)
+		case 2:
+			block.Index = int(int32(v.number))
+		case 3:
+			block.Type = string(v.bytes)
+		case 4:
+			block.Level = int(int32(v.number))
+		case 5:
+			block.Content = string(v.bytes)
+		case 6:
+			block.HTML = string(v.bytes)
+		case 7:
+			p := &block.Position
+			return decode(v.bytes, func(num protowire.Number, v value) error {
+				n := int(int32(v.number))
+				switch num {
+				case 1:
+					p.Start = n
+				case 2:
+					p.End = n
+				case 3:
+					p.Line = n
+				case 4:
+					p.Column = n
+				case 5:
+					p.EndLine = n
+				case 6:
+					p.EndColumn = n
+				}
+				return nil
+			})
+		case 8:
+			block.Text = string(v.bytes)
+		case 9:
+			block.ParentID = string(v.bytes)
+		case 10:
+			child, err := unmarshalBlock(v.bytes)
+			if err != nil {
+				return err
+			}
+			block.Children = append(block.Children, child)
+		}
+		return nil
+	})
+	return block, err
+}
+
+// Marshal encodes a StreamDocumentUpdatesRequest
+func (r StreamRequest) Marshal() []byte {
+	b := appendString(nil, 1, r.DocumentID)
+	for _, t := range r.Types {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, t)
+	}
+	return b
+}
+
+// UnmarshalStreamRequest decodes a StreamDocumentUpdatesRequest
+func UnmarshalStreamRequest(b []byte) (StreamRequest, error) {
+	var r StreamRequest
+	err := decode(b, func(num protowire.Number, v value) error {
+		switch num {
+		case 1:
+			r.DocumentID = string(v.bytes)
+		case 2:
+			r.Types = append(r.Types, string(v.bytes))
+		}
+		return nil
+	})
+	return r, err
+}
+
+// Marshal encodes a DocumentUpdate
+func (u DocumentUpdate) Marshal() []byte {
+	b := appendString(nil, 1, u.Type)
+	b = appendInt(b, 2, int64(u.Sequence))
+	b = appendBytes(b, 3, u.Data)
+	return appendInt(b, 4, u.Timestamp.UnixMilli())
+}
+
+// UnmarshalDocumentUpdate decodes a DocumentUpdate
+func UnmarshalDocumentUpdate(b []byte) (DocumentUpdate, error) {
+	var u DocumentUpdate
+	err := decode(b, func(num protowire.Number, v value) error {
+		switch num {
+		case 1:
+			u.Type = string(v.bytes)
+		case 2:
+			u.Sequence = v.number
+		case 3:
+			u.Data = json.RawMessage(v.bytes)
+		case 4:
+			u.Timestamp = time.UnixMilli(int64(v.number))
+		}
+		return nil
+	})
+	return u, err
+}
@@ -0,0 +1,393 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"markdown-parser/configs"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/documents"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/internal/workpool"
+	"markdown-parser/pkg/diff"
+)
+
+// servicePath prefixes the paths of the service's methods
+const servicePath = "/markdown.v1.MarkdownParser/"
+
+// gRPC status codes, see https://grpc.github.io/grpc/core/md_doc_statuscodes.html
+const (
+	codeOK                = 0
+	codeInvalidArgument   = 3
+	codeNotFound          = 5
+	codePermissionDenied  = 7
+	codeResourceExhausted = 8
+	codeUnimplemented     = 12
+	codeInternal          = 13
+	codeUnavailable       = 14
+	codeUnauthenticated   = 16
+)
+
+// statusError is an error with its gRPC status code
+type statusError struct {
+	code    int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// errorStatus wraps message with a status code
+func errorStatus(code int, format string, args ...interface{}) error {
+	return &statusError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// parseStatus maps a parser error to its gRPC status, as parseErrorStatus
+// maps it to an HTTP status for the API
+func parseStatus(err error) error {
+	switch {
+	case errors.Is(err, parser.ErrContentTooLarge), errors.Is(err, diff.ErrTooLarge), errors.Is(err, workpool.ErrQueueFull):
+		return errorStatus(codeResourceExhausted, "%v", err)
+	case errors.Is(err, parser.ErrInvalidText):
+		return errorStatus(codeInvalidArgument, "%v", err)
+	case errors.Is(err, workpool.ErrShutdown):
+		return errorStatus(codeUnavailable, "%v", err)
+	}
+	return errorStatus(codeInternal, "Failed to parse markdown: %v", err)
+}
+
+// Watcher streams a document's updates, such as the WebSocket hub
+type Watcher interface {
+	Watch(documentID string) (<-chan models.WebSocketResponse, func())
+	Snapshot(documentID string) (*models.ParseResponse, uint64, error)
+}
+
+// DocumentStore keeps the permissions of stored documents, such as
+// documents.Store
+type DocumentStore interface {
+	Get(documentID string) (models.Document, error)
+}
+
+// Server serves the MarkdownParser service of markdown.proto. It speaks
+// the gRPC protocol over net/http's HTTP/2 support, so it needs no gRPC
+// runtime; messages are uncompressed protobuf.
+type Server struct {
+	parser         atomic.Pointer[parser.MarkdownParser] // Replaced by Reconfigure
+	jobs           *workpool.Pool
+	watcher        Watcher
+	documents      DocumentStore
+	auth           *auth.Authenticator
+	maxMessageSize int64
+}
+
+// NewServer creates the service, parsing on jobs and streaming the updates
+// watcher sees
+func NewServer(config *configs.Config, jobs *workpool.Pool, watcher Watcher) *Server {
+	s := &Server{
+		jobs:    jobs,
+		watcher: watcher,
+		auth:    auth.New(config.Auth),
+	}
+	s.parser.Store(parser.NewMarkdownParserWithConfig(config.Parser))
+	if size := config.Parser.MaxContentSize; size > 0 {
+		// Room for the options and, for edits, the edit's text
+		s.maxMessageSize = 2*size + 64*1024
+	}
+	return s
+}
+
+// SetAuthenticator sets the authenticator calls are made with, such as the
+// one the REST API shares, so calls count against the same API key limits
+// and usage
+func (s *Server) SetAuthenticator(authenticator *auth.Authenticator) {
+	s.auth = authenticator
+}
+
+// SetDocumentStore checks the store's document permissions before a
+// document's updates are streamed to a user
+func (s *Server) SetDocumentStore(store DocumentStore) {
+	s.documents = store
+}
+
+// Reconfigure parses with a new parser configuration, such as a reloaded
+// one, from the next call on
+func (s *Server) Reconfigure(config configs.ParserConfig) {
+	s.parser.Store(parser.NewMarkdownParserWithConfig(config))
+}
+
+// Handler returns the service as a handler for cleartext HTTP/2 with prior
+// knowledge, as gRPC clients connect without TLS
+func (s *Server) Handler() http.Handler {
+	return h2c.NewHandler(s, &http2.Server{})
+}
+
+// ServeHTTP dispatches a gRPC call to its method
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", "application/grpc")
+	header.Add("Trailer", "Grpc-Status")
+	header.Add("Trailer", "Grpc-Message")
+
+	var user *models.User
+	if s.auth != nil {
+		authenticated, _, err := s.auth.Admit(auth.RequestToken(r))
+		if errors.Is(err, auth.ErrRateLimited) {
+			writeStatus(w, errorStatus(codeResourceExhausted, "%v", err))
+			return
+		}
+		if err != nil {
+			writeStatus(w, errorStatus(codeUnauthenticated, "Valid API key or bearer token required"))
+			return
+		}
+		user = &authenticated
+	}
+
+	var err error
+	switch method := strings.TrimPrefix(r.URL.Path, servicePath); method {
+	case "Parse":
+		err = s.unary(w, r, s.parse)
+	case "ParseIncremental":
+		err = s.unary(w, r, s.parseIncremental)
+	case "StreamDocumentUpdates":
+		err = s.streamDocumentUpdates(w, r, user)
+	default:
+		err = errorStatus(codeUnimplemented, "Unknown method %s", r.URL.Path)
+	}
+	writeStatus(w, err)
+}
+
+// writeStatus ends a call with its status in the trailers
+func writeStatus(w http.ResponseWriter, err error) {
+	code, message := codeOK, ""
+	if err != nil {
+		var status *statusError
+		if !errors.As(err, &status) {
+			status = &statusError{code: codeInternal, message: err.Error()}
+		}
+		code, message = status.code, status.message
+	}
+	header := w.Header()
+	header.Set("Grpc-Status", fmt.Sprint(code))
+	if message != "" {
+		// Messages are percent-encoded as the protocol asks
+		header.Set("Grpc-Message", strings.ReplaceAll(url.QueryEscape(message), "+", "%20"))
+	}
+}
+
+// readMessage reads the single length-prefixed message of a unary call
+func (s *Server) readMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, errorStatus(codeInvalidArgument, "Missing request message: %v", err)
+	}
+	if prefix[0] != 0 {
+		return nil, errorStatus(codeUnimplemented, "Compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if s.maxMessageSize > 0 && int64(size) > s.maxMessageSize {
+		return nil, errorStatus(codeResourceExhausted, "Message of %d bytes exceeds the limit of %d", size, s.maxMessageSize)
+	}
+	message := make([]byte, size)
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, errorStatus(codeInvalidArgument, "Truncated request message: %v", err)
+	}
+	return message, nil
+}
+
+// writeMessage writes a length-prefixed message and flushes it to the client
+func writeMessage(w http.ResponseWriter, message []byte) error {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	if _, err := w.Write(append(frame, message...)); err != nil {
+		return err
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// unary runs a call with one request and one response message
+func (s *Server) unary(w http.ResponseWriter, r *http.Request, call func([]byte) ([]byte, error)) error {
+	request, err := s.readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	response, err := call(request)
+	if err != nil {
+		return err
+	}
+	return writeMessage(w, response)
+}
+
+// runParse runs a parse on the worker pool and encodes its result
+func (s *Server) runParse(parse func() (*models.ParseResponse, error)) ([]byte, error) {
+	var response *models.ParseResponse
+	err := s.jobs.Run(func() (err error) {
+		response, err = parse()
+		return err
+	})
+	if err != nil {
+		return nil, parseStatus(err)
+	}
+	return MarshalParseResponse(response)
+}
+
+// parse implements Parse
+func (s *Server) parse(message []byte) ([]byte, error) {
+	req, err := UnmarshalParseRequest(message)
+	if err != nil {
+		return nil, errorStatus(codeInvalidArgument, "Invalid ParseRequest: %v", err)
+	}
+	opts := parser.ParseOptions{
+		HighlightTheme:  req.Theme,
+		LineNumbers:     req.LineNumbers,
+		SanitizePolicy:  req.Sanitize,
+		Dialect:         req.Dialect,
+		Format:          req.Format,
+		WrapWidth:       req.WrapWidth,
+		SourcePositions: req.SourcePositions,
+	}
+	return s.runParse(func() (*models.ParseResponse, error) {
+		return s.parser.Load().ParseWithOptions(req.Content, opts)
+	})
+}
+
+// parseIncremental implements ParseIncremental: the edit is applied to the
+// content, or without one every block is reported as added
+func (s *Server) parseIncremental(message []byte) ([]byte, error) {
+	req, err := UnmarshalParseIncrementalRequest(message)
+	if err != nil {
+		return nil, errorStatus(codeInvalidArgument, "Invalid ParseIncrementalRequest: %v", err)
+	}
+	if !diff.ValidGranularity(req.Granularity) {
+		return nil, errorStatus(codeInvalidArgument, "Unknown granularity %q; use block, line, or word", req.Granularity)
+	}
+	content, edit := req.Content, models.Edit{Text: req.Content}
+	if req.Edit != nil {
+		edit = *req.Edit
+	} else {
+		content = ""
+	}
+	return s.runParse(func() (*models.ParseResponse, error) {
+		doc, err := s.parser.Load().NewDocument(content)
+		if err != nil {
+			return nil, err
+		}
+		doc.Granularity = req.Granularity
+		response, err := doc.ApplyEdit(edit)
+		if err != nil || !req.ChangesOnly {
+			return response, err
+		}
+		return parser.ChangesOnly(response), nil
+	})
+}
+
+// checkRead fails with PERMISSION_DENIED unless user may read a document.
+// Everyone may when authentication is disabled, and documents the store
+// does not keep are open to everyone, as over WebSocket.
+func (s *Server) checkRead(user *models.User, documentID string) error {
+	if user == nil || s.documents == nil {
+		return nil
+	}
+	doc, err := s.documents.Get(documentID)
+	if errors.Is(err, documents.ErrUnknownDocument) {
+		return nil
+	}
+	if err != nil {
+		return errorStatus(codeInternal, "Failed to check permissions: %v", err)
+	}
+	if !auth.Allowed(*user, doc, auth.Read) {
+		return errorStatus(codePermissionDenied, "Not permitted to read document %s", documentID)
+	}
+	return nil
+}
+
+// streamDocumentUpdates implements StreamDocumentUpdates: a snapshot of
+// the document, then its updates until the client cancels. The stream ends
+// with UNAVAILABLE if the client falls behind or the server shuts down, so
+// the client resubscribes.
+func (s *Server) streamDocumentUpdates(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	message, err := s.readMessage(r.Body)
+	if err != nil {
+		return err
+	}
+	req, err := UnmarshalStreamRequest(message)
+	if err != nil {
+		return errorStatus(codeInvalidArgument, "Invalid StreamDocumentUpdatesRequest: %v", err)
+	}
+	if s.watcher == nil {
+		return errorStatus(codeUnimplemented, "Document updates are not available")
+	}
+	if err := s.checkRead(user, req.DocumentID); err != nil {
+		return err
+	}
+
+	// Watch before taking the snapshot so no update falls between them
+	events, stop := s.watcher.Watch(req.DocumentID)
+	defer stop()
+	snapshot, sequence, err := s.watcher.Snapshot(req.DocumentID)
+	if err != nil {
+		return parseStatus(err)
+	}
+	if snapshot == nil {
+		return errorStatus(codeNotFound, "Unknown document: %s", req.DocumentID)
+	}
+	if err := sendUpdate(w, "snapshot", sequence, snapshot, time.Now()); err != nil {
+		return err
+	}
+
+	var types map[string]bool
+	if len(req.Types) > 0 {
+		types = make(map[string]bool, len(req.Types))
+		for _, t := range req.Types {
+			types[t] = true
+		}
+	}
+	for {
+		select {
+		case response, ok := <-events:
+			if !ok {
+				return errorStatus(codeUnavailable, "Update stream ended; resubscribe")
+			}
+			if types != nil && !types[response.Type] {
+				continue
+			}
+			if err := sendUpdate(w, response.Type, response.Sequence, response.Data, response.Timestamp); err != nil {
+				log.Printf("WARN: Sending a document update over gRPC: %v", err)
+				return err
+			}
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+}
+
+// sendUpdate writes one DocumentUpdate with its data as JSON
+func sendUpdate(w http.ResponseWriter, updateType string, sequence uint64, data interface{}, timestamp time.Time) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	update := DocumentUpdate{Type: updateType, Sequence: sequence, Data: encoded, Timestamp: timestamp}
+	return writeMessage(w, update.Marshal())
+}
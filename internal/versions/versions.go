@@ -0,0 +1,150 @@
+package versions
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+)
+
+// ErrUnknownDocument is returned for a document that is neither being
+// edited nor has versions
+var ErrUnknownDocument = errors.New("unknown document")
+
+// Source provides the documents being edited, such as the WebSocket hub's
+type Source interface {
+	DocumentIDs() []string
+	DocumentContent(documentID string) (string, bool)
+}
+
+// Store keeps snapshots of documents in memory, numbered per document
+type Store struct {
+	source      Source
+	interval    time.Duration
+	maxVersions int
+
+	versions map[string][]models.DocumentVersion // Oldest first
+	next     map[string]int                      // Number of each document's next version
+	mu       sync.Mutex
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewStore creates a store of versions of the documents of source
+func NewStore(config configs.VersionsConfig, source Source) *Store {
+	return &Store{
+		source:      source,
+		interval:    time.Duration(config.IntervalSeconds) * time.Second,
+		maxVersions: config.MaxVersions,
+		versions:    make(map[string][]models.DocumentVersion),
+		next:        make(map[string]int),
+		stop:        make(chan struct{}),
+	}
+}
+
+// Run snapshots every document that changed once per interval, until Stop;
+// it returns at once if periodic snapshots are disabled
+func (s *Store) Run() {
+	if s.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.SnapshotAll()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Stop ends Run
+func (s *Store) Stop() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// SnapshotAll snapshots every document being edited that changed since its
+// latest version
+func (s *Store) SnapshotAll() {
+	created := 0
+	for _, documentID := range s.source.DocumentIDs() {
+		if _, ok, err := s.Snapshot(documentID); err == nil && ok {
+			created++
+		}
+	}
+	if created > 0 {
+		log.Printf("INFO: Snapshotted %d documents", created)
+	}
+}
+
+// Snapshot saves the current content of a document as a new version, unless
+// it is unchanged since the latest, which is returned instead. It reports
+// whether a version was created.
+func (s *Store) Snapshot(documentID string) (models.DocumentVersion, bool, error) {
+	content, ok := s.source.DocumentContent(documentID)
+	if !ok {
+		return models.DocumentVersion{}, false, ErrUnknownDocument
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.versions[documentID]
+	if n := len(versions); n > 0 && versions[n-1].Content == content {
+		return versions[n-1], false, nil
+	}
+	s.next[documentID]++
+	version := models.DocumentVersion{
+		DocumentID: documentID,
+		Version:    s.next[documentID],
+		CreatedAt:  time.Now(),
+		Size:       len(content),
+		Content:    content,
+	}
+	versions = append(versions, version)
+	if s.maxVersions > 0 && len(versions) > s.maxVersions {
+		versions = append([]models.DocumentVersion(nil), versions[len(versions)-s.maxVersions:]...)
+	}
+	s.versions[documentID] = versions
+	return version, true, nil
+}
+
+// List returns the versions kept of a document, oldest first, without
+// their content
+func (s *Store) List(documentID string) ([]models.DocumentVersion, error) {
+	s.mu.Lock()
+	versions, ok := s.versions[documentID]
+	s.mu.Unlock()
+	if !ok {
+		if _, editing := s.source.DocumentContent(documentID); !editing {
+			return nil, ErrUnknownDocument
+		}
+	}
+
+	list := make([]models.DocumentVersion, len(versions))
+	for i, v := range versions {
+		v.Content = ""
+		list[i] = v
+	}
+	return list, nil
+}
+
+// Get returns a version of a document, or false if it is not kept
+func (s *Store) Get(documentID string, version int) (models.DocumentVersion, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.versions[documentID]
+	i := sort.Search(len(versions), func(i int) bool { return versions[i].Version >= version })
+	if i == len(versions) || versions[i].Version != version {
+		return models.DocumentVersion{}, false
+	}
+	return versions[i], true
+}
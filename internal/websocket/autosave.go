@@ -0,0 +1,104 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"markdown-parser/internal/documents"
+	"markdown-parser/internal/models"
+)
+
+// scheduleSave saves a changed document to the document store once the
+// autosave window has passed since its first unsaved change, or at once if
+// autosave is not debounced. As with coalesced parses, the window is not
+// extended by later changes, so continuous typing is still saved. The
+// caller holds the shard's lock.
+func (h *Hub) scheduleSave(shard *documentShard, documentID string) {
+	if h.documents == nil {
+		return
+	}
+	if h.autosave <= 0 {
+		h.saveDocument(shard, documentID)
+		return
+	}
+
+	h.savesMu.Lock()
+	defer h.savesMu.Unlock()
+	if _, pending := h.saves[documentID]; pending {
+		return
+	}
+	h.saves[documentID] = time.AfterFunc(h.autosave, func() {
+		h.savesMu.Lock()
+		delete(h.saves, documentID)
+		h.savesMu.Unlock()
+
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+		h.saveDocument(shard, documentID)
+	})
+}
+
+// saveDocument saves the latest content of a document the hub holds and
+// tells its subscribers the revision saved; the caller holds the shard's
+// lock
+func (h *Hub) saveDocument(shard *documentShard, documentID string) {
+	doc, ok := shard.documents[documentID]
+	if !ok {
+		return
+	}
+	revision, err := h.documents.SaveContent(documentID, doc.Content())
+	if errors.Is(err, documents.ErrUnknownDocument) {
+		return // Only documents created through the API are kept
+	}
+	if err != nil {
+		log.Printf("WARN: Autosaving document %s: %v", documentID, err)
+		return
+	}
+
+	h.broadcastToDocument(documentID, models.WebSocketResponse{
+		Type:    "saved",
+		Success: true,
+		Data: models.SavedEvent{
+			DocumentID: documentID,
+			Version:    revision,
+			Sequence:   shard.history[documentID].last,
+		},
+		Timestamp: time.Now(),
+	}, nil)
+}
+
+// cancelSave drops the pending save of a document, such as one deleted
+func (h *Hub) cancelSave(documentID string) {
+	h.savesMu.Lock()
+	defer h.savesMu.Unlock()
+	if timer, ok := h.saves[documentID]; ok {
+		timer.Stop()
+		delete(h.saves, documentID)
+	}
+}
+
+// flushSaves saves every document with a pending save at once, so changes
+// are not lost on shutdown
+func (h *Hub) flushSaves() {
+	h.savesMu.Lock()
+	pending := make([]string, 0, len(h.saves))
+	for documentID, timer := range h.saves {
+		// A timer that already fired is saving its document itself
+		if timer.Stop() {
+			pending = append(pending, documentID)
+		}
+		delete(h.saves, documentID)
+	}
+	h.savesMu.Unlock()
+
+	for _, documentID := range pending {
+		shard := h.documentShard(documentID)
+		shard.mu.Lock()
+		h.saveDocument(shard, documentID)
+		shard.mu.Unlock()
+	}
+	if len(pending) > 0 {
+		log.Printf("INFO: Saved %d documents on shutdown", len(pending))
+	}
+}
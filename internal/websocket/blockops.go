@@ -0,0 +1,195 @@
+package websocket
+
+import (
+	"time"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/pkg/diff"
+	"markdown-parser/pkg/ot"
+)
+
+// handleBlockOperation applies an insert_block, update_block, delete_block,
+// move_block, or convert_block message to a stored document and sends the
+// operation with the changed blocks to the client and the document's other
+// subscribers
+func (h *Hub) handleBlockOperation(client *Client, msg models.WebSocketMessage) {
+	if msg.DocumentID == "" {
+		h.replyError(client, msg, "Document ID is required for block operations")
+		return
+	}
+	op := models.BlockOperation{
+		Type:      msg.Type,
+		BlockID:   msg.BlockID,
+		AfterID:   msg.AfterID,
+		Content:   msg.Content,
+		BlockType: msg.BlockType,
+	}
+
+	var result *models.ParseResponse
+	var sequence uint64
+	err := h.jobs.Run(func() (err error) {
+		result, sequence, err = h.applyBlockOperation(msg.DocumentID, h.author(msg.DocumentID, client), op)
+		return err
+	})
+	if err != nil {
+		h.replyError(client, msg, "Failed to apply "+msg.Type+": "+err.Error())
+		return
+	}
+
+	event := models.BlockOperationEvent{
+		DocumentID: msg.DocumentID,
+		Operation:  op,
+		Changes:    result.Changes,
+	}
+	if user, ok := h.identity(msg.DocumentID, client); ok {
+		event.User = &user
+	}
+	response := models.WebSocketResponse{
+		Type:      "block_operation",
+		Success:   true,
+		Data:      event,
+		Sequence:  sequence,
+		Timestamp: time.Now(),
+	}
+	h.reply(client, msg, response)
+	h.broadcastToDocument(msg.DocumentID, response, client)
+}
+
+// applyBlockOperation applies a block operation to a stored document,
+// creating it empty if need be, and records the change for replay and undo
+func (h *Hub) applyBlockOperation(documentID, author string, op models.BlockOperation) (*models.ParseResponse, uint64, error) {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	doc, err := h.openDocument(shard, documentID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Block operations report their changes block by block
+	doc.Granularity = diff.GranularityBlock
+	before := doc.Content()
+	result, err := doc.ApplyBlockOperation(op)
+	if err != nil {
+		return nil, 0, err
+	}
+	operation := ot.FromEdit(len(before), parser.DiffEdit(before, doc.Content()))
+	return result, h.record(shard, documentID, author, before, result, operation), nil
+}
+
+// handleBlockPatch applies a patch_blocks message's block operations to a
+// stored document, rewrites it in canonical markdown, and sends the changed
+// blocks with the markdown to the client and the document's other
+// subscribers
+func (h *Hub) handleBlockPatch(client *Client, msg models.WebSocketMessage) {
+	if msg.DocumentID == "" || len(msg.BlockOperations) == 0 {
+		h.replyError(client, msg, "Document ID and block operations are required to patch blocks")
+		return
+	}
+
+	var result *models.ParseResponse
+	var markdown string
+	var sequence uint64
+	err := h.jobs.Run(func() (err error) {
+		result, markdown, sequence, err = h.applyBlockPatch(msg.DocumentID, h.author(msg.DocumentID, client), msg.BlockOperations)
+		return err
+	})
+	if err != nil {
+		h.replyError(client, msg, "Failed to patch blocks: "+err.Error())
+		return
+	}
+
+	event := models.BlockPatchEvent{
+		DocumentID: msg.DocumentID,
+		Operations: msg.BlockOperations,
+		Changes:    result.Changes,
+		Markdown:   markdown,
+	}
+	if user, ok := h.identity(msg.DocumentID, client); ok {
+		event.User = &user
+	}
+	response := models.WebSocketResponse{
+		Type:      "blocks_patched",
+		Success:   true,
+		Data:      event,
+		Sequence:  sequence,
+		Timestamp: time.Now(),
+	}
+	h.reply(client, msg, response)
+	h.broadcastToDocument(msg.DocumentID, response, client)
+}
+
+// applyBlockPatch applies block operations to a stored document like
+// applyBlockOperation, recording the whole patch as one change, and returns
+// the canonical markdown with the result
+func (h *Hub) applyBlockPatch(documentID, author string, ops []models.BlockOperation) (*models.ParseResponse, string, uint64, error) {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	doc, err := h.openDocument(shard, documentID)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	doc.Granularity = diff.GranularityBlock
+	before := doc.Content()
+	result, err := doc.PatchBlocks(ops)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	operation := ot.FromEdit(len(before), parser.DiffEdit(before, doc.Content()))
+	return result, doc.Content(), h.record(shard, documentID, author, before, result, operation), nil
+}
+
+// handleRenderBlock renders one block of a stored document on demand and
+// sends it to the client alone, for clients that render long documents
+// lazily
+func (h *Hub) handleRenderBlock(client *Client, msg models.WebSocketMessage) {
+	if msg.DocumentID == "" || msg.BlockID == "" {
+		h.replyError(client, msg, "Document ID and block ID are required to render a block")
+		return
+	}
+
+	shard := h.documentShard(msg.DocumentID)
+	var rendered *models.Block
+	var sequence uint64
+	err := h.jobs.Run(func() error {
+		shard.mu.Lock()
+		doc, ok, err := h.loadDocument(shard, msg.DocumentID)
+		var block models.Block
+		if ok {
+			var found *models.Block
+			if found, ok = doc.Block(msg.BlockID); ok {
+				block = *found
+				sequence = shard.history[msg.DocumentID].last
+			}
+		}
+		shard.mu.Unlock()
+		if err != nil || !ok {
+			return err
+		}
+
+		// The copy is rendered outside the lock, so edits need not wait
+		rendered, err = h.parser.Load().RenderBlock(&block, parser.ParseOptions{})
+		return err
+	})
+	if err != nil {
+		h.replyError(client, msg, "Failed to render block: "+err.Error())
+		return
+	}
+	if rendered == nil {
+		h.replyError(client, msg, "Unknown block "+msg.BlockID+" in document "+msg.DocumentID)
+		return
+	}
+
+	h.reply(client, msg, models.WebSocketResponse{
+		Type:      "block_rendered",
+		Success:   true,
+		Data:      rendered,
+		Sequence:  sequence,
+		Timestamp: time.Now(),
+	})
+}
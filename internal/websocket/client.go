@@ -1,26 +1,27 @@
 package websocket
 
 import (
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/ratelimit"
 )
 
 const (
 	// Time allowed to write a message to the peer
 	writeWait = 10 * time.Second
 
-	// Time allowed to read the next pong message from the peer
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period (must be less than pongWait)
-	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer
-	maxMessageSize = 512 * 1024 // 512KB
+	// Time allowed to read the next pong message from the peer, unless configured
+	defaultPongWait = 60 * time.Second
 )
 
 var upgrader = websocket.Upgrader{
@@ -35,23 +36,56 @@ var upgrader = websocket.Upgrader{
 // Client represents a WebSocket client
 type Client struct {
 	hub                  *Hub
+	shard                *shard // Event loop that registers the client, see nextShard
 	conn                 *websocket.Conn
-	send                 chan []byte
+	send                 *sendQueue
 	subscribedDocuments  map[string]bool
+	id                   string       // Identity of the client until it announces one, see join
+	session              string       // Session the client can resume after disconnecting, see handleResume
+	user                 *models.User // Authenticated identity, if authentication is enabled
+	binary               atomic.Bool  // Responses are MessagePack rather than JSON, see handleHandshake
+
+	// Pending parse_incremental messages by document ID, see coalesce
+	batches   map[string]*parseBatch
+	batchesMu sync.Mutex
+	closed    bool
 }
 
 // NewClient creates a new WebSocket client
 func NewClient(hub *Hub, conn *websocket.Conn) *Client {
 	return &Client{
 		hub:                 hub,
+		shard:               hub.nextShard(),
 		conn:                conn,
-		send:                make(chan []byte, 256),
+		send:                newSendQueue(hub.sendBuffer, hub.slowClientPolicy != SlowClientClose),
 		subscribedDocuments: make(map[string]bool),
+		id:                  nextClientID(),
+		session:             newSessionID(),
+		batches:             make(map[string]*parseBatch),
 	}
 }
 
 // HandleWebSocket upgrades HTTP connection to WebSocket
 func HandleWebSocket(hub *Hub, c *gin.Context) {
+	// Credentials on the upgrade authenticate the client straight away;
+	// without any, its first message must be an auth message
+	var user *models.User
+	if hub.auth != nil {
+		if token := auth.RequestToken(c.Request); token != "" {
+			authenticated, wait, err := hub.auth.Admit(token)
+			if errors.Is(err, auth.ErrRateLimited) {
+				c.Header("Retry-After", strconv.Itoa(ratelimit.RetryAfter(wait)))
+				c.JSON(http.StatusTooManyRequests, gin.H{"success": false, "error": err.Error()})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"success": false, "error": "Invalid API key or token"})
+				return
+			}
+			user = &authenticated
+		}
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
@@ -59,75 +93,103 @@ func HandleWebSocket(hub *Hub, c *gin.Context) {
 	}
 
 	client := NewClient(hub, conn)
+	client.user = user
 	
-	// Register client with hub
-	client.hub.register <- client
+	// Register client with hub, unless it has stopped
+	select {
+	case client.shard.register <- client:
+	case <-client.hub.done:
+		client.closeWith(websocket.CloseGoingAway, "server shutting down")
+		return
+	}
 
 	// Start goroutines for reading and writing
 	go client.writePump()
 	go client.readPump()
 }
 
+// closeWith closes the connection with a close code and reason
+func (c *Client) closeWith(code int, reason string) {
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	c.conn.Close()
+}
+
 // readPump pumps messages from the WebSocket connection to the hub
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.stopBatches()
+		select {
+		case c.shard.unregister <- c:
+		case <-c.hub.done:
+		}
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	// Oversized messages close the connection with 1009 (message too big)
+	if c.hub.maxMessageSize > 0 {
+		c.conn.SetReadLimit(c.hub.maxMessageSize)
+	}
+	// A peer that answers no ping within pongWait is dead; the read fails
+	// and the client is unregistered
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
 	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.pongWait))
 		return nil
 	})
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		messageType, message, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				log.Printf("WebSocket message exceeds the limit of %d bytes; closing connection", c.hub.maxMessageSize)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
 		}
 
 		// Handle the message through the hub
-		c.hub.HandleMessage(c, message)
+		if messageType == websocket.BinaryMessage {
+			c.hub.HandleBinaryMessage(c, message)
+		} else {
+			c.hub.HandleMessage(c, message)
+		}
 	}
 }
 
 // writePump pumps messages from the hub to the WebSocket connection
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.hub.pingPeriod)
+	lingering := false
 	defer func() {
 		ticker.Stop()
-		c.conn.Close()
+		if !lingering {
+			c.conn.Close()
+		}
 	}()
 
 	for {
 		select {
-		case message, ok := <-c.send:
+		case <-c.send.ready:
+			frames, slow, closing := c.send.take()
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+			if slow {
+				// Closing at once would reset the connection and lose the
+				// close frame; readPump closes it when the client answers or
+				// writeWait has passed
+				err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseSlowClient, "client too slow"))
+				if err == nil {
+					c.conn.SetReadDeadline(time.Now().Add(writeWait))
+					lingering = true
+				}
 				return
 			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			if err := c.writeFrames(frames); err != nil {
 				return
 			}
-			w.Write(message)
-
-			// Add queued messages to the current WebSocket message
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.send)
-			}
-
-			if err := w.Close(); err != nil {
+			if closing != nil {
+				// The hub unregistered the client or is shutting down
+				c.conn.WriteMessage(websocket.CloseMessage, closing)
 				return
 			}
 
@@ -138,4 +200,33 @@ func (c *Client) writePump() {
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+// writeFrames writes queued messages, each MessagePack one in a binary
+// message of its own, and runs of JSON ones as newline-separated lines of a
+// single text message
+func (c *Client) writeFrames(frames []frame) error {
+	for i := 0; i < len(frames); {
+		if frames[i].binary {
+			if err := c.conn.WriteMessage(websocket.BinaryMessage, frames[i].data); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+
+		w, err := c.conn.NextWriter(websocket.TextMessage)
+		if err != nil {
+			return err
+		}
+		w.Write(frames[i].data)
+		for i++; i < len(frames) && !frames[i].binary; i++ {
+			w.Write([]byte{'\n'})
+			w.Write(frames[i].data)
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"fmt"
+	"time"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/pkg/crdt"
+	"markdown-parser/pkg/diff"
+	"markdown-parser/pkg/ot"
+)
+
+// Collab modes: how clients edit the text of a document concurrently
+const (
+	CollabOT   = "ot"   // Operation messages, transformed against a central revision
+	CollabCRDT = "crdt" // crdt_sync messages, merged without a central revision
+)
+
+// crdtServerSite is the CRDT site of edits made on the server, by
+// parse_incremental and block operation messages
+const crdtServerSite = "server"
+
+// handleCRDTSync merges the CRDT operations of a client's replica of a
+// document, made online or offline, and replies with the operations the
+// replica lacks given its vector. The document's other subscribers get the
+// operations merged, with the blocks changed.
+//
+// A replica's site is its client's ID, which the server assigns and a
+// resumed session keeps, so no client can make operations in the name of
+// another or of the server.
+func (h *Hub) handleCRDTSync(client *Client, msg models.WebSocketMessage) {
+	if h.collabMode != CollabCRDT {
+		h.replyError(client, msg, "CRDT sync is disabled in ot collab mode; use operation")
+		return
+	}
+	if msg.DocumentID == "" {
+		h.replyError(client, msg, "Document ID is required for CRDT sync")
+		return
+	}
+	for _, op := range msg.CRDTOperations {
+		if op.ID.Site != client.id {
+			h.replyError(client, msg, fmt.Sprintf("Operation of site %q; this replica's site is %q", op.ID.Site, client.id))
+			return
+		}
+	}
+
+	var sync, update models.CRDTSync
+	var sequence uint64
+	err := h.jobs.Run(func() (err error) {
+		sync, update, sequence, err = h.syncCRDT(msg.DocumentID, h.author(msg.DocumentID, client), msg.Vector, msg.CRDTOperations)
+		return err
+	})
+	if err != nil {
+		h.replyError(client, msg, "Failed to sync: "+err.Error())
+		return
+	}
+	sync.Site = client.id
+
+	h.reply(client, msg, models.WebSocketResponse{
+		Type:      "crdt_sync",
+		Success:   true,
+		Data:      sync,
+		Sequence:  sequence,
+		Timestamp: time.Now(),
+	})
+	if len(update.Operations) > 0 {
+		if user, ok := h.identity(msg.DocumentID, client); ok {
+			update.User = &user
+		}
+		h.broadcastToDocument(msg.DocumentID, models.WebSocketResponse{
+			Type:      "crdt_update",
+			Success:   true,
+			Data:      update,
+			Sequence:  sequence,
+			Timestamp: time.Now(),
+		}, client)
+	}
+}
+
+// syncCRDT merges a replica's operations into a stored document, creating
+// it empty if need be. It returns what the replica lacks, what the other
+// subscribers lack, and the sequence of the document's latest change.
+func (h *Hub) syncCRDT(documentID, author string, vector map[string]uint64, ops []models.CRDTOperation) (models.CRDTSync, models.CRDTSync, uint64, error) {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	doc, err := h.openDocument(shard, documentID)
+	if err != nil {
+		return models.CRDTSync{}, models.CRDTSync{}, 0, err
+	}
+	changes := shard.history[documentID]
+	replica, ok := shard.replicas[documentID]
+	if !ok {
+		replica = crdt.New()
+		shard.replicas[documentID] = replica
+	}
+
+	// Bring in edits made on the server since the last sync
+	merged, err := replica.Edit(crdtServerSite, parser.DiffEdit(replica.Text(), doc.Content()))
+	if err != nil {
+		return models.CRDTSync{}, models.CRDTSync{}, 0, err
+	}
+	applied, err := replica.Apply(ops...)
+	if err != nil {
+		return models.CRDTSync{}, models.CRDTSync{}, 0, err
+	}
+	merged = append(merged, applied...)
+
+	// The replica has its own operations, whether or not they applied yet
+	seen := make(map[string]uint64, len(vector))
+	for site, clock := range vector {
+		seen[site] = clock
+	}
+	for _, op := range ops {
+		seen[op.ID.Site] = max(seen[op.ID.Site], crdt.LastClock(op))
+	}
+
+	var result *models.ParseResponse
+	if content := replica.Text(); content != doc.Content() {
+		// CRDT edits report their changes block by block
+		doc.Granularity = diff.GranularityBlock
+		before := doc.Content()
+		edit := parser.DiffEdit(before, content)
+		operation := ot.FromEdit(len(before), edit)
+		if result, err = doc.ApplyEdit(edit); err != nil {
+			return models.CRDTSync{}, models.CRDTSync{}, 0, err
+		}
+		h.record(shard, documentID, author, before, result, operation)
+	}
+
+	sync := models.CRDTSync{
+		DocumentID: documentID,
+		Operations: replica.Since(seen),
+		Vector:     replica.Vector(),
+	}
+	update := models.CRDTSync{
+		DocumentID: documentID,
+		Operations: merged,
+		Vector:     sync.Vector,
+	}
+	if result != nil {
+		sync.Changes = result.Changes
+		update.Changes = result.Changes
+	}
+	return sync, update, changes.last, nil
+}
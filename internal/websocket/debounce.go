@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"markdown-parser/internal/models"
+)
+
+// parseBatch collects one document's parse_incremental messages until its
+// debounce window closes
+type parseBatch struct {
+	flushMu sync.Mutex // Serializes flushes so results are sent in order
+	msgs    []models.WebSocketMessage
+	timer   *time.Timer
+}
+
+// coalesce queues msg and calls flush with every message queued for its
+// document once window has passed since the first of them. The window is
+// not extended by later messages, so continuous typing still gets results.
+func (c *Client) coalesce(msg models.WebSocketMessage, window time.Duration, flush func([]models.WebSocketMessage)) {
+	c.batchesMu.Lock()
+	defer c.batchesMu.Unlock()
+
+	if c.closed {
+		return
+	}
+	batch, ok := c.batches[msg.DocumentID]
+	if !ok {
+		batch = &parseBatch{}
+		c.batches[msg.DocumentID] = batch
+	}
+
+	batch.msgs = append(batch.msgs, msg)
+	if batch.timer != nil {
+		return
+	}
+	batch.timer = time.AfterFunc(window, func() {
+		batch.flushMu.Lock()
+		defer batch.flushMu.Unlock()
+
+		c.batchesMu.Lock()
+		msgs := batch.msgs
+		batch.msgs, batch.timer = nil, nil
+		closed := c.closed
+		c.batchesMu.Unlock()
+
+		if !closed && len(msgs) > 0 {
+			flush(msgs)
+		}
+	})
+}
+
+// stopBatches drops pending messages and waits for in-flight flushes, so
+// nothing is sent to the client once it unregisters
+func (c *Client) stopBatches() {
+	c.batchesMu.Lock()
+	c.closed = true
+	batches := make([]*parseBatch, 0, len(c.batches))
+	for _, batch := range c.batches {
+		if batch.timer != nil {
+			batch.timer.Stop()
+		}
+		batches = append(batches, batch)
+	}
+	c.batchesMu.Unlock()
+
+	for _, batch := range batches {
+		batch.flushMu.Lock()
+		batch.flushMu.Unlock()
+	}
+}
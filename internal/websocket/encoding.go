@@ -0,0 +1,49 @@
+package websocket
+
+import (
+	"encoding/json"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Message encodings a client can choose with a handshake message. JSON
+// travels in text frames and MessagePack in binary frames, so each frame
+// says how it is encoded, and clients may send either at any time.
+const (
+	EncodingJSON    = "json"
+	EncodingMsgpack = "msgpack"
+)
+
+// msgpackHandle encodes MessagePack using the json struct tags of the
+// models, with timestamps as the MessagePack timestamp extension
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.WriteExt = true
+	h.RawToString = true
+	return h
+}()
+
+// frame is an encoded message queued for a client
+type frame struct {
+	data   []byte
+	binary bool // MessagePack in a binary frame, rather than JSON in a text frame
+}
+
+// encodeFrame encodes v as MessagePack or JSON
+func encodeFrame(v interface{}, binary bool) (frame, error) {
+	if !binary {
+		data, err := json.Marshal(v)
+		return frame{data: data}, err
+	}
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(v)
+	return frame{data: data, binary: true}, err
+}
+
+// decodeFrame decodes a MessagePack or JSON message into v
+func decodeFrame(data []byte, binary bool, v interface{}) error {
+	if !binary {
+		return json.Unmarshal(data, v)
+	}
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
@@ -1,90 +1,536 @@
 package websocket
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	gorilla "github.com/gorilla/websocket"
+	"markdown-parser/configs"
+	"markdown-parser/internal/auth"
 	"markdown-parser/internal/models"
 	"markdown-parser/internal/parser"
+	"markdown-parser/internal/workpool"
+	"markdown-parser/pkg/diff"
+	"markdown-parser/pkg/ot"
 )
 
+// ProtocolVersion is the version of the WebSocket protocol the hub speaks.
+// Clients may name the version they speak in a handshake message; older
+// versions are accepted and newer ones refused.
+const ProtocolVersion = 1
+
 // Hub maintains active WebSocket connections
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	parser     *parser.MarkdownParser
+	shards     []*shard                              // Event loops, each with a share of the clients
+	shardCount atomic.Uint64                         // Clients assigned to shards, see nextShard
+	done       chan struct{}                         // Closed when Run returns
+	parser     atomic.Pointer[parser.MarkdownParser] // Replaced by Reconfigure
+	jobs       *workpool.Pool                        // Shared with the API, so parse load is bounded process-wide
+	auth       *auth.Authenticator                   // nil when authentication is disabled
+
+	// Largest message accepted from a client, in bytes (0 means unlimited)
+	maxMessageSize int64
+
+	// Most clients connected at once (0 means unlimited), counted across shards
+	maxConnections int
+	connected      atomic.Int64
+	peak           atomic.Int64
+	rejected       atomic.Uint64
+
+	// Outgoing messages queued per client, and what happens when a client
+	// falls further behind, see sendQueue
+	sendBuffer       int
+	slowClientPolicy string
+	dropped          atomic.Uint64
+	slowClosed       atomic.Uint64
+
+	// Window in which a client's parse_incremental messages are coalesced (0 parses each one)
+	debounce time.Duration
+
+	// Heartbeat: clients are pinged every pingPeriod and dropped when no pong
+	// arrives within pongWait
+	pingPeriod time.Duration
+	pongWait   time.Duration
+
+	// Parsed documents by ID, kept so edits only reparse the changed blocks,
+	// sharded by ID, and the number of their recent changes kept for replay
+	documentShards []*documentShard
+	resumeBuffer   int
+
+	// Sessions of disconnected clients by ID, resumable for resumeWindow
+	sessions     map[string]*session
+	resumeWindow time.Duration
+	sessionsMu   sync.Mutex
+
+	// Subscribers of each document with their identities, see join
+	presence   map[string][]member
+	presenceMu sync.Mutex
+
+	// Advisory locks by document ID and block ID, "" for the whole document
+	locks   map[string]map[string]*editLock
+	lockTTL time.Duration
+	locksMu sync.Mutex
+
+	// How clients edit text concurrently: CollabOT or CollabCRDT
+	collabMode string
+
+	// Changes kept per document for undo and redo
+	undoDepth int
+
+	// Called with every change to a stored document, see OnChange
+	onChange ChangeFunc
+
+	// Where documents are loaded from and saved to, see SetDocumentStore;
+	// nil keeps them only in the hub
+	documents DocumentStore
+
+	// Where clients' changes to documents are recorded, see SetAuditLog
+	audit AuditLog
+
+	// Window after a document's first unsaved change in which it is saved
+	// (0 saves every change), and the pending saves by document ID
+	autosave time.Duration
+	saves    map[string]*time.Timer
+	savesMu  sync.Mutex
+
+	// Read-only consumers of each document's broadcasts, see Watch
+	watchers       map[string]map[*watcher]struct{}
+	watchersClosed bool
+	watchersMu     sync.Mutex
+}
+
+// DocumentStore keeps documents beyond the hub, which loads a document from
+// it when a client first uses it and autosaves changes back, telling
+// subscribers the revision saved. SaveContent fails with
+// documents.ErrUnknownDocument for documents the store does not keep.
+type DocumentStore interface {
+	DocumentContent(documentID string) (string, bool)
+	SaveContent(documentID, content string) (int, error)
+}
+
+// AuditLog records who changed which document, such as the audit package's
+type AuditLog interface {
+	Record(documentID, userID, action, detail string)
+}
+
+// ChangeFunc is told about a change to a document the hub holds: the text
+// operation that made it and the document's blocks afterwards
+type ChangeFunc func(documentID string, operation models.TextOperation, blocks map[string]*models.Block)
+
+// NewHub creates a new WebSocket hub whose parse work runs on jobs
+func NewHub(config *configs.Config, jobs *workpool.Pool) *Hub {
+	h := &Hub{
+		done:     make(chan struct{}),
+		jobs:     jobs,
+		auth:     auth.New(config.Auth),
+		sessions: make(map[string]*session),
+		presence: make(map[string][]member),
+		locks:    make(map[string]map[string]*editLock),
+
+		maxMessageSize:   config.WebSocket.MaxMessageSize,
+		maxConnections:   config.WebSocket.MaxConnections,
+		sendBuffer:       config.WebSocket.SendBuffer,
+		slowClientPolicy: config.WebSocket.SlowClientPolicy,
+		debounce:         time.Duration(config.WebSocket.DebounceMillis) * time.Millisecond,
+		resumeBuffer:     config.WebSocket.ResumeBuffer,
+		resumeWindow:     time.Duration(config.WebSocket.ResumeWindowSeconds) * time.Second,
+		collabMode:       config.WebSocket.CollabMode,
+		autosave:         time.Duration(config.Documents.AutosaveMillis) * time.Millisecond,
+		saves:            make(map[string]*time.Timer),
+		watchers:         make(map[string]map[*watcher]struct{}),
+	}
+	h.pingPeriod, h.pongWait = heartbeat(config.WebSocket)
+
+	shards := config.WebSocket.HubShards
+	if shards <= 0 {
+		shards = runtime.NumCPU()
+	}
+	for i := 0; i < shards; i++ {
+		h.shards = append(h.shards, newShard())
+		h.documentShards = append(h.documentShards, newDocumentShard())
+	}
+	h.parser.Store(parser.NewMarkdownParserWithConfig(config.Parser))
+	h.lockTTL = time.Duration(config.WebSocket.LockTTLSeconds) * time.Second
+	if h.lockTTL <= 0 {
+		h.lockTTL = defaultLockTTL
+	}
+	h.undoDepth = config.WebSocket.UndoDepth
+	if h.undoDepth <= 0 {
+		h.undoDepth = defaultUndoDepth
+	}
+	return h
 }
 
-// NewHub creates a new WebSocket hub
-func NewHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		parser:     parser.NewMarkdownParser(),
+// heartbeat returns the configured ping period and pong wait, defaulting
+// the pong wait and keeping the ping period below it, so a live peer's
+// pong always arrives in time
+func heartbeat(config configs.WebSocketConfig) (time.Duration, time.Duration) {
+	pongWait := time.Duration(config.PongWaitSeconds) * time.Second
+	if pongWait <= 0 {
+		pongWait = defaultPongWait
+	}
+	pingPeriod := time.Duration(config.PingPeriodSeconds) * time.Second
+	if pingPeriod <= 0 || pingPeriod >= pongWait {
+		pingPeriod = pongWait * 9 / 10
 	}
+	return pingPeriod, pongWait
 }
 
-// Run starts the hub event loop
+// Run starts the hub's event loops and lasts until Shutdown stops them
 func (h *Hub) Run() {
-	log.Println("INFO: WebSocket hub started")
-	
-	for {
+	log.Printf("INFO: WebSocket hub started with %d shards", len(h.shards))
+	defer close(h.done)
+
+	var wg sync.WaitGroup
+	for _, s := range h.shards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.runShard(s)
+		}()
+	}
+	wg.Wait()
+}
+
+// Shutdown tells every client the server is shutting down and turns new
+// ones away, waits for the parses in flight on the hub's worker pool, then
+// closes the connections with 1001 (going away) and stops the hub once they
+// are gone. It gives up waiting when ctx is done.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	for _, s := range h.shards {
 		select {
-		case client := <-h.register:
-			h.clients[client] = true
-			log.Printf("INFO: Client connected. Total clients: %d", len(h.clients))
-			
-			// Send connection confirmation
-			response := models.WebSocketResponse{
-				Type:      "connected",
-				Success:   true,
-				Timestamp: time.Now(),
-			}
-			
-			if data, err := json.Marshal(response); err == nil {
-				select {
-				case client.send <- data:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
+		case s.announce <- struct{}{}:
+		case <-h.done:
+			return nil
+		}
+	}
+	drained := h.jobs.Shutdown(ctx)
+	h.flushSaves()
+	h.CloseWatchers()
 
-		case client := <-h.unregister:
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				log.Printf("INFO: Client disconnected. Total clients: %d", len(h.clients))
-			}
+	for _, s := range h.shards {
+		stopped := make(chan struct{})
+		select {
+		case s.stop <- stopped:
+		case <-h.done:
+			return drained
+		}
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return drained
+}
 
-		case message := <-h.broadcast:
-			// Broadcast message to all connected clients
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+// deliver queues a frame for a client under the slow-client policy. A
+// client that falls too far behind is closed by its write pump and then
+// unregistered like any other.
+func (h *Hub) deliver(client *Client, f frame, broadcast bool) {
+	dropped, ok := client.send.push(f, broadcast)
+	if dropped {
+		h.dropped.Add(1)
+	}
+	if !ok {
+		h.slowClosed.Add(1)
+		log.Printf("WARN: Closing client whose send queue of %d messages is full", client.send.limit)
+	}
+}
+
+// ConnectionStats reports the current, peak, and rejected connections
+func (h *Hub) ConnectionStats() models.ConnectionStats {
+	return models.ConnectionStats{
+		Current:    int(h.connected.Load()),
+		Peak:       int(h.peak.Load()),
+		Max:        h.maxConnections,
+		Rejected:   h.rejected.Load(),
+		Dropped:    h.dropped.Load(),
+		SlowClosed: h.slowClosed.Load(),
+		Shards:     len(h.shards),
+	}
+}
+
+// QueueStats reports the send queues of each event loop's clients. A loop
+// that does not answer before ctx is done, such as one stuck delivering, is
+// reported as stalled.
+func (h *Hub) QueueStats(ctx context.Context) []models.ShardQueueStats {
+	limit := h.sendBuffer
+	if limit <= 0 {
+		limit = defaultSendBuffer
+	}
+	stats := make([]models.ShardQueueStats, len(h.shards))
+	for i, s := range h.shards {
+		reply := make(chan models.ShardQueueStats, 1)
+		select {
+		case s.inspect <- reply:
+			stats[i] = <-reply
+		case <-h.done:
+			stats[i].Stalled = true
+		case <-ctx.Done():
+			stats[i].Stalled = true
+		}
+		stats[i].Shard, stats[i].Limit = i, limit
+	}
+	return stats
+}
+
+// CacheStats reports the parse result cache of the hub's parser
+func (h *Hub) CacheStats() models.CacheStats {
+	return h.parser.Load().CacheStats()
+}
+
+// DocumentIDs lists the documents the hub holds
+func (h *Hub) DocumentIDs() []string {
+	var ids []string
+	for _, shard := range h.documentShards {
+		shard.mu.Lock()
+		for id := range shard.documents {
+			ids = append(ids, id)
+		}
+		shard.mu.Unlock()
+	}
+	return ids
+}
+
+// DocumentContent returns the markdown of a document the hub holds, or
+// else of one in its document store
+func (h *Hub) DocumentContent(documentID string) (string, bool) {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	doc, ok := shard.documents[documentID]
+	if !ok {
+		if h.documents == nil {
+			return "", false
+		}
+		return h.documents.DocumentContent(documentID)
+	}
+	return doc.Content(), true
+}
+
+// DocumentBlock returns a block of a document the hub holds
+func (h *Hub) DocumentBlock(documentID, blockID string) (models.Block, bool) {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	doc, ok, err := h.loadDocument(shard, documentID)
+	if err != nil || !ok {
+		return models.Block{}, false
+	}
+	block, ok := doc.Block(blockID)
+	if !ok {
+		return models.Block{}, false
+	}
+	return *block, true
+}
+
+// OnChange sets the function told about every change to a document the hub
+// holds, such as the re-anchoring of comments. It must be set before Run
+// and is called with the document's shard locked, so it must not call back
+// into the hub's documents.
+func (h *Hub) OnChange(fn ChangeFunc) {
+	h.onChange = fn
+}
+
+// SetDocumentStore sets where the hub loads documents from and saves them
+// to. It must be set before Run.
+func (h *Hub) SetDocumentStore(store DocumentStore) {
+	h.documents = store
+}
+
+// SetAuthenticator sets the authenticator clients sign in with, such as
+// the one the REST API shares, so their connections count against the
+// same API key limits and usage. It must be set before Run.
+func (h *Hub) SetAuthenticator(authenticator *auth.Authenticator) {
+	h.auth = authenticator
+}
+
+// SetAuditLog sets where the hub records the changes clients make to
+// documents. It must be set before Run.
+func (h *Hub) SetAuditLog(log AuditLog) {
+	h.audit = log
+}
+
+// Reconfigure parses with a new parser configuration, such as a reloaded
+// one with another sanitization policy or extensions, without dropping
+// clients. The documents the hub holds are reparsed with it, except any
+// whose content it would clean differently, which keep their settings
+// until next loaded so clients' offsets into them stay valid.
+func (h *Hub) Reconfigure(config configs.ParserConfig) {
+	p := parser.NewMarkdownParserWithConfig(config)
+	h.parser.Store(p)
+
+	for _, shard := range h.documentShards {
+		shard.mu.Lock()
+		for documentID, doc := range shard.documents {
+			reparsed, err := p.NewDocument(doc.Content())
+			if err != nil || reparsed.Content() != doc.Content() {
+				continue
 			}
+			reparsed.Granularity = doc.Granularity
+			shard.documents[documentID] = reparsed
 		}
+		shard.mu.Unlock()
 	}
 }
 
-// HandleMessage processes incoming WebSocket messages
+// UpdateDocument replaces the content of a document changed outside the
+// hub, such as through the API, and sends the changed blocks to its
+// subscribers. A document the hub does not hold is left to be loaded from
+// the document store when next used.
+func (h *Hub) UpdateDocument(documentID, content string) error {
+	var result *models.ParseResponse
+	var sequence uint64
+	err := h.jobs.Run(func() (err error) {
+		result, sequence, err = h.replaceDocument(documentID, content)
+		return err
+	})
+	if err != nil || result == nil {
+		return err
+	}
+
+	h.broadcastToDocument(documentID, models.WebSocketResponse{
+		Type:      "parsed_incremental",
+		Success:   true,
+		Data:      parser.ChangesOnly(result),
+		Sequence:  sequence,
+		Timestamp: time.Now(),
+	}, nil)
+	return nil
+}
+
+// replaceDocument replaces the content of a stored document, if the hub
+// holds it, and records the change
+func (h *Hub) replaceDocument(documentID, content string) (*models.ParseResponse, uint64, error) {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	doc, ok := shard.documents[documentID]
+	if !ok || doc.Content() == content {
+		return nil, 0, nil
+	}
+
+	// Replaced content reports its changes block by block
+	doc.Granularity = diff.GranularityBlock
+	before := doc.Content()
+	edit := parser.DiffEdit(before, content)
+	result, err := doc.ApplyEdit(edit)
+	if err != nil {
+		return nil, 0, err
+	}
+	return result, h.record(shard, documentID, "", before, result, ot.FromEdit(len(before), edit)), nil
+}
+
+// CloseDocument forgets a document deleted outside the hub, telling its
+// subscribers
+func (h *Hub) CloseDocument(documentID string) {
+	h.cancelSave(documentID)
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	delete(shard.documents, documentID)
+	delete(shard.history, documentID)
+	delete(shard.replicas, documentID)
+	delete(shard.undo, documentID)
+	shard.mu.Unlock()
+
+	h.broadcastToDocument(documentID, models.WebSocketResponse{
+		Type:      "deleted",
+		Success:   true,
+		Data:      map[string]string{"documentId": documentID},
+		Timestamp: time.Now(),
+	}, nil)
+}
+
+// loadDocument returns a stored document, loading it from the document
+// store if the hub does not hold it yet; the caller holds the shard's lock
+func (h *Hub) loadDocument(shard *documentShard, documentID string) (*parser.Document, bool, error) {
+	if doc, ok := shard.documents[documentID]; ok {
+		return doc, true, nil
+	}
+	if h.documents == nil || documentID == "" {
+		return nil, false, nil
+	}
+	content, ok := h.documents.DocumentContent(documentID)
+	if !ok {
+		return nil, false, nil
+	}
+	doc, err := h.parser.Load().NewDocument(content)
+	if err != nil {
+		return nil, false, err
+	}
+	shard.documents[documentID] = doc
+	shard.history[documentID] = newChangeLog(h.resumeBuffer)
+	return doc, true, nil
+}
+
+// openDocument returns a stored document, loading it or creating it empty
+// if need be; the caller holds the shard's lock
+func (h *Hub) openDocument(shard *documentShard, documentID string) (*parser.Document, error) {
+	doc, ok, err := h.loadDocument(shard, documentID)
+	if err != nil || ok {
+		return doc, err
+	}
+	if doc, err = h.parser.Load().NewDocument(""); err != nil {
+		return nil, err
+	}
+	shard.documents[documentID] = doc
+	shard.history[documentID] = newChangeLog(h.resumeBuffer)
+	return doc, nil
+}
+
+// BroadcastComment tells every subscriber of a document about a comment
+func (h *Hub) BroadcastComment(documentID string, event models.CommentEvent) {
+	h.broadcastToDocument(documentID, models.WebSocketResponse{
+		Type:      "comment",
+		Success:   true,
+		Data:      event,
+		Timestamp: time.Now(),
+	}, nil)
+}
+
+// HandleMessage processes incoming JSON WebSocket messages
 func (h *Hub) HandleMessage(client *Client, messageData []byte) {
+	h.handleFrame(client, messageData, false)
+}
+
+// HandleBinaryMessage processes incoming MessagePack WebSocket messages
+func (h *Hub) HandleBinaryMessage(client *Client, messageData []byte) {
+	h.handleFrame(client, messageData, true)
+}
+
+// handleFrame decodes and dispatches an incoming message
+func (h *Hub) handleFrame(client *Client, messageData []byte, binary bool) {
 	var msg models.WebSocketMessage
-	if err := json.Unmarshal(messageData, &msg); err != nil {
+	if err := decodeFrame(messageData, binary, &msg); err != nil {
 		h.sendError(client, "Invalid message format: "+err.Error())
 		return
 	}
 
+	// Clients that did not authenticate on the upgrade must do so first
+	if h.auth != nil && client.user == nil && msg.Type != "auth" && msg.Type != "handshake" {
+		client.closeWith(gorilla.ClosePolicyViolation, "authentication required")
+		return
+	}
+	if !h.permitted(client, msg) {
+		h.replyError(client, msg, "Not permitted to "+msg.Type+" document "+msg.DocumentID)
+		return
+	}
+
 	switch msg.Type {
+	case "handshake":
+		h.handleHandshake(client, msg)
+	case "auth":
+		h.handleAuth(client, msg)
 	case "parse":
 		h.handleParse(client, msg)
 	case "parse_incremental":
@@ -93,22 +539,48 @@ func (h *Hub) HandleMessage(client *Client, messageData []byte) {
 		h.handleSubscribe(client, msg)
 	case "unsubscribe":
 		h.handleUnsubscribe(client, msg)
+	case "resume":
+		h.handleResume(client, msg)
+	case "snapshot":
+		h.handleSnapshot(client, msg)
+	case "cursor":
+		h.handleCursor(client, msg)
+	case "lock":
+		h.handleLock(client, msg)
+	case "unlock":
+		h.handleUnlock(client, msg)
+	case "insert_block", "update_block", "delete_block", "move_block", "convert_block":
+		h.handleBlockOperation(client, msg)
+	case "patch_blocks":
+		h.handleBlockPatch(client, msg)
+	case "operation":
+		h.handleOperation(client, msg)
+	case "crdt_sync":
+		h.handleCRDTSync(client, msg)
+	case "undo", "redo":
+		h.handleUndo(client, msg)
+	case "render_block":
+		h.handleRenderBlock(client, msg)
 	default:
-		h.sendError(client, "Unknown message type: "+msg.Type)
+		h.replyError(client, msg, "Unknown message type: "+msg.Type)
 	}
 }
 
 // handleParse processes markdown parsing requests
 func (h *Hub) handleParse(client *Client, msg models.WebSocketMessage) {
 	if msg.Content == "" {
-		h.sendError(client, "Content is required for parsing")
+		h.replyError(client, msg, "Content is required for parsing")
 		return
 	}
 
 	// Parse markdown
-	result, err := h.parser.Parse(msg.Content)
+	var result *models.ParseResponse
+	err := h.jobs.Run(func() (err error) {
+		result, err = h.parser.Load().Parse(msg.Content)
+		return err
+	})
 	if err != nil {
-		h.sendError(client, "Failed to parse markdown: "+err.Error())
+		h.replyError(client, msg, "Failed to parse markdown: "+err.Error())
 		return
 	}
 
@@ -120,20 +592,49 @@ func (h *Hub) handleParse(client *Client, msg models.WebSocketMessage) {
 		Timestamp: time.Now(),
 	}
 
-	h.sendToClient(client, response)
+	h.reply(client, msg, response)
 }
 
 // handleParseIncremental processes incremental parsing requests
 func (h *Hub) handleParseIncremental(client *Client, msg models.WebSocketMessage) {
-	if msg.Content == "" {
-		h.sendError(client, "Content is required for incremental parsing")
+	if h.debounce <= 0 {
+		h.flushParses(client, []models.WebSocketMessage{msg})
 		return
 	}
+	client.coalesce(msg, h.debounce, func(msgs []models.WebSocketMessage) {
+		h.flushParses(client, msgs)
+	})
+}
+
+// flushParses parses a batch of parse_incremental messages for one document
+// once, acknowledging all but the last as superseded
+func (h *Hub) flushParses(client *Client, msgs []models.WebSocketMessage) {
+	for _, msg := range msgs[:len(msgs)-1] {
+		h.reply(client, msg, models.WebSocketResponse{
+			Type:    "superseded",
+			Success: true,
+			Data: models.SupersededAck{
+				DocumentID: msg.DocumentID,
+				Timestamp:  msg.Timestamp,
+			},
+			Timestamp: time.Now(),
+		})
+	}
 
-	// Parse markdown incrementally
-	result, err := h.parser.ParseIncremental(msg.Content, msg.BlockID)
+	last := msgs[len(msgs)-1]
+	if !diff.ValidGranularity(last.Granularity) {
+		h.replyError(client, last, fmt.Sprintf("Unknown diff granularity %q", last.Granularity))
+		return
+	}
+
+	var result *models.ParseResponse
+	var sequence uint64
+	err := h.jobs.Run(func() (err error) {
+		result, sequence, err = h.parseIncremental(last.DocumentID, h.author(last.DocumentID, client), msgs)
+		return err
+	})
 	if err != nil {
-		h.sendError(client, "Failed to parse markdown incrementally: "+err.Error())
+		h.replyError(client, last, "Failed to parse markdown incrementally: "+err.Error())
 		return
 	}
 
@@ -142,46 +643,201 @@ func (h *Hub) handleParseIncremental(client *Client, msg models.WebSocketMessage
 		Type:      "parsed_incremental",
 		Success:   true,
 		Data:      result,
+		Sequence:  sequence,
 		Timestamp: time.Now(),
 	}
 
-	h.sendToClient(client, response)
+	if last.ChangesOnly || last.Patch {
+		reply := response
+		data := result
+		if last.ChangesOnly {
+			data = parser.ChangesOnly(data)
+		}
+		if last.Patch {
+			data = parser.PatchChanges(data)
+		}
+		reply.Data = data
+		h.reply(client, last, reply)
+	} else {
+		h.reply(client, last, response)
+	}
 	
-	// Also broadcast to other clients subscribed to the same document
-	if msg.DocumentID != "" {
-		h.broadcastToDocument(msg.DocumentID, response)
+	// Also broadcast the changes to other clients subscribed to the same
+	// document, numbered so they can detect a gap and ask for a snapshot
+	if last.DocumentID != "" {
+		broadcast := response
+		broadcast.Data = parser.ChangesOnly(result)
+		h.broadcastToDocument(last.DocumentID, broadcast, client)
+	}
+}
+
+// parseIncremental applies a batch of messages to the stored document and
+// reparses it once. Each message's edit applies to the content so far; a
+// message without an edit, or the first one for an unknown document,
+// replaces the content with its own. A document is loaded from the
+// document store if need be; a new one starts out empty, so its first
+// response lists every block as added. Changes to a document with
+// an ID are recorded for replay and undo by author, and their sequence
+// returned.
+func (h *Hub) parseIncremental(documentID, author string, msgs []models.WebSocketMessage) (*models.ParseResponse, uint64, error) {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	doc, ok, err := h.loadDocument(shard, documentID)
+	if err != nil {
+		return nil, 0, err
+	}
+	content, seeded := "", ok
+	if ok {
+		content = doc.Content()
+	}
+	for _, msg := range msgs {
+		if msg.Edit == nil || !seeded {
+			if msg.Content == "" {
+				return nil, 0, fmt.Errorf("content is required for incremental parsing")
+			}
+			content = msg.Content
+			seeded = true
+		}
+		if edit := msg.Edit; edit != nil {
+			if edit.Start < 0 || edit.End < edit.Start || edit.End > len(content) {
+				return nil, 0, fmt.Errorf("edit range [%d, %d) is outside the document (length %d)", edit.Start, edit.End, len(content))
+			}
+			content = content[:edit.Start] + edit.Text + content[edit.End:]
+		}
 	}
+
+	if !ok {
+		if documentID == "" {
+			doc, err = h.parser.Load().NewDocument("")
+		} else {
+			doc, err = h.openDocument(shard, documentID)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	changes := shard.history[documentID]
+	if content == doc.Content() {
+		if changes == nil {
+			return doc.Response(), 0, nil
+		}
+		return doc.Response(), changes.last, nil
+	}
+
+	// Subscribers share the requesting client's granularity, as they share
+	// the rest of its response
+	doc.Granularity = msgs[len(msgs)-1].Granularity
+	before := doc.Content()
+	edit := parser.DiffEdit(before, content)
+	operation := ot.FromEdit(len(before), edit)
+	result, err := doc.ApplyEdit(edit)
+	if err != nil || changes == nil {
+		return result, 0, err
+	}
+	return result, h.record(shard, documentID, author, before, result, operation), nil
+}
+
+// handleAuth authenticates a client with the token of an auth message
+func (h *Hub) handleAuth(client *Client, msg models.WebSocketMessage) {
+	if h.auth == nil {
+		h.replyError(client, msg, "Authentication is not enabled")
+		return
+	}
+	user, _, err := h.auth.Admit(msg.Token)
+	if errors.Is(err, auth.ErrRateLimited) {
+		h.replyError(client, msg, "Not authenticated: "+err.Error())
+		return
+	}
+	if err != nil {
+		client.closeWith(gorilla.ClosePolicyViolation, "invalid token")
+		return
+	}
+	client.user = &user
+
+	h.reply(client, msg, models.WebSocketResponse{
+		Type:      "authenticated",
+		Success:   true,
+		Data:      map[string]interface{}{"user": user},
+		Timestamp: time.Now(),
+	})
 }
 
-// handleSubscribe handles document subscription requests
+// handleHandshake checks the protocol version a client speaks and switches
+// it to the encoding it asks for, if any; the acknowledgement is the first
+// response in the new encoding
+func (h *Hub) handleHandshake(client *Client, msg models.WebSocketMessage) {
+	if msg.Version > ProtocolVersion {
+		h.replyError(client, msg, fmt.Sprintf("Unsupported protocol version %d; the server speaks version %d", msg.Version, ProtocolVersion))
+		return
+	}
+	switch msg.Encoding {
+	case "":
+	case EncodingJSON:
+		client.binary.Store(false)
+	case EncodingMsgpack:
+		client.binary.Store(true)
+	default:
+		h.replyError(client, msg, "Unsupported encoding: "+msg.Encoding)
+		return
+	}
+
+	encoding := EncodingJSON
+	if client.binary.Load() {
+		encoding = EncodingMsgpack
+	}
+	h.reply(client, msg, models.WebSocketResponse{
+		Type:      "handshake",
+		Success:   true,
+		Data:      map[string]interface{}{"encoding": encoding, "version": ProtocolVersion},
+		Timestamp: time.Now(),
+	})
+}
+
+// handleSubscribe handles document subscription requests, sending late
+// joiners a snapshot of a document the hub already holds
 func (h *Hub) handleSubscribe(client *Client, msg models.WebSocketMessage) {
 	if msg.DocumentID == "" {
-		h.sendError(client, "Document ID is required for subscription")
+		h.replyError(client, msg, "Document ID is required for subscription")
 		return
 	}
 
 	// Add client to document subscription
 	client.subscribedDocuments[msg.DocumentID] = true
+	user, users := h.join(msg.DocumentID, client, msg.User)
 	
 	response := models.WebSocketResponse{
-		Type:      "subscribed",
-		Success:   true,
-		Data:      map[string]string{"documentId": msg.DocumentID},
+		Type:    "subscribed",
+		Success: true,
+		Data: map[string]interface{}{
+			"documentId": msg.DocumentID,
+			"user":       user,
+			"roster":     users,
+			"sequence":   h.sequence(msg.DocumentID),
+			"locks":      h.documentLocks(msg.DocumentID),
+		},
 		Timestamp: time.Now(),
 	}
 
-	h.sendToClient(client, response)
+	h.reply(client, msg, response)
+	h.sendSnapshot(client, msg)
+	h.broadcastPresence(msg.DocumentID, "joined", user, users, client)
 }
 
 // handleUnsubscribe handles document unsubscription requests
 func (h *Hub) handleUnsubscribe(client *Client, msg models.WebSocketMessage) {
 	if msg.DocumentID == "" {
-		h.sendError(client, "Document ID is required for unsubscription")
+		h.replyError(client, msg, "Document ID is required for unsubscription")
 		return
 	}
 
-	// Remove client from document subscription
+	// Remove client from document subscription, releasing its locks
 	delete(client.subscribedDocuments, msg.DocumentID)
+	h.releaseLocks(msg.DocumentID, client)
+	if user, users, ok := h.leave(msg.DocumentID, client); ok {
+		h.broadcastPresence(msg.DocumentID, "left", user, users, client)
+	}
 	
 	response := models.WebSocketResponse{
 		Type:      "unsubscribed",
@@ -190,10 +846,27 @@ func (h *Hub) handleUnsubscribe(client *Client, msg models.WebSocketMessage) {
 		Timestamp: time.Now(),
 	}
 
+	h.reply(client, msg, response)
+}
+
+// reply sends the response to a request, carrying the request's ID so the
+// client can match them up
+func (h *Hub) reply(client *Client, msg models.WebSocketMessage, response models.WebSocketResponse) {
+	response.ID = msg.ID
 	h.sendToClient(client, response)
 }
 
-// sendError sends an error response to a client
+// replyError sends an error response to a request
+func (h *Hub) replyError(client *Client, msg models.WebSocketMessage, errorMsg string) {
+	h.reply(client, msg, models.WebSocketResponse{
+		Type:      "error",
+		Success:   false,
+		Error:     errorMsg,
+		Timestamp: time.Now(),
+	})
+}
+
+// sendError sends an error response to a client, not in reply to a request
 func (h *Hub) sendError(client *Client, errorMsg string) {
 	response := models.WebSocketResponse{
 		Type:      "error",
@@ -207,36 +880,39 @@ func (h *Hub) sendError(client *Client, errorMsg string) {
 
 // sendToClient sends a response to a specific client
 func (h *Hub) sendToClient(client *Client, response models.WebSocketResponse) {
-	data, err := json.Marshal(response)
+	data, err := encodeFrame(response, client.binary.Load())
 	if err != nil {
 		log.Printf("Error marshaling response: %v", err)
 		return
 	}
 
-	select {
-	case client.send <- data:
-	default:
-		close(client.send)
-		delete(h.clients, client)
-	}
+	h.deliver(client, data, false)
 }
 
-// broadcastToDocument broadcasts a message to all clients subscribed to a document
-func (h *Hub) broadcastToDocument(documentID string, response models.WebSocketResponse) {
-	data, err := json.Marshal(response)
-	if err != nil {
-		log.Printf("Error marshaling broadcast response: %v", err)
-		return
-	}
+// broadcastToDocument broadcasts a message to all clients subscribed to a
+// document except sender, which gets its own response, and to its watchers
+func (h *Hub) broadcastToDocument(documentID string, response models.WebSocketResponse, sender *Client) {
+	h.notifyWatchers(documentID, response)
 
-	for client := range h.clients {
-		if client.subscribedDocuments[documentID] {
-			select {
-			case client.send <- data:
-			default:
-				close(client.send)
-				delete(h.clients, client)
+	// Encode the response once for each encoding in use
+	var encoded [2]*frame
+	for _, client := range h.subscribers(documentID) {
+		if client != sender {
+			binary := client.binary.Load()
+			i := 0
+			if binary {
+				i = 1
 			}
+			if encoded[i] == nil {
+				data, err := encodeFrame(response, binary)
+				if err != nil {
+					log.Printf("Error marshaling broadcast response: %v", err)
+					return
+				}
+				encoded[i] = &data
+			}
+
+			h.deliver(client, *encoded[i], true)
 		}
 	}
 }
\ No newline at end of file
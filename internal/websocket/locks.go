@@ -0,0 +1,189 @@
+package websocket
+
+import (
+	"fmt"
+	"time"
+
+	"markdown-parser/internal/models"
+)
+
+// defaultLockTTL is the lifetime of a lock when none is configured
+const defaultLockTTL = 30 * time.Second
+
+// editLock is a held advisory lock and the timer that expires it
+type editLock struct {
+	owner *Client
+	lock  models.EditLock
+	timer *time.Timer
+}
+
+// handleLock takes or renews an advisory lock on a block, or the whole
+// document if no block is given, and tells the document's subscribers
+func (h *Hub) handleLock(client *Client, msg models.WebSocketMessage) {
+	if msg.DocumentID == "" {
+		h.replyError(client, msg, "Document ID is required for locking")
+		return
+	}
+	user, ok := h.identity(msg.DocumentID, client)
+	if !ok {
+		h.replyError(client, msg, "Subscribe to the document before locking")
+		return
+	}
+
+	h.locksMu.Lock()
+	locks := h.locks[msg.DocumentID]
+	if locks == nil {
+		locks = make(map[string]*editLock)
+		h.locks[msg.DocumentID] = locks
+	}
+	if conflict := lockConflict(locks, msg.BlockID, client); conflict != nil {
+		h.locksMu.Unlock()
+		h.replyError(client, msg, fmt.Sprintf("%s is locked by %s", lockTarget(conflict.lock), lockHolder(conflict.lock)))
+		return
+	}
+
+	held, ok := locks[msg.BlockID]
+	if ok {
+		held.timer.Stop()
+	} else {
+		held = &editLock{owner: client}
+		locks[msg.BlockID] = held
+	}
+	held.lock = models.EditLock{
+		DocumentID: msg.DocumentID,
+		BlockID:    msg.BlockID,
+		User:       user,
+		ExpiresAt:  time.Now().Add(h.lockTTL),
+	}
+	held.timer = time.AfterFunc(h.lockTTL, func() { h.expireLock(held) })
+	lock := held.lock
+	h.locksMu.Unlock()
+
+	response := lockResponse("locked", lock)
+	h.reply(client, msg, response)
+	h.broadcastToDocument(lock.DocumentID, response, client)
+}
+
+// handleUnlock releases a lock the client holds
+func (h *Hub) handleUnlock(client *Client, msg models.WebSocketMessage) {
+	if msg.DocumentID == "" {
+		h.replyError(client, msg, "Document ID is required for unlocking")
+		return
+	}
+
+	h.locksMu.Lock()
+	held, ok := h.locks[msg.DocumentID][msg.BlockID]
+	if !ok || held.owner != client {
+		h.locksMu.Unlock()
+		h.replyError(client, msg, fmt.Sprintf("%s is not locked by this client", lockTarget(models.EditLock{BlockID: msg.BlockID})))
+		return
+	}
+	h.removeLock(held)
+	h.locksMu.Unlock()
+
+	response := lockResponse("unlocked", held.lock)
+	h.reply(client, msg, response)
+	h.broadcastToDocument(held.lock.DocumentID, response, client)
+}
+
+// releaseLocks releases every lock a client holds on a document, when it
+// unsubscribes or disconnects
+func (h *Hub) releaseLocks(documentID string, client *Client) {
+	h.locksMu.Lock()
+	var released []models.EditLock
+	for _, held := range h.locks[documentID] {
+		if held.owner == client {
+			h.removeLock(held)
+			released = append(released, held.lock)
+		}
+	}
+	h.locksMu.Unlock()
+
+	for _, lock := range released {
+		h.broadcastLock("unlocked", lock)
+	}
+}
+
+// expireLock releases a lock whose time ran out without renewal
+func (h *Hub) expireLock(held *editLock) {
+	h.locksMu.Lock()
+	current, ok := h.locks[held.lock.DocumentID][held.lock.BlockID]
+	if !ok || current != held || time.Now().Before(held.lock.ExpiresAt) {
+		// Released, or renewed after the timer fired
+		h.locksMu.Unlock()
+		return
+	}
+	h.removeLock(held)
+	h.locksMu.Unlock()
+
+	h.broadcastLock("expired", held.lock)
+}
+
+// removeLock drops a held lock; the caller holds locksMu
+func (h *Hub) removeLock(held *editLock) {
+	held.timer.Stop()
+	locks := h.locks[held.lock.DocumentID]
+	delete(locks, held.lock.BlockID)
+	if len(locks) == 0 {
+		delete(h.locks, held.lock.DocumentID)
+	}
+}
+
+// documentLocks lists the locks held on a document
+func (h *Hub) documentLocks(documentID string) []models.EditLock {
+	h.locksMu.Lock()
+	defer h.locksMu.Unlock()
+
+	locks := []models.EditLock{}
+	for _, held := range h.locks[documentID] {
+		locks = append(locks, held.lock)
+	}
+	return locks
+}
+
+// lockConflict returns a lock held by another client that overlaps a lock
+// on blockID: any lock for the whole document, or one on the same block or
+// the whole document for a block
+func lockConflict(locks map[string]*editLock, blockID string, client *Client) *editLock {
+	for _, held := range locks {
+		if held.owner == client {
+			continue
+		}
+		if blockID == "" || held.lock.BlockID == "" || held.lock.BlockID == blockID {
+			return held
+		}
+	}
+	return nil
+}
+
+// lockTarget names what a lock covers, for error messages
+func lockTarget(lock models.EditLock) string {
+	if lock.BlockID == "" {
+		return "Document"
+	}
+	return fmt.Sprintf("Block %q", lock.BlockID)
+}
+
+// lockHolder names the holder of a lock, for error messages
+func lockHolder(lock models.EditLock) string {
+	if lock.User.Name != "" {
+		return lock.User.Name
+	}
+	return lock.User.ID
+}
+
+// broadcastLock tells every subscriber of the lock's document about a lock
+// released without a request, by unsubscribing, disconnecting, or expiry
+func (h *Hub) broadcastLock(event string, lock models.EditLock) {
+	h.broadcastToDocument(lock.DocumentID, lockResponse(event, lock), nil)
+}
+
+// lockResponse is the lock message telling subscribers about an event
+func lockResponse(event string, lock models.EditLock) models.WebSocketResponse {
+	return models.WebSocketResponse{
+		Type:      "lock",
+		Success:   true,
+		Data:      models.LockEvent{Event: event, Lock: lock},
+		Timestamp: time.Now(),
+	}
+}
@@ -0,0 +1,98 @@
+package websocket
+
+import (
+	"fmt"
+	"time"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/pkg/diff"
+	"markdown-parser/pkg/ot"
+)
+
+// handleOperation applies a text operation, based on the revision the
+// client last saw, to a stored document. The operation is transformed past
+// the changes made since, so concurrent edits all apply; the client gets an
+// operation_ack and the document's other subscribers the transformed
+// operation, each with the changed blocks.
+func (h *Hub) handleOperation(client *Client, msg models.WebSocketMessage) {
+	if h.collabMode == CollabCRDT {
+		h.replyError(client, msg, "Text operations are disabled in crdt collab mode; use crdt_sync")
+		return
+	}
+	if msg.DocumentID == "" || msg.Operation == nil {
+		h.replyError(client, msg, "Document ID and operation are required for text operations")
+		return
+	}
+
+	var operation models.TextOperation
+	var result *models.ParseResponse
+	var sequence uint64
+	err := h.jobs.Run(func() (err error) {
+		operation, result, sequence, err = h.applyOperation(msg.DocumentID, h.author(msg.DocumentID, client), msg.Sequence, msg.Operation)
+		return err
+	})
+	if err != nil {
+		h.replyError(client, msg, "Failed to apply operation: "+err.Error())
+		return
+	}
+
+	event := models.OperationEvent{
+		DocumentID: msg.DocumentID,
+		Operation:  operation,
+		Changes:    result.Changes,
+	}
+	if user, ok := h.identity(msg.DocumentID, client); ok {
+		event.User = &user
+	}
+	response := models.WebSocketResponse{
+		Type:      "operation",
+		Success:   true,
+		Data:      event,
+		Sequence:  sequence,
+		Timestamp: time.Now(),
+	}
+	ack := response
+	ack.Type = "operation_ack"
+	h.reply(client, msg, ack)
+	h.broadcastToDocument(msg.DocumentID, response, client)
+}
+
+// applyOperation transforms an operation based on a revision of a stored
+// document past the changes made since, applies it, creating the document
+// empty if need be, and records it. It returns the transformed operation.
+func (h *Hub) applyOperation(documentID, author string, revision uint64, operation models.TextOperation) (models.TextOperation, *models.ParseResponse, uint64, error) {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	doc, err := h.openDocument(shard, documentID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	changes := shard.history[documentID]
+
+	events, ok := changes.since(revision)
+	if !ok {
+		return nil, nil, 0, fmt.Errorf("revision %d is unknown or too old to transform; take a snapshot", revision)
+	}
+	for _, event := range events {
+		var err error
+		if operation, _, err = ot.Transform(operation, event.operation); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	content, err := ot.Apply(doc.Content(), operation)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	// Text operations report their changes block by block
+	doc.Granularity = diff.GranularityBlock
+	before := doc.Content()
+	result, err := doc.ApplyEdit(parser.DiffEdit(before, content))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return operation, result, h.record(shard, documentID, author, before, result, operation), nil
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"errors"
+	"log"
+
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/documents"
+	"markdown-parser/internal/models"
+)
+
+// noPermission marks message types that do not touch a stored document
+const noPermission auth.Permission = 0
+
+// messagePermissions are the document permissions each message type needs.
+// Subscribers who may only read still receive the document's changes.
+// Types missing here are refused once authentication is on.
+var messagePermissions = map[string]auth.Permission{
+	"handshake":         noPermission,
+	"auth":              noPermission,
+	"parse":             noPermission,
+	"unsubscribe":       noPermission,
+	"subscribe":         auth.Read,
+	"resume":            auth.Read,
+	"snapshot":          auth.Read,
+	"cursor":            auth.Read,
+	"render_block":      auth.Read,
+	"parse_incremental": auth.Write,
+	"lock":              auth.Write,
+	"unlock":            auth.Write,
+	"insert_block":      auth.Write,
+	"update_block":      auth.Write,
+	"delete_block":      auth.Write,
+	"move_block":        auth.Write,
+	"convert_block":     auth.Write,
+	"patch_blocks":      auth.Write,
+	"operation":         auth.Write,
+	"crdt_sync":         auth.Write,
+	"undo":              auth.Write,
+	"redo":              auth.Write,
+}
+
+// permissionStore is implemented by document stores that keep document
+// permissions, such as documents.Store
+type permissionStore interface {
+	Get(documentID string) (models.Document, error)
+}
+
+// permitted reports whether a client may send a message about its
+// document. Everyone may when authentication is disabled, and documents
+// the store does not keep yet are open to everyone.
+func (h *Hub) permitted(client *Client, msg models.WebSocketMessage) bool {
+	if h.auth == nil || client.user == nil {
+		return true
+	}
+	permission, ok := messagePermissions[msg.Type]
+	if !ok {
+		return false
+	}
+	if permission == noPermission || msg.DocumentID == "" {
+		return true
+	}
+	// A crdt_sync without operations only catches up on others' changes
+	if msg.Type == "crdt_sync" && len(msg.CRDTOperations) == 0 {
+		permission = auth.Read
+	}
+
+	store, ok := h.documents.(permissionStore)
+	if !ok {
+		return true
+	}
+	doc, err := store.Get(msg.DocumentID)
+	if errors.Is(err, documents.ErrUnknownDocument) {
+		return true
+	}
+	if err != nil {
+		log.Printf("WARN: Checking permissions on document %s: %v", msg.DocumentID, err)
+		return false
+	}
+	return auth.Allowed(*client.user, doc, permission)
+}
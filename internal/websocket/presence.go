@@ -0,0 +1,214 @@
+package websocket
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"markdown-parser/internal/models"
+)
+
+// clientCount numbers connections, for the identity of anonymous clients
+var clientCount atomic.Uint64
+
+// nextClientID returns a process-unique ID for a new connection
+func nextClientID() string {
+	return fmt.Sprintf("anonymous-%d", clientCount.Add(1))
+}
+
+// member is a client subscribed to a document, with the identity it announced
+type member struct {
+	client *Client
+	user   models.User
+}
+
+// join adds a client to the roster of a document, or updates its identity
+// if it is already there, and returns the roster
+func (h *Hub) join(documentID string, client *Client, user *models.User) (models.User, []models.User) {
+	identity := models.User{ID: client.id}
+	if user != nil {
+		identity = *user
+		if identity.ID == "" {
+			identity.ID = client.id
+		}
+	}
+
+	// Authenticated clients keep the identity their credentials grant, only
+	// choosing how they are shown
+	if client.user != nil {
+		name := identity.Name
+		identity = *client.user
+		if identity.Name == "" {
+			identity.Name = name
+		}
+		if user != nil {
+			identity.Color = user.Color
+		}
+	}
+
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+
+	members := h.presence[documentID]
+	found := false
+	for i := range members {
+		if members[i].client == client {
+			members[i].user = identity
+			found = true
+		}
+	}
+	if !found {
+		members = append(members, member{client: client, user: identity})
+	}
+	h.presence[documentID] = members
+	return identity, roster(members)
+}
+
+// leave removes a client from the roster of a document, returning the
+// identity it had and the roster without it, or false if it was not there
+func (h *Hub) leave(documentID string, client *Client) (models.User, []models.User, bool) {
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+
+	members := h.presence[documentID]
+	for i, m := range members {
+		if m.client != client {
+			continue
+		}
+		members = append(members[:i:i], members[i+1:]...)
+		if len(members) == 0 {
+			delete(h.presence, documentID)
+		} else {
+			h.presence[documentID] = members
+		}
+		return m.user, roster(members), true
+	}
+	return models.User{}, nil, false
+}
+
+// leaveAll removes a disconnected client from every roster it is on and
+// releases its locks
+func (h *Hub) leaveAll(client *Client) {
+	for documentID := range client.subscribedDocuments {
+		h.releaseLocks(documentID, client)
+		if user, users, ok := h.leave(documentID, client); ok {
+			h.broadcastPresence(documentID, "left", user, users, client)
+		}
+	}
+}
+
+// identity returns the identity a client has on the roster of a document
+func (h *Hub) identity(documentID string, client *Client) (models.User, bool) {
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+
+	for _, m := range h.presence[documentID] {
+		if m.client == client {
+			return m.user, true
+		}
+	}
+	return models.User{}, false
+}
+
+// subscribers returns the clients subscribed to a document
+func (h *Hub) subscribers(documentID string) []*Client {
+	h.presenceMu.Lock()
+	defer h.presenceMu.Unlock()
+
+	clients := make([]*Client, len(h.presence[documentID]))
+	for i, m := range h.presence[documentID] {
+		clients[i] = m.client
+	}
+	return clients
+}
+
+// SubscriberStats counts the WebSocket clients and event streams following
+// each document, the most followed first
+func (h *Hub) SubscriberStats() []models.DocumentSubscribers {
+	counts := make(map[string]*models.DocumentSubscribers)
+	count := func(documentID string) *models.DocumentSubscribers {
+		if counts[documentID] == nil {
+			counts[documentID] = &models.DocumentSubscribers{DocumentID: documentID}
+		}
+		return counts[documentID]
+	}
+
+	h.presenceMu.Lock()
+	for documentID, members := range h.presence {
+		count(documentID).Clients = len(members)
+	}
+	h.presenceMu.Unlock()
+
+	h.watchersMu.Lock()
+	for documentID, watchers := range h.watchers {
+		if len(watchers) > 0 {
+			count(documentID).Watchers = len(watchers)
+		}
+	}
+	h.watchersMu.Unlock()
+
+	stats := make([]models.DocumentSubscribers, 0, len(counts))
+	for _, c := range counts {
+		stats = append(stats, *c)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		a, b := stats[i], stats[j]
+		if a.Clients+a.Watchers != b.Clients+b.Watchers {
+			return a.Clients+a.Watchers > b.Clients+b.Watchers
+		}
+		return a.DocumentID < b.DocumentID
+	})
+	return stats
+}
+
+// roster lists the identities of a document's members
+func roster(members []member) []models.User {
+	users := make([]models.User, len(members))
+	for i, m := range members {
+		users[i] = m.user
+	}
+	return users
+}
+
+// broadcastPresence tells the subscribers of a document, other than the
+// client the event is about, that it joined or left
+func (h *Hub) broadcastPresence(documentID, event string, user models.User, users []models.User, client *Client) {
+	h.broadcastToDocument(documentID, models.WebSocketResponse{
+		Type:    "presence",
+		Success: true,
+		Data: models.PresenceEvent{
+			DocumentID: documentID,
+			Event:      event,
+			User:       user,
+			Roster:     users,
+		},
+		Timestamp: time.Now(),
+	}, client)
+}
+
+// handleCursor relays a client's cursor to the other subscribers of the
+// document, under the identity it subscribed with
+func (h *Hub) handleCursor(client *Client, msg models.WebSocketMessage) {
+	if msg.DocumentID == "" || msg.Cursor == nil {
+		h.replyError(client, msg, "Document ID and cursor are required for cursor sharing")
+		return
+	}
+	user, ok := h.identity(msg.DocumentID, client)
+	if !ok {
+		h.replyError(client, msg, "Subscribe to the document before sharing a cursor")
+		return
+	}
+
+	h.broadcastToDocument(msg.DocumentID, models.WebSocketResponse{
+		Type:    "cursor",
+		Success: true,
+		Data: models.CursorEvent{
+			DocumentID: msg.DocumentID,
+			BlockID:    msg.BlockID,
+			User:       user,
+			Cursor:     *msg.Cursor,
+		},
+		Timestamp: time.Now(),
+	}, client)
+}
@@ -0,0 +1,135 @@
+package websocket
+
+import (
+	"sync"
+
+	gorilla "github.com/gorilla/websocket"
+)
+
+// Slow-client policies: what happens to a broadcast update for a client
+// whose send queue is full. Replies that do not fit always close the
+// connection with CloseSlowClient.
+const (
+	SlowClientDropOldest = "drop_oldest" // Drop the oldest queued broadcast update to make room
+	SlowClientClose      = "close"       // Close the connection with CloseSlowClient
+)
+
+// CloseSlowClient is the close code of connections closed because the
+// client did not read its messages fast enough
+const CloseSlowClient = 4000
+
+// defaultSendBuffer is the send queue limit when none is configured
+const defaultSendBuffer = 256
+
+// queued is a frame waiting in a send queue
+type queued struct {
+	frame
+	broadcast bool // An update about a document rather than a reply, so it may be dropped
+}
+
+// sendQueue is a client's bounded backlog of outgoing messages. The hub
+// pushes without blocking and the client's write pump drains it.
+type sendQueue struct {
+	mu         sync.Mutex
+	frames     []queued
+	limit      int
+	dropOldest bool
+	slow       bool          // Overflowed; the write pump closes the connection
+	closing    []byte        // Close frame payload once closed; the write pump sends what is queued, then it
+	ready      chan struct{} // Signalled when frames are pushed or the queue is closed
+}
+
+// newSendQueue creates a queue of at most limit frames
+func newSendQueue(limit int, dropOldest bool) *sendQueue {
+	if limit <= 0 {
+		limit = defaultSendBuffer
+	}
+	return &sendQueue{
+		limit:      limit,
+		dropOldest: dropOldest,
+		ready:      make(chan struct{}, 1),
+	}
+}
+
+// push queues a frame. It returns whether an older broadcast update was
+// dropped to make room, and false for ok if the frame did not fit and the
+// client is to be closed as too slow. Frames for a closing client are
+// discarded.
+func (q *sendQueue) push(f frame, broadcast bool) (dropped, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closing != nil || q.slow {
+		return false, true
+	}
+	if len(q.frames) >= q.limit {
+		i := -1
+		if broadcast && q.dropOldest {
+			i = q.oldestBroadcast()
+		}
+		if i < 0 {
+			q.slow = true
+			q.frames = nil
+			q.signal()
+			return false, false
+		}
+		q.frames = append(q.frames[:i], q.frames[i+1:]...)
+		dropped = true
+	}
+	q.frames = append(q.frames, queued{frame: f, broadcast: broadcast})
+	q.signal()
+	return dropped, true
+}
+
+// oldestBroadcast returns the index of the first queued broadcast update, or -1
+func (q *sendQueue) oldestBroadcast() int {
+	for i, f := range q.frames {
+		if f.broadcast {
+			return i
+		}
+	}
+	return -1
+}
+
+// close stops the queue; frames already queued are still written, followed
+// by a close frame with code and reason, or an empty one for
+// CloseNoStatusReceived. Only the first close counts.
+func (q *sendQueue) close(code int, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closing == nil {
+		q.closing = gorilla.FormatCloseMessage(code, reason)
+		q.signal()
+	}
+}
+
+// take empties the queue, returning its frames, whether the client
+// overflowed it, and the close frame payload if it was closed
+func (q *sendQueue) take() (frames []frame, slow bool, closing []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	frames = make([]frame, len(q.frames))
+	for i, f := range q.frames {
+		frames[i] = f.frame
+	}
+	q.frames = q.frames[:0]
+	return frames, q.slow, q.closing
+}
+
+// len returns the number of frames waiting to be written
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.frames)
+}
+
+// signal wakes the write pump, unless a wake-up is already pending
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
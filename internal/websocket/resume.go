@@ -0,0 +1,220 @@
+package websocket
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+)
+
+// newSessionID returns an unguessable ID for a client's session
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// session is what a disconnected client leaves behind for resuming
+type session struct {
+	clientID string // Identity on rosters, see join
+	userID   string // Authenticated user, so only they can resume it
+	expires  time.Time
+}
+
+// changeEvent is a document change as broadcast to its subscribers, see
+// parser.ChangesOnly, and the text operation that made it
+type changeEvent struct {
+	sequence  uint64
+	result    *models.ParseResponse
+	operation models.TextOperation
+}
+
+// changeLog numbers the changes of a document and keeps the most recent in
+// a ring buffer for replay, and for transforming text operations based on
+// earlier revisions; a document's revision is the sequence of its latest change
+type changeLog struct {
+	events []changeEvent
+	last   uint64 // Sequence of the latest change, 0 before the first
+}
+
+// newChangeLog creates a log that keeps the last size changes
+func newChangeLog(size int) *changeLog {
+	return &changeLog{events: make([]changeEvent, max(size, 0))}
+}
+
+// record numbers a change, keeps its changes and operation, and returns
+// its sequence
+func (l *changeLog) record(result *models.ParseResponse, operation models.TextOperation) uint64 {
+	l.last++
+	if n := uint64(len(l.events)); n > 0 {
+		l.events[l.last%n] = changeEvent{sequence: l.last, result: parser.ChangesOnly(result), operation: operation}
+	}
+	return l.last
+}
+
+// since returns the changes after sequence, or false if some of them are
+// no longer kept or sequence is from the future
+func (l *changeLog) since(sequence uint64) ([]changeEvent, bool) {
+	if sequence > l.last || l.last-sequence > uint64(len(l.events)) {
+		return nil, false
+	}
+	n := uint64(len(l.events))
+	events := make([]changeEvent, 0, l.last-sequence)
+	for seq := sequence + 1; seq <= l.last; seq++ {
+		events = append(events, l.events[seq%n])
+	}
+	return events, true
+}
+
+// suspend keeps a disconnecting client's session for the resume window,
+// dropping expired ones
+func (h *Hub) suspend(client *Client) {
+	if h.resumeWindow <= 0 {
+		return
+	}
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+
+	now := time.Now()
+	for id, s := range h.sessions {
+		if now.After(s.expires) {
+			delete(h.sessions, id)
+		}
+	}
+	s := &session{clientID: client.id, expires: now.Add(h.resumeWindow)}
+	if client.user != nil {
+		s.userID = client.user.ID
+	}
+	h.sessions[client.session] = s
+}
+
+// reclaim gives a client the identity and session ID of the suspended session
+// it asks for, if it has not expired and belongs to the same user. A session
+// is resumed at most once.
+func (h *Hub) reclaim(client *Client, sessionID string) {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+
+	s, ok := h.sessions[sessionID]
+	if !ok || time.Now().After(s.expires) {
+		return
+	}
+	userID := ""
+	if client.user != nil {
+		userID = client.user.ID
+	}
+	if s.userID != userID {
+		return
+	}
+	delete(h.sessions, sessionID)
+	client.id = s.clientID
+	client.session = sessionID
+}
+
+// handleResume resubscribes a reconnected client to a document and sends
+// the changes after the last one it saw. Changes made meanwhile may arrive
+// both replayed and broadcast; clients drop sequences they have seen.
+func (h *Hub) handleResume(client *Client, msg models.WebSocketMessage) {
+	if msg.DocumentID == "" {
+		h.replyError(client, msg, "Document ID is required to resume")
+		return
+	}
+	if msg.SessionID != "" && msg.SessionID != client.session {
+		h.reclaim(client, msg.SessionID)
+	}
+
+	// Join before reading the log, so no later change is missed
+	client.subscribedDocuments[msg.DocumentID] = true
+	user, users := h.join(msg.DocumentID, client, msg.User)
+
+	shard := h.documentShard(msg.DocumentID)
+	shard.mu.Lock()
+	var events []changeEvent
+	replayable, current, sequence := true, (*models.ParseResponse)(nil), uint64(0)
+	if changes, ok := shard.history[msg.DocumentID]; ok {
+		sequence = changes.last
+		events, replayable = changes.since(msg.Sequence)
+		if !replayable {
+			current = shard.documents[msg.DocumentID].Response()
+		}
+	}
+	shard.mu.Unlock()
+
+	h.reply(client, msg, models.WebSocketResponse{
+		Type:    "resumed",
+		Success: true,
+		Data: models.ResumeAck{
+			DocumentID: msg.DocumentID,
+			SessionID:  client.session,
+			User:       user,
+			Roster:     users,
+			Sequence:   sequence,
+			Replayed:   len(events),
+			Resync:     !replayable,
+		},
+		Timestamp: time.Now(),
+	})
+	for _, event := range events {
+		h.reply(client, msg, models.WebSocketResponse{
+			Type:      "parsed_incremental",
+			Success:   true,
+			Data:      event.result,
+			Sequence:  event.sequence,
+			Timestamp: time.Now(),
+		})
+	}
+	if !replayable {
+		h.reply(client, msg, models.WebSocketResponse{
+			Type:      "resync",
+			Success:   true,
+			Data:      current,
+			Sequence:  sequence,
+			Timestamp: time.Now(),
+		})
+	}
+	h.broadcastPresence(msg.DocumentID, "joined", user, users, client)
+}
+
+// sequence returns the sequence of a document's latest change
+func (h *Hub) sequence(documentID string) uint64 {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if changes, ok := shard.history[documentID]; ok {
+		return changes.last
+	}
+	return 0
+}
+
+// handleSnapshot sends the whole of a document with the sequence of its
+// latest change, for subscribers that missed a change
+func (h *Hub) handleSnapshot(client *Client, msg models.WebSocketMessage) {
+	if msg.DocumentID == "" {
+		h.replyError(client, msg, "Document ID is required for a snapshot")
+		return
+	}
+
+	if !h.sendSnapshot(client, msg) {
+		h.replyError(client, msg, "Unknown document: "+msg.DocumentID)
+	}
+}
+
+// sendSnapshot sends the whole of the document msg names, if the hub holds
+// it or can load it from the document store, in reply to msg
+func (h *Hub) sendSnapshot(client *Client, msg models.WebSocketMessage) bool {
+	current, sequence, err := h.Snapshot(msg.DocumentID)
+	if err != nil || current == nil {
+		return false
+	}
+	h.reply(client, msg, models.WebSocketResponse{
+		Type:      "snapshot",
+		Success:   true,
+		Data:      current,
+		Sequence:  sequence,
+		Timestamp: time.Now(),
+	})
+	return true
+}
@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	gorilla "github.com/gorilla/websocket"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/pkg/crdt"
+	"markdown-parser/pkg/hashing"
+)
+
+// shard is one of the hub's event loops, registering and unregistering a
+// share of its clients, so connection churn is spread over goroutines
+type shard struct {
+	clients    map[*Client]bool
+	broadcast  chan []byte
+	register   chan *Client
+	unregister chan *Client
+	announce   chan struct{}                    // Shutdown has begun, see Hub.Shutdown
+	stop       chan chan struct{}               // Close every client, then stop the loop
+	inspect    chan chan models.ShardQueueStats // Report the clients' send queues, see Hub.QueueStats
+}
+
+// newShard creates an event loop without clients
+func newShard() *shard {
+	return &shard{
+		clients:    make(map[*Client]bool),
+		broadcast:  make(chan []byte),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		announce:   make(chan struct{}),
+		stop:       make(chan chan struct{}),
+		inspect:    make(chan chan models.ShardQueueStats),
+	}
+}
+
+// documentShard holds a share of the hub's documents under its own lock,
+// so a long parse of one document only holds up those in the same shard
+type documentShard struct {
+	documents map[string]*parser.Document
+	history   map[string]*changeLog     // Recent changes, for clients resuming a session
+	replicas  map[string]*crdt.Document // Replicated text of documents edited in CRDT mode
+	undo      map[string]*undoStack     // Recent changes by author, for undo and redo
+	mu        sync.Mutex
+}
+
+// newDocumentShard creates a shard without documents
+func newDocumentShard() *documentShard {
+	return &documentShard{
+		documents: make(map[string]*parser.Document),
+		history:   make(map[string]*changeLog),
+		replicas:  make(map[string]*crdt.Document),
+		undo:      make(map[string]*undoStack),
+	}
+}
+
+// nextShard picks the event loop of a new client, round robin
+func (h *Hub) nextShard() *shard {
+	return h.shards[h.shardCount.Add(1)%uint64(len(h.shards))]
+}
+
+// documentShard returns the shard holding a document
+func (h *Hub) documentShard(documentID string) *documentShard {
+	return h.documentShards[hashing.Sum64(documentID)%uint64(len(h.documentShards))]
+}
+
+// runShard runs an event loop until Shutdown stops it
+func (h *Hub) runShard(s *shard) {
+	shuttingDown := false
+	var stopped chan struct{}
+	for {
+		select {
+		case client := <-s.register:
+			if shuttingDown {
+				client.closeWith(gorilla.CloseGoingAway, "server shutting down")
+				client.send.close(gorilla.CloseNoStatusReceived, "")
+				continue
+			}
+			if !h.admit() {
+				h.rejected.Add(1)
+				log.Printf("WARN: Rejecting client at the limit of %d connections", h.maxConnections)
+				client.closeWith(gorilla.CloseTryAgainLater, "server at connection limit")
+				client.send.close(gorilla.CloseNoStatusReceived, "")
+				continue
+			}
+
+			s.clients[client] = true
+			log.Printf("INFO: Client connected. Total clients: %d", h.connected.Load())
+
+			// Send connection confirmation
+			response := models.WebSocketResponse{
+				Type:      "connected",
+				Success:   true,
+				Data:      map[string]interface{}{"sessionId": client.session, "site": client.id, "version": ProtocolVersion},
+				Timestamp: time.Now(),
+			}
+
+			if data, err := encodeFrame(response, false); err == nil {
+				h.deliver(client, data, false)
+			}
+
+		case client := <-s.unregister:
+			if _, ok := s.clients[client]; ok {
+				h.suspend(client)
+				delete(s.clients, client)
+				h.connected.Add(-1)
+				h.leaveAll(client)
+				client.send.close(gorilla.CloseNoStatusReceived, "")
+				log.Printf("INFO: Client disconnected. Total clients: %d", h.connected.Load())
+			}
+			if stopped != nil && len(s.clients) == 0 {
+				close(stopped)
+				return
+			}
+
+		case <-s.announce:
+			shuttingDown = true
+			for client := range s.clients {
+				h.sendToClient(client, models.WebSocketResponse{
+					Type:      "server_shutdown",
+					Success:   true,
+					Timestamp: time.Now(),
+				})
+			}
+
+		case stopped = <-s.stop:
+			shuttingDown = true
+			for client := range s.clients {
+				client.send.close(gorilla.CloseGoingAway, "server shutting down")
+			}
+			if len(s.clients) == 0 {
+				close(stopped)
+				return
+			}
+
+		case reply := <-s.inspect:
+			stats := models.ShardQueueStats{Clients: len(s.clients)}
+			for client := range s.clients {
+				queued := client.send.len()
+				stats.Queued += queued
+				stats.Deepest = max(stats.Deepest, queued)
+			}
+			reply <- stats
+
+		case message := <-s.broadcast:
+			// Broadcast message to all of the shard's clients
+			for client := range s.clients {
+				h.deliver(client, frame{data: message}, true)
+			}
+		}
+	}
+}
+
+// admit counts a new connection, unless the hub is at its connection limit,
+// and records the peak
+func (h *Hub) admit() bool {
+	for {
+		n := h.connected.Load()
+		if h.maxConnections > 0 && n >= int64(h.maxConnections) {
+			return false
+		}
+		if h.connected.CompareAndSwap(n, n+1) {
+			for {
+				peak := h.peak.Load()
+				if n+1 <= peak || h.peak.CompareAndSwap(peak, n+1) {
+					return true
+				}
+			}
+		}
+	}
+}
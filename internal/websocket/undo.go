@@ -0,0 +1,219 @@
+package websocket
+
+import (
+	"fmt"
+	"time"
+
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/pkg/diff"
+	"markdown-parser/pkg/ot"
+)
+
+// defaultUndoDepth is the number of changes kept per document for undo and
+// redo when none is configured
+const defaultUndoDepth = 100
+
+// undoEntry is a change that can be undone or redone: the operation that
+// reverts it, kept transformed to apply to the document as it stands
+type undoEntry struct {
+	author  string
+	inverse models.TextOperation
+}
+
+// undoStack holds a document's recent changes for undo and redo by their
+// authors, oldest first
+type undoStack struct {
+	undo []undoEntry
+	redo []undoEntry
+}
+
+// track records a change to a document whose content was before. Entries
+// already kept are transformed past it. An ordinary change by author can be
+// undone and clears its redos; undoing one can be redone, and redoing one
+// undone again.
+func (s *undoStack) track(author, action string, operation models.TextOperation, before string, depth int) {
+	s.undo = transformEntries(s.undo, operation)
+	s.redo = transformEntries(s.redo, operation)
+
+	entry := undoEntry{author: author, inverse: ot.Invert(operation, before)}
+	switch action {
+	case "undo":
+		s.redo = append(s.redo, entry)
+	case "redo":
+		s.undo = append(s.undo, entry)
+	default:
+		s.undo = append(s.undo, entry)
+		redo := s.redo[:0]
+		for _, e := range s.redo {
+			if e.author != author {
+				redo = append(redo, e)
+			}
+		}
+		s.redo = redo
+	}
+	if len(s.undo) > depth {
+		s.undo = s.undo[len(s.undo)-depth:]
+	}
+	if len(s.redo) > depth {
+		s.redo = s.redo[len(s.redo)-depth:]
+	}
+}
+
+// pop removes an author's latest undoable, or redoable, change and returns
+// the operation that undoes, or redoes, it
+func (s *undoStack) pop(author, action string) (models.TextOperation, bool) {
+	entries := &s.undo
+	if action == "redo" {
+		entries = &s.redo
+	}
+	for i := len(*entries) - 1; i >= 0; i-- {
+		if (*entries)[i].author == author {
+			inverse := (*entries)[i].inverse
+			*entries = append((*entries)[:i], (*entries)[i+1:]...)
+			return inverse, true
+		}
+	}
+	return nil, false
+}
+
+// transformEntries transforms the operations of entries past a later
+// change, dropping any that no longer apply
+func transformEntries(entries []undoEntry, operation models.TextOperation) []undoEntry {
+	kept := entries[:0]
+	for _, e := range entries {
+		inverse, _, err := ot.Transform(e.inverse, operation)
+		if err != nil {
+			continue
+		}
+		e.inverse = inverse
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// author returns whose changes a client makes to a document, for undo: the
+// identity it subscribed with, or the client's own
+func (h *Hub) author(documentID string, client *Client) string {
+	if user, ok := h.identity(documentID, client); ok {
+		return user.ID
+	}
+	return client.id
+}
+
+// record numbers a change to a stored document whose content was before,
+// keeps it for replay, and makes it undoable by and audits it as its
+// author's, unless it has none as a change made outside the hub; the
+// caller holds the shard's lock
+func (h *Hub) record(shard *documentShard, documentID, author, before string, result *models.ParseResponse, operation models.TextOperation) uint64 {
+	h.trackUndo(shard, documentID, author, "", operation, before)
+	if author != "" {
+		h.auditEdit(documentID, author, "")
+	}
+	return h.logChange(shard, documentID, result, operation)
+}
+
+// auditEdit records an author's change to a document in the audit log
+func (h *Hub) auditEdit(documentID, author, detail string) {
+	if h.audit != nil {
+		h.audit.Record(documentID, author, "edit", detail)
+	}
+}
+
+// logChange numbers a change to a stored document, keeps it for replay,
+// schedules its autosave, and tells the OnChange function; the caller holds
+// the shard's lock
+func (h *Hub) logChange(shard *documentShard, documentID string, result *models.ParseResponse, operation models.TextOperation) uint64 {
+	sequence := shard.history[documentID].record(result, operation)
+	h.scheduleSave(shard, documentID)
+	if h.onChange != nil {
+		h.onChange(documentID, operation, result.Blocks)
+	}
+	return sequence
+}
+
+// trackUndo records a change in a document's undo stack; the caller holds
+// the shard's lock
+func (h *Hub) trackUndo(shard *documentShard, documentID, author, action string, operation models.TextOperation, before string) {
+	stack, ok := shard.undo[documentID]
+	if !ok {
+		stack = &undoStack{}
+		shard.undo[documentID] = stack
+	}
+	stack.track(author, action, operation, before, h.undoDepth)
+}
+
+// handleUndo reverts the client's last change to a document, or redoes the
+// last one it undid, and sends the changed blocks to it and the document's
+// other subscribers
+func (h *Hub) handleUndo(client *Client, msg models.WebSocketMessage) {
+	if msg.DocumentID == "" {
+		h.replyError(client, msg, "Document ID is required to "+msg.Type)
+		return
+	}
+	author := h.author(msg.DocumentID, client)
+
+	var operation models.TextOperation
+	var result *models.ParseResponse
+	var sequence uint64
+	err := h.jobs.Run(func() (err error) {
+		operation, result, sequence, err = h.applyUndo(msg.DocumentID, author, msg.Type)
+		return err
+	})
+	if err != nil {
+		h.replyError(client, msg, "Failed to "+msg.Type+": "+err.Error())
+		return
+	}
+
+	event := models.UndoEvent{
+		DocumentID: msg.DocumentID,
+		Operation:  operation,
+		User:       models.User{ID: author},
+		Changes:    result.Changes,
+	}
+	if user, ok := h.identity(msg.DocumentID, client); ok {
+		event.User = user
+	}
+	response := models.WebSocketResponse{
+		Type:      msg.Type,
+		Success:   true,
+		Data:      event,
+		Sequence:  sequence,
+		Timestamp: time.Now(),
+	}
+	h.reply(client, msg, response)
+	h.broadcastToDocument(msg.DocumentID, response, client)
+}
+
+// applyUndo undoes or redoes an author's latest change to a stored document
+// and records that as a change
+func (h *Hub) applyUndo(documentID, author, action string) (models.TextOperation, *models.ParseResponse, uint64, error) {
+	shard := h.documentShard(documentID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	doc, ok := shard.documents[documentID]
+	stack := shard.undo[documentID]
+	if !ok || stack == nil {
+		return nil, nil, 0, fmt.Errorf("nothing to %s", action)
+	}
+	operation, ok := stack.pop(author, action)
+	if !ok {
+		return nil, nil, 0, fmt.Errorf("nothing to %s", action)
+	}
+
+	before := doc.Content()
+	content, err := ot.Apply(before, operation)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	// Undo and redo report their changes block by block
+	doc.Granularity = diff.GranularityBlock
+	result, err := doc.ApplyEdit(parser.DiffEdit(before, content))
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	h.trackUndo(shard, documentID, author, action, operation, before)
+	h.auditEdit(documentID, author, action)
+	return operation, result, h.logChange(shard, documentID, result, operation), nil
+}
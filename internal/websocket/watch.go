@@ -0,0 +1,111 @@
+package websocket
+
+import (
+	"log"
+
+	"markdown-parser/internal/models"
+)
+
+// defaultWatchBuffer is the number of events queued per watcher when the
+// send buffer is not configured
+const defaultWatchBuffer = 256
+
+// watcher receives a document's broadcasts without a WebSocket connection
+type watcher struct {
+	events chan models.WebSocketResponse
+}
+
+// Watch streams the broadcasts about a document, as its WebSocket
+// subscribers get them, to read-only consumers such as Server-Sent Events
+// clients. The channel is closed by stop, when the hub shuts down, or when
+// the consumer falls a full buffer behind, since it has then missed
+// changes and must start over from a snapshot.
+func (h *Hub) Watch(documentID string) (events <-chan models.WebSocketResponse, stop func()) {
+	size := h.sendBuffer
+	if size <= 0 {
+		size = defaultWatchBuffer
+	}
+	w := &watcher{events: make(chan models.WebSocketResponse, size)}
+
+	h.watchersMu.Lock()
+	if h.watchersClosed {
+		close(w.events)
+	} else {
+		if h.watchers[documentID] == nil {
+			h.watchers[documentID] = make(map[*watcher]struct{})
+		}
+		h.watchers[documentID][w] = struct{}{}
+	}
+	h.watchersMu.Unlock()
+
+	return w.events, func() {
+		h.watchersMu.Lock()
+		defer h.watchersMu.Unlock()
+		h.unwatch(documentID, w)
+	}
+}
+
+// Snapshot returns the whole of a document, loading it from the document
+// store if need be, with the sequence of its latest change, or nil if
+// there is no such document
+func (h *Hub) Snapshot(documentID string) (*models.ParseResponse, uint64, error) {
+	shard := h.documentShard(documentID)
+	var current *models.ParseResponse
+	var sequence uint64
+	err := h.jobs.Run(func() error {
+		shard.mu.Lock()
+		defer shard.mu.Unlock()
+
+		doc, ok, err := h.loadDocument(shard, documentID)
+		if err != nil || !ok {
+			return err
+		}
+		current = doc.Response()
+		sequence = shard.history[documentID].last
+		return nil
+	})
+	return current, sequence, err
+}
+
+// notifyWatchers passes a broadcast about a document to its watchers,
+// dropping those that are a full buffer behind
+func (h *Hub) notifyWatchers(documentID string, response models.WebSocketResponse) {
+	h.watchersMu.Lock()
+	defer h.watchersMu.Unlock()
+
+	for w := range h.watchers[documentID] {
+		select {
+		case w.events <- response:
+		default:
+			log.Printf("WARN: Dropping a watcher of document %s that fell %d events behind", documentID, cap(w.events))
+			h.unwatch(documentID, w)
+		}
+	}
+}
+
+// unwatch removes a watcher and closes its channel, if it is still
+// watching; the caller holds watchersMu
+func (h *Hub) unwatch(documentID string, w *watcher) {
+	if _, ok := h.watchers[documentID][w]; !ok {
+		return
+	}
+	delete(h.watchers[documentID], w)
+	if len(h.watchers[documentID]) == 0 {
+		delete(h.watchers, documentID)
+	}
+	close(w.events)
+}
+
+// CloseWatchers ends every watch and refuses new ones, so streaming
+// requests finish when the server shuts down
+func (h *Hub) CloseWatchers() {
+	h.watchersMu.Lock()
+	defer h.watchersMu.Unlock()
+
+	h.watchersClosed = true
+	for documentID, watchers := range h.watchers {
+		for w := range watchers {
+			h.unwatch(documentID, w)
+		}
+	}
+}
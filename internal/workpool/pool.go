@@ -0,0 +1,134 @@
+package workpool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrQueueFull is returned when every worker is busy and the queue has no room
+var ErrQueueFull = errors.New("parse queue is full")
+
+// ErrShutdown is returned for jobs submitted after Shutdown
+var ErrShutdown = errors.New("parse pool is shutting down")
+
+// Pool runs jobs on a fixed number of workers fed by a bounded queue, so
+// load beyond its capacity is rejected instead of piling up goroutines
+type Pool struct {
+	queue    chan job
+	workers  int
+	rejected atomic.Uint64
+
+	// Jobs queued or running, and whether Shutdown has stopped new ones
+	inflight sync.WaitGroup
+	closed   bool
+	closedMu sync.RWMutex
+}
+
+// job is a queued function and the channel its result is delivered on
+type job struct {
+	run  func() error
+	done chan error
+}
+
+// Stats reports a pool's size and load
+type Stats struct {
+	Workers  int    `json:"workers"`
+	Queued   int    `json:"queued"`
+	Capacity int    `json:"capacity"`
+	Rejected uint64 `json:"rejected"`
+}
+
+// New starts a pool with the given number of workers (0 uses one per CPU)
+// and room for queueDepth jobs waiting for a free worker
+func New(workers, queueDepth int) *Pool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if queueDepth < 0 {
+		queueDepth = 0
+	}
+
+	p := &Pool{
+		queue:   make(chan job, queueDepth),
+		workers: workers,
+	}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Run queues run and waits for its result, or fails fast with ErrQueueFull,
+// or ErrShutdown once the pool is shutting down
+func (p *Pool) Run(run func() error) error {
+	p.closedMu.RLock()
+	if p.closed {
+		p.closedMu.RUnlock()
+		return ErrShutdown
+	}
+	p.inflight.Add(1)
+	p.closedMu.RUnlock()
+	defer p.inflight.Done()
+
+	j := job{run: run, done: make(chan error, 1)}
+
+	select {
+	case p.queue <- j:
+	default:
+		p.rejected.Add(1)
+		return ErrQueueFull
+	}
+	return <-j.done
+}
+
+// Shutdown stops the pool accepting jobs and waits for the queued and
+// running ones to finish, or for ctx to be done. Jobs still running then
+// carry on in the background.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.closedMu.Lock()
+	p.closed = true
+	p.closedMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats reports the pool's workers, queue occupancy, and rejected jobs
+func (p *Pool) Stats() Stats {
+	return Stats{
+		Workers:  p.workers,
+		Queued:   len(p.queue),
+		Capacity: cap(p.queue),
+		Rejected: p.rejected.Load(),
+	}
+}
+
+// work runs queued jobs until the process exits
+func (p *Pool) work() {
+	for j := range p.queue {
+		j.done <- safeRun(j.run)
+	}
+}
+
+// safeRun turns a panicking job into an error so it can't take down the worker
+func safeRun(run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("parse job panicked: %v", r)
+		}
+	}()
+	return run()
+}
@@ -1,38 +1,58 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"markdown-parser/configs"
 	"markdown-parser/internal/api"
+	"markdown-parser/internal/audit"
+	"markdown-parser/internal/cli"
+	"markdown-parser/internal/comments"
+	"markdown-parser/internal/documents"
+	"markdown-parser/internal/https"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/redis"
+	"markdown-parser/internal/rpc"
+	"markdown-parser/internal/versions"
 	"markdown-parser/internal/websocket"
+	"markdown-parser/internal/workpool"
 )
 
 func main() {
+	os.Exit(cli.Run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, serve))
+}
+
+// serve runs the service with config, loaded from configPath, until SIGINT
+// or SIGTERM; port, when set, is listened on in place of the configured one
+func serve(config *configs.Config, configPath, port string) {
 	// Set production mode if not already set
 	if gin.Mode() != gin.ReleaseMode {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	// Load configuration
-	config, err := configs.LoadConfig("configs/config.json")
-	if err != nil {
-		log.Printf("Error loading config: %v, using defaults", err)
-		config = configs.DefaultConfig()
-	}
-
-	// Initialize Gin router
+	// Initialize Gin router, taking client addresses only from trusted proxies
 	r := gin.Default()
+	if err := r.SetTrustedProxies(config.Server.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted_proxies: %v", err)
+	}
 
-	// Add CORS middleware for React frontend
+	// Add CORS middleware for React frontend, whose origins may be reloaded
+	var allowOrigins atomic.Pointer[[]string]
+	allowOrigins.Store(&config.Server.AllowOrigins)
 	r.Use(func(c *gin.Context) {
 		origin := c.Request.Header.Get("Origin")
 		allowed := false
-		for _, allowedOrigin := range config.Server.AllowOrigins {
+		for _, allowedOrigin := range *allowOrigins.Load() {
 			if origin == allowedOrigin || allowedOrigin == "*" {
 				allowed = true
 				break
@@ -42,8 +62,8 @@ func main() {
 		if allowed {
 			c.Header("Access-Control-Allow-Origin", origin)
 		}
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key")
 		
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -53,6 +73,66 @@ func main() {
 		c.Next()
 	})
 
+	// Parse work from the API and WebSocket hub shares one bounded worker pool
+	jobs := workpool.New(config.Parser.ParseWorkers, config.Parser.ParseQueueDepth)
+
+	// Redis, when configured, shares documents and parse results between instances
+	cache := redis.New(config.Redis)
+	if cache != nil {
+		if err := cache.Ping(); err != nil {
+			log.Printf("WARN: Redis at %s is not answering: %v", config.Redis.Addr, err)
+		}
+	}
+
+	// Initialize API routes
+	api.SetupRoutes(r, config, jobs)
+	if config.Parser.SharedParseCache {
+		if cache != nil {
+			api.SetSharedParseCache(cache.Namespace("parse"))
+		} else {
+			log.Printf("WARN: shared_parse_cache needs redis.addr; parse results are cached per instance")
+		}
+	}
+
+	// Initialize WebSocket hub, editing the kept documents and keeping
+	// comments anchored as they change
+	backend := documents.NewMemoryBackend()
+	if config.Documents.Backend == "redis" {
+		if cache != nil {
+			backend = documents.NewRedisBackend(cache)
+		} else {
+			log.Printf("WARN: The redis document backend needs redis.addr; keeping documents in memory")
+		}
+	}
+	docs := documents.NewStore(config.Documents, backend)
+	hub := websocket.NewHub(config, jobs)
+	hub.SetDocumentStore(docs)
+	hub.SetAuthenticator(api.Authenticator(config))
+
+	// Record who did what to each document, in the document backend
+	var auditLog *audit.Log
+	if config.Audit.Enabled {
+		auditBackend := audit.NewMemoryBackend()
+		if config.Documents.Backend == "redis" && cache != nil {
+			auditBackend = audit.NewRedisBackend(cache)
+		}
+		auditLog = audit.NewLog(config.Audit, auditBackend)
+		hub.SetAuditLog(auditLog)
+	}
+	notes := comments.NewStore(hub, hub)
+	hub.OnChange(notes.Reanchor)
+	go hub.Run()
+	api.SetupDocumentRoutes(r, config, docs, hub)
+	api.SetupCommentRoutes(r, config, notes)
+	api.SetupEventRoutes(r, config, hub)
+	api.SetupAuditRoutes(r, config, auditLog)
+	api.SetupAdminRoutes(r, config, hub)
+
+	// Snapshot the documents into their version history
+	store := versions.NewStore(config.Versions, docs)
+	go store.Run()
+	api.SetupVersionRoutes(r, config, store)
+
 	// Health check endpoint
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -63,23 +143,43 @@ func main() {
 				"max_content_size": config.Parser.MaxContentSize,
 				"max_connections":  config.WebSocket.MaxConnections,
 			},
+			"cache": gin.H{
+				"api":       api.CacheStats(),
+				"websocket": hub.CacheStats(),
+			},
+			"workers": jobs.Stats(),
 		})
 	})
 
-	// Initialize API routes
-	api.SetupRoutes(r)
-
-	// Initialize WebSocket hub
-	hub := websocket.NewHub()
-	go hub.Run()
-
 	// WebSocket endpoint
 	r.GET("/ws", func(c *gin.Context) {
 		websocket.HandleWebSocket(hub, c)
 	})
 
-	// Use Railway's PORT environment variable or fallback to config
-	port := os.Getenv("PORT")
+	// Admin endpoints, behind the same credentials as the API and only
+	// open to admins
+	admin := r.Group("/admin")
+	authenticator := api.Authenticator(config)
+	if authenticator != nil {
+		admin.Use(authenticator.Middleware())
+	}
+	admin.Use(api.RequireAdmin(config))
+	admin.GET("/connections", func(c *gin.Context) {
+		c.JSON(http.StatusOK, hub.ConnectionStats())
+	})
+	admin.GET("/api-keys", func(c *gin.Context) {
+		var keys []models.APIKeyUsage
+		if authenticator != nil {
+			keys = authenticator.Usage()
+		}
+		c.JSON(http.StatusOK, models.APIKeyUsageResponse{Keys: keys, Success: true})
+	})
+	api.SetupDebugRoutes(admin, config, hub, jobs)
+
+	// Use the --port flag, Railway's PORT environment variable, or fallback to config
+	if port == "" {
+		port = os.Getenv("PORT")
+	}
 	if port == "" {
 		port = config.Server.Port
 	}
@@ -88,5 +188,103 @@ func main() {
 	address := config.Server.Host + ":" + port
 	log.Printf("INFO: Starting markdown parser service on %s", address)
 	log.Printf("INFO: CORS origins: %s", strings.Join(config.Server.AllowOrigins, ", "))
-	log.Fatal(r.Run(":" + port))
+	server := &http.Server{Addr: ":" + port, Handler: r}
+	server.RegisterOnShutdown(hub.CloseWatchers) // Ends event streams, which never go idle
+
+	// Serve HTTPS when configured, with plain HTTP redirecting to it
+	var redirectServer *http.Server
+	if https.Enabled(config.Server.TLS) {
+		tlsConfig, redirect, err := https.Setup(config.Server.TLS, port)
+		if err != nil {
+			log.Fatalf("TLS: %v", err)
+		}
+		server.TLSConfig = tlsConfig
+		if config.Server.TLS.RedirectPort != "" {
+			log.Printf("INFO: Redirecting HTTP on :%s to HTTPS", config.Server.TLS.RedirectPort)
+			redirectServer = &http.Server{Addr: ":" + config.Server.TLS.RedirectPort, Handler: redirect}
+			go func() {
+				if err := redirectServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					log.Fatal(err)
+				}
+			}()
+		}
+	}
+	go func() {
+		var err error
+		if server.TLSConfig != nil {
+			err = server.ListenAndServeTLS("", "") // The certificates are in TLSConfig
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// The gRPC service, on its own port, shares the worker pool and hub
+	var grpcServer *http.Server
+	var grpcService *rpc.Server
+	if config.Server.GRPCPort != "" {
+		log.Printf("INFO: Starting gRPC service on :%s", config.Server.GRPCPort)
+		grpcService = rpc.NewServer(config, jobs, hub)
+		grpcService.SetDocumentStore(docs)
+		grpcService.SetAuthenticator(api.Authenticator(config))
+		grpcServer = &http.Server{
+			Addr:    ":" + config.Server.GRPCPort,
+			Handler: grpcService.Handler(),
+		}
+		grpcServer.RegisterOnShutdown(hub.CloseWatchers)
+		go func() {
+			if err := grpcServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	// When the config file changes or on SIGHUP, apply the settings that are
+	// safe to change live, keeping every connection open
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	stopWatching := make(chan struct{})
+	go configs.Watch(configPath, time.Duration(config.Server.ReloadIntervalSeconds)*time.Second, reload, stopWatching, func(reloaded *configs.Config) {
+		if changed := config.RestartChanges(reloaded); len(changed) > 0 {
+			log.Printf("WARN: Changes to %s take effect on restart", strings.Join(changed, ", "))
+		}
+		allowOrigins.Store(&reloaded.Server.AllowOrigins)
+		api.Reconfigure(config, reloaded)
+		hub.Reconfigure(reloaded.Parser)
+		if grpcService != nil {
+			grpcService.Reconfigure(reloaded.Parser)
+		}
+		log.Printf("INFO: Reloaded %s", configPath)
+	})
+
+	// On SIGINT or SIGTERM, stop accepting connections and let requests,
+	// parses, and WebSocket clients finish before exiting
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	log.Println("INFO: Shutting down")
+	close(stopWatching)
+	store.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Server.ShutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("WARN: HTTP server shutdown: %v", err)
+	}
+	if redirectServer != nil {
+		if err := redirectServer.Shutdown(ctx); err != nil {
+			log.Printf("WARN: HTTP redirect server shutdown: %v", err)
+		}
+	}
+	if grpcServer != nil {
+		if err := grpcServer.Shutdown(ctx); err != nil {
+			log.Printf("WARN: gRPC server shutdown: %v", err)
+		}
+	}
+	if err := hub.Shutdown(ctx); err != nil {
+		log.Printf("WARN: WebSocket hub shutdown: %v", err)
+	}
+	log.Println("INFO: Server stopped")
 }
\ No newline at end of file
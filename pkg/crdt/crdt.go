@@ -0,0 +1,264 @@
+package crdt
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"markdown-parser/internal/models"
+)
+
+// maxPending bounds the operations kept waiting for the characters they
+// refer to, so a replica cannot be made to hold an unbounded backlog
+const maxPending = 10000
+
+// element is a character of a document; deleted ones stay as tombstones,
+// since later inserts may refer to them
+type element struct {
+	id      models.CRDTID
+	value   string
+	deleted bool
+}
+
+// Document is a replicated text (RGA): every character has a unique ID and
+// goes after the character it was typed after, ahead of any concurrent
+// insert there with a lower ID. Replicas that apply the same operations, in
+// any order, hold the same text.
+type Document struct {
+	elements []element
+	known    map[models.CRDTID]bool // IDs of every character and deletion applied
+	clock    uint64                 // Highest clock seen, for numbering local operations
+	vector   map[string]uint64      // Highest clock seen of each site
+	log      []models.CRDTOperation // Operations applied, in order, for syncing other replicas
+	pending  []models.CRDTOperation // Operations waiting for a character they refer to
+}
+
+// New creates an empty document
+func New() *Document {
+	return &Document{
+		known:  make(map[models.CRDTID]bool),
+		vector: make(map[string]uint64),
+	}
+}
+
+// Text returns the document's text
+func (d *Document) Text() string {
+	n := 0
+	for _, e := range d.elements {
+		if !e.deleted {
+			n += len(e.value)
+		}
+	}
+	text := make([]byte, 0, n)
+	for _, e := range d.elements {
+		if !e.deleted {
+			text = append(text, e.value...)
+		}
+	}
+	return string(text)
+}
+
+// Vector returns the highest clock seen of each site
+func (d *Document) Vector() map[string]uint64 {
+	vector := make(map[string]uint64, len(d.vector))
+	for site, clock := range d.vector {
+		vector[site] = clock
+	}
+	return vector
+}
+
+// Since returns the operations applied that a replica which has seen the
+// given clocks lacks, in the order they were applied
+func (d *Document) Since(vector map[string]uint64) []models.CRDTOperation {
+	ops := []models.CRDTOperation{}
+	for _, op := range d.log {
+		if LastClock(op) > vector[op.ID.Site] {
+			ops = append(ops, op)
+		}
+	}
+	return ops
+}
+
+// Validate checks that an operation is well formed
+func Validate(op models.CRDTOperation) error {
+	if op.ID.Site == "" || op.ID.Clock == 0 {
+		return fmt.Errorf("operation needs a site and a clock above 0")
+	}
+	switch op.Type {
+	case "insert":
+		if op.Text == "" || !utf8.ValidString(op.Text) {
+			return fmt.Errorf("insert %s/%d needs valid UTF-8 text", op.ID.Site, op.ID.Clock)
+		}
+	case "delete":
+		if op.Ref.Site == "" {
+			return fmt.Errorf("delete %s/%d needs the character it removes", op.ID.Site, op.ID.Clock)
+		}
+	default:
+		return fmt.Errorf("unknown operation type %q", op.Type)
+	}
+	return nil
+}
+
+// Apply applies operations from any replica and returns those applied,
+// including earlier ones that were waiting for them. Operations already
+// applied are ignored, and those referring to a character not seen yet wait
+// for it.
+func (d *Document) Apply(ops ...models.CRDTOperation) ([]models.CRDTOperation, error) {
+	for _, op := range ops {
+		if err := Validate(op); err != nil {
+			return nil, err
+		}
+	}
+	if len(d.pending)+len(ops) > maxPending {
+		return nil, fmt.Errorf("more than %d operations are waiting for characters they refer to", maxPending)
+	}
+
+	queue := append(d.pending, ops...)
+	d.pending = nil
+	applied := []models.CRDTOperation{}
+	for progress := true; progress; {
+		progress = false
+		waiting := queue[:0]
+		for _, op := range queue {
+			switch {
+			case d.known[op.ID]:
+				// Applied already
+			case d.ready(op):
+				d.integrate(op)
+				applied = append(applied, op)
+				progress = true
+			default:
+				waiting = append(waiting, op)
+			}
+		}
+		queue = waiting
+	}
+	d.pending = append(d.pending, queue...)
+	return applied, nil
+}
+
+// Edit makes an edit to the text as the replica site and returns the
+// operations that make it, for sending to other replicas
+func (d *Document) Edit(site string, edit models.Edit) ([]models.CRDTOperation, error) {
+	// Visible characters, and the byte offset of each
+	var visible []int
+	var offsets []int
+	offset := 0
+	for i, e := range d.elements {
+		if !e.deleted {
+			visible = append(visible, i)
+			offsets = append(offsets, offset)
+			offset += len(e.value)
+		}
+	}
+	offsets = append(offsets, offset)
+	position := func(at int) (int, error) {
+		for i, o := range offsets {
+			if o == at {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("offset %d is not at a character of the text (length %d)", at, offset)
+	}
+	start, err := position(edit.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := position(edit.End)
+	if err != nil || end < start {
+		return nil, fmt.Errorf("edit range [%d, %d) is outside the text (length %d)", edit.Start, edit.End, offset)
+	}
+
+	var ops []models.CRDTOperation
+	for _, i := range visible[start:end] {
+		d.clock++
+		ops = append(ops, models.CRDTOperation{
+			Type: "delete",
+			ID:   models.CRDTID{Site: site, Clock: d.clock},
+			Ref:  d.elements[i].id,
+		})
+	}
+	if edit.Text != "" {
+		op := models.CRDTOperation{
+			Type: "insert",
+			ID:   models.CRDTID{Site: site, Clock: d.clock + 1},
+			Text: edit.Text,
+		}
+		if start > 0 {
+			op.Ref = d.elements[visible[start-1]].id
+		}
+		ops = append(ops, op)
+	}
+	return d.Apply(ops...)
+}
+
+// ready reports whether the character an operation refers to is here
+func (d *Document) ready(op models.CRDTOperation) bool {
+	return op.Ref == (models.CRDTID{}) || d.known[op.Ref]
+}
+
+// integrate applies an operation whose reference is here
+func (d *Document) integrate(op models.CRDTOperation) {
+	if op.Type == "delete" {
+		for i := range d.elements {
+			if d.elements[i].id == op.Ref {
+				d.elements[i].deleted = true
+				break
+			}
+		}
+		d.see(op.ID)
+	} else {
+		at := d.index(op.Ref) + 1
+		id := op.ID
+		for _, r := range op.Text {
+			// Skip concurrent inserts after the same character with
+			// higher IDs, and the characters typed after them
+			for at < len(d.elements) && greater(d.elements[at].id, id) {
+				at++
+			}
+			d.elements = append(d.elements, element{})
+			copy(d.elements[at+1:], d.elements[at:])
+			d.elements[at] = element{id: id, value: string(r)}
+			d.see(id)
+			at++
+			id.Clock++
+		}
+	}
+	d.log = append(d.log, op)
+}
+
+// see records an applied ID
+func (d *Document) see(id models.CRDTID) {
+	d.known[id] = true
+	d.clock = max(d.clock, id.Clock)
+	d.vector[id.Site] = max(d.vector[id.Site], id.Clock)
+}
+
+// index returns the position of a character, or -1 for the zero ID
+func (d *Document) index(id models.CRDTID) int {
+	if id == (models.CRDTID{}) {
+		return -1
+	}
+	for i, e := range d.elements {
+		if e.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// greater orders IDs by clock, then site
+func greater(a, b models.CRDTID) bool {
+	if a.Clock != b.Clock {
+		return a.Clock > b.Clock
+	}
+	return a.Site > b.Site
+}
+
+// LastClock returns the clock of the last character or deletion an
+// operation makes
+func LastClock(op models.CRDTOperation) uint64 {
+	if op.Type == "insert" {
+		return op.ID.Clock + uint64(utf8.RuneCountInString(op.Text)) - 1
+	}
+	return op.ID.Clock
+}
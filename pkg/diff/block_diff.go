@@ -1,16 +1,24 @@
 package diff
 
 import (
-	"crypto/md5"
-	"fmt"
+	"slices"
+	"strconv"
 	"strings"
 
 	"markdown-parser/internal/models"
+	"markdown-parser/pkg/hashing"
 )
 
 // BlockDiffer handles block-level diff operations
 type BlockDiffer struct {
 	previousBlocks map[string]*models.Block
+
+	// IgnoreWhitespace compares blocks by their whitespace-normalized content,
+	// see NormalizeWhitespace. Since block IDs hash the exact content, a block
+	// whose content only changed in whitespace is matched to its old version
+	// and not reported, even though its ID changed; this suits comparing
+	// versions, not keeping a client's copy of the blocks in sync.
+	IgnoreWhitespace bool
 }
 
 // NewBlockDiffer creates a new block differ
@@ -20,20 +28,54 @@ func NewBlockDiffer() *BlockDiffer {
 	}
 }
 
-// ComputeDiff computes the differences between old and new blocks
+// ComputeDiff computes the differences between old and new blocks, in
+// document order. Blocks are compared in Index order: a block that kept its
+// ID but left the longest run of blocks whose relative order is unchanged is
+// reported as moved, with its old and new indexes and its current rendering.
+// Removed blocks come last, in their old order.
 func (d *BlockDiffer) ComputeDiff(newBlocks map[string]*models.Block) []models.BlockChange {
 	var changes []models.BlockChange
 
 	// Track which blocks we've seen in the new version
 	seenBlocks := make(map[string]bool)
 
-	// Check for added or modified blocks
-	for blockID, newBlock := range newBlocks {
-		seenBlocks[blockID] = true
-		
-		if oldBlock, exists := d.previousBlocks[blockID]; exists {
-			// Block exists, check if it's been modified
-			if d.hasBlockChanged(oldBlock, newBlock) {
+	// Old IDs of blocks that only changed in whitespace, by their new IDs
+	renamed := make(map[string]string)
+	if d.IgnoreWhitespace {
+		renamed = d.whitespaceRenames(newBlocks)
+	}
+	oldID := func(blockID string) string {
+		if id, ok := renamed[blockID]; ok {
+			return id
+		}
+		return blockID
+	}
+
+	oldOrder := orderedIDs(d.previousBlocks)
+	newOrder := orderedIDs(newBlocks)
+	matchedOrder := make([]string, len(newOrder))
+	for i, blockID := range newOrder {
+		matchedOrder[i] = oldID(blockID)
+	}
+	stayed := unmoved(oldOrder, matchedOrder)
+
+	// Check for added, moved, or modified blocks
+	for _, blockID := range newOrder {
+		newBlock := newBlocks[blockID]
+		seenBlocks[oldID(blockID)] = true
+
+		if oldBlock, exists := d.previousBlocks[oldID(blockID)]; exists {
+			if !stayed[oldID(blockID)] {
+				oldIndex, newIndex := oldBlock.Index, newBlock.Index
+				changes = append(changes, models.BlockChange{
+					Type:     "moved",
+					BlockID:  blockID,
+					Block:    newBlock,
+					OldIndex: &oldIndex,
+					NewIndex: &newIndex,
+				})
+			} else if d.hasBlockChanged(oldBlock, newBlock) {
+				// Block exists, check if it's been modified
 				changes = append(changes, models.BlockChange{
 					Type:    "modified",
 					BlockID: blockID,
@@ -51,7 +93,8 @@ func (d *BlockDiffer) ComputeDiff(newBlocks map[string]*models.Block) []models.B
 	}
 
 	// Check for removed blocks
-	for blockID, oldBlock := range d.previousBlocks {
+	for _, blockID := range oldOrder {
+		oldBlock := d.previousBlocks[blockID]
 		if !seenBlocks[blockID] {
 			changes = append(changes, models.BlockChange{
 				Type:    "removed",
@@ -77,11 +120,15 @@ func (d *BlockDiffer) hasBlockChanged(oldBlock, newBlock *models.Block) bool {
 }
 
 // computeBlockHash computes a hash for a block to detect changes
-func (d *BlockDiffer) computeBlockHash(block *models.Block) string {
+func (d *BlockDiffer) computeBlockHash(block *models.Block) uint64 {
+	// Rendering follows the content, apart from hard line breaks made of
+	// trailing spaces, so it is left out when whitespace is ignored
+	if d.IgnoreWhitespace {
+		return hashing.Sum64(block.Type, NormalizeWhitespace(block.Content), strconv.Itoa(block.Level))
+	}
+
 	// Create hash based on block content, type, and level
-	content := fmt.Sprintf("%s|%s|%d|%s", block.Type, block.Content, block.Level, block.HTML)
-	hash := md5.Sum([]byte(content))
-	return fmt.Sprintf("%x", hash)
+	return hashing.Sum64(block.Type, block.Content, strconv.Itoa(block.Level), block.HTML)
 }
 
 // copyBlocks creates a deep copy of the blocks map
@@ -106,6 +153,8 @@ func (d *BlockDiffer) copyBlock(block *models.Block) *models.Block {
 		Content:  block.Content,
 		HTML:     block.HTML,
 		Position: block.Position,
+		ParentID: block.ParentID,
+		Index:    block.Index,
 	}
 
 	// Copy children if they exist
@@ -120,15 +169,20 @@ func (d *BlockDiffer) copyBlock(block *models.Block) *models.Block {
 }
 
 // LineDiffer handles line-by-line diff operations for fine-grained updates
-type LineDiffer struct{}
+type LineDiffer struct {
+	// IgnoreWhitespace compares lines by their whitespace-normalized form,
+	// see NormalizeWhitespace; changes still carry the lines as written
+	IgnoreWhitespace bool
+}
 
 // NewLineDiffer creates a new line differ
 func NewLineDiffer() *LineDiffer {
 	return &LineDiffer{}
 }
 
-// ComputeLineDiff computes line-by-line differences
-func (ld *LineDiffer) ComputeLineDiff(oldContent, newContent string) []LineChange {
+// ComputeLineDiff computes line-by-line differences, failing with
+// ErrTooLarge if more than MaxItems lines of either version were edited
+func (ld *LineDiffer) ComputeLineDiff(oldContent, newContent string) ([]LineChange, error) {
 	oldLines := strings.Split(oldContent, "\n")
 	newLines := strings.Split(newContent, "\n")
 
@@ -136,67 +190,71 @@ func (ld *LineDiffer) ComputeLineDiff(oldContent, newContent string) []LineChang
 }
 
 // LineChange represents a change in a line
-type LineChange struct {
-	Type    string `json:"type"`    // added, removed, unchanged
-	LineNum int    `json:"lineNum"`
-	Content string `json:"content"`
-}
+type LineChange = models.LineChange
 
 // computeLCS computes the Longest Common Subsequence for diff
-func (ld *LineDiffer) computeLCS(oldLines, newLines []string) []LineChange {
-	oldLen := len(oldLines)
-	newLen := len(newLines)
-	
-	// Dynamic programming table for LCS
-	dp := make([][]int, oldLen+1)
-	for i := range dp {
-		dp[i] = make([]int, newLen+1)
-	}
-
-	// Fill the LCS table
-	for i := 1; i <= oldLen; i++ {
-		for j := 1; j <= newLen; j++ {
-			if oldLines[i-1] == newLines[j-1] {
-				dp[i][j] = dp[i-1][j-1] + 1
-			} else {
-				dp[i][j] = max(dp[i-1][j], dp[i][j-1])
-			}
+func (ld *LineDiffer) computeLCS(oldLines, newLines []string) ([]LineChange, error) {
+	// Lines as compared
+	oldKeys, newKeys := oldLines, newLines
+	if ld.IgnoreWhitespace {
+		oldKeys, newKeys = normalizeLines(oldLines), normalizeLines(newLines)
+	}
+
+	if err := checkEdited(oldKeys, newKeys, "lines"); err != nil {
+		return nil, err
+	}
+
+	lineNum := func(i int) int { return i + 1 }
+	return diffSequences(oldLines, newLines, oldKeys, newKeys, lineNum, lineNum), nil
+}
+
+// diffSequences computes the changes between two sequences of lines or words
+// from their Longest Common Subsequence, comparing items by their keys and
+// numbering each change with the line its item is on
+func diffSequences(oldItems, newItems, oldKeys, newKeys []string, oldNum, newNum func(int) int) []LineChange {
+	matches := matchItems(oldKeys, newKeys)
+	matched := make([]bool, len(newItems))
+	for _, j := range matches {
+		if j >= 0 {
+			matched[j] = true
 		}
 	}
 
-	// Backtrack to find changes
-	var changes []LineChange
-	i, j := oldLen, newLen
-	
+	// Walk back from the end, so each gap between matched items lists its
+	// removed items before its added ones once reversed
+	changes := make([]LineChange, 0, max(len(oldItems), len(newItems)))
+	i, j := len(oldItems), len(newItems)
 	for i > 0 || j > 0 {
-		if i > 0 && j > 0 && oldLines[i-1] == newLines[j-1] {
-			// Lines are the same
-			changes = append([]LineChange{{
+		switch {
+		case i > 0 && matches[i-1] >= 0 && matches[i-1] == j-1:
+			// Items are the same
+			changes = append(changes, LineChange{
 				Type:    "unchanged",
-				LineNum: j,
-				Content: newLines[j-1],
-			}}, changes...)
+				LineNum: newNum(j - 1),
+				Content: newItems[j-1],
+			})
 			i--
 			j--
-		} else if j > 0 && (i == 0 || dp[i][j-1] >= dp[i-1][j]) {
-			// Line was added
-			changes = append([]LineChange{{
+		case j > 0 && !matched[j-1]:
+			// Item was added
+			changes = append(changes, LineChange{
 				Type:    "added",
-				LineNum: j,
-				Content: newLines[j-1],
-			}}, changes...)
+				LineNum: newNum(j - 1),
+				Content: newItems[j-1],
+			})
 			j--
-		} else if i > 0 {
-			// Line was removed
-			changes = append([]LineChange{{
+		default:
+			// Item was removed
+			changes = append(changes, LineChange{
 				Type:    "removed",
-				LineNum: i,
-				Content: oldLines[i-1],
-			}}, changes...)
+				LineNum: oldNum(i - 1),
+				Content: oldItems[i-1],
+			})
 			i--
 		}
 	}
 
+	slices.Reverse(changes)
 	return changes
 }
 
@@ -0,0 +1,147 @@
+package diff
+
+import (
+	"regexp"
+	"strings"
+
+	"markdown-parser/internal/models"
+)
+
+// Diff granularities supported by Differ
+const (
+	GranularityBlock = "block" // Blocks added, modified, moved, and removed
+	GranularityLine  = "line"  // Lines added and removed
+	GranularityWord  = "word"  // Words and the whitespace between them added and removed
+)
+
+// ValidGranularity reports whether granularity names a diff granularity; the
+// empty string selects the default, GranularityBlock
+func ValidGranularity(granularity string) bool {
+	switch granularity {
+	case "", GranularityBlock, GranularityLine, GranularityWord:
+		return true
+	}
+	return false
+}
+
+// tokenPattern splits text into words and the runs of whitespace between them
+var tokenPattern = regexp.MustCompile(`\s+|\S+`)
+
+// WordDiffer handles word-by-word diff operations for inline changes
+type WordDiffer struct {
+	// IgnoreWhitespace compares all whitespace within a line as equal, and
+	// ignores trailing whitespace and line endings, see NormalizeWhitespace
+	IgnoreWhitespace bool
+}
+
+// NewWordDiffer creates a new word differ
+func NewWordDiffer() *WordDiffer {
+	return &WordDiffer{}
+}
+
+// ComputeWordDiff computes word-by-word differences. Each change holds one
+// word or run of whitespace, numbered with the line it starts on. It fails
+// with ErrTooLarge if more than MaxItems of them were edited in either version.
+func (wd *WordDiffer) ComputeWordDiff(oldContent, newContent string) ([]LineChange, error) {
+	if wd.IgnoreWhitespace {
+		oldContent, newContent = NormalizeWhitespace(oldContent), NormalizeWhitespace(newContent)
+	}
+	oldWords, oldLines := tokenize(oldContent)
+	newWords, newLines := tokenize(newContent)
+
+	oldKeys, newKeys := oldWords, newWords
+	if wd.IgnoreWhitespace {
+		oldKeys, newKeys = whitespaceKeys(oldWords), whitespaceKeys(newWords)
+	}
+
+	if err := checkEdited(oldKeys, newKeys, "words"); err != nil {
+		return nil, err
+	}
+
+	return diffSequences(oldWords, newWords, oldKeys, newKeys,
+		func(i int) int { return oldLines[i] },
+		func(i int) int { return newLines[i] }), nil
+}
+
+// tokenize splits text into words and whitespace, with the line each starts on
+func tokenize(text string) ([]string, []int) {
+	tokens := tokenPattern.FindAllString(text, -1)
+	lines := make([]int, len(tokens))
+	line := 1
+	for i, token := range tokens {
+		lines[i] = line
+		line += strings.Count(token, "\n")
+	}
+	return tokens, lines
+}
+
+// whitespaceKeys compares whitespace tokens only by the line breaks they hold
+func whitespaceKeys(tokens []string) []string {
+	keys := make([]string, len(tokens))
+	for i, token := range tokens {
+		keys[i] = token
+		if strings.TrimSpace(token) == "" {
+			keys[i] = " " + strings.Repeat("\n", strings.Count(token, "\n"))
+		}
+	}
+	return keys
+}
+
+// Changes holds the changes between two versions of a document at one
+// granularity; the fields of the other granularities are nil
+type Changes struct {
+	Blocks []models.BlockChange
+	Lines  []LineChange
+	Words  []LineChange
+}
+
+// Differ computes the changes between successive versions of a document at
+// any granularity, keeping the blocks and content of the previous version
+type Differ struct {
+	blocks  *BlockDiffer
+	lines   *LineDiffer
+	words   *WordDiffer
+	content string
+}
+
+// NewDiffer creates a differ whose previous version is the empty document
+func NewDiffer() *Differ {
+	return &Differ{
+		blocks: NewBlockDiffer(),
+		lines:  NewLineDiffer(),
+		words:  NewWordDiffer(),
+	}
+}
+
+// SetIgnoreWhitespace makes the differ ignore cosmetic whitespace changes at
+// every granularity, see NormalizeWhitespace
+func (d *Differ) SetIgnoreWhitespace(ignore bool) {
+	d.blocks.IgnoreWhitespace = ignore
+	d.lines.IgnoreWhitespace = ignore
+	d.words.IgnoreWhitespace = ignore
+}
+
+// Diff computes the changes from the previous version to the given content
+// and blocks at the given granularity, and makes them the previous version.
+// Blocks are tracked whatever the granularity, so it can vary between calls.
+// If the changes are too large to compute, the previous version is kept.
+func (d *Differ) Diff(granularity, content string, blocks map[string]*models.Block) (Changes, error) {
+	var changes Changes
+	var err error
+	switch granularity {
+	case GranularityLine:
+		changes.Lines, err = d.lines.ComputeLineDiff(d.content, content)
+	case GranularityWord:
+		changes.Words, err = d.words.ComputeWordDiff(d.content, content)
+	}
+	if err != nil {
+		return Changes{}, err
+	}
+
+	blockChanges := d.blocks.ComputeDiff(blocks)
+	if granularity != GranularityLine && granularity != GranularityWord {
+		changes.Blocks = blockChanges
+	}
+	d.content = content
+	return changes, nil
+}
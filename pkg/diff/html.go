@@ -0,0 +1,139 @@
+package diff
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Diff HTML layouts supported by RenderHTML
+const (
+	RenderUnified    = "unified"      // One column, removed lines before added ones
+	RenderSideBySide = "side-by-side" // Old and new versions in adjacent columns
+)
+
+// numberedLine is a line change with its line numbers in both versions,
+// 0 where the line is absent from a version
+type numberedLine struct {
+	LineChange
+	oldNum, newNum int
+}
+
+// numberLines assigns old and new line numbers to a line diff
+func numberLines(changes []LineChange) []numberedLine {
+	lines := make([]numberedLine, len(changes))
+	oldNum, newNum := 0, 0
+	for i, change := range changes {
+		lines[i].LineChange = change
+		if change.Type != "added" {
+			oldNum++
+			lines[i].oldNum = oldNum
+		}
+		if change.Type != "removed" {
+			newNum++
+			lines[i].newNum = newNum
+		}
+	}
+	return lines
+}
+
+// RenderHTML renders a line diff as an HTML table in the given layout, with
+// rows and cells classed diff-added, diff-removed, diff-unchanged, and
+// diff-empty for styling. It reports false for an unknown layout.
+func RenderHTML(changes []LineChange, layout string) (string, bool) {
+	switch layout {
+	case RenderUnified:
+		return renderUnified(numberLines(changes)), true
+	case RenderSideBySide:
+		return renderSideBySide(numberLines(changes)), true
+	}
+	return "", false
+}
+
+// renderUnified renders one row per line, with both line numbers and a +/- sign
+func renderUnified(lines []numberedLine) string {
+	var b strings.Builder
+	b.WriteString(`<table class="diff diff-unified">` + "\n")
+	for _, line := range lines {
+		sign := " "
+		switch line.Type {
+		case "added":
+			sign = "+"
+		case "removed":
+			sign = "-"
+		}
+		fmt.Fprintf(&b, `<tr class="diff-%s"><td class="diff-line-num">%s</td><td class="diff-line-num">%s</td><td class="diff-sign">%s</td><td class="diff-text">%s</td></tr>`+"\n",
+			line.Type, lineNum(line.oldNum), lineNum(line.newNum), sign, html.EscapeString(line.Content))
+	}
+	b.WriteString("</table>\n")
+	return b.String()
+}
+
+// renderSideBySide renders old lines on the left and new lines on the right,
+// pairing each run of removed lines with the run of added lines after it
+func renderSideBySide(lines []numberedLine) string {
+	var b strings.Builder
+	b.WriteString(`<table class="diff diff-side-by-side">` + "\n")
+	for i := 0; i < len(lines); {
+		if lines[i].Type == "unchanged" {
+			writeSideBySideRow(&b, &lines[i], &lines[i])
+			i++
+			continue
+		}
+
+		var removed, added []*numberedLine
+		for ; i < len(lines) && lines[i].Type == "removed"; i++ {
+			removed = append(removed, &lines[i])
+		}
+		for ; i < len(lines) && lines[i].Type == "added"; i++ {
+			added = append(added, &lines[i])
+		}
+		for j := 0; j < len(removed) || j < len(added); j++ {
+			var old, new *numberedLine
+			if j < len(removed) {
+				old = removed[j]
+			}
+			if j < len(added) {
+				new = added[j]
+			}
+			writeSideBySideRow(&b, old, new)
+		}
+	}
+	b.WriteString("</table>\n")
+	return b.String()
+}
+
+// writeSideBySideRow writes a row with an old line and a new line, either of
+// which may be missing
+func writeSideBySideRow(b *strings.Builder, old, new *numberedLine) {
+	b.WriteString("<tr>")
+	if old != nil {
+		writeSide(b, old, old.oldNum)
+	} else {
+		writeSide(b, nil, 0)
+	}
+	if new != nil {
+		writeSide(b, new, new.newNum)
+	} else {
+		writeSide(b, nil, 0)
+	}
+	b.WriteString("</tr>\n")
+}
+
+// writeSide writes the line number and text cells of one side of a row
+func writeSide(b *strings.Builder, line *numberedLine, num int) {
+	if line == nil {
+		b.WriteString(`<td class="diff-line-num diff-empty"></td><td class="diff-text diff-empty"></td>`)
+		return
+	}
+	fmt.Fprintf(b, `<td class="diff-line-num diff-%s">%d</td><td class="diff-text diff-%s">%s</td>`,
+		line.Type, num, line.Type, html.EscapeString(line.Content))
+}
+
+// lineNum formats a line number for a cell, leaving absent lines blank
+func lineNum(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return fmt.Sprint(n)
+}
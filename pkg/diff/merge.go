@@ -0,0 +1,81 @@
+package diff
+
+import "fmt"
+
+// MergeRegion is a stretch of a three-way merge: either items both sides
+// agree on, or a conflict between incompatible changes to the same items
+type MergeRegion struct {
+	Merged   []string // Resolved items, when not a conflict
+	Conflict bool
+	Base     []string // Conflicting items of each version
+	Ours     []string
+	Theirs   []string
+}
+
+// Merge3 merges two edited versions of a sequence with their common base,
+// diff3 style: items unchanged on one side take the other side's changes,
+// identical changes on both sides are taken once, and differing changes to
+// the same stretch of the base are reported as a conflict. Merges of more
+// than MaxItems items in any version fail with ErrTooLarge.
+func Merge3(base, ours, theirs []string) ([]MergeRegion, error) {
+	if n := max(len(base), max(len(ours), len(theirs))); n > MaxItems {
+		return nil, fmt.Errorf("%w: %d items exceeds the limit of %d", ErrTooLarge, n, MaxItems)
+	}
+	oursMatch := matchItems(base, ours)
+	theirsMatch := matchItems(base, theirs)
+
+	var regions []MergeRegion
+	resolved := func(items []string) {
+		if len(items) == 0 {
+			return
+		}
+		if n := len(regions); n > 0 && !regions[n-1].Conflict {
+			regions[n-1].Merged = append(regions[n-1].Merged, items...)
+			return
+		}
+		regions = append(regions, MergeRegion{Merged: append([]string(nil), items...)})
+	}
+
+	b, o, t := 0, 0, 0
+	for b < len(base) || o < len(ours) || t < len(theirs) {
+		// The next base item kept by both sides ends the unstable stretch
+		next := b
+		for next < len(base) && (oursMatch[next] < 0 || theirsMatch[next] < 0) {
+			next++
+		}
+		oEnd, tEnd := len(ours), len(theirs)
+		if next < len(base) {
+			oEnd, tEnd = oursMatch[next], theirsMatch[next]
+		}
+
+		baseSeg, oursSeg, theirsSeg := base[b:next], ours[o:oEnd], theirs[t:tEnd]
+		switch {
+		case equalItems(oursSeg, baseSeg):
+			resolved(theirsSeg)
+		case equalItems(theirsSeg, baseSeg), equalItems(oursSeg, theirsSeg):
+			resolved(oursSeg)
+		default:
+			regions = append(regions, MergeRegion{Conflict: true, Base: baseSeg, Ours: oursSeg, Theirs: theirsSeg})
+		}
+
+		if next == len(base) {
+			break
+		}
+		resolved(base[next : next+1])
+		b, o, t = next+1, oEnd+1, tEnd+1
+	}
+	return regions, nil
+}
+
+// equalItems reports whether two sequences hold the same items
+func equalItems(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,160 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MaxItems is the most lines, or words and runs of whitespace, either side
+// of a merge, or of the edited region of a diff, may hold. Diffs take time
+// proportional to the items times the number of changes, so larger ones
+// fail with ErrTooLarge.
+const MaxItems = 10000
+
+// ErrTooLarge is returned for a diff or merge of more than MaxItems items
+var ErrTooLarge = errors.New("too large to diff")
+
+// matchItems returns, for each item of a, the index of the item of b it is
+// paired with in a longest common subsequence, or -1. It follows Myers'
+// linear space refinement, splitting both sequences at the middle of a
+// shortest edit path and matching each half in turn, so memory stays
+// proportional to the length of the sequences.
+func matchItems(a, b []string) []int {
+	matches := make([]int, len(a))
+	for i := range matches {
+		matches[i] = -1
+	}
+	m := &matcher{a: a, b: b, matches: matches}
+	m.match(0, len(a), 0, len(b))
+	return matches
+}
+
+// checkEdited fails with ErrTooLarge if the items between the start and end
+// two sequences have in common are more than MaxItems on either side
+func checkEdited(a, b []string, unit string) error {
+	n, k := len(a), len(b)
+	for n > 0 && k > 0 && a[n-1] == b[k-1] {
+		n--
+		k--
+	}
+	start := 0
+	for start < n && start < k && a[start] == b[start] {
+		start++
+	}
+	if edited := max(n, k) - start; edited > MaxItems {
+		return fmt.Errorf("%w: %d edited %s exceeds the limit of %d", ErrTooLarge, edited, unit, MaxItems)
+	}
+	return nil
+}
+
+// matcher pairs up the items of two sequences, see matchItems
+type matcher struct {
+	a, b    []string
+	matches []int
+}
+
+// match pairs up the items of a[aLo:aHi] and b[bLo:bHi]
+func (m *matcher) match(aLo, aHi, bLo, bHi int) {
+	// Pair the common end before the common start, so items added after
+	// one that repeats an earlier item are reported ahead of it
+	for aLo < aHi && bLo < bHi && m.a[aHi-1] == m.b[bHi-1] {
+		aHi--
+		bHi--
+		m.matches[aHi] = bHi
+	}
+	for aLo < aHi && bLo < bHi && m.a[aLo] == m.b[bLo] {
+		m.matches[aLo] = bLo
+		aLo++
+		bLo++
+	}
+	if aLo == aHi || bLo == bHi {
+		return
+	}
+
+	x, y, ok := m.split(aLo, aHi, bLo, bHi)
+	if !ok {
+		return // Nothing in common
+	}
+	m.match(aLo, x, bLo, y)
+	m.match(x, aHi, y, bHi)
+}
+
+// split finds where a shortest edit path from the start of a[aLo:aHi] and
+// b[bLo:bHi] to their ends crosses its middle, searching from both ends at
+// once; ok is false if the sequences have no item in common
+func (m *matcher) split(aLo, aHi, bLo, bHi int) (x, y int, ok bool) {
+	n, k := aHi-aLo, bHi-bLo
+	maxD := (n + k + 1) / 2
+	offset := maxD
+	// forward[offset+d] is the furthest x reached from the start on diagonal
+	// d (x - y), backward the same from the end
+	forward := make([]int, 2*maxD+2)
+	backward := make([]int, 2*maxD+2)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - k
+	odd := delta%2 != 0
+	var fStart, fEnd, bStart, bEnd int
+	for d := 0; d < maxD; d++ {
+		for diag := -d + fStart; diag <= d-fEnd; diag += 2 {
+			i := offset + diag
+			var x1 int
+			if diag == -d || (diag != d && forward[i-1] < forward[i+1]) {
+				x1 = forward[i+1]
+			} else {
+				x1 = forward[i-1] + 1
+			}
+			y1 := x1 - diag
+			for x1 < n && y1 < k && m.a[aLo+x1] == m.b[bLo+y1] {
+				x1++
+				y1++
+			}
+			forward[i] = x1
+			switch {
+			case x1 > n:
+				fEnd += 2 // Off the right of the grid
+			case y1 > k:
+				fStart += 2 // Off the bottom of the grid
+			case odd:
+				j := offset + delta - diag
+				if j >= 0 && j < len(backward) && backward[j] != -1 && x1 >= n-backward[j] {
+					return aLo + x1, bLo + y1, true
+				}
+			}
+		}
+
+		for diag := -d + bStart; diag <= d-bEnd; diag += 2 {
+			i := offset + diag
+			var x2 int
+			if diag == -d || (diag != d && backward[i-1] < backward[i+1]) {
+				x2 = backward[i+1]
+			} else {
+				x2 = backward[i-1] + 1
+			}
+			y2 := x2 - diag
+			for x2 < n && y2 < k && m.a[aHi-x2-1] == m.b[bHi-y2-1] {
+				x2++
+				y2++
+			}
+			backward[i] = x2
+			switch {
+			case x2 > n:
+				bEnd += 2
+			case y2 > k:
+				bStart += 2
+			case !odd:
+				j := offset + delta - diag
+				if j >= 0 && j < len(forward) && forward[j] != -1 {
+					x1 := forward[j]
+					if x1 >= n-x2 {
+						return aLo + x1, bLo + x1 - (j - offset), true
+					}
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
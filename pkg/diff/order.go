@@ -0,0 +1,67 @@
+package diff
+
+import (
+	"sort"
+
+	"markdown-parser/internal/models"
+)
+
+// orderedIDs returns the IDs of blocks sorted by their Index in the document
+func orderedIDs(blocks map[string]*models.Block) []string {
+	ids := make([]string, 0, len(blocks))
+	for id := range blocks {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := blocks[ids[i]], blocks[ids[j]]
+		if a.Index != b.Index {
+			return a.Index < b.Index
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// unmoved returns the IDs common to both orders that keep their relative
+// order, as the longest such subsequence. Since block IDs are unique, this is
+// the longest increasing run of old positions taken in new order, found with
+// patience sorting in O(n log n). Every other common ID has moved.
+func unmoved(oldOrder, newOrder []string) map[string]bool {
+	oldPos := make(map[string]int, len(oldOrder))
+	for i, id := range oldOrder {
+		oldPos[id] = i
+	}
+
+	// piles[k] is the index into common of the smallest tail of an increasing
+	// run of length k+1; prev links each entry to its predecessor in that run
+	var common []string
+	var piles, prev []int
+	for _, id := range newOrder {
+		pos, ok := oldPos[id]
+		if !ok {
+			continue
+		}
+		k := sort.Search(len(piles), func(k int) bool {
+			return oldPos[common[piles[k]]] >= pos
+		})
+		link := -1
+		if k > 0 {
+			link = piles[k-1]
+		}
+		common = append(common, id)
+		prev = append(prev, link)
+		if k == len(piles) {
+			piles = append(piles, len(common)-1)
+		} else {
+			piles[k] = len(common) - 1
+		}
+	}
+
+	stayed := make(map[string]bool, len(piles))
+	if len(piles) > 0 {
+		for i := piles[len(piles)-1]; i >= 0; i = prev[i] {
+			stayed[common[i]] = true
+		}
+	}
+	return stayed
+}
@@ -0,0 +1,33 @@
+package diff
+
+import (
+	"strings"
+
+	"markdown-parser/internal/models"
+)
+
+// pointerEscaper escapes a JSON Pointer reference token (RFC 6901)
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// JSONPatch expresses block changes as RFC 6902 operations on a document of
+// the form {"blocks": {<id>: <block>}}: added blocks are added, modified and
+// moved blocks are replaced with their current version, and removed blocks
+// are removed
+func JSONPatch(changes []models.BlockChange) []models.PatchOperation {
+	ops := make([]models.PatchOperation, 0, len(changes))
+	for _, change := range changes {
+		op := models.PatchOperation{Path: "/blocks/" + pointerEscaper.Replace(change.BlockID)}
+		switch change.Type {
+		case "added":
+			op.Op, op.Value = "add", change.Block
+		case "modified", "moved":
+			op.Op, op.Value = "replace", change.Block
+		case "removed":
+			op.Op = "remove"
+		default:
+			continue
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
@@ -0,0 +1,66 @@
+package diff
+
+import (
+	"regexp"
+	"strings"
+
+	"markdown-parser/internal/models"
+)
+
+var (
+	// lineEndingPattern matches CRLF and lone CR line endings
+	lineEndingPattern = regexp.MustCompile(`\r\n?`)
+
+	// spacingPattern matches runs of spaces and tabs after a non-blank character
+	spacingPattern = regexp.MustCompile(`(\S)[ \t]+`)
+)
+
+// NormalizeWhitespace reduces text to the form compared when whitespace is
+// ignored: line endings become \n, trailing whitespace is dropped from each
+// line, and runs of spaces and tabs between words become a single space.
+// Leading indentation is kept, since it changes the meaning of markdown.
+func NormalizeWhitespace(text string) string {
+	lines := strings.Split(lineEndingPattern.ReplaceAllString(text, "\n"), "\n")
+	return strings.Join(normalizeLines(lines), "\n")
+}
+
+// normalizeLines normalizes the whitespace of each line, see NormalizeWhitespace
+func normalizeLines(lines []string) []string {
+	normalized := make([]string, len(lines))
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		normalized[i] = spacingPattern.ReplaceAllString(line, "$1 ")
+	}
+	return normalized
+}
+
+// whitespaceRenames matches blocks that are new by ID to previous blocks that
+// are gone, when they only differ in whitespace, and returns the old ID of
+// each matched block by its new ID
+func (d *BlockDiffer) whitespaceRenames(newBlocks map[string]*models.Block) map[string]string {
+	key := func(block *models.Block) string {
+		return block.Type + "\x00" + NormalizeWhitespace(block.Content)
+	}
+
+	// Candidates in document order, so repeated blocks pair up in order
+	gone := make(map[string][]string)
+	for _, id := range orderedIDs(d.previousBlocks) {
+		if _, ok := newBlocks[id]; !ok {
+			k := key(d.previousBlocks[id])
+			gone[k] = append(gone[k], id)
+		}
+	}
+
+	renamed := make(map[string]string)
+	for _, id := range orderedIDs(newBlocks) {
+		if _, ok := d.previousBlocks[id]; ok {
+			continue
+		}
+		k := key(newBlocks[id])
+		if candidates := gone[k]; len(candidates) > 0 {
+			renamed[id] = candidates[0]
+			gone[k] = candidates[1:]
+		}
+	}
+	return renamed
+}
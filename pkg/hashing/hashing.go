@@ -0,0 +1,58 @@
+package hashing
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+)
+
+// Hasher computes 64-bit fingerprints for block IDs and change detection.
+// Fingerprints only need to tell blocks apart, not resist attackers, so
+// implementations need not be cryptographic.
+type Hasher interface {
+	Sum64(parts ...string) uint64
+}
+
+// Default is the hasher used by the parser and differ
+var Default Hasher = FNV{}
+
+// Sum64 fingerprints parts with the Default hasher
+func Sum64(parts ...string) uint64 {
+	return Default.Sum64(parts...)
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// FNV hashes with 64-bit FNV-1a. It allocates nothing and is several times
+// faster than MD5 on the short inputs blocks produce.
+type FNV struct{}
+
+// Sum64 implements Hasher. Parts are separated so ("ab", "c") and ("a", "bc") differ.
+func (FNV) Sum64(parts ...string) uint64 {
+	hash := uint64(fnvOffset64)
+	for _, part := range parts {
+		for i := 0; i < len(part); i++ {
+			hash ^= uint64(part[i])
+			hash *= fnvPrime64
+		}
+		hash ^= 0xff
+		hash *= fnvPrime64
+	}
+	return hash
+}
+
+// MD5 hashes with the first eight bytes of MD5, the previous fingerprint;
+// it is kept so benchmarks can compare against it
+type MD5 struct{}
+
+// Sum64 implements Hasher
+func (MD5) Sum64(parts ...string) uint64 {
+	h := md5.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0xff})
+	}
+	return binary.BigEndian.Uint64(h.Sum(nil))
+}
@@ -0,0 +1,139 @@
+// Package markdown is the service's parser as a Go library: it parses
+// markdown into sanitized HTML and content-addressed blocks, or into the
+// other output formats the service offers, so other services can embed the
+// same parsing without an HTTP hop.
+//
+//	result, err := markdown.Parse("# Hello", nil)
+//	fmt.Print(result.HTML)
+package markdown
+
+import (
+	"sync"
+
+	"markdown-parser/configs"
+	"markdown-parser/internal/models"
+	"markdown-parser/internal/parser"
+	"markdown-parser/pkg/diff"
+)
+
+// Config is the parser's configuration, the parser section of the
+// service's config file
+type Config = configs.ParserConfig
+
+// Result is the outcome of a parse: the HTML, the blocks by ID and in
+// document order, and the output of the requested format
+type Result = models.ParseResponse
+
+// Block is a top-level element of a document, identified by a hash of its
+// content and position
+type Block = models.Block
+
+// BlockChange is a block added, modified, moved, or removed between two
+// parses, see Diff
+type BlockChange = models.BlockChange
+
+// Edit replaces the bytes from Start to End of parsed content with Text
+type Edit = models.Edit
+
+// Output formats, set in Options.Format, produced in Result.Output, or in
+// Result.AST for FormatAST
+const (
+	FormatText     = parser.FormatText
+	FormatAST      = parser.FormatAST
+	FormatMarkdown = parser.FormatMarkdown
+	FormatSlack    = parser.FormatSlack
+	FormatJira     = parser.FormatJira
+	FormatSlides   = parser.FormatSlides
+	FormatEmail    = parser.FormatEmail
+)
+
+// Errors a parse may fail with, to be checked with errors.Is
+var (
+	ErrContentTooLarge = parser.ErrContentTooLarge // Content over Config.MaxContentSize
+	ErrInvalidText     = parser.ErrInvalidText     // Content that is not valid UTF-8, or an edit that breaks it
+)
+
+// Options adjust a single parse; the zero value uses the configuration
+type Options struct {
+	Dialect        string // commonmark, gfm, or notion
+	SanitizePolicy string // none, strict, gfm, or custom
+	HighlightTheme string // Chroma style of fenced code
+	Format         string // Output format besides HTML, see FormatText and the rest
+	LineNumbers    *bool  // Line numbers in highlighted code
+	WrapWidth      *int   // Paragraph wrap width of FormatMarkdown
+
+	// data-sourcepos attributes on rendered blocks
+	SourcePositions *bool
+
+	Stats bool // Add document statistics to the result
+	Lint  bool // Add lint warnings to the result
+}
+
+// parseOptions converts options to the parser's
+func (o *Options) parseOptions() parser.ParseOptions {
+	if o == nil {
+		return parser.ParseOptions{}
+	}
+	return parser.ParseOptions{
+		HighlightTheme:  o.HighlightTheme,
+		LineNumbers:     o.LineNumbers,
+		SanitizePolicy:  o.SanitizePolicy,
+		Dialect:         o.Dialect,
+		Format:          o.Format,
+		WrapWidth:       o.WrapWidth,
+		SourcePositions: o.SourcePositions,
+		Stats:           o.Stats,
+		Lint:            o.Lint,
+	}
+}
+
+// DefaultConfig returns the parser configuration the service defaults to
+func DefaultConfig() Config {
+	return configs.DefaultConfig().Parser
+}
+
+// Parser parses markdown with one configuration, caching recent results.
+// It is safe for concurrent use.
+type Parser struct {
+	parser *parser.MarkdownParser
+}
+
+// New creates a parser with config
+func New(config Config) *Parser {
+	return &Parser{parser: parser.NewMarkdownParserWithConfig(config)}
+}
+
+// Parse parses content; opts may be nil
+func (p *Parser) Parse(content string, opts *Options) (*Result, error) {
+	return p.parser.ParseWithOptions(content, opts.parseOptions())
+}
+
+// ParseIncremental applies an edit to content parsed before, reparsing
+// only the blocks around it
+func (p *Parser) ParseIncremental(content string, edit Edit) (*Result, error) {
+	return p.parser.ParseIncremental(content, edit)
+}
+
+// Format rewrites content as canonical markdown, wrapping paragraphs at
+// width (0 keeps line breaks)
+func (p *Parser) Format(content string, width int) (string, error) {
+	return p.parser.Format(content, width)
+}
+
+// defaultParser is the parser of the package-level functions
+var defaultParser = sync.OnceValue(func() *Parser {
+	return New(DefaultConfig())
+})
+
+// Parse parses content with the default configuration; opts may be nil
+func Parse(content string, opts *Options) (*Result, error) {
+	return defaultParser().Parse(content, opts)
+}
+
+// Diff lists the block changes from one parse result to another, in
+// document order with removed blocks last
+func Diff(from, to *Result) []BlockChange {
+	differ := diff.NewBlockDiffer()
+	differ.ComputeDiff(from.Blocks)
+	return differ.ComputeDiff(to.Blocks)
+}
@@ -0,0 +1,253 @@
+package ot
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"markdown-parser/internal/models"
+)
+
+// Operation builds a text operation component by component, merging
+// adjacent components of the same kind and dropping empty ones
+type Operation struct {
+	components models.TextOperation
+}
+
+// Retain keeps the next n bytes
+func (o *Operation) Retain(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	if last := o.last(); last != nil && last.Retain > 0 {
+		last.Retain += n
+		return o
+	}
+	o.components = append(o.components, models.TextComponent{Retain: n})
+	return o
+}
+
+// Insert inserts text at the current position
+func (o *Operation) Insert(text string) *Operation {
+	if text == "" {
+		return o
+	}
+	last := o.last()
+	if last != nil && last.Insert != "" {
+		last.Insert += text
+		return o
+	}
+	if last != nil && last.Delete > 0 {
+		// Keep inserts before deletes, so equal operations look the same
+		n := len(o.components)
+		if n > 1 && o.components[n-2].Insert != "" {
+			o.components[n-2].Insert += text
+			return o
+		}
+		o.components = append(o.components[:n-1], models.TextComponent{Insert: text}, *last)
+		return o
+	}
+	o.components = append(o.components, models.TextComponent{Insert: text})
+	return o
+}
+
+// Delete deletes the next n bytes
+func (o *Operation) Delete(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+	if last := o.last(); last != nil && last.Delete > 0 {
+		last.Delete += n
+		return o
+	}
+	o.components = append(o.components, models.TextComponent{Delete: n})
+	return o
+}
+
+// Build returns the operation built so far
+func (o *Operation) Build() models.TextOperation {
+	if o.components == nil {
+		return models.TextOperation{}
+	}
+	return o.components
+}
+
+// last returns the last component, or nil
+func (o *Operation) last() *models.TextComponent {
+	if len(o.components) == 0 {
+		return nil
+	}
+	return &o.components[len(o.components)-1]
+}
+
+// Validate checks that every component does exactly one thing
+func Validate(op models.TextOperation) error {
+	for i, c := range op {
+		set := 0
+		if c.Retain != 0 {
+			set++
+		}
+		if c.Insert != "" {
+			set++
+		}
+		if c.Delete != 0 {
+			set++
+		}
+		if set != 1 || c.Retain < 0 || c.Delete < 0 {
+			return fmt.Errorf("component %d must retain, insert, or delete", i)
+		}
+	}
+	return nil
+}
+
+// BaseLength returns the length of the text an operation applies to
+func BaseLength(op models.TextOperation) int {
+	n := 0
+	for _, c := range op {
+		n += c.Retain + c.Delete
+	}
+	return n
+}
+
+// TargetLength returns the length of the text an operation produces
+func TargetLength(op models.TextOperation) int {
+	n := 0
+	for _, c := range op {
+		n += c.Retain + len(c.Insert)
+	}
+	return n
+}
+
+// Apply applies an operation to text, which must be as long as the
+// operation's base and is only split between characters
+func Apply(text string, op models.TextOperation) (string, error) {
+	if err := Validate(op); err != nil {
+		return "", err
+	}
+	if n := BaseLength(op); n != len(text) {
+		return "", fmt.Errorf("operation spans %d bytes, the text has %d", n, len(text))
+	}
+
+	result := make([]byte, 0, TargetLength(op))
+	pos := 0
+	for _, c := range op {
+		if pos < len(text) && !utf8.RuneStart(text[pos]) {
+			return "", fmt.Errorf("operation splits the character at byte %d", pos)
+		}
+		switch {
+		case c.Retain > 0:
+			result = append(result, text[pos:pos+c.Retain]...)
+			pos += c.Retain
+		case c.Delete > 0:
+			pos += c.Delete
+		default:
+			result = append(result, c.Insert...)
+		}
+	}
+	if pos < len(text) && !utf8.RuneStart(text[pos]) {
+		return "", fmt.Errorf("operation splits the character at byte %d", pos)
+	}
+	return string(result), nil
+}
+
+// Transform transforms two concurrent operations on the same text, returning
+// a' and b' such that applying a then b' gives the same text as b then a'.
+// Where both insert at the same position, a's text comes first.
+func Transform(a, b models.TextOperation) (models.TextOperation, models.TextOperation, error) {
+	if err := Validate(a); err != nil {
+		return nil, nil, err
+	}
+	if err := Validate(b); err != nil {
+		return nil, nil, err
+	}
+	if BaseLength(a) != BaseLength(b) {
+		return nil, nil, fmt.Errorf("operations span %d and %d bytes", BaseLength(a), BaseLength(b))
+	}
+
+	var aPrime, bPrime Operation
+	i, j := 0, 0
+	var x, y *models.TextComponent
+	next := func(op models.TextOperation, k *int) *models.TextComponent {
+		if *k >= len(op) {
+			return nil
+		}
+		c := op[*k]
+		*k++
+		return &c
+	}
+	x, y = next(a, &i), next(b, &j)
+	for x != nil || y != nil {
+		if x != nil && x.Insert != "" {
+			aPrime.Insert(x.Insert)
+			bPrime.Retain(len(x.Insert))
+			x = next(a, &i)
+			continue
+		}
+		if y != nil && y.Insert != "" {
+			aPrime.Retain(len(y.Insert))
+			bPrime.Insert(y.Insert)
+			y = next(b, &j)
+			continue
+		}
+
+		// Both retain or delete; equal base lengths keep them in step
+		n := min(x.Retain+x.Delete, y.Retain+y.Delete)
+		switch {
+		case x.Retain > 0 && y.Retain > 0:
+			aPrime.Retain(n)
+			bPrime.Retain(n)
+		case x.Delete > 0 && y.Retain > 0:
+			aPrime.Delete(n)
+		case x.Retain > 0 && y.Delete > 0:
+			bPrime.Delete(n)
+		}
+		// Both deleting the same text leaves nothing to do
+		if x = consume(x, n); x == nil {
+			x = next(a, &i)
+		}
+		if y = consume(y, n); y == nil {
+			y = next(b, &j)
+		}
+	}
+	return aPrime.Build(), bPrime.Build(), nil
+}
+
+// consume shortens a retain or delete by n bytes, returning nil once it is used up
+func consume(c *models.TextComponent, n int) *models.TextComponent {
+	if c.Retain > 0 {
+		c.Retain -= n
+		if c.Retain == 0 {
+			return nil
+		}
+		return c
+	}
+	c.Delete -= n
+	if c.Delete == 0 {
+		return nil
+	}
+	return c
+}
+
+// Invert returns the operation that undoes an operation applied to text
+func Invert(op models.TextOperation, text string) models.TextOperation {
+	var inverse Operation
+	pos := 0
+	for _, c := range op {
+		switch {
+		case c.Retain > 0:
+			inverse.Retain(c.Retain)
+			pos += c.Retain
+		case c.Delete > 0:
+			inverse.Insert(text[pos : pos+c.Delete])
+			pos += c.Delete
+		default:
+			inverse.Delete(len(c.Insert))
+		}
+	}
+	return inverse.Build()
+}
+
+// FromEdit returns the operation that makes an edit to text of the given length
+func FromEdit(length int, edit models.Edit) models.TextOperation {
+	var op Operation
+	return op.Retain(edit.Start).Delete(edit.End - edit.Start).Insert(edit.Text).Retain(length - edit.End).Build()
+}
@@ -0,0 +1,148 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Page layout in points: A4 with one-inch margins
+const (
+	pageWidth  = 595
+	pageHeight = 842
+	margin     = 72
+
+	titleSize  = 18
+	bodySize   = 10
+	lineHeight = 14
+
+	// Courier glyphs are all 600/1000 of the font size wide, so lines
+	// wrap by character count
+	charsPerLine = (pageWidth - 2*margin) * 1000 / (600 * bodySize)
+	linesPerPage = (pageHeight - 2*margin) / lineHeight
+)
+
+// FromText lays out plain text as a PDF: the title in bold on the first
+// page, then the text in a monospaced font, wrapped at word boundaries and
+// broken into pages. Only the standard PDF fonts are used, so characters
+// outside Latin-1 are replaced with '?'.
+func FromText(title, text string) []byte {
+	lines := wrap(text, charsPerLine)
+
+	// The title takes the first page's first three lines
+	var pages [][]string
+	first := linesPerPage
+	if title != "" {
+		first -= 3
+	}
+	for len(lines) > first {
+		pages = append(pages, lines[:first])
+		lines = lines[first:]
+		first = linesPerPage
+	}
+	pages = append(pages, lines)
+
+	w := &writer{}
+	w.object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		// Each page is followed by its content stream
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	w.object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	w.object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	w.object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range pages {
+		var content bytes.Buffer
+		y := pageHeight - margin
+		if i == 0 && title != "" {
+			fmt.Fprintf(&content, "BT /F2 %d Tf %d %d Td (%s) Tj ET\n", titleSize, margin, y-titleSize, escape(title))
+			y -= 3 * lineHeight
+		}
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d TL %d %d Td\n", bodySize, lineHeight, margin, y-bodySize+lineHeight)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) '\n", escape(line))
+		}
+		content.WriteString("ET\n")
+
+		w.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, 6+2*i))
+		w.object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.Bytes()))
+	}
+	return w.finish()
+}
+
+// writer numbers objects from 1 as they are written and records their
+// offsets for the cross-reference table
+type writer struct {
+	buf     bytes.Buffer
+	offsets []int
+}
+
+// object writes the next object
+func (w *writer) object(body string) {
+	if w.buf.Len() == 0 {
+		w.buf.WriteString("%PDF-1.4\n")
+	}
+	w.offsets = append(w.offsets, w.buf.Len())
+	fmt.Fprintf(&w.buf, "%d 0 obj\n%s\nendobj\n", len(w.offsets), body)
+}
+
+// finish writes the cross-reference table and trailer and returns the file
+func (w *writer) finish() []byte {
+	xref := w.buf.Len()
+	fmt.Fprintf(&w.buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.offsets)+1)
+	for _, offset := range w.offsets {
+		fmt.Fprintf(&w.buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&w.buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.offsets)+1, xref)
+	return w.buf.Bytes()
+}
+
+// wrap breaks text into lines of at most width characters, at spaces
+// where it can; tabs become four spaces
+func wrap(text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n") {
+		paragraph = strings.TrimRight(paragraph, " \r")
+		for utf8.RuneCountInString(paragraph) > width {
+			runes := []rune(paragraph)
+			cut := width
+			for i := width; i > 0; i-- {
+				if runes[i] == ' ' {
+					cut = i
+					break
+				}
+			}
+			lines = append(lines, strings.TrimRight(string(runes[:cut]), " "))
+			paragraph = strings.TrimLeft(string(runes[cut:]), " ")
+		}
+		lines = append(lines, paragraph)
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// escape encodes text as the body of a PDF string in WinAnsiEncoding,
+// which matches Latin-1 for the characters kept
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || (r >= 0x7f && r < 0xa0) || r > 0xff:
+			b.WriteByte('?')
+		case r >= 0x80:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -1,9 +1,65 @@
 package tests
 
 import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"encoding/binary"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/gin-gonic/gin"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+	"golang.org/x/net/http2"
+
+	"markdown-parser/configs"
+	"markdown-parser/internal/api"
+	"markdown-parser/internal/audit"
+	"markdown-parser/internal/auth"
+	"markdown-parser/internal/cli"
+	"markdown-parser/internal/comments"
+	"markdown-parser/internal/documents"
+	"markdown-parser/internal/https"
+	"markdown-parser/internal/models"
 	"markdown-parser/internal/parser"
+	"markdown-parser/internal/redis"
+	"markdown-parser/internal/rpc"
+	"markdown-parser/internal/versions"
+	"markdown-parser/internal/websocket"
+	"markdown-parser/internal/workpool"
+	"markdown-parser/pkg/crdt"
+	"markdown-parser/pkg/diff"
+	"markdown-parser/pkg/hashing"
+	"markdown-parser/pkg/markdown"
+	"markdown-parser/pkg/ot"
 )
 
 func TestMarkdownParser_Parse(t *testing.T) {
@@ -161,4 +217,5246 @@ func TestLineParsing(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+func TestTableExtraction(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("| Name | Score |\n|:-----|------:|\n| Ann | *10* |\n| Bob | 7 |")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var table *models.Table
+	for _, block := range result.Blocks {
+		if block.Type == "table" {
+			table = block.Table
+		}
+	}
+
+	if table == nil {
+		t.Fatalf("Parse() returned no table block")
+	}
+
+	if len(table.Alignments) != 2 || table.Alignments[0] != "left" || table.Alignments[1] != "right" {
+		t.Errorf("table alignments = %v, want [left right]", table.Alignments)
+	}
+
+	if len(table.Header) != 2 || table.Header[0].Content != "Name" {
+		t.Errorf("table header = %v, want Name/Score", table.Header)
+	}
+
+	if len(table.Rows) != 2 {
+		t.Fatalf("table rows = %d, want 2", len(table.Rows))
+	}
+
+	if table.Rows[0][1].HTML != "<em>10</em>" {
+		t.Errorf("table cell HTML = %v, want <em>10</em>", table.Rows[0][1].HTML)
+	}
+}
+
+func TestCodeHighlighting(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	input := "```go\nfunc main() {}\n```"
+
+	result, err := p.Parse(input)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if !strings.Contains(result.HTML, "<span style=") {
+		t.Errorf("Parse() HTML = %v, want highlighted code", result.HTML)
+	}
+
+	lineNumbers := true
+	result, err = p.ParseWithOptions(input, parser.ParseOptions{
+		HighlightTheme: "monokai",
+		LineNumbers:    &lineNumbers,
+	})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+
+	if !strings.Contains(result.HTML, "background-color: #272822") {
+		t.Errorf("ParseWithOptions() HTML = %v, want monokai theme", result.HTML)
+	}
+
+	if !strings.Contains(result.HTML, "user-select: none") {
+		t.Errorf("ParseWithOptions() HTML = %v, want line numbers", result.HTML)
+	}
+}
+
+func TestMathExtension(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("Price is $5 and $10, but $x^2$ is math.\n\n$$\na < b\n$$")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if !strings.Contains(result.HTML, `<span class="math math-inline">x^2</span>`) {
+		t.Errorf("Parse() HTML = %v, want inline math span", result.HTML)
+	}
+
+	if strings.Contains(result.HTML, `<span class="math math-inline">5`) {
+		t.Errorf("Parse() HTML = %v, currency should not be math", result.HTML)
+	}
+
+	if !strings.Contains(result.HTML, `<div class="math math-display">a &lt; b`) {
+		t.Errorf("Parse() HTML = %v, want escaped display math", result.HTML)
+	}
+
+	found := false
+	for _, block := range result.Blocks {
+		if block.Type == "math" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Parse() returned no math block")
+	}
+}
+
+func TestFrontmatter(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("---\ntitle: Notes\ntags: [go, markdown]\n---\n# Body")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if result.Frontmatter["title"] != "Notes" {
+		t.Errorf("Parse() frontmatter = %v, want title Notes", result.Frontmatter)
+	}
+
+	if strings.Contains(result.HTML, "title") {
+		t.Errorf("Parse() HTML = %v, frontmatter should be excluded", result.HTML)
+	}
+
+	found := false
+	for _, block := range result.Blocks {
+		if block.Type == "frontmatter" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Parse() returned no frontmatter block")
+	}
+}
+
+func TestWikilinks(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("Read [[Getting Started]] and [[api|the API]].")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if !strings.Contains(result.HTML, `<a class="wikilink" href="/pages/Getting%20Started">Getting Started</a>`) {
+		t.Errorf("Parse() HTML = %v, want wikilink anchor", result.HTML)
+	}
+
+	if result.Links == nil || len(result.Links.Internal) != 2 {
+		t.Fatalf("Parse() links = %v, want 2 internal links", result.Links)
+	}
+
+	if result.Links.Internal[1].Target != "api" || result.Links.Internal[1].Alias != "the API" {
+		t.Errorf("Parse() internal link = %v, want api|the API", result.Links.Internal[1])
+	}
+}
+
+func TestCallouts(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("> [!WARNING] Heads up\n> Back up your data.\n\n> Just a quote")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if !strings.Contains(result.HTML, `<div class="callout callout-warning">`) {
+		t.Errorf("Parse() HTML = %v, want callout div", result.HTML)
+	}
+
+	if strings.Contains(result.HTML, "[!WARNING]") {
+		t.Errorf("Parse() HTML = %v, marker should be stripped", result.HTML)
+	}
+
+	if !strings.Contains(result.HTML, "<blockquote>") {
+		t.Errorf("Parse() HTML = %v, plain quotes should stay blockquotes", result.HTML)
+	}
+
+	var callout *models.Block
+	for _, block := range result.Blocks {
+		if block.Type == "callout" {
+			callout = block
+		}
+	}
+	if callout == nil || callout.Variant != "warning" {
+		t.Errorf("Parse() callout block = %v, want variant warning", callout)
+	}
+}
+
+func TestTaskListCheckboxes(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("- [ ] Write *docs*\n- [x] Ship it\n- Plain item")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	checkboxes := make(map[string]bool)
+	for _, block := range result.Blocks {
+		if block.Type != "checkbox" {
+			continue
+		}
+		if block.Checked == nil {
+			t.Fatalf("checkbox block %v has no checked state", block.Text)
+		}
+		checkboxes[block.Text] = *block.Checked
+	}
+
+	if len(checkboxes) != 2 {
+		t.Fatalf("Parse() checkboxes = %v, want 2", checkboxes)
+	}
+
+	if checked, ok := checkboxes["Write docs"]; !ok || checked {
+		t.Errorf("checkbox %q checked = %v, want unchecked", "Write docs", checked)
+	}
+
+	if checked, ok := checkboxes["Ship it"]; !ok || !checked {
+		t.Errorf("checkbox %q checked = %v, want checked", "Ship it", checked)
+	}
+}
+
+func TestFootnotes(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("Claim[^src] and another[^src].\n\n[^src]: A *reliable* source.")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if result.Footnotes["src"] != "<p>A <em>reliable</em> source.</p>" {
+		t.Errorf("Parse() footnotes = %v, want rendered content without backrefs", result.Footnotes)
+	}
+
+	definitions, references := 0, 0
+	for _, block := range result.Blocks {
+		switch block.Type {
+		case "footnote_definition":
+			definitions++
+		case "footnote_reference":
+			references++
+			if block.Ref != "src" {
+				t.Errorf("footnote reference ref = %v, want src", block.Ref)
+			}
+		}
+	}
+
+	if definitions != 1 || references != 2 {
+		t.Errorf("Parse() footnote blocks = %d definitions, %d references, want 1 and 2", definitions, references)
+	}
+}
+
+func TestDefinitionLists(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("Go\n:   A *language*.\n:   A board game.\n\nRust\n:   Another language.")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var list *models.Block
+	for _, block := range result.Blocks {
+		if block.Type == "definition_list" {
+			list = block
+		}
+	}
+	if list == nil {
+		t.Fatalf("Parse() returned no definition_list block")
+	}
+
+	if len(list.Definitions) != 2 {
+		t.Fatalf("definitions = %v, want 2 terms", list.Definitions)
+	}
+
+	first := list.Definitions[0]
+	if first.Term != "Go" || len(first.Descriptions) != 2 || first.Descriptions[0] != "A language." {
+		t.Errorf("first definition = %v, want Go with 2 descriptions", first)
+	}
+}
+
+func TestImageExtraction(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("Intro ![A cat](cat.png \"Sleepy\") text\n\n![](banner.jpg)")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if len(result.Images) != 2 {
+		t.Fatalf("Parse() images = %v, want 2", result.Images)
+	}
+
+	want := models.Image{Src: "cat.png", Alt: "A cat", Title: "Sleepy"}
+	if result.Images[0] != want {
+		t.Errorf("Parse() first image = %v, want %v", result.Images[0], want)
+	}
+
+	imageBlocks := 0
+	for _, block := range result.Blocks {
+		if block.Type == "image" {
+			imageBlocks++
+			if block.Image == nil || block.Image.Src == "" {
+				t.Errorf("image block %v has no image metadata", block.ID)
+			}
+		}
+	}
+	if imageBlocks != 2 {
+		t.Errorf("Parse() image blocks = %d, want 2", imageBlocks)
+	}
+}
+
+func TestHeadingSlugs(t *testing.T) {
+	config := configs.DefaultConfig().Parser
+	config.HeadingIDPrefix = "sec-"
+	p := parser.NewMarkdownParserWithConfig(config)
+
+	result, err := p.Parse("# Hello, *World*!\n\n## Setup\n\n## Setup")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	for _, want := range []string{`id="sec-hello-world"`, `id="sec-setup"`, `id="sec-setup-1"`} {
+		if !strings.Contains(result.HTML, want) {
+			t.Errorf("Parse() HTML = %v, want %v", result.HTML, want)
+		}
+	}
+
+	slugs := make(map[string]bool)
+	for _, block := range result.Blocks {
+		if block.Level > 0 {
+			slugs[block.Slug] = true
+		}
+	}
+	if !slugs["sec-hello-world"] || !slugs["sec-setup"] || !slugs["sec-setup-1"] {
+		t.Errorf("heading block slugs = %v, want slugs matching the HTML ids", slugs)
+	}
+}
+
+func TestHeadingTextAndSlug(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "# Getting *started* with `go`\n\nIntro\n\n## Getting started with go\n"
+
+	result, err := p.Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var headings []*models.Block
+	for _, block := range result.Tree {
+		if block.Level > 0 {
+			headings = append(headings, block)
+		}
+	}
+	if len(headings) != 2 {
+		t.Fatalf("Parse() headings = %v, want 2", headings)
+	}
+
+	for i, want := range []struct{ text, slug string }{
+		{"Getting started with go", "getting-started-with-go"},
+		{"Getting started with go", "getting-started-with-go-1"},
+	} {
+		if headings[i].Text != want.text || headings[i].Slug != want.slug {
+			t.Errorf("heading %d text = %q, slug = %q, want %q and %q", i, headings[i].Text, headings[i].Slug, want.text, want.slug)
+		}
+		if !strings.Contains(headings[i].HTML, `id="`+want.slug+`"`) {
+			t.Errorf("heading %d HTML = %v, want id %q", i, headings[i].HTML, want.slug)
+		}
+	}
+}
+
+func TestHighlightAndStrikethrough(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("Keep ==this== but ~~not this~~, and a == b stays.")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if !strings.Contains(result.HTML, "<mark>this</mark>") || !strings.Contains(result.HTML, "<del>not this</del>") {
+		t.Errorf("Parse() HTML = %v, want <mark> and <del>", result.HTML)
+	}
+
+	if !strings.Contains(result.HTML, "a == b") {
+		t.Errorf("Parse() HTML = %v, lone == should stay text", result.HTML)
+	}
+
+	for _, block := range result.Blocks {
+		if block.Type != "paragraph" {
+			continue
+		}
+		if len(block.Inlines) != 2 || block.Inlines[0].Type != "highlight" || block.Inlines[1].Type != "strikethrough" {
+			t.Errorf("paragraph inlines = %v, want highlight and strikethrough", block.Inlines)
+		}
+		if block.Inlines[0].Text != "this" || block.Inlines[0].Start != 7 {
+			t.Errorf("highlight span = %v, want text this at offset 7", block.Inlines[0])
+		}
+	}
+}
+
+func TestSubscriptSuperscript(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("H~2~O, E=mc^2^ and ~~struck~~")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	for _, want := range []string{"H<sub>2</sub>O", "mc<sup>2</sup>", "<del>struck</del>"} {
+		if !strings.Contains(result.HTML, want) {
+			t.Errorf("Parse() HTML = %v, want %v", result.HTML, want)
+		}
+	}
+
+	config := configs.DefaultConfig().Parser
+	config.EnableSubscript = false
+	config.EnableSuperscript = false
+	result, err = parser.NewMarkdownParserWithConfig(config).Parse("H~2~O and x^2^")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if strings.Contains(result.HTML, "<sub>") || strings.Contains(result.HTML, "<sup>") {
+		t.Errorf("Parse() HTML = %v, want no sub/sup when disabled", result.HTML)
+	}
+}
+
+func TestCustomContainers(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("::: tip Pro tip\nUse **containers**.\n:::\n\nAfter")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if !strings.Contains(result.HTML, `<div class="container-tip">`) {
+		t.Errorf("Parse() HTML = %v, want container div", result.HTML)
+	}
+
+	if !strings.Contains(result.HTML, `<p class="container-title">Pro tip</p>`) {
+		t.Errorf("Parse() HTML = %v, want container title", result.HTML)
+	}
+
+	if !strings.Contains(result.HTML, "</div>\n<p>After</p>") {
+		t.Errorf("Parse() HTML = %v, want container closed before trailing paragraph", result.HTML)
+	}
+
+	found := false
+	for _, block := range result.Blocks {
+		if block.Type == "container" && block.Name == "tip" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Parse() returned no container block named tip")
+	}
+}
+
+func TestHTMLSanitization(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	source := "<b>bold</b> <script>alert(1)</script>"
+
+	result, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if strings.Contains(result.HTML, "<script>") || !strings.Contains(result.HTML, "<b>bold</b>") {
+		t.Errorf("Parse() HTML = %v, want script stripped and <b> kept", result.HTML)
+	}
+	for _, block := range result.Blocks {
+		if strings.Contains(block.HTML, "<script>") {
+			t.Errorf("block %s HTML = %v, want script stripped", block.ID, block.HTML)
+		}
+	}
+
+	strict, err := p.ParseWithOptions(source, parser.ParseOptions{SanitizePolicy: parser.SanitizeStrict})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	if strings.Contains(strict.HTML, "<") {
+		t.Errorf("ParseWithOptions(strict) HTML = %v, want no markup", strict.HTML)
+	}
+
+	raw, err := p.ParseWithOptions(source, parser.ParseOptions{SanitizePolicy: parser.SanitizeNone})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	if !strings.Contains(raw.HTML, "<script>") {
+		t.Errorf("ParseWithOptions(none) HTML = %v, want raw HTML preserved", raw.HTML)
+	}
+
+	custom := configs.DefaultConfig().Parser
+	custom.SanitizePolicy = parser.SanitizeCustom
+	custom.Sanitize = configs.SanitizeConfig{Elements: []string{"p"}}
+	result, err = parser.NewMarkdownParserWithConfig(custom).Parse(source)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if strings.Contains(result.HTML, "<b>") || !strings.Contains(result.HTML, "<p>") {
+		t.Errorf("Parse() with custom allowlist HTML = %v, want only <p> kept", result.HTML)
+	}
+
+	// Tags named in the attributes are allowed too
+	custom.Sanitize.Attributes = map[string][]string{"b": nil}
+	result, err = parser.NewMarkdownParserWithConfig(custom).Parse(source)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !strings.Contains(result.HTML, "<b>") {
+		t.Errorf("Parse() with custom attributes HTML = %v, want <b> kept", result.HTML)
+	}
+}
+
+func TestDialects(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	input := "# Title\n\n| a |\n|---|\n| b |\n\n==mark== ~~gone~~\nnext"
+
+	strict, err := p.ParseWithOptions(input, parser.ParseOptions{Dialect: parser.DialectCommonMark})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	for _, unwanted := range []string{"<table>", "<mark>", "<del>", "<br", `id="title"`} {
+		if strings.Contains(strict.HTML, unwanted) {
+			t.Errorf("ParseWithOptions(commonmark) HTML = %v, want no %s", strict.HTML, unwanted)
+		}
+	}
+	if !strings.Contains(strict.HTML, "<h1>Title</h1>") {
+		t.Errorf("ParseWithOptions(commonmark) HTML = %v, want plain heading", strict.HTML)
+	}
+
+	gfm, err := p.ParseWithOptions(input, parser.ParseOptions{Dialect: parser.DialectGFM})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	if !strings.Contains(gfm.HTML, "<table>") || !strings.Contains(gfm.HTML, "<del>gone</del>") {
+		t.Errorf("ParseWithOptions(gfm) HTML = %v, want table and strikethrough", gfm.HTML)
+	}
+	if strings.Contains(gfm.HTML, "<mark>") {
+		t.Errorf("ParseWithOptions(gfm) HTML = %v, want no highlight", gfm.HTML)
+	}
+
+	notion, err := p.ParseWithOptions(input, parser.ParseOptions{Dialect: parser.DialectNotion})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	if !strings.Contains(notion.HTML, "<mark>mark</mark>") {
+		t.Errorf("ParseWithOptions(notion) HTML = %v, want highlight", notion.HTML)
+	}
+}
+
+func TestParserFeatureFlags(t *testing.T) {
+	config := configs.DefaultConfig().Parser
+	config.EnableTables = false
+	config.EnableAutolink = false
+	config.EnableFootnotes = false
+	p := parser.NewMarkdownParserWithConfig(config)
+
+	result, err := p.Parse("| a |\n|---|\n| b |\n\nSee https://example.com and ~~this~~[^1]\n\n[^1]: note")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	for _, unwanted := range []string{"<table>", `href="https://example.com"`, "footnote"} {
+		if strings.Contains(result.HTML, unwanted) {
+			t.Errorf("Parse() HTML = %v, want no %s", result.HTML, unwanted)
+		}
+	}
+	if !strings.Contains(result.HTML, "<del>this</del>") {
+		t.Errorf("Parse() HTML = %v, want strikethrough while EnableGFM is set", result.HTML)
+	}
+}
+
+func TestInlineSpans(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	source := "Some **bold**, *italic*, `code`, [a link](https://example.com) and https://auto.example.com"
+
+	result, err := p.Parse(source)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := []struct{ typ, text, href string }{
+		{"bold", "bold", ""},
+		{"italic", "italic", ""},
+		{"code", "code", ""},
+		{"link", "a link", "https://example.com"},
+		{"link", "https://auto.example.com", "https://auto.example.com"},
+	}
+
+	for _, block := range result.Blocks {
+		if block.Type != "paragraph" {
+			continue
+		}
+		if len(block.Inlines) != len(want) {
+			t.Fatalf("paragraph inlines = %v, want %d spans", block.Inlines, len(want))
+		}
+		for i, w := range want {
+			got := block.Inlines[i]
+			if got.Type != w.typ || got.Text != w.text || got.Href != w.href {
+				t.Errorf("inline %d = %+v, want %s %q href %q", i, got, w.typ, w.text, w.href)
+			}
+			if source[got.Start:got.End] != w.text {
+				t.Errorf("inline %d offsets cover %q, want %q", i, source[got.Start:got.End], w.text)
+			}
+		}
+	}
+}
+
+func TestBlockHierarchy(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.Parse("- first\n\n  nested paragraph\n- second\n\n> quoted")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var list, quote *models.Block
+	for _, block := range result.Blocks {
+		switch block.Type {
+		case "unordered_list":
+			list = block
+		case "blockquote":
+			quote = block
+		}
+	}
+	if list == nil || quote == nil {
+		t.Fatalf("Parse() blocks = %v, want list and blockquote", result.Blocks)
+	}
+
+	if list.ParentID != "" || len(list.Children) != 2 {
+		t.Fatalf("list = %+v, want top-level list with 2 items", list)
+	}
+	item := list.Children[0]
+	if item.Type != "list_item" || item.ParentID != list.ID {
+		t.Errorf("list child = %+v, want list_item with parent %s", item, list.ID)
+	}
+	if len(item.Children) != 2 || item.Children[1].Type != "paragraph" || item.Children[1].ParentID != item.ID {
+		t.Errorf("list_item children = %v, want two paragraphs", item.Children)
+	}
+
+	if len(quote.Children) != 1 || quote.Children[0].Type != "paragraph" {
+		t.Errorf("blockquote children = %v, want one paragraph", quote.Children)
+	}
+	if result.Blocks[quote.Children[0].ID] != quote.Children[0] {
+		t.Errorf("blockquote child %s missing from the block map", quote.Children[0].ID)
+	}
+}
+
+func TestPlainTextFormat(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.ParseWithOptions("# Hello *world*\n\nSome **bold** and [a link](https://example.com).\n\n- [x] done\n- <b>raw</b> item", parser.ParseOptions{
+		Format: parser.FormatText,
+	})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+
+	want := "Hello world\n\nSome bold and a link.\n\ndone\nraw item"
+	if result.Output != want {
+		t.Errorf("ParseWithOptions(text) Output = %q, want %q", result.Output, want)
+	}
+
+	for _, block := range result.Blocks {
+		if block.Type == "h1" && block.Text != "Hello world" {
+			t.Errorf("heading block Text = %q, want %q", block.Text, "Hello world")
+		}
+	}
+
+	html, err := p.Parse("# Hello")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if html.Output != "" {
+		t.Errorf("Parse() Output = %q, want empty without a text format", html.Output)
+	}
+}
+
+func TestASTFormat(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.ParseWithOptions("# Title\n\nSee [docs](https://example.com).", parser.ParseOptions{
+		Format: parser.FormatAST,
+	})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+
+	doc, ok := result.AST.(*models.ASTNode)
+	if !ok {
+		t.Fatalf("ParseWithOptions(ast) AST = %T, want *models.ASTNode", result.AST)
+	}
+	if doc.Kind != "Document" || len(doc.Children) != 2 {
+		t.Fatalf("document = %+v, want Document with 2 children", doc)
+	}
+
+	heading := doc.Children[0]
+	if heading.Kind != "Heading" || heading.Attributes["level"] != 1 || heading.Attributes["id"] != "title" {
+		t.Errorf("heading = %+v, want level 1 with id title", heading)
+	}
+
+	paragraph := doc.Children[1]
+	if paragraph.Position.Line != 3 {
+		t.Errorf("paragraph line = %d, want 3", paragraph.Position.Line)
+	}
+	link := paragraph.Children[1]
+	if link.Kind != "Link" || link.Attributes["destination"] != "https://example.com" {
+		t.Errorf("link = %+v, want Link to https://example.com", link)
+	}
+	if len(link.Children) != 1 || link.Children[0].Text != "docs" {
+		t.Errorf("link children = %v, want text docs", link.Children)
+	}
+}
+
+func TestMarkdownFormat(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	input := "Title\n=====\n\n* one\n* two\n    + nested\n\n1) first\n2) second\n\n~~~python\nprint(1)\n~~~\n\n| a | b |\n|:-|-:|\n| 1 | 22 |\n"
+	want := "# Title\n\n- one\n- two\n  - nested\n\n1. first\n2. second\n\n```python\nprint(1)\n```\n\n| a   | b   |\n| :-- | --: |\n| 1   | 22  |\n"
+
+	got, err := p.Format(input, 0)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("Format() = %q, want %q", got, want)
+	}
+
+	again, err := p.Format(got, 0)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+	if again != got {
+		t.Errorf("Format() is not idempotent: %q then %q", got, again)
+	}
+
+	wrapped, err := p.Format("one two three four five six\n", 10)
+	if err != nil {
+		t.Fatalf("Format() error = %v", err)
+	}
+	if wrapped != "one two\nthree four\nfive six\n" {
+		t.Errorf("Format() with width 10 = %q", wrapped)
+	}
+
+	result, err := p.ParseWithOptions("* item", parser.ParseOptions{Format: parser.FormatMarkdown})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	if result.Output != "- item\n" {
+		t.Errorf("ParseWithOptions(markdown) Output = %q, want %q", result.Output, "- item\n")
+	}
+}
+
+func TestSlackFormat(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.ParseWithOptions("# Release\n\n**Bold**, *italic*, ~~old~~ and [docs](https://example.com) for a < b\n\n- one\n- two", parser.ParseOptions{
+		Format: parser.FormatSlack,
+	})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+
+	want := "*Release*\n\n*Bold*, _italic_, ~old~ and <https://example.com|docs> for a &lt; b\n\n• one\n• two"
+	if result.Output != want {
+		t.Errorf("ParseWithOptions(slack) Output = %q, want %q", result.Output, want)
+	}
+}
+
+func TestJiraFormat(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.ParseWithOptions("## Steps\n\n**Bold** and `code` in [docs](https://example.com)\n\n- one\n  1. nested\n\n```go\nx := 1\n```", parser.ParseOptions{
+		Format: parser.FormatJira,
+	})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+
+	want := "h2. Steps\n\n*Bold* and {{code}} in [docs|https://example.com]\n\n* one\n*# nested\n\n{code:go}\nx := 1\n{code}"
+	if result.Output != want {
+		t.Errorf("ParseWithOptions(jira) Output = %q, want %q", result.Output, want)
+	}
+}
+
+func TestSlidesFormat(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.ParseWithOptions("---\ntitle: Quarterly <Review>\n---\n# Intro\n\nWelcome\n\n---\n\n## Numbers\n\n- up\n\n---\n\nThanks", parser.ParseOptions{
+		Format: parser.FormatSlides,
+	})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+
+	if got := strings.Count(result.Output, "<section>"); got != 3 {
+		t.Errorf("ParseWithOptions(slides) has %d sections, want 3", got)
+	}
+	if !strings.Contains(result.Output, "<title>Quarterly &lt;Review&gt;</title>") {
+		t.Errorf("ParseWithOptions(slides) Output missing escaped frontmatter title")
+	}
+	if !strings.Contains(result.Output, `<div class="reveal">`) || strings.Contains(result.Output, "<hr") {
+		t.Errorf("ParseWithOptions(slides) Output = %v, want reveal.js markup without <hr> separators", result.Output)
+	}
+}
+
+func TestEmailFormat(t *testing.T) {
+	p := parser.NewMarkdownParser()
+
+	result, err := p.ParseWithOptions("# Hello\n\n<img src=x onerror=\"alert(1)\"><script>track()</script>Visit [us](https://example.com)", parser.ParseOptions{
+		Format:         parser.FormatEmail,
+		SanitizePolicy: parser.SanitizeNone,
+	})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+
+	for _, unwanted := range []string{"<script", "track()", "onerror", "\n"} {
+		if strings.Contains(result.Output, unwanted) {
+			t.Errorf("ParseWithOptions(email) Output = %v, want no %q", result.Output, unwanted)
+		}
+	}
+	if !strings.Contains(result.Output, `<h1 id="hello" style="`) || !strings.Contains(result.Output, `<a href="https://example.com" style="`) {
+		t.Errorf("ParseWithOptions(email) Output = %v, want inline styles on elements", result.Output)
+	}
+}
+
+func TestBlockPositions(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "## Title ##\n\n- one\n- two\n  - nested\n\n> quoted\n> - item\n\n```go\nfunc main() {}\n```\n\n---\n\nSetext\n======\n\n| a | b |\n|---|---|\n| 1 | 2 |\n"
+
+	result, err := p.Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := map[string]string{
+		"h2":                "## Title ##",
+		"unordered_list":    "- one\n- two\n  - nested",
+		"blockquote":        "> quoted\n> - item",
+		"fenced_code_block": "```go\nfunc main() {}\n```",
+		"thematic_break":    "---",
+		"h1":                "Setext\n======",
+		"table":             "| a | b |\n|---|---|\n| 1 | 2 |",
+	}
+	for _, block := range result.Blocks {
+		pos := block.Position
+		if pos.Start < 0 || pos.End < pos.Start || pos.End > len(content) || content[pos.Start:pos.End] != block.Content {
+			t.Errorf("%s block position %+v does not match its content %q", block.Type, pos, block.Content)
+		}
+		if block.ParentID != "" {
+			continue
+		}
+		if w, ok := want[block.Type]; ok && block.Content != w {
+			t.Errorf("%s block content = %q, want %q", block.Type, block.Content, w)
+		}
+		delete(want, block.Type)
+	}
+	for blockType := range want {
+		t.Errorf("no top-level %s block", blockType)
+	}
+}
+
+func TestBlockLineColumns(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "# Café\n\n> quoted\n> ünïcode\n\n```\ncode\n```\n"
+
+	result, err := p.Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	want := map[string]models.Position{
+		"h1":                {Line: 1, Column: 1, EndLine: 1, EndColumn: 7},
+		"blockquote":        {Line: 3, Column: 1, EndLine: 4, EndColumn: 10},
+		"paragraph":         {Line: 3, Column: 3, EndLine: 4, EndColumn: 10},
+		"fenced_code_block": {Line: 6, Column: 1, EndLine: 8, EndColumn: 4},
+	}
+	for _, block := range result.Blocks {
+		w, ok := want[block.Type]
+		if !ok {
+			continue
+		}
+		got := block.Position
+		if got.Line != w.Line || got.Column != w.Column || got.EndLine != w.EndLine || got.EndColumn != w.EndColumn {
+			t.Errorf("%s position = %d:%d-%d:%d, want %d:%d-%d:%d", block.Type,
+				got.Line, got.Column, got.EndLine, got.EndColumn, w.Line, w.Column, w.EndLine, w.EndColumn)
+		}
+	}
+
+	// Lines stay correct for blocks after an edit that adds lines
+	doc, err := p.NewDocument(content)
+	if err != nil {
+		t.Fatalf("NewDocument() error = %v", err)
+	}
+	edited, err := doc.ApplyEdit(models.Edit{Start: 0, End: 0, Text: "Intro\n\n"})
+	if err != nil {
+		t.Fatalf("ApplyEdit() error = %v", err)
+	}
+	for _, block := range edited.Blocks {
+		if block.Type == "fenced_code_block" && (block.Position.Line != 8 || block.Position.EndLine != 10) {
+			t.Errorf("fenced_code_block lines after edit = %d-%d, want 8-10", block.Position.Line, block.Position.EndLine)
+		}
+	}
+}
+
+func TestSourcePositionAttributes(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "# Title\n\n- one\n- two\n\n::: tip\ninside\n:::\n"
+
+	enabled := true
+	result, err := p.ParseWithOptions(content, parser.ParseOptions{SourcePositions: &enabled})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	for _, want := range []string{
+		`<h1 id="title" data-sourcepos="1:1-1:8">`,
+		`<ul data-sourcepos="3:1-4:6">`,
+		`<li data-sourcepos="4:1-4:6">`,
+		`<div class="container-tip" data-sourcepos="6:1-8:4">`,
+	} {
+		if !strings.Contains(result.HTML, want) {
+			t.Errorf("ParseWithOptions() HTML = %v, want %s", result.HTML, want)
+		}
+	}
+
+	plain, err := p.Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if strings.Contains(plain.HTML, "data-sourcepos") {
+		t.Errorf("Parse() HTML = %v, want no source positions by default", plain.HTML)
+	}
+
+	// Edits shift the positions of later blocks, so documents reparse in full
+	config := configs.DefaultConfig().Parser
+	config.SourcePositions = true
+	doc, err := parser.NewMarkdownParserWithConfig(config).NewDocument(content)
+	if err != nil {
+		t.Fatalf("NewDocument() error = %v", err)
+	}
+	edited, err := doc.ApplyEdit(models.Edit{Start: 0, End: 0, Text: "Intro\n\n"})
+	if err != nil {
+		t.Fatalf("ApplyEdit() error = %v", err)
+	}
+	if !strings.Contains(edited.HTML, `<ul data-sourcepos="5:1-6:6">`) {
+		t.Errorf("ApplyEdit() HTML = %v, want shifted source positions", edited.HTML)
+	}
+}
+
+func TestBlockOrder(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "# Title\n\n- one\n- two\n\nClosing paragraph.\n"
+
+	check := func(name string, result *models.ParseResponse, wantTypes []string) {
+		t.Helper()
+		var types []string
+		for _, block := range result.Tree {
+			types = append(types, block.Type)
+		}
+		if strings.Join(types, ",") != strings.Join(wantTypes, ",") {
+			t.Errorf("%s tree = %v, want %v", name, types, wantTypes)
+		}
+
+		seen := make(map[int]bool)
+		for _, block := range result.Blocks {
+			if block.Index < 0 || block.Index >= len(result.Blocks) || seen[block.Index] {
+				t.Errorf("%s block %s has index %d, want a unique index below %d", name, block.ID, block.Index, len(result.Blocks))
+			}
+			seen[block.Index] = true
+			for _, child := range block.Children {
+				if child.Index <= block.Index {
+					t.Errorf("%s child %s index %d is not after its parent's %d", name, child.ID, child.Index, block.Index)
+				}
+			}
+		}
+	}
+
+	result, err := p.Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	check("Parse()", result, []string{"h1", "unordered_list", "paragraph"})
+
+	doc, err := p.NewDocument(content)
+	if err != nil {
+		t.Fatalf("NewDocument() error = %v", err)
+	}
+	edited, err := doc.ApplyEdit(models.Edit{Start: len("# Title\n\n"), End: len("# Title\n\n"), Text: "Intro.\n\n"})
+	if err != nil {
+		t.Fatalf("ApplyEdit() error = %v", err)
+	}
+	check("ApplyEdit()", edited, []string{"h1", "paragraph", "unordered_list", "paragraph"})
+}
+
+func TestCodeBlockFields(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "```go {linenos=true}\nfunc main() {\n\tfmt.Println(\"<hi>\")\n}\n```\n\n    indented code\n"
+
+	result, err := p.Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	var fenced, indented *models.Block
+	for _, block := range result.Blocks {
+		switch block.Type {
+		case "fenced_code_block":
+			fenced = block
+		case "code_block":
+			indented = block
+		}
+	}
+	if fenced == nil || indented == nil {
+		t.Fatalf("Parse() blocks = %v, want fenced and indented code blocks", result.Blocks)
+	}
+
+	if fenced.Language != "go" || fenced.Info != "go {linenos=true}" {
+		t.Errorf("fenced language = %q, info = %q, want go and the full info string", fenced.Language, fenced.Info)
+	}
+	if want := "func main() {\n\tfmt.Println(\"<hi>\")\n}\n"; fenced.Code != want {
+		t.Errorf("fenced code = %q, want %q", fenced.Code, want)
+	}
+	if indented.Code != "indented code\n" || indented.Language != "" {
+		t.Errorf("indented code = %q, language = %q, want the dedented code and no language", indented.Code, indented.Language)
+	}
+}
+
+func TestIncrementalParsing(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "# Title\n\nFirst paragraph.\n\n```go\nfunc main() {}\n```\n\n- one\n- two\n\n## Title\n\nLast paragraph.\n"
+
+	doc, err := p.NewDocument(content)
+	if err != nil {
+		t.Fatalf("NewDocument() error = %v", err)
+	}
+
+	// Each edit replaces the first occurrence of old with new
+	edits := []struct{ old, new string }{
+		{"First paragraph.", "First *edited* paragraph."},
+		{"# Title", "Intro\n\n# Title"},
+		{"- two\n", "- two\n- three\n"},
+		{"```go", "```\n\n```go"},
+		{"```\n\n```go", "```go"},
+		{"## Title", "## Renamed"},
+	}
+	for _, e := range edits {
+		start := strings.Index(content, e.old)
+		edit := models.Edit{Start: start, End: start + len(e.old), Text: e.new}
+
+		result, err := doc.ApplyEdit(edit)
+		if err != nil {
+			t.Fatalf("ApplyEdit(%+v) error = %v", edit, err)
+		}
+		content = content[:edit.Start] + edit.Text + content[edit.End:]
+
+		full, err := p.Parse(content)
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if result.HTML != full.HTML {
+			t.Errorf("ApplyEdit(%+v) HTML = %q, want %q", edit, result.HTML, full.HTML)
+		}
+		if len(result.Blocks) != len(full.Blocks) {
+			t.Errorf("ApplyEdit(%+v) returned %d blocks, want %d", edit, len(result.Blocks), len(full.Blocks))
+		}
+		for id, want := range full.Blocks {
+			got, ok := result.Blocks[id]
+			if !ok || got.Content != want.Content || got.Position != want.Position || got.ParentID != want.ParentID {
+				t.Errorf("ApplyEdit(%+v) block %s = %+v, want %+v", edit, id, got, want)
+			}
+		}
+		if len(result.Changes) == 0 {
+			t.Errorf("ApplyEdit(%+v) reported no changes", edit)
+		}
+	}
+
+	if doc.Content() != content {
+		t.Errorf("Content() = %q, want %q", doc.Content(), content)
+	}
+	if _, err := doc.ApplyEdit(models.Edit{Start: 5, End: len(content) + 1}); err == nil {
+		t.Error("ApplyEdit() with an out-of-range edit should fail")
+	}
+}
+
+func TestStableBlockIDs(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "# Title\n\nSame text.\n\nSame text.\n\n---\n\n---\n"
+
+	before, err := p.Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(before.Blocks) != 5 {
+		t.Errorf("Parse() returned %d blocks, want duplicates kept distinct (5)", len(before.Blocks))
+	}
+
+	after, err := p.Parse("Inserted line.\n\n" + content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	for id, block := range before.Blocks {
+		if moved, ok := after.Blocks[id]; !ok || moved.Content != block.Content {
+			t.Errorf("block %s (%q) lost its ID after an insertion above it", id, block.Content)
+		}
+	}
+
+	doc, err := p.NewDocument(content)
+	if err != nil {
+		t.Fatalf("NewDocument() error = %v", err)
+	}
+	result, err := doc.ApplyEdit(models.Edit{Start: 0, End: 0, Text: "Inserted line.\n\n"})
+	if err != nil {
+		t.Fatalf("ApplyEdit() error = %v", err)
+	}
+	if len(result.Changes) != 1 || result.Changes[0].Type != "added" || result.Changes[0].Block.Content != "Inserted line." {
+		t.Errorf("ApplyEdit() changes = %+v, want only the inserted paragraph added", result.Changes)
+	}
+}
+
+func TestMovedBlocks(t *testing.T) {
+	ip := parser.NewIncrementalParser()
+	if _, err := ip.ParseWithDiff("# Title\n\nFirst\n\nSecond\n\nThird\n"); err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+
+	// Dragging the last paragraph to the top moves it, not the others
+	result, err := ip.ParseWithDiff("Third\n\n# Title\n\nFirst\n\nSecond\n")
+	if err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+	if len(result.Changes) != 1 {
+		t.Fatalf("ParseWithDiff() changes = %+v, want a single move", result.Changes)
+	}
+	change := result.Changes[0]
+	if change.Type != "moved" || change.Block.Content != "Third" {
+		t.Fatalf("ParseWithDiff() change = %+v, want the third paragraph moved", change)
+	}
+	if change.OldIndex == nil || change.NewIndex == nil || *change.OldIndex != 3 || *change.NewIndex != 0 {
+		t.Errorf("moved change indexes = %v -> %v, want 3 -> 0", change.OldIndex, change.NewIndex)
+	}
+
+	// Inserting a block shifts the others without moving them
+	result, err = ip.ParseWithDiff("Third\n\n# Title\n\nNew\n\nFirst\n\nSecond\n")
+	if err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+	if len(result.Changes) != 1 || result.Changes[0].Type != "added" {
+		t.Errorf("ParseWithDiff() changes = %+v, want only the new paragraph added", result.Changes)
+	}
+}
+
+func TestJSONPatchChanges(t *testing.T) {
+	ip := parser.NewIncrementalParser()
+	ip.Patch = true
+
+	first, err := ip.ParseWithDiff("Keep\n\nEdit me\n")
+	if err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+	if first.Changes != nil || len(first.Patch) != len(first.Blocks) {
+		t.Fatalf("ParseWithDiff() patch = %+v, want one operation per block", first.Patch)
+	}
+
+	// Replay the patches on a blocks map, as a generic JSON Patch client would
+	blocks := make(map[string]*models.Block)
+	apply := func(ops []models.PatchOperation) {
+		for _, op := range ops {
+			id := strings.TrimPrefix(op.Path, "/blocks/")
+			switch op.Op {
+			case "add", "replace":
+				blocks[id] = op.Value
+			case "remove":
+				delete(blocks, id)
+			default:
+				t.Fatalf("unexpected patch operation %+v", op)
+			}
+		}
+	}
+	apply(first.Patch)
+
+	second, err := ip.ParseWithDiff("Keep\n\nEdited\n")
+	if err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+	apply(second.Patch)
+
+	if len(blocks) != len(second.Blocks) {
+		t.Fatalf("patched blocks = %d, want %d", len(blocks), len(second.Blocks))
+	}
+	for id, block := range second.Blocks {
+		if blocks[id] == nil || blocks[id].HTML != block.HTML {
+			t.Errorf("patched block %s = %+v, want %+v", id, blocks[id], block)
+		}
+	}
+}
+
+func TestChangesOnlyResponse(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "# Title\n\nFirst paragraph.\n\nSecond paragraph.\n"
+	start := strings.Index(content, "First")
+	edit := models.Edit{Start: start, End: start + len("First paragraph."), Text: "Edited paragraph."}
+
+	full, err := p.ParseIncremental(content, edit)
+	if err != nil {
+		t.Fatalf("ParseIncremental() error = %v", err)
+	}
+	result := parser.ChangesOnly(full)
+	if result.Blocks != nil || result.HTML != "" || !result.Success {
+		t.Errorf("ChangesOnly() = %+v, want only changes", result)
+	}
+	if len(result.Changes) != len(full.Changes) {
+		t.Fatalf("ChangesOnly() returned %d changes, want %d", len(result.Changes), len(full.Changes))
+	}
+	for _, change := range result.Changes {
+		switch change.Type {
+		case "removed":
+			if change.Block != nil {
+				t.Errorf("removed change %s carries its block", change.BlockID)
+			}
+		default:
+			if change.Block == nil || change.Block.HTML == "" {
+				t.Errorf("%s change %s has no HTML", change.Type, change.BlockID)
+			}
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	if strings.Contains(string(data), `"blocks"`) || strings.Contains(string(data), `"html":""`) {
+		t.Errorf("changes-only JSON = %s, want no blocks or document HTML", data)
+	}
+
+	ip := parser.NewIncrementalParser()
+	ip.ChangesOnly = true
+	ip.ParseWithDiff(content)
+	diffed, err := ip.ParseWithDiff(content + "\nThird paragraph.\n")
+	if err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+	if diffed.Blocks != nil || len(diffed.Changes) != 1 || diffed.Changes[0].Type != "added" {
+		t.Errorf("ParseWithDiff() = %+v, want one added block only", diffed)
+	}
+}
+
+func TestConcurrentParsing(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "# Title\n\nSome **bold** text.\n\n```go\nfunc main() {}\n```\n\n| a | b |\n|---|---|\n| 1 | 2 |\n"
+	strict := parser.ParseOptions{SanitizePolicy: parser.SanitizeStrict}
+
+	want, err := p.Parse(content)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	wantStrict, err := p.ParseWithOptions(content, strict)
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan string, 64)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if i%2 == 0 {
+					if got, err := p.Parse(content); err != nil || got.HTML != want.HTML {
+						errs <- "Parse() returned different HTML under concurrency"
+						return
+					}
+				} else if got, err := p.ParseWithOptions(content, strict); err != nil || got.HTML != wantStrict.HTML {
+					errs <- "ParseWithOptions() returned different HTML under concurrency"
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestParseCache(t *testing.T) {
+	config := configs.DefaultConfig().Parser
+	config.ParseCacheSize = 2
+	p := parser.NewMarkdownParserWithConfig(config)
+
+	first, err := p.Parse("# Cached")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	first.Changes = []models.BlockChange{{Type: "added"}}
+
+	second, err := p.Parse("# Cached")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if second.HTML != first.HTML || len(second.Changes) != 0 {
+		t.Errorf("cached Parse() = %+v, want the original result without caller changes", second)
+	}
+
+	// Different options are cached separately
+	if _, err := p.ParseWithOptions("# Cached", parser.ParseOptions{Format: parser.FormatText}); err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	if _, err := p.Parse("# Other"); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	stats := p.CacheStats()
+	if !stats.Enabled || stats.Hits != 1 || stats.Misses != 3 || stats.Entries != 2 || stats.Evictions != 1 {
+		t.Errorf("CacheStats() = %+v, want 1 hit, 3 misses, 2 entries, 1 eviction", stats)
+	}
+
+	config.ParseCacheSize = 0
+	if stats := parser.NewMarkdownParserWithConfig(config).CacheStats(); stats.Enabled {
+		t.Errorf("CacheStats() = %+v, want caching disabled", stats)
+	}
+}
+
+func TestContentSizeLimit(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.Parser.MaxContentSize = 16
+	p := parser.NewMarkdownParserWithConfig(config.Parser)
+
+	if _, err := p.Parse("# Within limit"); err != nil {
+		t.Errorf("Parse() error = %v, want content within the limit accepted", err)
+	}
+	if _, err := p.Parse("# This heading is too long"); !errors.Is(err, parser.ErrContentTooLarge) {
+		t.Errorf("Parse() error = %v, want ErrContentTooLarge", err)
+	}
+
+	doc, err := p.NewDocument("# Short")
+	if err != nil {
+		t.Fatalf("NewDocument() error = %v", err)
+	}
+	if _, err := doc.ApplyEdit(models.Edit{Start: 7, End: 7, Text: " and much longer"}); !errors.Is(err, parser.ErrContentTooLarge) {
+		t.Errorf("ApplyEdit() error = %v, want ErrContentTooLarge", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"within limit", `{"content": "# Fits"}`, http.StatusOK},
+		{"content too large", `{"content": "# This heading is too long"}`, http.StatusRequestEntityTooLarge},
+		{"body too large", `{"content": "# Fits", "theme": "` + strings.Repeat("x", 70*1024) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api/parse", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.want {
+				t.Errorf("POST /api/parse status = %d, want %d (%s)", recorder.Code, tt.want, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestDiffEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, configs.DefaultConfig(), workpool.New(1, 1))
+
+	post := func(body string) (*httptest.ResponseRecorder, models.DiffResponse) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/diff", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(recorder, request)
+
+		var response models.DiffResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("POST /api/diff response = %s, error = %v", recorder.Body.String(), err)
+		}
+		return recorder, response
+	}
+
+	recorder, response := post(`{"old_content": "# Title\n\nOld <text>\n", "new_content": "# Title\n\nNew text\n", "render": "side-by-side"}`)
+	if recorder.Code != http.StatusOK || !response.Success {
+		t.Fatalf("POST /api/diff status = %d, response = %+v", recorder.Code, response)
+	}
+
+	types := make(map[string]int)
+	for _, change := range response.Changes {
+		types[change.Type]++
+	}
+	if types["added"] != 1 || types["removed"] != 1 || len(response.Changes) != 2 {
+		t.Errorf("diff changes = %+v, want the paragraph removed and re-added", response.Changes)
+	}
+
+	var lines []string
+	for _, line := range response.Lines {
+		lines = append(lines, line.Type+":"+line.Content)
+	}
+	if got, want := strings.Join(lines, ","), "unchanged:# Title,unchanged:,removed:Old <text>,added:New text,unchanged:"; got != want {
+		t.Errorf("diff lines = %v, want %v", got, want)
+	}
+
+	for _, want := range []string{`class="diff diff-side-by-side"`, `<td class="diff-text diff-removed">Old &lt;text&gt;</td><td class="diff-line-num diff-added">3</td>`} {
+		if !strings.Contains(response.HTML, want) {
+			t.Errorf("diff HTML = %v, want %v", response.HTML, want)
+		}
+	}
+
+	if recorder, _ := post(`{"old_content": "a", "new_content": "b", "render": "fancy"}`); recorder.Code != http.StatusBadRequest {
+		t.Errorf("POST /api/diff with an unknown rendering status = %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+}
+
+func TestDiffLimit(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	lines := func(n int, format string) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&b, format+"\n", i)
+		}
+		return b.String()
+	}
+
+	// Line diffs of long documents stay exact
+	oldContent := lines(diff.MaxItems/2, "line %d")
+	newContent := strings.Replace(oldContent, "line 2500\n", "line 2500 edited\n", 1) + "tail\n"
+	response, err := p.Compare(oldContent, newContent, parser.CompareOptions{Granularity: diff.GranularityLine})
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+	var changed []string
+	for _, line := range response.Lines {
+		if line.Type != "unchanged" {
+			changed = append(changed, fmt.Sprintf("%s %d:%s", line.Type, line.LineNum, line.Content))
+		}
+	}
+	if got, want := strings.Join(changed, ", "), "removed 2501:line 2500, added 2501:line 2500 edited, added 5001:tail"; got != want {
+		t.Errorf("Compare() line changes = %v, want %v", got, want)
+	}
+
+	// Only the edited lines count towards the limit
+	tooLong := lines(diff.MaxItems+1, "item %d")
+	if _, err := p.Compare(tooLong, tooLong+"more\n", parser.CompareOptions{Granularity: diff.GranularityLine}); err != nil {
+		t.Errorf("Compare() of a line added to %d error = %v", diff.MaxItems+1, err)
+	}
+	rewritten := lines(diff.MaxItems+1, "other %d")
+	if _, err := p.Compare(tooLong, rewritten, parser.CompareOptions{Granularity: diff.GranularityLine}); !errors.Is(err, diff.ErrTooLarge) {
+		t.Errorf("Compare() of %d edited lines error = %v, want %v", diff.MaxItems+1, err, diff.ErrTooLarge)
+	}
+
+	// Words too
+	ip := parser.NewIncrementalParser()
+	ip.Granularity = diff.GranularityWord
+	words := strings.Repeat("word ", diff.MaxItems) + "\n"
+	if _, err := ip.ParseWithDiff(words); !errors.Is(err, diff.ErrTooLarge) {
+		t.Errorf("ParseWithDiff() of %d words error = %v, want %v", diff.MaxItems, err, diff.ErrTooLarge)
+	}
+	ip.Granularity = diff.GranularityBlock
+	if _, err := ip.ParseWithDiff(words); err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+	ip.Granularity = diff.GranularityWord
+	edited, err := ip.ParseWithDiff("first " + words)
+	if err != nil {
+		t.Fatalf("ParseWithDiff() of an edit to %d words error = %v", diff.MaxItems, err)
+	}
+	changed = nil
+	for _, word := range edited.Words {
+		if word.Type != "unchanged" {
+			changed = append(changed, word.Type+":"+word.Content)
+		}
+	}
+	if got, want := strings.Join(changed, ", "), "added:first, added: "; got != want {
+		t.Errorf("ParseWithDiff() word changes = %v, want %v", got, want)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, configs.DefaultConfig(), workpool.New(1, 1))
+	body, _ := json.Marshal(models.DiffRequest{OldContent: tooLong, NewContent: rewritten})
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/diff", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST /api/diff of %d edited lines status = %d, want %d", diff.MaxItems+1, recorder.Code, http.StatusRequestEntityTooLarge)
+	}
+
+	// An edit whose changes are too large leaves the document as it was
+	doc, err := p.NewDocument(tooLong)
+	if err != nil {
+		t.Fatalf("NewDocument() error = %v", err)
+	}
+	doc.Granularity = diff.GranularityLine
+	if _, err := doc.ApplyEdit(models.Edit{Start: 0, End: len(tooLong), Text: rewritten}); !errors.Is(err, diff.ErrTooLarge) {
+		t.Errorf("ApplyEdit() error = %v, want %v", err, diff.ErrTooLarge)
+	}
+	if doc.Content() != tooLong {
+		t.Errorf("ApplyEdit() failing left content of length %d, want %d", len(doc.Content()), len(tooLong))
+	}
+}
+
+func TestDiffGranularity(t *testing.T) {
+	ip := parser.NewIncrementalParser()
+	if _, err := ip.ParseWithDiff("# Title\n\nThe quick fox\n"); err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+
+	ip.Granularity = diff.GranularityLine
+	lines, err := ip.ParseWithDiff("# Title\n\nThe quick brown fox\n")
+	if err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+	if lines.Changes != nil || lines.Words != nil {
+		t.Errorf("ParseWithDiff() = %+v, want only line changes", lines)
+	}
+	var changed []string
+	for _, line := range lines.Lines {
+		if line.Type != "unchanged" {
+			changed = append(changed, fmt.Sprintf("%s %d:%s", line.Type, line.LineNum, line.Content))
+		}
+	}
+	if got, want := strings.Join(changed, ", "), "removed 3:The quick fox, added 3:The quick brown fox"; got != want {
+		t.Errorf("line changes = %v, want %v", got, want)
+	}
+
+	ip.Granularity = diff.GranularityWord
+	words, err := ip.ParseWithDiff("# Title\n\nThe quick brown dog\n")
+	if err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+	changed = nil
+	for _, word := range words.Words {
+		if word.Type != "unchanged" {
+			changed = append(changed, fmt.Sprintf("%s %d:%s", word.Type, word.LineNum, word.Content))
+		}
+	}
+	if got, want := strings.Join(changed, ", "), "removed 3:fox, added 3:dog"; got != want {
+		t.Errorf("word changes = %v, want %v", got, want)
+	}
+
+	// Blocks are still tracked, so switching back reports block changes
+	ip.Granularity = diff.GranularityBlock
+	blocks, err := ip.ParseWithDiff("# Title\n\nThe quick brown dog\n\nNew\n")
+	if err != nil {
+		t.Fatalf("ParseWithDiff() error = %v", err)
+	}
+	if len(blocks.Changes) != 1 || blocks.Changes[0].Type != "added" || blocks.Lines != nil {
+		t.Errorf("ParseWithDiff() changes = %+v, want only the new paragraph added", blocks.Changes)
+	}
+}
+
+func TestWhitespaceInsensitiveDiff(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	oldContent := "# Title\n\nSome  spaced   text\n\n- item\n"
+	newContent := "# Title  \r\n\r\nSome spaced text\t\r\n\r\n- item\r\n\r\nReal change\r\n"
+
+	strict, err := p.Compare(oldContent, newContent, parser.CompareOptions{})
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+	if len(strict.Changes) <= 1 {
+		t.Errorf("Compare() changes = %+v, want whitespace edits reported", strict.Changes)
+	}
+
+	relaxed, err := p.Compare(oldContent, newContent, parser.CompareOptions{IgnoreWhitespace: true})
+	if err != nil {
+		t.Fatalf("Compare() error = %v", err)
+	}
+	if len(relaxed.Changes) != 1 || relaxed.Changes[0].Type != "added" || relaxed.Changes[0].Block.Content != "Real change" {
+		t.Errorf("Compare() changes = %+v, want only the new paragraph added", relaxed.Changes)
+	}
+	for _, line := range relaxed.Lines {
+		if line.Type != "unchanged" && line.Content != "Real change\r" && line.Content != "\r" {
+			t.Errorf("Compare() line change = %+v, want cosmetic changes ignored", line)
+		}
+	}
+}
+
+func TestThreeWayMerge(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	base := "# Title\n\nFirst paragraph.\n\n- one\n- two\n- three\n\nLast paragraph.\n"
+
+	// Edits to different blocks merge cleanly
+	ours := "# New title\n\nFirst paragraph.\n\n- one\n- two\n- three\n\nLast paragraph.\n"
+	theirs := "# Title\n\nFirst paragraph.\n\n- one\n- two\n- three\n- four\n\nLast paragraph, edited.\n"
+	result, err := p.Merge(base, ours, theirs)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	want := "# New title\n\nFirst paragraph.\n\n- one\n- two\n- three\n- four\n\nLast paragraph, edited.\n"
+	if result.Content != want || len(result.Conflicts) != 0 {
+		t.Errorf("Merge() = %q, %+v, want %q without conflicts", result.Content, result.Conflicts, want)
+	}
+
+	// Edits to different lines of one block merge line by line
+	ours = "# Title\n\nFirst paragraph.\n\n- uno\n- two\n- three\n\nLast paragraph.\n"
+	theirs = "# Title\n\nFirst paragraph.\n\n- one\n- two\n- tres\n\nLast paragraph.\n"
+	if result, err = p.Merge(base, ours, theirs); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	if want := "# Title\n\nFirst paragraph.\n\n- uno\n- two\n- tres\n\nLast paragraph.\n"; result.Content != want || len(result.Conflicts) != 0 {
+		t.Errorf("Merge() = %q, %+v, want %q without conflicts", result.Content, result.Conflicts, want)
+	}
+
+	// Different edits to the same line conflict
+	ours = "# Title\n\nOur paragraph.\n\n- one\n- two\n- three\n\nLast paragraph.\n"
+	theirs = "# Title\n\nTheir paragraph.\n\n- one\n- two\n- three\n\nLast paragraph!\n"
+	if result, err = p.Merge(base, ours, theirs); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	want = "# Title\n\n<<<<<<< ours\nOur paragraph.\n=======\nTheir paragraph.\n>>>>>>> theirs\n\n- one\n- two\n- three\n\nLast paragraph!\n"
+	if result.Content != want {
+		t.Errorf("Merge() content = %q, want %q", result.Content, want)
+	}
+	if len(result.Conflicts) != 1 {
+		t.Fatalf("Merge() conflicts = %+v, want 1", result.Conflicts)
+	}
+	conflict := result.Conflicts[0]
+	if conflict.Line != 3 || conflict.Base != "First paragraph.\n" || conflict.Ours != "Our paragraph.\n" || conflict.Theirs != "Their paragraph.\n" {
+		t.Errorf("Merge() conflict = %+v, want the first paragraph at line 3", conflict)
+	}
+
+	// Merges of too many lines are refused
+	code := "```\n" + strings.Repeat("x\n", diff.MaxItems) + "```\n"
+	huge := models.MergeRequest{Base: code, Ours: "```go" + code[3:], Theirs: code + "\n"}
+	if _, err := p.Merge(huge.Base, huge.Ours, huge.Theirs); !errors.Is(err, diff.ErrTooLarge) {
+		t.Errorf("Merge() of %d lines error = %v, want %v", diff.MaxItems+2, err, diff.ErrTooLarge)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, configs.DefaultConfig(), workpool.New(1, 1))
+	body, _ := json.Marshal(huge)
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/merge", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("POST /api/merge of %d lines status = %d, want %d", diff.MaxItems+2, recorder.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	jobs := workpool.New(1, 1)
+
+	// Occupy the only worker, then fill the queue
+	started, release := make(chan struct{}), make(chan struct{})
+	go jobs.Run(func() error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	queued := make(chan error, 1)
+	go func() {
+		queued <- jobs.Run(func() error { return errors.New("queued job ran") })
+	}()
+	for jobs.Stats().Queued == 0 {
+		runtime.Gosched()
+	}
+
+	if err := jobs.Run(func() error { return nil }); !errors.Is(err, workpool.ErrQueueFull) {
+		t.Errorf("Run() on a full pool error = %v, want ErrQueueFull", err)
+	}
+
+	close(release)
+	if err := <-queued; err == nil || err.Error() != "queued job ran" {
+		t.Errorf("queued Run() error = %v, want the job's own error", err)
+	}
+	if err := jobs.Run(func() error { panic("boom") }); err == nil {
+		t.Error("Run() with a panicking job should return an error")
+	}
+
+	if stats := jobs.Stats(); stats.Workers != 1 || stats.Capacity != 1 || stats.Rejected != 1 {
+		t.Errorf("Stats() = %+v, want 1 worker, capacity 1, 1 rejected", stats)
+	}
+}
+
+func TestWebSocketDebounce(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 200
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		websocket.HandleWebSocket(hub, c)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	// Typing "# Hi" as a baseline and two edits arrives within one window
+	messages := []models.WebSocketMessage{
+		{Type: "parse_incremental", Content: "# Hi"},
+		{Type: "parse_incremental", Content: "# Hi", Edit: &models.Edit{Start: 4, End: 4, Text: "!"}},
+		{Type: "parse_incremental", Content: "# Hi!", Edit: &models.Edit{Start: 5, End: 5, Text: "!"}},
+	}
+	for _, msg := range messages {
+		if err := conn.WriteJSON(msg); err != nil {
+			t.Fatalf("WriteJSON() error = %v", err)
+		}
+	}
+
+	var types []string
+	var parsed struct {
+		Data models.ParseResponse `json:"data"`
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(types) == 0 || types[len(types)-1] != "parsed_incremental" {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v after %v", err, types)
+		}
+		// Queued responses are batched into one frame, separated by newlines
+		for _, line := range strings.Split(string(frame), "\n") {
+			var response models.WebSocketResponse
+			if err := json.Unmarshal([]byte(line), &response); err != nil {
+				t.Fatalf("response %q is not JSON: %v", line, err)
+			}
+			if response.Type == "parsed_incremental" {
+				json.Unmarshal([]byte(line), &parsed)
+			}
+			if response.Type != "connected" {
+				types = append(types, response.Type)
+			}
+		}
+	}
+
+	want := []string{"superseded", "superseded", "parsed_incremental"}
+	if strings.Join(types, ",") != strings.Join(want, ",") {
+		t.Errorf("responses = %v, want %v", types, want)
+	}
+	if !strings.Contains(parsed.Data.HTML, "Hi!!") {
+		t.Errorf("parsed HTML = %q, want the content after both edits", parsed.Data.HTML)
+	}
+}
+
+// wsClient reads hub responses one at a time, although queued responses
+// arrive batched into one frame, separated by newlines
+type wsClient struct {
+	*gorilla.Conn
+	pending  []string
+	sequence uint64 // Sequence of the last response returned by next
+	id       string // Request ID echoed by the last response returned by next
+}
+
+// dialHub serves hub over a test server and connects a client to it
+func dialHub(t *testing.T, hub *websocket.Hub) (*wsClient, func() *wsClient) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		websocket.HandleWebSocket(hub, c)
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	dial := func() *wsClient {
+		conn, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return &wsClient{Conn: conn}
+	}
+	return dial(), dial
+}
+
+// send writes a message to the hub
+func (c *wsClient) send(t *testing.T, msg models.WebSocketMessage) {
+	t.Helper()
+	if err := c.WriteJSON(msg); err != nil {
+		t.Fatalf("WriteJSON() error = %v", err)
+	}
+}
+
+// next returns the next response of the given type, skipping other types,
+// and decodes its data into data
+func (c *wsClient) next(t *testing.T, responseType string, data interface{}) {
+	t.Helper()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if len(c.pending) == 0 {
+			_, frame, err := c.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() error = %v waiting for %s", err, responseType)
+			}
+			c.pending = strings.Split(string(frame), "\n")
+		}
+		line := c.pending[0]
+		c.pending = c.pending[1:]
+
+		var response struct {
+			ID       string          `json:"id"`
+			Type     string          `json:"type"`
+			Data     json.RawMessage `json:"data"`
+			Sequence uint64          `json:"sequence"`
+		}
+		if err := json.Unmarshal([]byte(line), &response); err != nil {
+			t.Fatalf("response %q is not JSON: %v", line, err)
+		}
+		if response.Type == responseType {
+			c.sequence = response.Sequence
+			c.id = response.ID
+			if data != nil {
+				if err := json.Unmarshal(response.Data, data); err != nil {
+					t.Fatalf("%s data %s: %v", responseType, response.Data, err)
+				}
+			}
+			return
+		}
+	}
+}
+
+func TestPresence(t *testing.T) {
+	hub := websocket.NewHub(configs.DefaultConfig(), workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+
+	alice.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc", User: &models.User{ID: "alice", Name: "Alice"}})
+	var subscribed struct {
+		User   models.User   `json:"user"`
+		Roster []models.User `json:"roster"`
+	}
+	alice.next(t, "subscribed", &subscribed)
+	if subscribed.User.ID != "alice" || len(subscribed.Roster) != 1 {
+		t.Errorf("subscribed = %+v, want Alice alone", subscribed)
+	}
+
+	// Bob announces no identity, so he gets an anonymous one
+	bob.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	bob.next(t, "subscribed", &subscribed)
+	if len(subscribed.Roster) != 2 || subscribed.Roster[0].ID != "alice" || subscribed.User.ID == "" {
+		t.Errorf("subscribed = %+v, want Alice and Bob", subscribed)
+	}
+
+	var event models.PresenceEvent
+	alice.next(t, "presence", &event)
+	if event.Event != "joined" || event.User.ID != subscribed.User.ID || len(event.Roster) != 2 {
+		t.Errorf("presence = %+v, want Bob joined", event)
+	}
+
+	bob.Close()
+	alice.next(t, "presence", &event)
+	if event.Event != "left" || event.User.ID != subscribed.User.ID || len(event.Roster) != 1 || event.Roster[0].ID != "alice" {
+		t.Errorf("presence = %+v, want Bob left", event)
+	}
+}
+
+func TestCursorSharing(t *testing.T) {
+	hub := websocket.NewHub(configs.DefaultConfig(), workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+
+	cursor := models.WebSocketMessage{
+		Type:       "cursor",
+		DocumentID: "doc",
+		BlockID:    "abc123",
+		Cursor:     &models.Cursor{Offset: 4, Selection: &models.Range{Start: 2, End: 4}},
+	}
+
+	// Only subscribers can share their cursor
+	alice.send(t, cursor)
+	alice.next(t, "error", nil)
+
+	alice.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc", User: &models.User{ID: "alice", Color: "#f00"}})
+	alice.next(t, "subscribed", nil)
+	bob.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	bob.next(t, "subscribed", nil)
+
+	alice.send(t, cursor)
+	var event models.CursorEvent
+	bob.next(t, "cursor", &event)
+	if event.User.ID != "alice" || event.User.Color != "#f00" || event.BlockID != "abc123" || event.Cursor.Offset != 4 ||
+		event.Cursor.Selection == nil || *event.Cursor.Selection != (models.Range{Start: 2, End: 4}) {
+		t.Errorf("cursor = %+v, want Alice's cursor and selection", event)
+	}
+}
+
+// signJWT builds an HS256 JWT with the given claims
+func signJWT(secret string, claims map[string]interface{}) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload, _ := json.Marshal(claims)
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAuthentication(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.Auth = configs.AuthConfig{
+		Enabled:   true,
+		APIKeys:   []configs.APIKeyConfig{{Key: "secret-key", UserID: "service", Name: "Service"}},
+		JWTSecret: "jwt-secret",
+	}
+	hour := time.Now().Add(time.Hour).Unix()
+	valid := signJWT("jwt-secret", map[string]interface{}{"sub": "alice", "name": "Alice", "exp": hour})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"unknown API key", auth.APIKeyHeader, "wrong", http.StatusUnauthorized},
+		{"API key", auth.APIKeyHeader, "secret-key", http.StatusOK},
+		{"JWT", "Authorization", "Bearer " + valid, http.StatusOK},
+		{"expired JWT", "Authorization", "Bearer " + signJWT("jwt-secret", map[string]interface{}{"sub": "alice", "exp": time.Now().Add(-time.Hour).Unix()}), http.StatusUnauthorized},
+		{"JWT with another secret", "Authorization", "Bearer " + signJWT("other", map[string]interface{}{"sub": "alice", "exp": hour}), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api/parse", strings.NewReader(`{"content": "# Hi"}`))
+			request.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				request.Header.Set(tt.header, tt.value)
+			}
+			router.ServeHTTP(recorder, request)
+
+			if recorder.Code != tt.want {
+				t.Errorf("POST /api/parse status = %d, want %d (%s)", recorder.Code, tt.want, recorder.Body.String())
+			}
+		})
+	}
+
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+	unauthenticated, dial := dialHub(t, hub)
+
+	// Without a token on the upgrade, the first message must authenticate
+	unauthenticated.send(t, models.WebSocketMessage{Type: "parse", Content: "# Hi"})
+	unauthenticated.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := unauthenticated.ReadMessage(); err != nil {
+			if !gorilla.IsCloseError(err, gorilla.ClosePolicyViolation) {
+				t.Errorf("ReadMessage() error = %v, want a policy violation close", err)
+			}
+			break
+		}
+	}
+
+	client := dial()
+	client.send(t, models.WebSocketMessage{Type: "auth", Token: valid})
+	client.next(t, "authenticated", nil)
+	client.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc", User: &models.User{ID: "mallory", Color: "#0f0"}})
+	var subscribed struct {
+		User models.User `json:"user"`
+	}
+	client.next(t, "subscribed", &subscribed)
+	if user := subscribed.User; user.ID != "alice" || user.Name != "Alice" || user.Color != "#0f0" {
+		t.Errorf("subscribed user = %+v, want the identity from the token", subscribed.User)
+	}
+}
+
+func TestMaxConnections(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.MaxConnections = 1
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+
+	first, dial := dialHub(t, hub)
+	first.next(t, "connected", nil)
+
+	second := dial()
+	second.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := second.ReadMessage(); !gorilla.IsCloseError(err, gorilla.CloseTryAgainLater) {
+		t.Errorf("ReadMessage() error = %v, want a try-again-later close", err)
+	}
+
+	stats := hub.ConnectionStats()
+	if stats.Current != 1 || stats.Peak != 1 || stats.Max != 1 || stats.Rejected != 1 {
+		t.Errorf("ConnectionStats() = %+v, want one connected and one rejected", stats)
+	}
+
+	// Once the first client leaves, there is room again
+	first.Close()
+	for deadline := time.Now().Add(5 * time.Second); hub.ConnectionStats().Current != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("ConnectionStats() = %+v, want the first client gone", hub.ConnectionStats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dial().next(t, "connected", nil)
+}
+
+func TestSessionResume(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	config.WebSocket.ResumeBuffer = 2
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+
+	alice, dial := dialHub(t, hub)
+	var connected struct {
+		SessionID string `json:"sessionId"`
+	}
+	alice.next(t, "connected", &connected)
+	if connected.SessionID == "" {
+		t.Fatal("connected response has no session ID")
+	}
+	var subscribed struct {
+		User models.User `json:"user"`
+	}
+	alice.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	alice.next(t, "subscribed", &subscribed)
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: "# One"})
+	alice.next(t, "parsed_incremental", nil)
+
+	alice.Close()
+	for deadline := time.Now().Add(5 * time.Second); hub.ConnectionStats().Current != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("client still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Changes 2 and 3 happen while alice is away
+	bob := dial()
+	for _, content := range []string{"# One\n\nTwo", "# One\n\nTwo\n\nThree"} {
+		bob.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: content})
+		bob.next(t, "parsed_incremental", nil)
+	}
+
+	resumed := dial()
+	resumed.send(t, models.WebSocketMessage{Type: "resume", SessionID: connected.SessionID, DocumentID: "doc", Sequence: 1})
+	var ack models.ResumeAck
+	resumed.next(t, "resumed", &ack)
+	if ack.SessionID != connected.SessionID || ack.User.ID != subscribed.User.ID {
+		t.Errorf("resumed as session %q user %q, want %q and %q", ack.SessionID, ack.User.ID, connected.SessionID, subscribed.User.ID)
+	}
+	if ack.Sequence != 3 || ack.Replayed != 2 || ack.Resync {
+		t.Errorf("resumed = %+v, want changes 2 and 3 replayed", ack)
+	}
+	var replayed models.ParseResponse
+	resumed.next(t, "parsed_incremental", nil)
+	resumed.next(t, "parsed_incremental", &replayed)
+	if resumed.sequence != 3 || len(replayed.Changes) != 1 || replayed.Changes[0].Block.Content != "Three" {
+		t.Errorf("last replayed change %d = %+v, want change 3 adding Three", resumed.sequence, replayed.Changes)
+	}
+
+	// Changes older than the buffer are not replayed; the whole document is
+	// sent instead, and a session resumes only once
+	stale := dial()
+	stale.send(t, models.WebSocketMessage{Type: "resume", SessionID: connected.SessionID, DocumentID: "doc"})
+	stale.next(t, "resumed", &ack)
+	if !ack.Resync || ack.Replayed != 0 || ack.SessionID == connected.SessionID {
+		t.Errorf("resumed = %+v, want a resync in a new session", ack)
+	}
+	var current models.ParseResponse
+	stale.next(t, "resync", &current)
+	if !strings.Contains(current.HTML, "Three") {
+		t.Errorf("resync html = %q", current.HTML)
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.SendBuffer = 1
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+
+	// Large replies the client does not read fill the socket buffers, then
+	// its send queue
+	slow, _ := dialHub(t, hub)
+	content := strings.Repeat("Lorem ipsum dolor sit amet, *consectetur* adipiscing elit.\n\n", 4000)
+	for i := 0; i < 200; i++ {
+		if err := slow.WriteJSON(models.WebSocketMessage{Type: "parse", Content: content}); err != nil {
+			break
+		}
+		if hub.ConnectionStats().SlowClosed > 0 {
+			break
+		}
+	}
+
+	slow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		if _, _, err := slow.ReadMessage(); err != nil {
+			if !gorilla.IsCloseError(err, websocket.CloseSlowClient) {
+				t.Fatalf("ReadMessage() error = %v, want close code %d", err, websocket.CloseSlowClient)
+			}
+			break
+		}
+	}
+	if stats := hub.ConnectionStats(); stats.SlowClosed != 1 {
+		t.Errorf("ConnectionStats() = %+v, want one slow client closed", stats)
+	}
+}
+
+func TestBlockOperations(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+	bob.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	bob.next(t, "subscribed", nil)
+
+	var parsed models.ParseResponse
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: "# Title\n\nFirst\n\nSecond\n"})
+	alice.next(t, "parsed_incremental", &parsed)
+	if len(parsed.Tree) != 3 {
+		t.Fatalf("Tree has %d blocks, want 3", len(parsed.Tree))
+	}
+	title, first, second := parsed.Tree[0].ID, parsed.Tree[1].ID, parsed.Tree[2].ID
+	bob.next(t, "parsed_incremental", nil)
+
+	// apply sends an operation and returns the changes; other subscribers
+	// get the same operation and rendered blocks
+	apply := func(msg models.WebSocketMessage) []models.BlockChange {
+		t.Helper()
+		msg.DocumentID = "doc"
+		alice.send(t, msg)
+		var event, broadcast models.BlockOperationEvent
+		alice.next(t, "block_operation", &event)
+		bob.next(t, "block_operation", &broadcast)
+		if event.Operation.Type != msg.Type || broadcast.Operation.Type != msg.Type || len(broadcast.Changes) != len(event.Changes) {
+			t.Fatalf("%s: reply %+v, broadcast %+v", msg.Type, event, broadcast)
+		}
+		return event.Changes
+	}
+
+	changes := apply(models.WebSocketMessage{Type: "insert_block", AfterID: title, Content: "Inserted"})
+	var inserted string
+	for _, change := range changes {
+		if change.Type == "added" && change.Block.HTML == "<p>Inserted</p>\n" {
+			inserted = change.BlockID
+		}
+	}
+	if inserted == "" {
+		t.Fatalf("insert_block changes = %+v, want the rendered block added", changes)
+	}
+
+	apply(models.WebSocketMessage{Type: "update_block", BlockID: first, Content: "Updated *first*"})
+	apply(models.WebSocketMessage{Type: "move_block", BlockID: second})
+	apply(models.WebSocketMessage{Type: "delete_block", BlockID: inserted})
+
+	alice.send(t, models.WebSocketMessage{Type: "delete_block", DocumentID: "doc", BlockID: "missing"})
+	alice.next(t, "error", nil)
+
+	// An empty edit returns the document as it stands
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Edit: &models.Edit{}})
+	alice.next(t, "parsed_incremental", &parsed)
+	want := "<p>Second</p>\n<h1 id=\"title\">Title</h1>\n<p>Updated <em>first</em></p>\n"
+	if parsed.HTML != want {
+		t.Errorf("html = %q, want %q", parsed.HTML, want)
+	}
+}
+
+func TestBroadcastChanges(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+	bob.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	bob.next(t, "subscribed", nil)
+
+	// Subscribers get each change alone, numbered
+	for i, content := range []string{"# Title\n", "# Title\n\nBody\n"} {
+		alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: content})
+		alice.next(t, "parsed_incremental", nil)
+
+		var broadcast models.ParseResponse
+		bob.next(t, "parsed_incremental", &broadcast)
+		if bob.sequence != uint64(i+1) {
+			t.Errorf("change sequence = %d, want %d", bob.sequence, i+1)
+		}
+		if broadcast.HTML != "" || broadcast.Blocks != nil || len(broadcast.Changes) != 1 || broadcast.Changes[0].Type != "added" {
+			t.Errorf("broadcast %d = %+v, want only the added block", i+1, broadcast)
+		}
+	}
+
+	// A subscriber that missed a change asks for the whole document
+	var snapshot models.ParseResponse
+	bob.send(t, models.WebSocketMessage{Type: "snapshot", DocumentID: "doc"})
+	bob.next(t, "snapshot", &snapshot)
+	if bob.sequence != 2 || !strings.Contains(snapshot.HTML, "<p>Body</p>") || len(snapshot.Blocks) != 2 {
+		t.Errorf("snapshot %d = %+v, want the document at change 2", bob.sequence, snapshot)
+	}
+
+	bob.send(t, models.WebSocketMessage{Type: "snapshot", DocumentID: "missing"})
+	bob.next(t, "error", nil)
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	jobs := workpool.New(1, 8)
+	hub := websocket.NewHub(configs.DefaultConfig(), jobs)
+	go hub.Run()
+	client, dial := dialHub(t, hub)
+	client.next(t, "connected", nil)
+
+	// A parse in flight holds up the shutdown until it finishes
+	started, finished := make(chan struct{}), make(chan struct{})
+	go jobs.Run(func() error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		close(finished)
+		return nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- hub.Shutdown(ctx) }()
+
+	client.next(t, "server_shutdown", nil)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := client.ReadMessage(); !gorilla.IsCloseError(err, gorilla.CloseGoingAway) {
+		t.Errorf("ReadMessage() error = %v, want a going-away close", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	select {
+	case <-finished:
+	default:
+		t.Error("Shutdown() returned before the parse in flight finished")
+	}
+
+	if err := jobs.Run(func() error { return nil }); !errors.Is(err, workpool.ErrShutdown) {
+		t.Errorf("Run() after shutdown error = %v, want ErrShutdown", err)
+	}
+	late := dial()
+	late.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := late.ReadMessage(); !gorilla.IsCloseError(err, gorilla.CloseGoingAway) {
+		t.Errorf("ReadMessage() error = %v, want a going-away close for a client after shutdown", err)
+	}
+}
+
+func TestEditLocks(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.LockTTLSeconds = 1
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+
+	alice.send(t, models.WebSocketMessage{Type: "lock", DocumentID: "doc", BlockID: "b1"})
+	alice.next(t, "error", nil)
+
+	alice.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc", User: &models.User{ID: "alice", Name: "Alice"}})
+	alice.next(t, "subscribed", nil)
+	// next returns the next lock event a client is told about
+	next := func(c *wsClient) models.LockEvent {
+		t.Helper()
+		var event models.LockEvent
+		c.next(t, "lock", &event)
+		return event
+	}
+
+	alice.send(t, models.WebSocketMessage{Type: "lock", DocumentID: "doc", BlockID: "b1"})
+	event := next(alice)
+	if event.Event != "locked" || event.Lock.BlockID != "b1" || event.Lock.User.ID != "alice" {
+		t.Errorf("lock event = %+v, want b1 locked by alice", event)
+	}
+
+	// Later subscribers see the lock, and can't take overlapping ones
+	var subscribed struct {
+		Locks []models.EditLock `json:"locks"`
+	}
+	bob.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc", User: &models.User{ID: "bob"}})
+	bob.next(t, "subscribed", &subscribed)
+	if len(subscribed.Locks) != 1 || subscribed.Locks[0].BlockID != "b1" {
+		t.Errorf("subscribed locks = %+v, want b1", subscribed.Locks)
+	}
+	for _, blockID := range []string{"b1", ""} {
+		bob.send(t, models.WebSocketMessage{Type: "lock", DocumentID: "doc", BlockID: blockID})
+		bob.next(t, "error", nil)
+	}
+	bob.send(t, models.WebSocketMessage{Type: "unlock", DocumentID: "doc", BlockID: "b1"})
+	bob.next(t, "error", nil)
+
+	alice.send(t, models.WebSocketMessage{Type: "unlock", DocumentID: "doc", BlockID: "b1"})
+	next(alice)
+	event = next(bob)
+	if event.Event != "unlocked" || event.Lock.BlockID != "b1" {
+		t.Errorf("lock event = %+v, want b1 unlocked", event)
+	}
+
+	// Locks lapse unless renewed
+	bob.send(t, models.WebSocketMessage{Type: "lock", DocumentID: "doc", BlockID: "b2"})
+	event = next(alice)
+	if event.Event != "locked" || event.Lock.User.ID != "bob" {
+		t.Errorf("lock event = %+v, want b2 locked by bob", event)
+	}
+	event = next(alice)
+	if event.Event != "expired" || event.Lock.BlockID != "b2" {
+		t.Errorf("lock event = %+v, want b2 expired", event)
+	}
+
+	// Disconnecting releases a client's locks
+	bob.send(t, models.WebSocketMessage{Type: "lock", DocumentID: "doc"})
+	event = next(alice)
+	bob.Close()
+	event = next(alice)
+	if event.Event != "unlocked" || event.Lock.BlockID != "" {
+		t.Errorf("lock event = %+v, want the document unlocked", event)
+	}
+}
+
+func TestHubShards(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.HubShards = 4
+	config.WebSocket.MaxConnections = 6
+	config.WebSocket.DebounceMillis = 0
+	hub := websocket.NewHub(config, workpool.New(2, 8))
+	go hub.Run()
+
+	// The connection limit holds across shards
+	first, dial := dialHub(t, hub)
+	clients := []*wsClient{first}
+	for i := 0; i < 7; i++ {
+		clients = append(clients, dial())
+	}
+	var accepted []*wsClient
+	for _, client := range clients {
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, frame, err := client.ReadMessage(); err == nil && strings.Contains(string(frame), `"connected"`) {
+			accepted = append(accepted, client)
+		} else if !gorilla.IsCloseError(err, gorilla.CloseTryAgainLater) {
+			t.Fatalf("ReadMessage() = %q, %v, want connected or a try-again-later close", frame, err)
+		}
+	}
+	stats := hub.ConnectionStats()
+	if len(accepted) != 6 || stats.Current != 6 || stats.Rejected != 2 || stats.Shards != 4 {
+		t.Fatalf("%d accepted, ConnectionStats() = %+v, want 6 connected and 2 rejected over 4 shards", len(accepted), stats)
+	}
+
+	// Subscribers on every shard hear about a document's changes
+	for _, client := range accepted[1:] {
+		client.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+		client.next(t, "subscribed", nil)
+	}
+	accepted[0].send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: "# Shared"})
+	for _, client := range accepted[1:] {
+		client.next(t, "parsed_incremental", nil)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.PingPeriodSeconds = 1
+	config.WebSocket.PongWaitSeconds = 2
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+
+	// Reading answers pings, so this client stays connected
+	live, dial := dialHub(t, hub)
+	go func() {
+		for {
+			if _, _, err := live.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// This one never reads, so it never answers a ping and is reaped
+	dial()
+
+	time.Sleep(500 * time.Millisecond)
+	if stats := hub.ConnectionStats(); stats.Current != 2 {
+		t.Fatalf("ConnectionStats() = %+v, want both clients connected", stats)
+	}
+	for deadline := time.Now().Add(5 * time.Second); hub.ConnectionStats().Current != 1; {
+		if time.Now().After(deadline) {
+			t.Fatalf("ConnectionStats() = %+v, want the silent client reaped", hub.ConnectionStats())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// largeDocument builds a document of n sections mixing the common block types
+func largeDocument(n int) string {
+	var buf strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&buf, "## Section %d\n\nSome *emphasis* and a [link](https://example.com/%d) in a paragraph.\n\n", i, i)
+		buf.WriteString("- first item\n- second item\n  - nested item\n\n> A quoted line\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n")
+	}
+	return buf.String()
+}
+
+// BenchmarkParseLargeDocument compares full parses of a large document with
+// each block fingerprint hasher
+func BenchmarkParseLargeDocument(b *testing.B) {
+	config := configs.DefaultConfig().Parser
+	config.ParseCacheSize = 0
+	p := parser.NewMarkdownParserWithConfig(config)
+	content := largeDocument(500)
+
+	defer func(h hashing.Hasher) { hashing.Default = h }(hashing.Default)
+	for _, bench := range []struct {
+		name   string
+		hasher hashing.Hasher
+	}{
+		{"fnv", hashing.FNV{}},
+		{"md5", hashing.MD5{}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			hashing.Default = bench.hasher
+			b.SetBytes(int64(len(content)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := p.Parse(content); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkHashers measures the hashers alone on a typical block
+func BenchmarkHashers(b *testing.B) {
+	content := strings.Repeat("Some *emphasis* and a [link](https://example.com) in a paragraph. ", 4)
+	for _, bench := range []struct {
+		name   string
+		hasher hashing.Hasher
+	}{
+		{"fnv", hashing.FNV{}},
+		{"md5", hashing.MD5{}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				bench.hasher.Sum64("Paragraph", content, "0")
+			}
+		})
+	}
+}
+
+func TestMsgpackEncoding(t *testing.T) {
+	hub := websocket.NewHub(configs.DefaultConfig(), workpool.New(1, 8))
+	go hub.Run()
+	client, _ := dialHub(t, hub)
+
+	// nextBinary decodes the next MessagePack response, skipping JSON ones
+	handle := &codec.MsgpackHandle{}
+	handle.RawToString = true
+	type response struct {
+		Type string                 `json:"type"`
+		Data map[string]interface{} `json:"data"`
+	}
+	nextBinary := func() response {
+		t.Helper()
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			messageType, frame, err := client.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage() error = %v", err)
+			}
+			if messageType != gorilla.BinaryMessage {
+				continue
+			}
+			var r response
+			if err := codec.NewDecoderBytes(frame, handle).Decode(&r); err != nil {
+				t.Fatalf("response is not MessagePack: %v", err)
+			}
+			return r
+		}
+	}
+
+	client.send(t, models.WebSocketMessage{Type: "handshake", Encoding: "protobuf"})
+	client.next(t, "error", nil)
+
+	client.send(t, models.WebSocketMessage{Type: "handshake", Encoding: websocket.EncodingMsgpack})
+	ack := nextBinary()
+	if ack.Type != "handshake" || ack.Data["encoding"] != websocket.EncodingMsgpack {
+		t.Fatalf("handshake response = %v", ack)
+	}
+
+	// Requests may be MessagePack too
+	var request []byte
+	if err := codec.NewEncoderBytes(&request, handle).Encode(models.WebSocketMessage{Type: "parse", Content: "# Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteMessage(gorilla.BinaryMessage, request); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	parsed := nextBinary()
+	if parsed.Type != "parsed" {
+		t.Fatalf("response = %v, want parsed", parsed)
+	}
+	if html, _ := parsed.Data["html"].(string); !strings.Contains(html, "Hello</h1>") {
+		t.Errorf("parsed html = %q", html)
+	}
+}
+
+func TestRequestIDs(t *testing.T) {
+	hub := websocket.NewHub(configs.DefaultConfig(), workpool.New(1, 8))
+	go hub.Run()
+	client, dial := dialHub(t, hub)
+	other := dial()
+
+	var connected struct {
+		Version int `json:"version"`
+	}
+	client.next(t, "connected", &connected)
+	if connected.Version != websocket.ProtocolVersion {
+		t.Errorf("connected version = %d, want %d", connected.Version, websocket.ProtocolVersion)
+	}
+
+	// The handshake refuses versions newer than the server's
+	client.send(t, models.WebSocketMessage{ID: "h1", Type: "handshake", Version: websocket.ProtocolVersion + 1})
+	client.next(t, "error", nil)
+	if client.id != "h1" {
+		t.Errorf("error id = %q, want h1", client.id)
+	}
+	var handshake struct {
+		Encoding string `json:"encoding"`
+		Version  int    `json:"version"`
+	}
+	client.send(t, models.WebSocketMessage{ID: "h2", Type: "handshake", Version: websocket.ProtocolVersion})
+	client.next(t, "handshake", &handshake)
+	if client.id != "h2" || handshake.Version != websocket.ProtocolVersion || handshake.Encoding != websocket.EncodingJSON {
+		t.Errorf("handshake = %+v with id %q", handshake, client.id)
+	}
+
+	other.send(t, models.WebSocketMessage{ID: "s1", Type: "subscribe", DocumentID: "doc"})
+	other.next(t, "subscribed", nil)
+	if other.id != "s1" {
+		t.Errorf("subscribed id = %q, want s1", other.id)
+	}
+
+	// Superseded and final replies each carry their own request's ID; the
+	// broadcast to other subscribers carries none
+	client.send(t, models.WebSocketMessage{ID: "p1", Type: "parse_incremental", DocumentID: "doc", Content: "# One"})
+	client.send(t, models.WebSocketMessage{ID: "p2", Type: "parse_incremental", DocumentID: "doc", Content: "# Two"})
+	ids := map[string]string{}
+	for len(ids) < 2 {
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, frame, err := client.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		for _, line := range strings.Split(string(frame), "\n") {
+			var response models.WebSocketResponse
+			if err := json.Unmarshal([]byte(line), &response); err != nil {
+				t.Fatalf("response %q is not JSON: %v", line, err)
+			}
+			if response.Type == "parsed_incremental" || response.Type == "superseded" {
+				ids[response.ID] = response.Type
+			}
+		}
+	}
+	if ids["p2"] != "parsed_incremental" || (ids["p1"] != "parsed_incremental" && ids["p1"] != "superseded") {
+		t.Errorf("reply ids = %v", ids)
+	}
+	other.next(t, "parsed_incremental", nil)
+	if other.id != "" {
+		t.Errorf("broadcast id = %q, want none", other.id)
+	}
+}
+
+func TestSnapshotOnSubscribe(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: "# Title\n\nBody\n"})
+	alice.next(t, "parsed_incremental", nil)
+
+	// A late joiner gets the document without waiting for the next edit
+	bob := dial()
+	var snapshot models.ParseResponse
+	bob.send(t, models.WebSocketMessage{ID: "s1", Type: "subscribe", DocumentID: "doc"})
+	bob.next(t, "subscribed", nil)
+	bob.next(t, "snapshot", &snapshot)
+	if bob.id != "s1" || bob.sequence != 1 || !strings.Contains(snapshot.HTML, "<p>Body</p>") || len(snapshot.Blocks) != 2 {
+		t.Errorf("snapshot %d (id %q) = %+v, want the document at change 1", bob.sequence, bob.id, snapshot)
+	}
+
+	// Subscribing to a document the hub does not hold sends none
+	carol := dial()
+	carol.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "new"})
+	carol.next(t, "subscribed", nil)
+	if len(carol.pending) != 0 {
+		t.Errorf("responses after subscribed = %q, want none", carol.pending)
+	}
+	carol.send(t, models.WebSocketMessage{Type: "snapshot", DocumentID: "new"})
+	carol.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var response models.WebSocketResponse
+	if err := carol.ReadJSON(&response); err != nil || response.Type != "error" {
+		t.Errorf("response after subscribing = %+v (%v), want the snapshot error", response, err)
+	}
+}
+
+func TestOperationalTransform(t *testing.T) {
+	text := "Hello world"
+	var a, b ot.Operation
+	// Concurrently, one edit inserts at the start and the other deletes "world"
+	a.Insert("Oh, ").Retain(len(text))
+	b.Retain(6).Delete(5).Insert("there")
+
+	aPrime, bPrime, err := ot.Transform(a.Build(), b.Build())
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	afterA, _ := ot.Apply(text, a.Build())
+	afterB, _ := ot.Apply(text, b.Build())
+	left, err := ot.Apply(afterA, bPrime)
+	if err != nil {
+		t.Fatalf("Apply(b') error = %v", err)
+	}
+	right, err := ot.Apply(afterB, aPrime)
+	if err != nil {
+		t.Fatalf("Apply(a') error = %v", err)
+	}
+	if left != "Oh, Hello there" || right != left {
+		t.Errorf("a then b' = %q, b then a' = %q, want %q", left, right, "Oh, Hello there")
+	}
+
+	// Inserts at the same position keep the first operation's text first
+	var x, y ot.Operation
+	x.Retain(5).Insert("!").Retain(6)
+	y.Retain(5).Insert("?").Retain(6)
+	xPrime, _, _ := ot.Transform(x.Build(), y.Build())
+	afterY, _ := ot.Apply(text, y.Build())
+	if got, _ := ot.Apply(afterY, xPrime); got != "Hello!? world" {
+		t.Errorf("tied inserts = %q, want %q", got, "Hello!? world")
+	}
+
+	if _, err := ot.Apply(text, x.Build()[:1]); err == nil {
+		t.Error("Apply() of an operation shorter than the text succeeded")
+	}
+	if _, err := ot.Apply("é", models.TextOperation{{Retain: 1}, {Delete: 1}}); err == nil {
+		t.Error("Apply() splitting a character succeeded")
+	}
+	if op := ot.FromEdit(len(text), models.Edit{Start: 6, End: 11, Text: "Go"}); len(op) != 3 {
+		t.Errorf("FromEdit() = %+v, want retain, insert, delete", op)
+	}
+}
+
+func TestTextOperations(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+	for _, c := range []*wsClient{alice, bob} {
+		c.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+		c.next(t, "subscribed", nil)
+	}
+
+	var parsed models.ParseResponse
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: "# Title\n\nBody\n"})
+	alice.next(t, "parsed_incremental", &parsed)
+	bob.next(t, "parsed_incremental", nil)
+	revision := alice.sequence
+
+	// Both edit revision 1 at once: alice appends to the title, bob to the body
+	var title, body ot.Operation
+	title.Retain(7).Insert(" here").Retain(7)
+	body.Retain(13).Insert(" text").Retain(1)
+	alice.send(t, models.WebSocketMessage{Type: "operation", DocumentID: "doc", Sequence: revision, Operation: title.Build()})
+	var ack, remote models.OperationEvent
+	alice.next(t, "operation_ack", &ack)
+	if alice.sequence != revision+1 || len(ack.Changes) != 2 {
+		t.Fatalf("ack %d = %+v, want revision %d with the title changed", alice.sequence, ack, revision+1)
+	}
+	bob.next(t, "operation", &remote)
+	if bob.sequence != revision+1 || len(remote.Operation) != 3 || remote.Operation[1].Insert != " here" {
+		t.Fatalf("broadcast %d = %+v", bob.sequence, remote)
+	}
+
+	// Bob's operation, still based on revision 1, is transformed past alice's
+	bob.send(t, models.WebSocketMessage{ID: "b1", Type: "operation", DocumentID: "doc", Sequence: revision, Operation: body.Build()})
+	bob.next(t, "operation_ack", nil)
+	var transformed models.OperationEvent
+	alice.next(t, "operation", &transformed)
+	if bob.id != "b1" || alice.sequence != revision+2 || transformed.Operation[0].Retain != 18 {
+		t.Errorf("transformed = %+v at %d", transformed, alice.sequence)
+	}
+
+	var snapshot models.ParseResponse
+	alice.send(t, models.WebSocketMessage{Type: "snapshot", DocumentID: "doc"})
+	alice.next(t, "snapshot", &snapshot)
+	if want := "<h1 id=\"title-here\">Title here</h1>\n<p>Body text</p>\n"; snapshot.HTML != want {
+		t.Errorf("html = %q, want %q", snapshot.HTML, want)
+	}
+
+	// Operations must span the document at their revision
+	alice.send(t, models.WebSocketMessage{Type: "operation", DocumentID: "doc", Sequence: revision + 2, Operation: title.Build()})
+	alice.next(t, "error", nil)
+	alice.send(t, models.WebSocketMessage{Type: "operation", DocumentID: "doc", Sequence: 99, Operation: title.Build()})
+	alice.next(t, "error", nil)
+}
+
+func TestCRDTMerge(t *testing.T) {
+	// Two replicas start from the same text, then edit it offline
+	base := crdt.New()
+	seed, err := base.Edit("a", models.Edit{Text: "Hello world"})
+	if err != nil {
+		t.Fatalf("Edit() error = %v", err)
+	}
+	alice, bob := crdt.New(), crdt.New()
+	alice.Apply(seed...)
+	bob.Apply(seed...)
+
+	fromAlice, _ := alice.Edit("a", models.Edit{Start: 5, End: 5, Text: ","})
+	more, _ := alice.Edit("a", models.Edit{Start: 0, End: 0, Text: "Oh, "})
+	fromAlice = append(fromAlice, more...)
+	fromBob, _ := bob.Edit("b", models.Edit{Start: 6, End: 11, Text: "there"})
+
+	// Each merges the other's edits, in any order, even twice
+	bob.Apply(fromAlice[1], fromAlice[0])
+	alice.Apply(alice.Since(nil)...)
+	if _, err := alice.Apply(bob.Since(alice.Vector())...); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if want := "Oh, Hello, there"; alice.Text() != want || bob.Text() != want {
+		t.Errorf("merged texts = %q and %q, want %q", alice.Text(), bob.Text(), want)
+	}
+	if ops := bob.Since(alice.Vector()); len(ops) != 0 {
+		t.Errorf("Since(merged vector) = %+v, want none", ops)
+	}
+
+	// Operations referring to characters not seen yet wait for them
+	late := crdt.New()
+	if applied, _ := late.Apply(fromBob...); len(applied) != 0 || late.Text() != "" {
+		t.Errorf("Apply() before the seed applied %+v", applied)
+	}
+	late.Apply(seed...)
+	if late.Text() != "Hello there" {
+		t.Errorf("text after the seed = %q, want %q", late.Text(), "Hello there")
+	}
+
+	if _, err := late.Apply(models.CRDTOperation{Type: "insert", ID: models.CRDTID{Site: "c"}}); err == nil {
+		t.Error("Apply() of an operation without a clock succeeded")
+	}
+}
+
+func TestCRDTSync(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	config.WebSocket.CollabMode = websocket.CollabCRDT
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+	bob.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	bob.next(t, "subscribed", nil)
+
+	// A document written by parse_incremental reaches a replica as server edits
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: "# Title\n"})
+	alice.next(t, "parsed_incremental", nil)
+	replica := crdt.New()
+	var sync models.CRDTSync
+	alice.send(t, models.WebSocketMessage{ID: "s1", Type: "crdt_sync", DocumentID: "doc"})
+	alice.next(t, "crdt_sync", &sync)
+	replica.Apply(sync.Operations...)
+	if alice.id != "s1" || replica.Text() != "# Title\n" || sync.Site == "" {
+		t.Fatalf("replica = %q after %+v", replica.Text(), sync)
+	}
+	bob.next(t, "crdt_update", nil)
+
+	// Replicas edit at the site the server assigned them, not another's
+	site := sync.Site
+	forged, _ := crdt.New().Edit("server", models.Edit{Text: "x"})
+	alice.send(t, models.WebSocketMessage{Type: "crdt_sync", DocumentID: "doc", CRDTOperations: forged})
+	alice.next(t, "error", nil)
+
+	// Offline edits merge, and the other subscribers get them
+	ops, _ := replica.Edit(site, models.Edit{Start: 8, End: 8, Text: "\nBody\n"})
+	alice.send(t, models.WebSocketMessage{Type: "crdt_sync", DocumentID: "doc", Vector: sync.Vector, CRDTOperations: ops})
+	sync = models.CRDTSync{}
+	alice.next(t, "crdt_sync", &sync)
+	if len(sync.Operations) != 0 || len(sync.Changes) != 1 || sync.Changes[0].Type != "added" {
+		t.Errorf("sync = %+v, want the added block and nothing to merge", sync)
+	}
+	var update models.CRDTSync
+	bob.next(t, "crdt_update", &update)
+	if len(update.Operations) != 1 || update.Operations[0].Text != "\nBody\n" {
+		t.Errorf("update = %+v", update)
+	}
+
+	var snapshot models.ParseResponse
+	bob.send(t, models.WebSocketMessage{Type: "snapshot", DocumentID: "doc"})
+	bob.next(t, "snapshot", &snapshot)
+	if want := "<h1 id=\"title\">Title</h1>\n<p>Body</p>\n"; snapshot.HTML != want {
+		t.Errorf("html = %q, want %q", snapshot.HTML, want)
+	}
+
+	// Server edits that change a character into one sharing its first
+	// byte keep merging
+	for _, content := range []string{"# Café\n\nBody\n", "# Cafè\n\nBody\n"} {
+		bob.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: content})
+		bob.next(t, "parsed_incremental", nil)
+		sync = models.CRDTSync{}
+		alice.send(t, models.WebSocketMessage{Type: "crdt_sync", DocumentID: "doc", Vector: replica.Vector()})
+		alice.next(t, "crdt_sync", &sync)
+		if _, err := replica.Apply(sync.Operations...); err != nil || replica.Text() != content {
+			t.Errorf("replica = %q, %v; want %q", replica.Text(), err, content)
+		}
+	}
+
+	// Text operations are for OT mode
+	alice.send(t, models.WebSocketMessage{Type: "operation", DocumentID: "doc", Operation: models.TextOperation{{Retain: 14}}})
+	alice.next(t, "error", nil)
+}
+
+func TestUndoRedo(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+	for _, c := range []struct {
+		client *wsClient
+		id     string
+	}{{alice, "alice"}, {bob, "bob"}} {
+		c.client.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc", User: &models.User{ID: c.id}})
+		c.client.next(t, "subscribed", nil)
+	}
+	html := func() string {
+		t.Helper()
+		var snapshot models.ParseResponse
+		alice.send(t, models.WebSocketMessage{Type: "snapshot", DocumentID: "doc"})
+		alice.next(t, "snapshot", &snapshot)
+		return snapshot.HTML
+	}
+
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: "# Title\n\nBody\n"})
+	alice.next(t, "parsed_incremental", nil)
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: "# Title\n\nBody, edited\n"})
+	alice.next(t, "parsed_incremental", nil)
+	bob.next(t, "parsed_incremental", nil)
+	bob.next(t, "parsed_incremental", nil)
+	bob.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Edit: &models.Edit{Start: 22, End: 22, Text: "\nBob\n"}})
+	bob.next(t, "parsed_incremental", nil)
+	alice.next(t, "parsed_incremental", nil)
+
+	// Undo reverts alice's last change, not bob's later one
+	alice.send(t, models.WebSocketMessage{ID: "u1", Type: "undo", DocumentID: "doc"})
+	var undone, broadcast models.UndoEvent
+	alice.next(t, "undo", &undone)
+	bob.next(t, "undo", &broadcast)
+	if alice.id != "u1" || broadcast.User.ID != "alice" || len(broadcast.Changes) != 2 {
+		t.Errorf("undo = %+v, broadcast %+v", undone, broadcast)
+	}
+	if want := "<h1 id=\"title\">Title</h1>\n<p>Body</p>\n<p>Bob</p>\n"; html() != want {
+		t.Errorf("html after undo = %q, want %q", html(), want)
+	}
+
+	alice.send(t, models.WebSocketMessage{Type: "redo", DocumentID: "doc"})
+	alice.next(t, "redo", nil)
+	if want := "<h1 id=\"title\">Title</h1>\n<p>Body, edited</p>\n<p>Bob</p>\n"; html() != want {
+		t.Errorf("html after redo = %q, want %q", html(), want)
+	}
+
+	bob.send(t, models.WebSocketMessage{Type: "undo", DocumentID: "doc"})
+	bob.next(t, "undo", nil)
+	if want := "<h1 id=\"title\">Title</h1>\n<p>Body, edited</p>\n"; html() != want {
+		t.Errorf("html after bob's undo = %q, want %q", html(), want)
+	}
+
+	alice.send(t, models.WebSocketMessage{Type: "redo", DocumentID: "doc"})
+	alice.next(t, "error", nil)
+}
+
+// editedDocuments is a versions.Source of documents by ID
+type editedDocuments map[string]string
+
+func (d editedDocuments) DocumentIDs() []string {
+	var ids []string
+	for id := range d {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (d editedDocuments) DocumentContent(documentID string) (string, bool) {
+	content, ok := d[documentID]
+	return content, ok
+}
+
+func TestDocumentVersions(t *testing.T) {
+	// The hub provides the documents being edited
+	var _ versions.Source = websocket.NewHub(configs.DefaultConfig(), workpool.New(1, 1))
+
+	documents := editedDocuments{"doc": "# Title\n\nFirst draft\n"}
+	config := configs.DefaultConfig()
+	config.Versions.MaxVersions = 2
+	store := versions.NewStore(config.Versions, documents)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupVersionRoutes(router, config, store)
+
+	request := func(method, path string, response interface{}) int {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatalf("%s %s response = %s, error = %v", method, path, recorder.Body.String(), err)
+		}
+		return recorder.Code
+	}
+
+	// Snapshots on demand only make a version when the content changed
+	var snapshot models.VersionResponse
+	if code := request(http.MethodPost, "/api/documents/doc/versions", &snapshot); code != http.StatusCreated || snapshot.Version.Version != 1 {
+		t.Fatalf("first snapshot = %d %+v", code, snapshot)
+	}
+	snapshot = models.VersionResponse{}
+	if code := request(http.MethodPost, "/api/documents/doc/versions", &snapshot); code != http.StatusOK || snapshot.Created || snapshot.Version.Version != 1 {
+		t.Errorf("unchanged snapshot = %d %+v", code, snapshot)
+	}
+
+	// Periodic snapshots take every changed document
+	documents["doc"] = "# Title\n\nSecond draft\n"
+	store.SnapshotAll()
+	documents["doc"] = "# New title\n\nSecond draft\n"
+	store.SnapshotAll()
+
+	var list models.VersionsResponse
+	request(http.MethodGet, "/api/documents/doc/versions", &list)
+	if len(list.Versions) != 2 || list.Versions[0].Version != 2 || list.Versions[1].Version != 3 || list.Versions[0].Content != "" {
+		t.Errorf("versions = %+v, want 2 and 3 without content", list.Versions)
+	}
+
+	var version models.VersionResponse
+	if code := request(http.MethodGet, "/api/documents/doc/versions/2", &version); code != http.StatusOK || version.Version.Content != "# Title\n\nSecond draft\n" {
+		t.Errorf("version 2 = %d %+v", code, version)
+	}
+	if code := request(http.MethodGet, "/api/documents/doc/versions/1", &version); code != http.StatusNotFound {
+		t.Errorf("dropped version 1 status = %d, want 404", code)
+	}
+
+	var changes models.DiffResponse
+	if code := request(http.MethodGet, "/api/documents/doc/diff?from=2&to=3&granularity=line", &changes); code != http.StatusOK || len(changes.Lines) < 2 || changes.Lines[0].Type != "removed" || changes.Lines[1].Content != "# New title" {
+		t.Errorf("diff = %d %+v, want the title line removed and added", code, changes)
+	}
+	if code := request(http.MethodGet, "/api/documents/doc/diff?from=2&to=x", &changes); code != http.StatusBadRequest {
+		t.Errorf("diff to an invalid version status = %d, want 400", code)
+	}
+	if code := request(http.MethodGet, "/api/documents/missing/versions", &list); code != http.StatusNotFound {
+		t.Errorf("unknown document status = %d, want 404", code)
+	}
+}
+
+func TestComments(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	store := comments.NewStore(hub, hub)
+	hub.OnChange(store.Reanchor)
+	go hub.Run()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupCommentRoutes(router, config, store)
+
+	request := func(method, path, body string, response interface{}) int {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatalf("%s %s response = %s, error = %v", method, path, recorder.Body.String(), err)
+		}
+		return recorder.Code
+	}
+
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+	bob.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	bob.next(t, "subscribed", nil)
+	var parsed models.ParseResponse
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Content: "# Title\n\nBody\n\nMore\n"})
+	alice.next(t, "parsed_incremental", &parsed)
+	bob.next(t, "parsed_incremental", nil)
+	var body string
+	for id, block := range parsed.Blocks {
+		if block.Content == "Body" {
+			body = id
+		}
+	}
+
+	var created models.CommentResponse
+	if code := request(http.MethodPost, "/api/documents/doc/comments", `{"block_id": "`+body+`", "body": "Too short", "author": "alice"}`, &created); code != http.StatusCreated || created.Comment.BlockID != body {
+		t.Fatalf("create comment = %d %+v", code, created)
+	}
+	var event models.CommentEvent
+	bob.next(t, "comment", &event)
+	if event.Event != "created" || event.Comment.ID != created.Comment.ID {
+		t.Errorf("created event = %+v", event)
+	}
+	if code := request(http.MethodPost, "/api/documents/doc/comments", `{"block_id": "missing", "body": "x"}`, &created); code != http.StatusBadRequest {
+		t.Errorf("comment on an unknown block status = %d, want 400", code)
+	}
+
+	// Editing the block moves the comment to the block that replaced it
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Edit: &models.Edit{Start: 13, End: 13, Text: ", edited"}})
+	alice.next(t, "parsed_incremental", &parsed)
+	bob.next(t, "comment", &event)
+	if event.Event != "reanchored" || parsed.Blocks[event.Comment.BlockID] == nil || parsed.Blocks[event.Comment.BlockID].Content != "Body, edited" {
+		t.Errorf("reanchored event = %+v", event)
+	}
+
+	// Deleting it orphans the comment
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Edit: &models.Edit{Start: 9, End: 23, Text: ""}})
+	alice.next(t, "parsed_incremental", nil)
+	bob.next(t, "comment", &event)
+	if event.Event != "orphaned" || !event.Comment.Orphaned {
+		t.Errorf("orphaned event = %+v", event)
+	}
+
+	var updated models.CommentResponse
+	if code := request(http.MethodPatch, "/api/documents/doc/comments/"+created.Comment.ID, `{"resolved": true}`, &updated); code != http.StatusOK || !updated.Comment.Resolved || updated.Comment.Body != "Too short" {
+		t.Errorf("resolve comment = %d %+v", code, updated)
+	}
+	bob.next(t, "comment", &event)
+	if event.Event != "resolved" {
+		t.Errorf("resolved event = %+v", event)
+	}
+
+	var list models.CommentsResponse
+	if code := request(http.MethodGet, "/api/documents/doc/comments", "", &list); code != http.StatusOK || len(list.Comments) != 1 {
+		t.Errorf("comments = %d %+v", code, list)
+	}
+	if code := request(http.MethodDelete, "/api/documents/doc/comments/"+created.Comment.ID, "", &updated); code != http.StatusOK {
+		t.Errorf("delete comment status = %d", code)
+	}
+	bob.next(t, "comment", &event)
+	if code := request(http.MethodGet, "/api/documents/doc/comments/"+created.Comment.ID, "", &updated); code != http.StatusNotFound {
+		t.Errorf("deleted comment status = %d, want 404", code)
+	}
+	if code := request(http.MethodGet, "/api/documents/missing/comments", "", &list); code != http.StatusNotFound {
+		t.Errorf("unknown document status = %d, want 404", code)
+	}
+}
+
+func TestDocumentStore(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	config.Documents.AutosaveMillis = 50
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	hub.SetDocumentStore(docs)
+	go hub.Run()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupDocumentRoutes(router, config, docs, hub)
+
+	request := func(method, path, body string, response interface{}) int {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatalf("%s %s response = %s, error = %v", method, path, recorder.Body.String(), err)
+		}
+		return recorder.Code
+	}
+
+	var created models.DocumentResponse
+	if code := request(http.MethodPost, "/api/documents", `{"id": "doc", "title": "Notes", "content": "# Title\n\nBody\n"}`, &created); code != http.StatusCreated || created.Document.Revision != 1 {
+		t.Fatalf("create document = %d %+v", code, created)
+	}
+	if code := request(http.MethodPost, "/api/documents", `{"id": "doc"}`, &created); code != http.StatusConflict {
+		t.Errorf("create taken ID status = %d, want 409", code)
+	}
+
+	// Subscribers get the stored document, and their edits are autosaved
+	// to it together
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+	var snapshot models.ParseResponse
+	bob.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	bob.next(t, "snapshot", &snapshot)
+	if snapshot.HTML != "<h1 id=\"title\">Title</h1>\n<p>Body</p>\n" {
+		t.Errorf("snapshot html = %q", snapshot.HTML)
+	}
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Edit: &models.Edit{Start: 13, End: 13, Text: ", edited"}})
+	alice.next(t, "parsed_incremental", nil)
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "doc", Edit: &models.Edit{Start: 21, End: 21, Text: "!"}})
+	alice.next(t, "parsed_incremental", nil)
+	var saved models.SavedEvent
+	bob.next(t, "saved", &saved)
+	if saved.DocumentID != "doc" || saved.Version != 2 || saved.Sequence != 2 {
+		t.Errorf("saved = %+v, want both edits saved as revision 2", saved)
+	}
+
+	var doc models.DocumentResponse
+	if code := request(http.MethodGet, "/api/documents/doc", "", &doc); code != http.StatusOK || doc.Document.Content != "# Title\n\nBody, edited!\n" || doc.Document.Revision != 2 {
+		t.Errorf("edited document = %d %+v", code, doc)
+	}
+
+	// Changes through the API reach the subscribers
+	var changed models.ParseResponse
+	if code := request(http.MethodPut, "/api/documents/doc", `{"content": "# Title\n\nRewritten\n"}`, &doc); code != http.StatusOK || doc.Document.Title != "Notes" {
+		t.Errorf("update document = %d %+v", code, doc)
+	}
+	bob.next(t, "parsed_incremental", &changed)
+	if len(changed.Changes) != 2 {
+		t.Errorf("changes sent for an update = %+v, want one block removed and one added", changed.Changes)
+	}
+	bob.next(t, "saved", &saved)
+	if saved.Version != 3 {
+		t.Errorf("saved after update = %+v, want revision 3", saved)
+	}
+
+	var list models.DocumentsResponse
+	if code := request(http.MethodGet, "/api/documents", "", &list); code != http.StatusOK || len(list.Documents) != 1 || list.Documents[0].Content != "" {
+		t.Errorf("documents = %d %+v, want one without content", code, list)
+	}
+	if code := request(http.MethodDelete, "/api/documents/doc", "", &doc); code != http.StatusOK {
+		t.Errorf("delete document status = %d", code)
+	}
+	bob.next(t, "deleted", nil)
+	if code := request(http.MethodGet, "/api/documents/doc", "", &doc); code != http.StatusNotFound {
+		t.Errorf("deleted document status = %d, want 404", code)
+	}
+}
+
+// fakeRedis serves the few commands the Redis client sends from a map,
+// recording each key's PX expiry
+type fakeRedis struct {
+	mu     sync.Mutex
+	values map[string]string
+	expiry map[string]string
+}
+
+// startFakeRedis listens on a local port for Redis connections
+func startFakeRedis(t *testing.T) (*fakeRedis, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &fakeRedis{values: make(map[string]string), expiry: make(map[string]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+	return server, listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		args := make([]string, n)
+		for i := range args {
+			header, _ := reader.ReadString('\n')
+			size, _ := strconv.Atoi(strings.TrimSpace(header[1:]))
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(reader, data); err != nil {
+				return
+			}
+			args[i] = string(data[:size])
+		}
+		conn.Write([]byte(f.reply(args)))
+	}
+}
+
+func (f *fakeRedis) reply(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	bulk := func(s string) string { return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s) }
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "GET":
+		if value, ok := f.values[args[1]]; ok {
+			return bulk(value)
+		}
+		return "$-1\r\n"
+	case "SET":
+		f.values[args[1]] = args[2]
+		f.expiry[args[1]] = ""
+		if len(args) == 5 {
+			f.expiry[args[1]] = args[4]
+		}
+		return "+OK\r\n"
+	case "DEL":
+		_, ok := f.values[args[1]]
+		delete(f.values, args[1])
+		if ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "SCAN":
+		prefix := strings.TrimSuffix(args[3], "*")
+		var keys []string
+		for key := range f.values {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, bulk(key))
+			}
+		}
+		return fmt.Sprintf("*2\r\n%s*%d\r\n%s", bulk("0"), len(keys), strings.Join(keys, ""))
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestRedisBackend(t *testing.T) {
+	server, addr := startFakeRedis(t)
+	client := redis.New(configs.RedisConfig{Addr: addr, KeyPrefix: "test:"})
+	if err := client.Ping(); err != nil {
+		t.Fatalf("ping = %v", err)
+	}
+
+	docs := documents.NewStore(configs.DocumentsConfig{TTLSeconds: 60}, documents.NewRedisBackend(client))
+	title, content, ttl := "Notes", "# Title\n", 5
+	if _, err := docs.Create(models.DocumentRequest{ID: "a", Title: &title, Content: &content}); err != nil {
+		t.Fatalf("create = %v", err)
+	}
+	doc, err := docs.Create(models.DocumentRequest{ID: "b", TTLSeconds: &ttl})
+	if err != nil || doc.ExpiresAt == nil {
+		t.Fatalf("create with TTL = %+v, %v", doc, err)
+	}
+	if _, err := docs.Create(models.DocumentRequest{ID: "a"}); !errors.Is(err, documents.ErrDocumentExists) {
+		t.Errorf("create taken ID error = %v", err)
+	}
+
+	// Documents expire after their own lifetime, or the store's
+	server.mu.Lock()
+	if server.expiry["test:doc:a"] != "60000" || server.expiry["test:doc:b"] != "5000" {
+		t.Errorf("expiries = %v", server.expiry)
+	}
+	server.mu.Unlock()
+
+	if revision, err := docs.SaveContent("a", "# Title\n\nMore\n"); err != nil || revision != 2 {
+		t.Errorf("save content = %d, %v", revision, err)
+	}
+	if _, err := docs.SaveContent("c", "# Unowned\n"); !errors.Is(err, documents.ErrUnknownDocument) {
+		t.Errorf("save content of an unknown document error = %v, want %v", err, documents.ErrUnknownDocument)
+	}
+	doc, err = docs.Get("a")
+	if err != nil || doc.Content != "# Title\n\nMore\n" || doc.Revision != 2 || doc.Title != "Notes" {
+		t.Errorf("saved document = %+v, %v", doc, err)
+	}
+	list, err := docs.List()
+	if err != nil || len(list) != 2 || list[0].ID != "a" || list[0].Content != "" {
+		t.Errorf("documents = %+v, %v", list, err)
+	}
+	if err := docs.Delete("b"); err != nil {
+		t.Errorf("delete = %v", err)
+	}
+	if _, err := docs.Get("b"); !errors.Is(err, documents.ErrUnknownDocument) {
+		t.Errorf("deleted document error = %v", err)
+	}
+
+	// A parser reads results another cached, here replaced to tell them apart
+	config := configs.DefaultConfig().Parser
+	config.ParseCacheSize = 0
+	first := parser.NewMarkdownParserWithConfig(config)
+	first.SetSharedCache(client.Namespace("parse"))
+	if _, err := first.ParseWithOptions("# Shared\n", parser.ParseOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	for key := range server.values {
+		if strings.HasPrefix(key, "test:parse:") {
+			server.values[key] = `{"html": "<p>from redis</p>", "success": true}`
+		}
+	}
+	server.mu.Unlock()
+	second := parser.NewMarkdownParserWithConfig(config)
+	second.SetSharedCache(client.Namespace("parse"))
+	response, err := second.ParseWithOptions("# Shared\n", parser.ParseOptions{})
+	if err != nil || response.HTML != "<p>from redis</p>" {
+		t.Errorf("shared parse = %+v, %v", response, err)
+	}
+}
+
+func TestImportDocuments(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.Parser.MaxBatchSize = 4
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupDocumentRoutes(router, config, docs, nil)
+
+	var archive bytes.Buffer
+	zipped := zip.NewWriter(&archive)
+	for name, content := range map[string]string{
+		"notes/a.md":            "# A\n",
+		"notes/b.txt":           "plain",
+		"notes/image.png":       "\x89PNG",
+		"__MACOSX/notes/._a.md": "metadata",
+	} {
+		w, err := zipped.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, content)
+	}
+	zipped.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, file := range []struct{ field, name, content string }{
+		{"a", "Readme.md", "# Hello\n\nWorld\n"},
+		{"b", "photo.png", "\x89PNG"},
+		{"c", "notes.zip", archive.String()},
+	} {
+		w, err := form.CreateFormFile(file.field, file.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, file.content)
+	}
+	form.Close()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/documents/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	router.ServeHTTP(recorder, req)
+	var response models.ImportResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusCreated {
+		t.Fatalf("import = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	imported := make(map[string]models.ImportedFile)
+	for _, file := range response.Files {
+		imported[file.File] = file
+	}
+	if len(response.Files) != 4 {
+		t.Errorf("imported files = %+v, want Readme.md, photo.png, and the archive's two documents", response.Files)
+	}
+	readme := imported["Readme.md"]
+	if readme.ID == "" || readme.Title != "Readme" || readme.Result == nil || !strings.Contains(readme.Result.HTML, "<h1") {
+		t.Errorf("Readme.md = %+v", readme)
+	}
+	if doc, err := docs.Get(readme.ID); err != nil || doc.Content != "# Hello\n\nWorld\n" {
+		t.Errorf("imported document = %+v, %v", doc, err)
+	}
+	if imported["photo.png"].Error == "" || imported["photo.png"].ID != "" {
+		t.Errorf("photo.png = %+v, want it rejected", imported["photo.png"])
+	}
+	if imported["notes/a.md"].ID == "" || imported["notes/b.txt"].Title != "b" {
+		t.Errorf("archive files = %+v", response.Files)
+	}
+
+	// A request importing nothing fails
+	body.Reset()
+	form = multipart.NewWriter(&body)
+	w, _ := form.CreateFormFile("file", "photo.png")
+	io.WriteString(w, "\x89PNG")
+	form.Close()
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/documents/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	router.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("import of no documents status = %d, want 422", recorder.Code)
+	}
+
+	// Archives' files count against the batch limit, and an upload over
+	// it imports nothing
+	before, _ := docs.List()
+	body.Reset()
+	form = multipart.NewWriter(&body)
+	for _, name := range []string{"one.zip", "two.zip", "Readme.md"} {
+		w, _ := form.CreateFormFile("file", name)
+		io.WriteString(w, archive.String())
+	}
+	form.Close()
+	recorder = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/api/documents/import", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	router.ServeHTTP(recorder, req)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusRequestEntityTooLarge ||
+		response.Error != "Upload of 5 files exceeds the limit of 4" {
+		t.Errorf("import over the batch limit = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if after, _ := docs.List(); len(after) != len(before) {
+		t.Errorf("import over the batch limit created %d documents", len(after)-len(before))
+	}
+}
+
+func TestExportDocument(t *testing.T) {
+	config := configs.DefaultConfig()
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupDocumentRoutes(router, config, docs, nil)
+
+	title, content := "My Notes", "# Hello\n\nSome *text* (with parens).\n"
+	if _, err := docs.Create(models.DocumentRequest{ID: "doc", Title: &title, Content: &content}); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(query string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/documents/doc/export"+query, nil))
+		return recorder
+	}
+
+	md := export("")
+	if md.Code != http.StatusOK || md.Body.String() != content || md.Header().Get("Content-Type") != "text/markdown; charset=utf-8" {
+		t.Errorf("markdown export = %d %q %v", md.Code, md.Body.String(), md.Header())
+	}
+	if disposition := md.Header().Get("Content-Disposition"); disposition != "attachment; filename=My-Notes.md" {
+		t.Errorf("Content-Disposition = %q", disposition)
+	}
+
+	page := export("?format=html")
+	if !strings.Contains(page.Body.String(), "<title>My Notes</title>") || !strings.Contains(page.Body.String(), `<h1 id="hello">Hello</h1>`) {
+		t.Errorf("html export = %s", page.Body.String())
+	}
+
+	document := export("?format=pdf")
+	if !strings.HasPrefix(document.Body.String(), "%PDF-") || !strings.Contains(document.Body.String(), `Some text \(with parens\).`) {
+		t.Errorf("pdf export = %q", document.Body.String())
+	}
+	if disposition := document.Header().Get("Content-Disposition"); disposition != "attachment; filename=My-Notes.pdf" {
+		t.Errorf("pdf Content-Disposition = %q", disposition)
+	}
+
+	if code := export("?format=docx").Code; code != http.StatusBadRequest {
+		t.Errorf("unknown format status = %d, want 400", code)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/documents/missing/export", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("missing document status = %d, want 404", recorder.Code)
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.Parser.MaxBatchSize = 3
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(2, 4))
+
+	batch := func(body string) (int, models.BatchParseResponse) {
+		t.Helper()
+		var response models.BatchParseResponse
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/parse/batch", strings.NewReader(body)))
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("batch response = %s, error = %v", recorder.Body.String(), err)
+		}
+		return recorder.Code, response
+	}
+
+	code, response := batch(`{"documents": [
+		{"id": "a", "content": "# A"},
+		{"id": "b", "content": "*b*", "format": "text"},
+		{"id": "c", "content": "c", "dialect": "unknown"}
+	]}`)
+	if code != http.StatusOK || len(response.Results) != 3 {
+		t.Fatalf("batch = %d %+v", code, response)
+	}
+	if r := response.Results[0]; r.ID != "a" || !r.Success || r.HTML != "<h1 id=\"a\">A</h1>\n" {
+		t.Errorf("result a = %+v", r)
+	}
+	if r := response.Results[1]; r.ID != "b" || r.Output != "b" {
+		t.Errorf("result b = %+v", r)
+	}
+	if r := response.Results[2]; r.ID != "c" || (r.Success != (r.Error == "")) {
+		t.Errorf("result c = %+v", r)
+	}
+
+	if code, _ := batch(`{"documents": [{"id": "a", "content": "a"}, {"id": "b", "content": "b"}, {"id": "c", "content": "c"}, {"id": "d", "content": "d"}]}`); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized batch status = %d, want 413", code)
+	}
+	if code, _ := batch(`{"documents": [{"id": "a"}]}`); code != http.StatusBadRequest {
+		t.Errorf("batch without content status = %d, want 400", code)
+	}
+}
+
+func TestDocumentEvents(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.Documents.AutosaveMillis = 0
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	hub.SetDocumentStore(docs)
+	go hub.Run()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupDocumentRoutes(router, config, docs, hub)
+	api.SetupEventRoutes(router, config, hub)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	content := "# Title\n\nBody\n"
+	if _, err := docs.Create(models.DocumentRequest{ID: "doc", Content: &content}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := http.Get(server.URL + "/api/documents/missing/events"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("events of a missing document = %v, %v", resp, err)
+	}
+
+	resp, err := http.Get(server.URL + "/api/documents/doc/events?types=parsed_incremental,saved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", resp.Header.Get("Content-Type"))
+	}
+
+	// next reads the next event's name and data
+	reader := bufio.NewReader(resp.Body)
+	next := func() (string, string) {
+		t.Helper()
+		var name, data string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("reading events: %v", err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "" && name != "":
+				return name, data
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				data = strings.TrimPrefix(line, "data: ")
+			}
+		}
+	}
+
+	name, data := next()
+	var snapshot models.ParseResponse
+	if err := json.Unmarshal([]byte(data), &snapshot); name != "snapshot" || err != nil || !strings.Contains(snapshot.HTML, "<p>Body</p>") {
+		t.Fatalf("first event = %s %s", name, data)
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/api/documents/doc", strings.NewReader(`{"content": "# Title\n\nChanged\n"}`)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("update = %d %s", recorder.Code, recorder.Body.String())
+	}
+	seen := make(map[string]string)
+	for len(seen) < 2 {
+		name, data := next()
+		seen[name] = data
+	}
+	if !strings.Contains(seen["parsed_incremental"], "Changed") || !strings.Contains(seen["saved"], `"version":2`) {
+		t.Errorf("events = %v", seen)
+	}
+}
+
+// grpcCall makes a gRPC call over cleartext HTTP/2, returning the response
+// messages read before the stream ended, and the call's status
+func grpcCall(t *testing.T, url, method string, request []byte, read func([]byte) bool) string {
+	t.Helper()
+	return grpcCallAs(t, url, "", method, request, read)
+}
+
+// grpcCallAs makes a call with a bearer token, or none when it is empty
+func grpcCallAs(t *testing.T, url, token, method string, request []byte, read func([]byte) bool) string {
+	t.Helper()
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	frame := make([]byte, 5, 5+len(request))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(request)))
+	req, _ := http.NewRequest(http.MethodPost, url+"/markdown.v1.MarkdownParser/"+method, bytes.NewReader(append(frame, request...)))
+	req.Header.Set("Content-Type", "application/grpc")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	for {
+		var prefix [5]byte
+		if _, err := io.ReadFull(resp.Body, prefix[:]); err != nil {
+			break
+		}
+		message := make([]byte, binary.BigEndian.Uint32(prefix[1:]))
+		if _, err := io.ReadFull(resp.Body, message); err != nil {
+			t.Fatalf("%s: reading message: %v", method, err)
+		}
+		if read != nil && !read(message) {
+			return ""
+		}
+	}
+	return resp.Trailer.Get("Grpc-Status")
+}
+
+func TestGRPCService(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.Documents.AutosaveMillis = 0
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	hub.SetDocumentStore(docs)
+	go hub.Run()
+	server := httptest.NewServer(rpc.NewServer(config, workpool.New(1, 4), hub).Handler())
+	defer server.Close()
+
+	var parsed *models.ParseResponse
+	status := grpcCall(t, server.URL, "Parse", rpc.MarshalParseRequest(models.ParseRequest{Content: "# Title\n\n- a\n- b\n"}), func(message []byte) bool {
+		var err error
+		parsed, err = rpc.UnmarshalParseResponse(message)
+		return err == nil
+	})
+	if status != "0" || parsed == nil || !strings.Contains(parsed.HTML, "<h1") || len(parsed.Tree) != 2 || len(parsed.Tree[1].Children) != 2 {
+		t.Fatalf("Parse = %s %+v", status, parsed)
+	}
+
+	edit := models.ParseRequest{Content: "# Title\n\nBody\n", Edit: &models.Edit{Start: 13, End: 13, Text: "!"}, ChangesOnly: true}
+	status = grpcCall(t, server.URL, "ParseIncremental", rpc.MarshalParseIncrementalRequest(edit), func(message []byte) bool {
+		parsed, _ = rpc.UnmarshalParseResponse(message)
+		return true
+	})
+	if status != "0" || len(parsed.Changes) != 2 || parsed.HTML != "" {
+		t.Errorf("ParseIncremental = %s %+v", status, parsed)
+	}
+
+	if status := grpcCall(t, server.URL, "Format", nil, nil); status != "12" {
+		t.Errorf("unknown method status = %s, want 12 (unimplemented)", status)
+	}
+	if status := grpcCall(t, server.URL, "Parse", rpc.MarshalParseRequest(models.ParseRequest{Content: "bad \xff"}), nil); status != "3" {
+		t.Errorf("invalid UTF-8 status = %s, want 3 (invalid argument)", status)
+	}
+	if status := grpcCall(t, server.URL, "StreamDocumentUpdates", rpc.StreamRequest{DocumentID: "missing"}.Marshal(), nil); status != "5" {
+		t.Errorf("stream of a missing document status = %s, want 5 (not found)", status)
+	}
+
+	content := "# Title\n\nBody\n"
+	if _, err := docs.Create(models.DocumentRequest{ID: "doc", Content: &content}); err != nil {
+		t.Fatal(err)
+	}
+	var updates []rpc.DocumentUpdate
+	grpcCall(t, server.URL, "StreamDocumentUpdates", rpc.StreamRequest{DocumentID: "doc", Types: []string{"parsed_incremental"}}.Marshal(), func(message []byte) bool {
+		update, err := rpc.UnmarshalDocumentUpdate(message)
+		if err != nil {
+			t.Errorf("DocumentUpdate: %v", err)
+			return false
+		}
+		updates = append(updates, update)
+		if update.Type == "snapshot" {
+			if err := hub.UpdateDocument("doc", "# Title\n\nChanged\n"); err != nil {
+				t.Errorf("UpdateDocument: %v", err)
+			}
+		}
+		return update.Type == "snapshot"
+	})
+	if len(updates) != 2 || updates[1].Type != "parsed_incremental" || updates[1].Sequence != 1 || !strings.Contains(string(updates[1].Data), "Changed") {
+		t.Errorf("updates = %+v", updates)
+	}
+}
+
+func TestVersionedAPI(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.Parser.MaxContentSize = 16
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+
+	post := func(path, body, requestID string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		if requestID != "" {
+			request.Header.Set(api.RequestIDHeader, requestID)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	recorder := post("/api/v1/parse", `{"content": "# Fits"}`, "")
+	var parsed models.ParseResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &parsed); err != nil || recorder.Code != http.StatusOK || !parsed.Success {
+		t.Fatalf("POST /api/v1/parse = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("Deprecation") != "" || recorder.Header().Get(api.RequestIDHeader) == "" {
+		t.Errorf("v1 headers = %v, want a request ID and no deprecation", recorder.Header())
+	}
+
+	recorder = post("/api/v1/parse", `{"content": "# This heading is too long"}`, "req-42")
+	var failed models.ErrorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &failed); err != nil {
+		t.Fatalf("error response = %s, error = %v", recorder.Body.String(), err)
+	}
+	if recorder.Code != http.StatusRequestEntityTooLarge || failed.Success || failed.Error.Code != "payload_too_large" ||
+		failed.Error.Message == "" || failed.Error.RequestID != "req-42" || recorder.Header().Get(api.RequestIDHeader) != "req-42" {
+		t.Errorf("v1 error = %d %+v", recorder.Code, failed)
+	}
+
+	recorder = post("/api/v1/diff", `not json`, "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &failed); err != nil || recorder.Code != http.StatusBadRequest || failed.Error.Code != "invalid_request" {
+		t.Errorf("v1 bad request = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	// Legacy routes answer as before, marked as deprecated
+	recorder = post("/api/parse", `{"content": "# This heading is too long"}`, "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &parsed); err != nil || recorder.Code != http.StatusRequestEntityTooLarge || parsed.Error == "" {
+		t.Errorf("legacy error = %d %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder.Header().Get("Deprecation") != "true" || recorder.Header().Get("Link") != `</api/v1/parse>; rel="successor-version"` {
+		t.Errorf("legacy headers = %v", recorder.Header())
+	}
+}
+
+func TestParseETag(t *testing.T) {
+	config := configs.DefaultConfig()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+
+	parse := func(body, ifNoneMatch string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/parse", strings.NewReader(body))
+		if ifNoneMatch != "" {
+			request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		router.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	first := parse(`{"content": "# Polled"}`, "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first parse = %d, ETag %q", first.Code, etag)
+	}
+	if again := parse(`{"content": "# Polled"}`, ""); again.Header().Get("ETag") != etag {
+		t.Errorf("ETag of the same parse = %q, want %q", again.Header().Get("ETag"), etag)
+	}
+
+	if unchanged := parse(`{"content": "# Polled"}`, `"other", W/`+etag); unchanged.Code != http.StatusNotModified || unchanged.Body.Len() != 0 {
+		t.Errorf("unchanged parse = %d %q, want 304 without a body", unchanged.Code, unchanged.Body.String())
+	}
+	if edited := parse(`{"content": "# Edited"}`, etag); edited.Code != http.StatusOK || edited.Header().Get("ETag") == etag {
+		t.Errorf("edited parse = %d, ETag %q", edited.Code, edited.Header().Get("ETag"))
+	}
+	if reformatted := parse(`{"content": "# Polled", "format": "text"}`, etag); reformatted.Code != http.StatusOK {
+		t.Errorf("parse with other options = %d, want 200", reformatted.Code)
+	}
+
+	// So do settings of the configuration that requests cannot override
+	base := parser.NewMarkdownParserWithConfig(config.Parser).ETag("# Polled", parser.ParseOptions{})
+	for name, configure := range map[string]func(*configs.ParserConfig){
+		"heading prefix":   func(c *configs.ParserConfig) { c.HeadingIDPrefix = "doc-" },
+		"lint":             func(c *configs.ParserConfig) { c.Lint.MaxLineLength = 10 },
+		"NFC":              func(c *configs.ParserConfig) { c.NormalizeNFC = !c.NormalizeNFC },
+		"sanitize schemes": func(c *configs.ParserConfig) { c.Sanitize.URLSchemes = []string{"https"} },
+	} {
+		changed := config.Parser
+		configure(&changed)
+		if parser.NewMarkdownParserWithConfig(changed).ETag("# Polled", parser.ParseOptions{}) == base {
+			t.Errorf("ETag with another %s = %s, want it to change", name, base)
+		}
+	}
+}
+
+func TestRenderPage(t *testing.T) {
+	config := configs.DefaultConfig()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+
+	recorder := httptest.NewRecorder()
+	body := `{"content": "# Guide\n\n## Install\n\n` + "```go\\nfmt.Println(1)\\n```" + `", "pageTheme": "dark", "toc": true}`
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/render", strings.NewReader(body)))
+	page := recorder.Body.String()
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("POST /api/v1/render = %d %s", recorder.Code, page)
+	}
+	for _, want := range []string{"<!DOCTYPE html>", "<title>Guide</title>", "--bg:#0d1117", `<nav class="toc">`, `href="#install"`, `<h2 id="install">Install</h2>`, "<pre"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("rendered page lacks %q:\n%s", want, page)
+		}
+	}
+	if recorder.Header().Get("Content-Security-Policy") == "" {
+		t.Error("rendered page has no Content-Security-Policy")
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/render?content=%2A%2Ahi%2A%2A&title=Shared", nil))
+	if page := recorder.Body.String(); recorder.Code != http.StatusOK || !strings.Contains(page, "<strong>hi</strong>") ||
+		!strings.Contains(page, "<title>Shared</title>") || strings.Contains(page, `<nav class="toc">`) {
+		t.Errorf("GET /api/v1/render = %d %s", recorder.Code, page)
+	}
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/render?content=x&page_theme=neon", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("unknown page theme status = %d, want 400", recorder.Code)
+	}
+}
+
+func TestSyntaxCheckBody(t *testing.T) {
+	config := configs.DefaultConfig()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+
+	var failed models.ErrorResponse
+	check := func(body string) (int, models.SyntaxCheckResponse) {
+		t.Helper()
+		var response models.SyntaxCheckResponse
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/syntax-check", strings.NewReader(body)))
+		var target interface{} = &response
+		if recorder.Code != http.StatusOK {
+			target = &failed // Errors come in the v1 envelope
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), target); err != nil {
+			t.Fatalf("syntax check response = %s, error = %v", recorder.Body.String(), err)
+		}
+		return recorder.Code, response
+	}
+
+	code, response := check(`{"line": "## Paths like a/b #1"}`)
+	if code != http.StatusOK || response.DetectedType != "h2" || !response.IsBlock || !strings.Contains(response.HTML, "<h2") {
+		t.Errorf("heading check = %d %+v", code, response)
+	}
+
+	tests := []struct {
+		line       string
+		cursor     int
+		completion string
+	}{
+		{"##", 2, " "},
+		{"- [", 3, " ] "},
+		{"```go", 5, "\n```"},
+		{"some **bold", 11, "**"},
+		{"see [[Pa", 8, "]]"},
+		{"**bold** and", 12, ""},
+		{"**bo**", 4, ""},
+	}
+	for _, tt := range tests {
+		body, _ := json.Marshal(models.SyntaxCheckRequest{Line: tt.line, Cursor: &tt.cursor})
+		if _, response := check(string(body)); response.Completion != tt.completion {
+			t.Errorf("completion of %q at %d = %q, want %q", tt.line, tt.cursor, response.Completion, tt.completion)
+		}
+	}
+
+	if code, _ := check(`{"line": "x", "cursor": 5}`); code != http.StatusBadRequest || failed.Error.Code != "invalid_request" ||
+		failed.Error.Message != "Cursor 5 is outside the line (length 1)" {
+		t.Errorf("cursor outside the line = %d %+v, want 400 invalid_request", code, failed.Error)
+	}
+}
+
+func TestDocumentStats(t *testing.T) {
+	p := parser.NewMarkdownParser()
+	content := "# Title\n\nSome words with a [link](https://example.com) and <https://go.dev>.\n\n" +
+		"## Part\n\n![alt](a.png) see [[Other Page]]\n\n```go\nx := 1\n```\n\n## Part two\n"
+	response, err := p.ParseWithOptions(content, parser.ParseOptions{Stats: true})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	stats := response.Stats
+	if stats == nil {
+		t.Fatal("Stats = nil, want statistics")
+	}
+	if stats.Headings[1] != 1 || stats.Headings[2] != 2 || stats.CodeBlocks != 1 || stats.Links != 3 || stats.Images != 1 {
+		t.Errorf("Stats = %+v", stats)
+	}
+	if stats.Words < 10 || stats.Characters < 50 || stats.ReadingMinutes != 1 {
+		t.Errorf("Stats counts = %+v", stats)
+	}
+	if plain, _ := p.Parse(content); plain.Stats != nil {
+		t.Errorf("Stats without the option = %+v, want nil", plain.Stats)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, configs.DefaultConfig(), workpool.New(1, 1))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/stats", strings.NewReader(`{"content": "one two three"}`)))
+	var stated models.StatsResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stated); err != nil || recorder.Code != http.StatusOK || stated.Stats == nil || stated.Stats.Words != 3 {
+		t.Errorf("POST /api/v1/stats = %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestLint(t *testing.T) {
+	content := strings.Join([]string{
+		"# Title",
+		"",
+		"### Skipped",
+		"",
+		"Visit https://example.com today.   ",
+		"",
+		"![](pic.png)",
+		"",
+		"# Title",
+		"",
+		"Hard break  ",
+		"next",
+		"",
+		strings.TrimSpace(strings.Repeat("word ", 30)),
+		"",
+		"```",
+		strings.TrimSpace(strings.Repeat("code ", 30)),
+		"```",
+		"",
+		"<https://ok.example> and ![alt](a.png)",
+	}, "\n")
+
+	config := configs.DefaultConfig()
+	p := parser.NewMarkdownParserWithConfig(config.Parser)
+	response, err := p.ParseWithOptions(content, parser.ParseOptions{Lint: true})
+	if err != nil {
+		t.Fatalf("ParseWithOptions() error = %v", err)
+	}
+	got := make([]string, len(response.Warnings))
+	for i, warning := range response.Warnings {
+		got[i] = fmt.Sprintf("%s@%d", warning.Rule, warning.Line)
+	}
+	want := []string{"heading-increment@3", "bare-url@5", "trailing-spaces@5", "missing-alt-text@7", "duplicate-heading@9", "line-length@14"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Warnings = %v, want %v", got, want)
+	}
+
+	config.Parser.Lint.Rules = map[string]bool{parser.LintBareURL: false, parser.LintTrailingSpaces: false}
+	response, _ = parser.NewMarkdownParserWithConfig(config.Parser).ParseWithOptions(content, parser.ParseOptions{Lint: true})
+	for _, warning := range response.Warnings {
+		if warning.Rule == parser.LintBareURL || warning.Rule == parser.LintTrailingSpaces {
+			t.Errorf("disabled rule warned: %+v", warning)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, configs.DefaultConfig(), workpool.New(1, 1))
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/lint", strings.NewReader(`{"content": "# Clean\n\nNothing to see.\n"}`)))
+	var linted models.LintResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &linted); err != nil || recorder.Code != http.StatusOK || linted.Warnings == nil || len(linted.Warnings) != 0 {
+		t.Errorf("POST /api/v1/lint = %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestCheckLinks(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/get-only":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer site.Close()
+
+	content := "# Intro\n\n## Set up\n\n" +
+		"[ok](" + site.URL + "/ok), [get](" + site.URL + "/get-only), [gone](" + site.URL + "/gone)\n\n" +
+		"[setup](#set-up) [missing](#nowhere) [mail](mailto:a@example.com)\n\n" +
+		"![logo](" + site.URL + "/ok)\n"
+
+	check := func(config *configs.Config, body string) models.LinkCheckResponse {
+		t.Helper()
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		api.SetupRoutes(router, config, workpool.New(1, 1))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/check-links", strings.NewReader(body)))
+		var response models.LinkCheckResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK {
+			t.Fatalf("POST /api/v1/check-links = %d %s", recorder.Code, recorder.Body.String())
+		}
+		return response
+	}
+	body, _ := json.Marshal(models.LinkCheckRequest{Content: content})
+
+	config := configs.DefaultConfig()
+	config.LinkCheck.AllowPrivate = true
+	response := check(config, string(body))
+	got := make([]string, len(response.Links))
+	for i, link := range response.Links {
+		got[i] = fmt.Sprintf("%s:%s:%s", link.Text, link.Kind, link.Status)
+	}
+	want := []string{"ok:external:ok", "get:external:ok", "gone:external:broken", "setup:anchor:ok", "missing:anchor:broken", "mail:other:skipped", "logo:image:ok"}
+	if strings.Join(got, " ") != strings.Join(want, " ") || response.Broken != 2 {
+		t.Errorf("links = %v (%d broken), want %v", got, response.Broken, want)
+	}
+	if response.Links[0].Line != 5 || response.Links[2].StatusCode != http.StatusNotFound {
+		t.Errorf("link details = %+v", response.Links)
+	}
+
+	// By default the checker keeps off the server's own network
+	response = check(configs.DefaultConfig(), string(body))
+	if link := response.Links[0]; link.Status != "broken" || !strings.Contains(link.Error, "private") {
+		t.Errorf("link to a private address = %+v, want refused", link)
+	}
+
+	body, _ = json.Marshal(models.LinkCheckRequest{Content: content, SkipExternal: true})
+	if response := check(config, string(body)); response.Links[0].Status != "skipped" || response.Broken != 1 {
+		t.Errorf("skipped external links = %+v", response)
+	}
+}
+
+func TestOutline(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, configs.DefaultConfig(), workpool.New(1, 1))
+
+	content := "# Guide\n\n## Install\n\n### Linux\n\n#### Debian\n\n## Use\n\n# Appendix\n\n### Skipped level\n"
+	body, _ := json.Marshal(models.ParseRequest{Content: content})
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/outline", bytes.NewReader(body)))
+	var response models.OutlineResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("POST /api/v1/outline = %d %s", recorder.Code, recorder.Body.String())
+	}
+
+	var describe func(headings []*models.OutlineHeading) string
+	describe = func(headings []*models.OutlineHeading) string {
+		parts := make([]string, len(headings))
+		for i, h := range headings {
+			parts[i] = h.Text
+			if len(h.Children) > 0 {
+				parts[i] += "(" + describe(h.Children) + ")"
+			}
+		}
+		return strings.Join(parts, " ")
+	}
+	if got, want := describe(response.Outline), "Guide(Install(Linux(Debian)) Use) Appendix(Skipped level)"; got != want {
+		t.Errorf("outline = %s, want %s", got, want)
+	}
+	install := response.Outline[0].Children[0]
+	if install.Slug != "install" || install.Level != 2 || install.BlockID == "" || install.Position.Line != 3 {
+		t.Errorf("Install heading = %+v", install)
+	}
+}
+
+func TestRenderBlock(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	content := "# Title\n\nSome *text*\n\n# Title\n"
+	if _, err := docs.Create(models.DocumentRequest{ID: "doc", Content: &content}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupDocumentRoutes(router, config, docs, nil)
+	parsed, err := parser.NewMarkdownParserWithConfig(config.Parser).Parse(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	paragraph, repeated := parsed.Tree[1], parsed.Tree[2]
+
+	var failed models.ErrorResponse
+	fetch := func(blockID string) (int, models.BlockResponse) {
+		t.Helper()
+		var response models.BlockResponse
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/documents/doc/blocks/"+blockID, nil))
+		var target interface{} = &response
+		if recorder.Code != http.StatusOK {
+			target = &failed // Errors come in the v1 envelope
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), target); err != nil {
+			t.Fatalf("block response = %s, error = %v", recorder.Body.String(), err)
+		}
+		return recorder.Code, response
+	}
+	if code, response := fetch(paragraph.ID); code != http.StatusOK || response.Block.HTML != "<p>Some <em>text</em></p>\n" {
+		t.Errorf("paragraph block = %d %+v", code, response.Block)
+	}
+	if _, response := fetch(repeated.ID); !strings.Contains(response.Block.HTML, `id="`+repeated.Slug+`"`) || repeated.Slug == "title" {
+		t.Errorf("repeated heading = %+v, want its own anchor %q", response.Block, repeated.Slug)
+	}
+	if code, _ := fetch("missing"); code != http.StatusNotFound || failed.Error.Code != "not_found" || failed.Error.Message != "Unknown block: missing" {
+		t.Errorf("unknown block = %d %+v, want 404 not_found", code, failed.Error)
+	}
+
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	hub.SetDocumentStore(docs)
+	go hub.Run()
+	client, _ := dialHub(t, hub)
+	client.send(t, models.WebSocketMessage{Type: "render_block", DocumentID: "doc", BlockID: paragraph.ID})
+	var block models.Block
+	client.next(t, "block_rendered", &block)
+	if block.ID != paragraph.ID || block.HTML != "<p>Some <em>text</em></p>\n" {
+		t.Errorf("render_block = %+v", block)
+	}
+	client.send(t, models.WebSocketMessage{Type: "render_block", DocumentID: "doc", BlockID: "missing"})
+	client.next(t, "error", nil)
+}
+
+func TestBlockPatch(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	content := "Intro text\n\n# Old\n\n- one\n- two\n"
+	if _, err := docs.Create(models.DocumentRequest{ID: "doc", Content: &content}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupDocumentRoutes(router, config, docs, nil)
+	parsed, err := parser.NewMarkdownParserWithConfig(config.Parser).Parse(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intro, heading, list := parsed.Tree[0], parsed.Tree[1], parsed.Tree[2]
+
+	var failed models.ErrorResponse
+	patch := func(ops ...models.BlockOperation) (int, models.BlockPatchResponse) {
+		t.Helper()
+		body, _ := json.Marshal(models.BlockPatchRequest{Operations: ops})
+		var response models.BlockPatchResponse
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPatch, "/api/v1/documents/doc/blocks", bytes.NewReader(body)))
+		var target interface{} = &response
+		if recorder.Code != http.StatusOK {
+			target = &failed // Errors come in the v1 envelope
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), target); err != nil {
+			t.Fatalf("patch response = %s, error = %v", recorder.Body.String(), err)
+		}
+		return recorder.Code, response
+	}
+
+	code, response := patch(
+		models.BlockOperation{Type: "update_block", BlockID: heading.ID, Content: "# New"},
+		models.BlockOperation{Type: "move_block", BlockID: list.ID},
+		models.BlockOperation{Type: "convert_block", BlockID: intro.ID, BlockType: "h2"},
+	)
+	if code != http.StatusOK || !response.Success || len(response.Changes) == 0 {
+		t.Fatalf("patch = %d %+v", code, response)
+	}
+	list1, intro1, new1 := strings.Index(response.Markdown, "one"), strings.Index(response.Markdown, "## Intro text"), strings.Index(response.Markdown, "# New")
+	if list1 < 0 || intro1 < list1 || new1 < intro1 || strings.Contains(response.Markdown, "Old") {
+		t.Errorf("patched markdown = %q", response.Markdown)
+	}
+	if saved, _ := docs.Get("doc"); saved.Content != response.Markdown {
+		t.Errorf("saved content = %q, want %q", saved.Content, response.Markdown)
+	}
+
+	// A failing operation leaves the document as it was
+	before, _ := docs.Get("doc")
+	if code, _ := patch(
+		models.BlockOperation{Type: "delete_block", BlockID: list.ID},
+		models.BlockOperation{Type: "convert_block", BlockID: "missing", BlockType: "paragraph"},
+	); code != http.StatusUnprocessableEntity || failed.Error.Code != "unprocessable" ||
+		!strings.HasPrefix(failed.Error.Message, "Failed to patch blocks: ") || !strings.Contains(failed.Error.Message, "missing") {
+		t.Errorf("invalid patch = %d %+v, want 422 unprocessable", code, failed.Error)
+	}
+	if after, _ := docs.Get("doc"); after.Content != before.Content {
+		t.Errorf("invalid patch changed the document to %q", after.Content)
+	}
+
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	hub.SetDocumentStore(docs)
+	go hub.Run()
+	client, _ := dialHub(t, hub)
+	current, err := parser.NewMarkdownParserWithConfig(config.Parser).Parse(before.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.send(t, models.WebSocketMessage{Type: "patch_blocks", DocumentID: "doc", BlockOperations: []models.BlockOperation{
+		{Type: "convert_block", BlockID: current.Tree[0].ID, BlockType: "ordered_list"},
+	}})
+	var event models.BlockPatchEvent
+	client.next(t, "blocks_patched", &event)
+	if !strings.HasPrefix(event.Markdown, "1. one\n2. two\n") || len(event.Changes) == 0 {
+		t.Errorf("patch_blocks = %+v", event)
+	}
+}
+
+func TestLocate(t *testing.T) {
+	config := configs.DefaultConfig()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+
+	content := "# Título\n\n> quoted *text*\n\nlast"
+	var failed models.ErrorResponse
+	locate := func(req models.LocateRequest) (int, models.LocateResponse) {
+		t.Helper()
+		req.Content = content
+		body, _ := json.Marshal(req)
+		var response models.LocateResponse
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/locate", bytes.NewReader(body)))
+		var target interface{} = &response
+		if recorder.Code != http.StatusOK {
+			target = &failed // Errors come in the v1 envelope
+		}
+		if err := json.Unmarshal(recorder.Body.Bytes(), target); err != nil {
+			t.Fatalf("locate response = %s, error = %v", recorder.Body.String(), err)
+		}
+		return recorder.Code, response
+	}
+	offset := func(n int) *int { return &n }
+
+	quote := strings.Index(content, "quoted")
+	if code, response := locate(models.LocateRequest{Offset: offset(quote)}); code != http.StatusOK || len(response.Path) != 2 ||
+		response.Path[0].Type != "blockquote" || response.Type != "paragraph" || response.Position.Start > quote {
+		t.Errorf("caret in quote = %d %+v", code, response)
+	}
+	if _, response := locate(models.LocateRequest{Line: 1, Column: 9}); response.Type != "h1" || response.Offset != strings.Index(content, "\n") {
+		t.Errorf("caret at end of heading = %+v", response)
+	}
+	if _, response := locate(models.LocateRequest{Offset: offset(8), Unit: "character"}); response.Offset != 9 || response.Type != "h1" {
+		t.Errorf("character offset = %+v, want byte offset 9", response)
+	}
+	if _, response := locate(models.LocateRequest{Line: 2}); response.BlockID != "" || len(response.Path) != 0 {
+		t.Errorf("caret between blocks = %+v, want no block", response)
+	}
+	if _, response := locate(models.LocateRequest{Offset: offset(len(content))}); response.Type != "paragraph" {
+		t.Errorf("caret at end of content = %+v", response)
+	}
+	if code, _ := locate(models.LocateRequest{Offset: offset(len(content) + 1)}); code != http.StatusBadRequest || failed.Error.Code != "invalid_request" ||
+		failed.Error.Message != fmt.Sprintf("Invalid caret: offset %d is outside the content's %d bytes", len(content)+1, len(content)) {
+		t.Errorf("offset past the end = %d %+v, want 400 invalid_request", code, failed.Error)
+	}
+	if code, _ := locate(models.LocateRequest{Line: 9}); code != http.StatusBadRequest || failed.Error.Code != "invalid_request" ||
+		!strings.HasPrefix(failed.Error.Message, "Invalid caret: line 9 is past the end") {
+		t.Errorf("line past the end = %d %+v, want 400 invalid_request", code, failed.Error)
+	}
+}
+
+func TestSanitizeConfig(t *testing.T) {
+	source := "<iframe src=\"https://www.youtube.com/embed/abc\" width=\"560\" onload=\"steal()\"></iframe>\n\n" +
+		"<iframe src=\"https://www.youtube.com.evil.example/\"></iframe>\n\n" +
+		"<details><summary>More</summary></details>\n\n[call](tel:123)\n"
+
+	config := configs.DefaultConfig().Parser
+	result, err := parser.NewMarkdownParserWithConfig(config).Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(result.HTML, "<iframe") || strings.Contains(result.HTML, "tel:") {
+		t.Errorf("default policy HTML = %v, want iframes and tel links removed", result.HTML)
+	}
+
+	config.Sanitize = configs.SanitizeConfig{
+		Elements:    []string{"details", "summary"},
+		Attributes:  map[string][]string{"details": {"open"}},
+		URLSchemes:  []string{"tel"},
+		IframeHosts: []string{"www.youtube.com"},
+	}
+	result, err = parser.NewMarkdownParserWithConfig(config).Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<iframe src="https://www.youtube.com/embed/abc" width="560">`, "<details><summary>More</summary></details>", `href="tel:123"`} {
+		if !strings.Contains(result.HTML, want) {
+			t.Errorf("configured policy HTML = %v, want %s", result.HTML, want)
+		}
+	}
+	if strings.Contains(result.HTML, "steal") || strings.Contains(result.HTML, "evil.example") {
+		t.Errorf("configured policy HTML = %v, want event handlers and other hosts removed", result.HTML)
+	}
+
+	strict, err := parser.NewMarkdownParserWithConfig(config).ParseWithOptions(source, parser.ParseOptions{SanitizePolicy: parser.SanitizeStrict})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(strict.HTML, "<iframe") {
+		t.Errorf("strict policy HTML = %v, want the configured markup removed too", strict.HTML)
+	}
+}
+
+func TestAPIKeyLimits(t *testing.T) {
+	hash := sha256.Sum256([]byte("limited-key"))
+	config := configs.DefaultConfig()
+	config.Auth = configs.AuthConfig{
+		Enabled: true,
+		APIKeys: []configs.APIKeyConfig{
+			{KeyHash: hex.EncodeToString(hash[:]), UserID: "limited", RequestsPerMinute: 2},
+			{Key: "open-key", UserID: "open"},
+		},
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupDocumentRoutes(router, config, documents.NewStore(config.Documents, documents.NewMemoryBackend()), nil)
+
+	request := func(method, path, key string) *httptest.ResponseRecorder {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"content": "# Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(auth.APIKeyHeader, key)
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// The limit covers every route, not each group of them
+	if code := request(http.MethodPost, "/api/v1/parse", "limited-key").Code; code != http.StatusOK {
+		t.Fatalf("first request status = %d, want 200", code)
+	}
+	if code := request(http.MethodGet, "/api/v1/documents", "limited-key").Code; code != http.StatusOK {
+		t.Fatalf("second request status = %d, want 200", code)
+	}
+	limited := request(http.MethodPost, "/api/v1/parse", "limited-key")
+	if limited.Code != http.StatusTooManyRequests || limited.Header().Get("Retry-After") == "" {
+		t.Errorf("request over the limit = %d, Retry-After %q; want 429 with Retry-After", limited.Code, limited.Header().Get("Retry-After"))
+	}
+	if code := request(http.MethodPost, "/api/v1/parse", "open-key").Code; code != http.StatusOK {
+		t.Errorf("request with another key status = %d, want 200", code)
+	}
+
+	var response models.APIKeyUsageResponse
+	if err := json.Unmarshal(request(http.MethodGet, "/api/v1/usage", "open-key").Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Usage == nil || response.Usage.UserID != "open" || response.Usage.Requests != 2 || response.Usage.LastUsed == nil {
+		t.Errorf("usage = %+v, want the open key's 2 requests", response.Usage)
+	}
+
+	usage := api.Authenticator(config).Usage()
+	if len(usage) != 2 || usage[0].Requests != 2 || usage[0].RateLimited != 1 || usage[0].RequestsPerMinute != 2 {
+		t.Errorf("key usage = %+v, want 2 requests and 1 rate limited for the limited key", usage)
+	}
+
+	// WebSocket connections and gRPC calls count against the same keys
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	hub.SetAuthenticator(api.Authenticator(config))
+	go hub.Run()
+	client, _ := dialHub(t, hub)
+	client.send(t, models.WebSocketMessage{Type: "auth", Token: "open-key"})
+	client.next(t, "authenticated", nil)
+	client.send(t, models.WebSocketMessage{Type: "auth", Token: "limited-key"})
+	client.next(t, "error", nil)
+
+	grpcService := rpc.NewServer(config, workpool.New(1, 4), hub)
+	grpcService.SetAuthenticator(api.Authenticator(config))
+	server := httptest.NewServer(grpcService.Handler())
+	defer server.Close()
+	parse := rpc.MarshalParseRequest(models.ParseRequest{Content: "# Hi"})
+	if status := grpcCallAs(t, server.URL, "open-key", "Parse", parse, nil); status != "0" {
+		t.Errorf("gRPC call status = %s, want 0", status)
+	}
+	if status := grpcCallAs(t, server.URL, "limited-key", "Parse", parse, nil); status != "8" {
+		t.Errorf("gRPC call over the limit status = %s, want 8 (resource exhausted)", status)
+	}
+
+	usage = api.Authenticator(config).Usage()
+	if usage[0].RateLimited != 3 || usage[1].Requests != 4 {
+		t.Errorf("key usage = %+v, want the WebSocket and gRPC requests counted", usage)
+	}
+}
+
+func TestDocumentPermissions(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	config.Auth = configs.AuthConfig{
+		Enabled:   true,
+		APIKeys:   []configs.APIKeyConfig{{Key: "admin-key", UserID: "ops", Roles: []string{auth.RoleAdmin}}},
+		JWTSecret: "jwt-secret",
+	}
+	hour := time.Now().Add(time.Hour).Unix()
+	token := func(user string, roles ...string) string {
+		return signJWT("jwt-secret", map[string]interface{}{"sub": user, "roles": roles, "exp": hour})
+	}
+	alice, bob, carol, dave := token("alice"), token("bob"), token("carol"), token("dave", "editor")
+
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupDocumentRoutes(router, config, docs, nil)
+
+	request := func(method, path, token, body string) int {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+
+	created := `{"id": "doc", "content": "# Plan", "permissions": {"read": ["bob"], "write": ["role:editor"]}}`
+	if code := request(http.MethodPost, "/api/v1/documents", alice, created); code != http.StatusCreated {
+		t.Fatalf("create status = %d, want 201", code)
+	}
+	if doc, _ := docs.Get("doc"); doc.Owner != "alice" {
+		t.Errorf("owner = %q, want alice", doc.Owner)
+	}
+
+	tests := []struct {
+		name         string
+		method, path string
+		token, body  string
+		want         int
+	}{
+		{"reader reads", http.MethodGet, "/api/v1/documents/doc", bob, "", http.StatusOK},
+		{"reader reads outline", http.MethodGet, "/api/v1/documents/doc/outline", bob, "", http.StatusOK},
+		{"reader writes", http.MethodPut, "/api/v1/documents/doc", bob, `{"content": "# Mine"}`, http.StatusForbidden},
+		{"stranger reads", http.MethodGet, "/api/v1/documents/doc", carol, "", http.StatusForbidden},
+		{"stranger renders", http.MethodGet, "/api/v1/render?document=doc", carol, "", http.StatusForbidden},
+		{"writer by role writes", http.MethodPut, "/api/v1/documents/doc", dave, `{"content": "# Plan\n\nDraft"}`, http.StatusOK},
+		{"writer changes permissions", http.MethodPut, "/api/v1/documents/doc", dave, `{"permissions": {"write": ["*"]}}`, http.StatusForbidden},
+		{"writer deletes", http.MethodDelete, "/api/v1/documents/doc", dave, "", http.StatusForbidden},
+		{"admin reads", http.MethodGet, "/api/v1/documents/doc", "admin-key", "", http.StatusOK},
+		{"owner changes permissions", http.MethodPut, "/api/v1/documents/doc", alice, `{"permissions": {"read": ["bob"], "write": ["role:editor"]}}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := request(tt.method, tt.path, tt.token, tt.body); code != tt.want {
+			t.Errorf("%s: %s %s status = %d, want %d", tt.name, tt.method, tt.path, code, tt.want)
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	list := httptest.NewRequest(http.MethodGet, "/api/v1/documents", nil)
+	list.Header.Set("Authorization", "Bearer "+carol)
+	router.ServeHTTP(recorder, list)
+	var listed models.DocumentsResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &listed); err != nil || len(listed.Documents) != 0 {
+		t.Errorf("stranger's document list = %s, want the document left out", recorder.Body.String())
+	}
+
+	// Readers receive broadcasts but may not publish edits
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	hub.SetDocumentStore(docs)
+	go hub.Run()
+	reader, dial := dialHub(t, hub)
+	reader.send(t, models.WebSocketMessage{Type: "auth", Token: bob})
+	reader.next(t, "authenticated", nil)
+	reader.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	reader.next(t, "subscribed", nil)
+	reader.send(t, models.WebSocketMessage{Type: "insert_block", DocumentID: "doc", Content: "Sneaky"})
+	reader.next(t, "error", nil)
+
+	writer := dial()
+	writer.send(t, models.WebSocketMessage{Type: "auth", Token: dave})
+	writer.next(t, "authenticated", nil)
+	writer.send(t, models.WebSocketMessage{Type: "insert_block", DocumentID: "doc", Content: "Shared"})
+	writer.next(t, "block_operation", nil)
+	var event models.BlockOperationEvent
+	reader.next(t, "block_operation", &event)
+	if event.Operation.Content != "Shared" {
+		t.Errorf("reader's broadcast = %+v, want the writer's insert", event)
+	}
+
+	stranger := dial()
+	stranger.send(t, models.WebSocketMessage{Type: "auth", Token: carol})
+	stranger.next(t, "authenticated", nil)
+	stranger.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	stranger.next(t, "error", nil)
+	stranger.send(t, models.WebSocketMessage{Type: "resume", DocumentID: "doc"})
+	stranger.next(t, "error", nil)
+	stranger.send(t, models.WebSocketMessage{Type: "unknown", DocumentID: "doc"})
+	stranger.next(t, "error", nil)
+	stranger.send(t, models.WebSocketMessage{Type: "parse", Content: "# Still parses"})
+	stranger.next(t, "parsed", nil)
+
+	// Document update streams over gRPC need the read permission too
+	grpcService := rpc.NewServer(config, workpool.New(1, 4), hub)
+	grpcService.SetDocumentStore(docs)
+	server := httptest.NewServer(grpcService.Handler())
+	defer server.Close()
+	stream := rpc.StreamRequest{DocumentID: "doc"}.Marshal()
+	if status := grpcCallAs(t, server.URL, carol, "StreamDocumentUpdates", stream, nil); status != "7" {
+		t.Errorf("stranger's stream status = %s, want 7 (permission denied)", status)
+	}
+	snapshot := false
+	grpcCallAs(t, server.URL, bob, "StreamDocumentUpdates", stream, func(message []byte) bool {
+		update, err := rpc.UnmarshalDocumentUpdate(message)
+		snapshot = err == nil && update.Type == "snapshot"
+		return false
+	})
+	if !snapshot {
+		t.Error("reader's stream sent no snapshot")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.RateLimit = configs.RateLimitConfig{RequestsPerSecond: 0.5, Burst: 2}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	if err := router.SetTrustedProxies([]string{"10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+
+	request := func(remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/parse", strings.NewReader(`{"content": "# Hi"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := request("192.0.2.1:1234", "").Code; code != http.StatusOK {
+			t.Fatalf("request %d within the burst status = %d, want 200", i+1, code)
+		}
+	}
+	// Forwarding headers from untrusted addresses are ignored
+	limited := request("192.0.2.1:1234", "198.51.100.9")
+	if limited.Code != http.StatusTooManyRequests || limited.Header().Get("Retry-After") != "2" {
+		t.Errorf("request over the burst = %d, Retry-After %q; want 429 with Retry-After 2", limited.Code, limited.Header().Get("Retry-After"))
+	}
+	if code := request("192.0.2.2:1234", "").Code; code != http.StatusOK {
+		t.Errorf("request from another client status = %d, want 200", code)
+	}
+
+	// Clients behind a trusted proxy are limited apart from each other
+	for i := 0; i < 2; i++ {
+		if code := request("10.0.0.1:80", "198.51.100.7").Code; code != http.StatusOK {
+			t.Fatalf("proxied request %d status = %d, want 200", i+1, code)
+		}
+	}
+	if code := request("10.0.0.1:80", "198.51.100.8").Code; code != http.StatusOK {
+		t.Errorf("request from another proxied client status = %d, want 200", code)
+	}
+	if code := request("10.0.0.1:80", "198.51.100.7").Code; code != http.StatusTooManyRequests {
+		t.Errorf("proxied request over the burst status = %d, want 429", code)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.Parser.MaxContentSize = 1024
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+
+	// 2 bytes per content byte for JSON escaping, and 64KB for the other fields
+	limit := 2*config.Parser.MaxContentSize + 64*1024
+	body := `{"content": "` + strings.Repeat("x", int(limit)) + `"}`
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/parse", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	var failed models.ErrorResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &failed); err != nil {
+		t.Fatalf("error response = %s, error = %v", recorder.Body.String(), err)
+	}
+	if recorder.Code != http.StatusRequestEntityTooLarge || failed.Error.Code != "payload_too_large" ||
+		failed.Error.Details["limit"] != float64(limit) || failed.Error.Details["body_bytes"] != float64(len(body)) {
+		t.Errorf("declared oversized body = %d %+v", recorder.Code, failed.Error)
+	}
+
+	// Bodies of unknown length are cut off while they are read
+	recorder = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodPost, "/api/parse", io.MultiReader(strings.NewReader(body)))
+	request.ContentLength = -1
+	request.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(recorder, request)
+	var parsed models.ParseResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &parsed); err != nil || recorder.Code != http.StatusRequestEntityTooLarge ||
+		!strings.Contains(parsed.Error, strconv.FormatInt(limit, 10)) {
+		t.Errorf("streamed oversized body = %d %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestHTTPS(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := configs.TLSConfig{CertFile: certFile, KeyFile: keyFile}
+	if !https.Enabled(config) || https.Enabled(configs.TLSConfig{}) {
+		t.Error("HTTPS is enabled only with certificates or autocert hosts")
+	}
+	tlsConfig, redirect, err := https.Setup(config, "8443")
+	if err != nil || len(tlsConfig.Certificates) != 1 || tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("Setup() = %+v, %v", tlsConfig, err)
+	}
+	if _, _, err := https.Setup(configs.TLSConfig{CertFile: certFile}, "8443"); err == nil {
+		t.Error("Setup() without a key file succeeded")
+	}
+
+	recorder := httptest.NewRecorder()
+	redirect.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com:8080/path?q=1", nil))
+	if recorder.Code != http.StatusMovedPermanently || recorder.Header().Get("Location") != "https://example.com:8443/path?q=1" {
+		t.Errorf("redirect = %d %q", recorder.Code, recorder.Header().Get("Location"))
+	}
+	recorder = httptest.NewRecorder()
+	https.Redirect("443").ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+	if recorder.Header().Get("Location") != "https://example.com/" {
+		t.Errorf("redirect to the default port = %q", recorder.Header().Get("Location"))
+	}
+	recorder = httptest.NewRecorder()
+	redirect.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "http://example.com/api/parse", strings.NewReader("{}")))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("POST over HTTP status = %d, want 400", recorder.Code)
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	config := configs.DefaultConfig()
+	config.WebSocket.DebounceMillis = 0
+	config.Auth = configs.AuthConfig{Enabled: true, JWTSecret: "jwt-secret"}
+	hour := time.Now().Add(time.Hour).Unix()
+	alice := signJWT("jwt-secret", map[string]interface{}{"sub": "alice", "exp": hour})
+	admin := signJWT("jwt-secret", map[string]interface{}{"sub": "ops", "roles": []string{auth.RoleAdmin}, "exp": hour})
+
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	log := audit.NewLog(config.Audit, audit.NewMemoryBackend())
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	hub.SetDocumentStore(docs)
+	hub.SetAuditLog(log)
+	go hub.Run()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	api.SetupDocumentRoutes(router, config, docs, hub)
+	api.SetupAuditRoutes(router, config, log)
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+token)
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	request(http.MethodPost, "/api/v1/documents", alice, `{"id": "doc", "content": "# Plan"}`)
+	request(http.MethodPut, "/api/v1/documents/doc", alice, `{"content": "# Plan\n\nDraft"}`)
+	request(http.MethodGet, "/api/v1/documents/doc/outline", alice, "")
+	request(http.MethodGet, "/api/v1/documents/doc/export?format=html", alice, "")
+
+	// Consecutive edits over WebSocket are counted in one entry
+	client, _ := dialHub(t, hub)
+	client.send(t, models.WebSocketMessage{Type: "auth", Token: alice})
+	client.next(t, "authenticated", nil)
+	client.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	client.next(t, "subscribed", nil)
+	for _, content := range []string{"One", "Two"} {
+		client.send(t, models.WebSocketMessage{Type: "insert_block", DocumentID: "doc", Content: content})
+		client.next(t, "block_operation", nil)
+	}
+
+	request(http.MethodDelete, "/api/v1/documents/doc", alice, "")
+
+	type step struct {
+		action, user, detail string
+		count                int
+	}
+	want := []step{
+		{audit.Create, "alice", "", 1},
+		{audit.Edit, "alice", "revision 2", 1},
+		{audit.Parse, "alice", "outline", 1},
+		{audit.Export, "alice", "html", 1},
+		{audit.Edit, "alice", "", 2},
+		{audit.Delete, "alice", "", 1},
+	}
+	recorder := request(http.MethodGet, "/api/v1/documents/doc/audit", admin, "")
+	var response models.AuditResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("audit response = %d %s", recorder.Code, recorder.Body.String())
+	}
+	var got []step
+	for _, entry := range response.Entries {
+		got = append(got, step{entry.Action, entry.UserID, entry.Detail, entry.Count})
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("audit log = %v, want %v", got, want)
+	}
+
+	// Filters, and only admins see the log of a deleted document
+	recorder = request(http.MethodGet, "/api/v1/documents/doc/audit?action=edit&limit=1", admin, "")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || len(response.Entries) != 1 || response.Entries[0].Count != 2 {
+		t.Errorf("filtered audit log = %s, want the WebSocket edits", recorder.Body.String())
+	}
+	if code := request(http.MethodGet, "/api/v1/documents/doc/audit", alice, "").Code; code != http.StatusForbidden {
+		t.Errorf("owner's audit of a deleted document status = %d, want 403", code)
+	}
+	if code := request(http.MethodGet, "/api/v1/documents/doc/audit?since=yesterday", admin, "").Code; code != http.StatusBadRequest {
+		t.Errorf("invalid since status = %d, want 400", code)
+	}
+}
+
+func TestInputHygiene(t *testing.T) {
+	p := parser.NewMarkdownParserWithConfig(configs.DefaultConfig().Parser)
+
+	if _, err := p.Parse("# Title\n\nbad \xff byte"); !errors.Is(err, parser.ErrInvalidText) || !strings.Contains(err.Error(), "byte 13") {
+		t.Errorf("Parse() of invalid UTF-8 error = %v, want ErrInvalidText at byte 13", err)
+	}
+
+	result, err := p.Parse("Null\x00 and\x1b bell\u0085\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NormalizedContent != "Null and bell\n" || result.HTML != "<p>Null and bell</p>\n" {
+		t.Errorf("control characters = %q, %q, want them stripped", result.NormalizedContent, result.HTML)
+	}
+	if result, _ := p.Parse("Tab\tand\r\nlines\n"); result.NormalizedContent != "" {
+		t.Errorf("clean content reported normalized as %q", result.NormalizedContent)
+	}
+
+	// Composed and decomposed text parse to the same blocks
+	composed, err := p.Parse("# Café\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decomposed, err := p.Parse("# Cafe\u0301\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if composed.Tree[0].ID != decomposed.Tree[0].ID || composed.Tree[0].Slug != decomposed.Tree[0].Slug ||
+		decomposed.NormalizedContent != "# Café\n" {
+		t.Errorf("decomposed heading = %+v, want the composed one %+v", decomposed.Tree[0], composed.Tree[0])
+	}
+	config := configs.DefaultConfig().Parser
+	config.NormalizeNFC = false
+	if result, _ := parser.NewMarkdownParserWithConfig(config).Parse("# Cafe\u0301\n"); result.NormalizedContent != "" {
+		t.Errorf("NFC disabled normalized to %q", result.NormalizedContent)
+	}
+
+	// Edits apply exactly or not at all
+	doc, err := p.NewDocument("Café\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, edit := range []models.Edit{
+		{Start: 4, End: 4, Text: "x"},      // Inside é
+		{Start: 6, End: 6, Text: "a\x00b"}, // NUL
+		{Start: 3, End: 4, Text: ""},       // Half of é
+	} {
+		if _, err := doc.ApplyEdit(edit); !errors.Is(err, parser.ErrInvalidText) {
+			t.Errorf("ApplyEdit(%+v) error = %v, want ErrInvalidText", edit, err)
+		}
+	}
+	if _, err := doc.ApplyEdit(parser.DiffEdit(doc.Content(), "Cafè\n")); err != nil || doc.Content() != "Cafè\n" {
+		t.Errorf("edit within a character's bytes = %q, %v", doc.Content(), err)
+	}
+}
+
+func TestDiffEditCharacters(t *testing.T) {
+	tests := []struct {
+		old, new string
+		want     models.Edit
+	}{
+		{"café", "cafè", models.Edit{Start: 3, End: 5, Text: "è"}},
+		{"a€b", "a₤b", models.Edit{Start: 1, End: 4, Text: "₤"}},
+		{"日本", "日本語", models.Edit{Start: 6, End: 6, Text: "語"}},
+		{"plain", "plan", models.Edit{Start: 3, End: 4, Text: ""}},
+	}
+	for _, tt := range tests {
+		if edit := parser.DiffEdit(tt.old, tt.new); edit != tt.want {
+			t.Errorf("DiffEdit(%q, %q) = %+v, want %+v", tt.old, tt.new, edit, tt.want)
+		}
+	}
+
+	// A concurrent delete of the replaced character rebases onto it
+	edit := ot.FromEdit(len("café!"), parser.DiffEdit("café!", "cafè!"))
+	remove := ot.FromEdit(len("café!"), models.Edit{Start: 3, End: 5})
+	_, rebased, err := ot.Transform(edit, remove)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, err := ot.Apply("cafè!", rebased); err != nil || text != "cafè!" {
+		t.Errorf("rebased delete = %q, %v; want the replacement kept", text, err)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := configs.DefaultConfig()
+	if err := config.SaveConfig(path); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := make(chan *configs.Config, 1)
+	reload := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go configs.Watch(path, 10*time.Millisecond, reload, done, func(c *configs.Config) { reloaded <- c })
+	next := func(what string) *configs.Config {
+		t.Helper()
+		select {
+		case c := <-reloaded:
+			return c
+		case <-time.After(2 * time.Second):
+			t.Fatalf("no reload %s", what)
+			return nil
+		}
+	}
+
+	reload <- syscall.SIGHUP
+	next("on SIGHUP")
+	changed := configs.DefaultConfig()
+	changed.Server.AllowOrigins = []string{"https://example.com"}
+	changed.Server.Port = "9090"
+	if err := changed.SaveConfig(path); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	latest := next("when the file changed")
+	if len(latest.Server.AllowOrigins) != 1 || latest.Server.AllowOrigins[0] != "https://example.com" {
+		t.Errorf("reloaded origins = %v", latest.Server.AllowOrigins)
+	}
+	if restart := config.RestartChanges(latest); fmt.Sprint(restart) != "[server]" {
+		t.Errorf("RestartChanges() = %v, want [server] for the port", restart)
+	}
+
+	// Rate limits and the sanitization policy apply live
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 1))
+	docs := documents.NewStore(config.Documents, documents.NewMemoryBackend())
+	content := "[call](tel:123)\n"
+	if _, err := docs.Create(models.DocumentRequest{ID: "doc", Content: &content}); err != nil {
+		t.Fatal(err)
+	}
+	hub := websocket.NewHub(config, workpool.New(1, 8))
+	hub.SetDocumentStore(docs)
+	go hub.Run()
+	client, _ := dialHub(t, hub)
+	client.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "doc"})
+	client.next(t, "subscribed", nil)
+
+	parse := func() (int, string) {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/parse", strings.NewReader(`{"content": "[call](tel:123)"}`)))
+		var response models.ParseResponse
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response.HTML
+	}
+	snapshot := func() string {
+		var response models.ParseResponse
+		client.send(t, models.WebSocketMessage{Type: "snapshot", DocumentID: "doc"})
+		client.next(t, "snapshot", &response)
+		return response.HTML
+	}
+	if code, html := parse(); code != http.StatusOK || strings.Contains(html, "tel:") || strings.Contains(snapshot(), "tel:") {
+		t.Fatalf("parse before reload = %d %q", code, html)
+	}
+
+	latest.RateLimit = configs.RateLimitConfig{RequestsPerSecond: 0.01, Burst: 1}
+	latest.Parser.Sanitize.URLSchemes = []string{"tel"}
+	api.Reconfigure(config, latest)
+	hub.Reconfigure(latest.Parser)
+	if code, html := parse(); code != http.StatusOK || !strings.Contains(html, `href="tel:123"`) {
+		t.Errorf("parse after reload = %d %q, want tel links allowed", code, html)
+	}
+	if code, _ := parse(); code != http.StatusTooManyRequests {
+		t.Errorf("request over the reloaded rate limit status = %d, want 429", code)
+	}
+	if html := snapshot(); !strings.Contains(html, `href="tel:123"`) {
+		t.Errorf("open document after reload = %q, want tel links allowed", html)
+	}
+}
+
+func TestCLI(t *testing.T) {
+	dir := t.TempDir()
+	run := func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		status := cli.Run(args, strings.NewReader(stdin), &stdout, &stderr, func(*configs.Config, string, string) {
+			t.Fatal("serve was run")
+		})
+		return status, stdout.String(), stderr.String()
+	}
+
+	// parse reads stdin, or a file, and prints the parse result
+	status, stdout, stderr := run("# Title\n\nText\n", "parse")
+	var response models.ParseResponse
+	if status != 0 || json.Unmarshal([]byte(stdout), &response) != nil || !strings.Contains(response.HTML, "<h1") {
+		t.Fatalf("parse from stdin = %d, %q, %q", status, stdout, stderr)
+	}
+	file := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(file, []byte("- item\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if status, stdout, stderr := run("", "parse", file); status != 0 || !strings.Contains(stdout, "<li>item</li>") {
+		t.Errorf("parse %s = %d, %q, %q", file, status, stdout, stderr)
+	}
+	if status, _, _ := run("", "parse", filepath.Join(dir, "missing.md")); status != 1 {
+		t.Errorf("parse of a missing file exited %d, want 1", status)
+	}
+
+	// Any format, to stdout, a file, or a directory of results
+	formats := map[string]string{
+		"html":     "<strong>bold</strong>",
+		"blocks":   `"type": "h1"`,
+		"text":     "Title\n\nbold",
+		"markdown": "**bold**",
+		"slack":    "*bold*",
+		"jira":     "h1. Title",
+		"pdf":      "%PDF-",
+	}
+	for format, want := range formats {
+		status, stdout, stderr := run("# Title\n\n**bold**\n", "parse", "--format", format)
+		if status != 0 || !strings.Contains(stdout, want) {
+			t.Errorf("parse --format %s = %d, %q, %q, want %q", format, status, stdout, stderr, want)
+		}
+	}
+	if status, _, stderr := run("", "parse", "--format", "docx"); status != 2 || !strings.Contains(stderr, "html") {
+		t.Errorf("parse of an unknown format = %d, %q", status, stderr)
+	}
+	out := filepath.Join(dir, "doc.html")
+	if status, stdout, _ := run("", "parse", "--format", "html", "--out", out, file); status != 0 || stdout != "" {
+		t.Errorf("parse --out = %d, %q, want nothing on stdout", status, stdout)
+	}
+	if data, err := os.ReadFile(out); err != nil || !strings.Contains(string(data), "<li>item</li>") {
+		t.Errorf("parse --out wrote %q, %v", data, err)
+	}
+	other := filepath.Join(dir, "other.md")
+	if err := os.WriteFile(other, []byte("Other\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	results := filepath.Join(dir, "results")
+	status, _, stderr = run("", "parse", "--format", "markdown", "--out-dir", results, file, filepath.Join(dir, "missing.md"), other)
+	if status != 1 || !strings.Contains(stderr, "missing.md") {
+		t.Errorf("parse --out-dir with a missing file = %d, %q, want it reported", status, stderr)
+	}
+	for name, want := range map[string]string{"doc.md": "- item", "other.md": "Other"} {
+		if data, err := os.ReadFile(filepath.Join(results, name)); err != nil || !strings.Contains(string(data), want) {
+			t.Errorf("parse --out-dir wrote %s = %q, %v", name, data, err)
+		}
+	}
+	if status, _, _ := run("", "parse", "--out", out, file, other); status != 2 {
+		t.Errorf("parse --out with two inputs exited %d, want 2", status)
+	}
+
+	// check-config passes a valid file and lists every problem in a broken one
+	valid := filepath.Join(dir, "valid.json")
+	if err := configs.DefaultConfig().SaveConfig(valid); err != nil {
+		t.Fatal(err)
+	}
+	if status, stdout, _ := run("", "check-config", "--config", valid); status != 0 || !strings.Contains(stdout, "OK") {
+		t.Errorf("check-config of the defaults = %d, %q", status, stdout)
+	}
+	broken := configs.DefaultConfig()
+	broken.Server.Port = "http"
+	broken.Parser.SanitizePolicy = "loose"
+	broken.Documents.Backend = "redis"
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := broken.SaveConfig(invalid); err != nil {
+		t.Fatal(err)
+	}
+	status, _, stderr = run("", "check-config", invalid)
+	if status != 1 {
+		t.Errorf("check-config of a broken file exited %d, want 1", status)
+	}
+	for _, want := range []string{"server.port", "parser.sanitize_policy", "documents.backend"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("check-config errors %q do not mention %s", stderr, want)
+		}
+	}
+	if status, _, _ := run("", "check-config", "--config", filepath.Join(dir, "missing.json")); status != 1 {
+		t.Errorf("check-config of a missing file exited %d, want 1", status)
+	}
+
+	// serve is the default command, and takes the config and port flags
+	var served []string
+	status = cli.Run([]string{"--config", valid, "--port", "9090"}, nil, io.Discard, io.Discard, func(config *configs.Config, path, port string) {
+		served = []string{config.Server.Port, path, port}
+	})
+	if status != 0 || strings.Join(served, " ") != "8080 "+valid+" 9090" {
+		t.Errorf("serve ran with %q, exit %d", served, status)
+	}
+	if status, _, _ := run("", "serve", "--config", filepath.Join(dir, "missing.json")); status != 1 {
+		t.Errorf("serve with a missing --config exited %d, want 1", status)
+	}
+	if status, _, _ := run("", "frobnicate"); status != 2 {
+		t.Errorf("unknown command exited %d, want 2", status)
+	}
+}
+
+func TestDebugEndpoints(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config := configs.DefaultConfig()
+	config.WebSocket.HubShards = 2
+	config.Auth = configs.AuthConfig{
+		Enabled: true,
+		APIKeys: []configs.APIKeyConfig{
+			{Key: "admin-key", UserID: "ops", Roles: []string{auth.RoleAdmin}},
+			{Key: "user-key", UserID: "user"},
+		},
+	}
+	jobs := workpool.New(1, 8)
+	hub := websocket.NewHub(config, jobs)
+	go hub.Run()
+	client, _ := dialHub(t, hub)
+	client.next(t, "connected", nil)
+
+	router := func(enabled bool) *gin.Engine {
+		config.Server.DebugEndpoints = enabled
+		r := gin.New()
+		admin := r.Group("/admin", auth.New(config.Auth).Middleware(), api.RequireAdmin(config))
+		admin.GET("/connections", func(c *gin.Context) {
+			c.JSON(http.StatusOK, hub.ConnectionStats())
+		})
+		api.SetupDebugRoutes(admin, config, hub, jobs)
+		return r
+	}
+	get := func(r *gin.Engine, path, key string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-API-Key", key)
+		r.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// Off by default
+	if recorder := get(router(false), "/admin/debug/runtime", "admin-key"); recorder.Code != http.StatusNotFound {
+		t.Errorf("runtime stats while disabled = %d, want 404", recorder.Code)
+	}
+
+	r := router(true)
+	if recorder := get(r, "/admin/debug/runtime", "user-key"); recorder.Code != http.StatusForbidden {
+		t.Errorf("runtime stats for a non-admin = %d, want 403", recorder.Code)
+	}
+	if recorder := get(router(false), "/admin/connections", "user-key"); recorder.Code != http.StatusForbidden {
+		t.Errorf("connections for a non-admin = %d, want 403", recorder.Code)
+	}
+	if recorder := get(router(false), "/admin/connections", "admin-key"); recorder.Code != http.StatusOK {
+		t.Errorf("connections for an admin = %d, want 200", recorder.Code)
+	}
+	if recorder := get(r, "/admin/debug/runtime", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("runtime stats without credentials = %d, want 401", recorder.Code)
+	}
+
+	recorder := get(r, "/admin/debug/runtime", "admin-key")
+	var stats models.RuntimeStats
+	if err := json.Unmarshal(recorder.Body.Bytes(), &stats); err != nil || recorder.Code != http.StatusOK {
+		t.Fatalf("runtime stats = %d, %s", recorder.Code, recorder.Body)
+	}
+	if stats.Goroutines == 0 || stats.Heap.AllocBytes == 0 || stats.ParseQueueCapacity != 8 {
+		t.Errorf("runtime stats = %+v, want goroutines, heap, and the parse queue", stats)
+	}
+	clients := 0
+	for _, shard := range stats.Shards {
+		if shard.Stalled || shard.Limit != 256 {
+			t.Errorf("shard stats = %+v, want answered with the default limit", shard)
+		}
+		clients += shard.Clients
+	}
+	if len(stats.Shards) != 2 || clients != 1 || stats.Connections.Current != 1 {
+		t.Errorf("hub stats = %+v, %+v, want 1 client over 2 shards", stats.Shards, stats.Connections)
+	}
+
+	// pprof's index and profiles, named under the admin prefix
+	if recorder := get(r, "/admin/debug/pprof/", "admin-key"); recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "goroutine") {
+		t.Errorf("pprof index = %d", recorder.Code)
+	}
+	if recorder := get(r, "/admin/debug/pprof/goroutine?debug=1", "admin-key"); recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "goroutine profile") {
+		t.Errorf("goroutine profile = %d, %.100s", recorder.Code, recorder.Body)
+	}
+	if recorder := get(r, "/admin/debug/pprof/heap", "user-key"); recorder.Code != http.StatusForbidden {
+		t.Errorf("heap profile for a non-admin = %d, want 403", recorder.Code)
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	hubConfig := configs.DefaultConfig()
+	hubConfig.WebSocket.DebounceMillis = 0
+	hub := websocket.NewHub(hubConfig, workpool.New(1, 8))
+	go hub.Run()
+	alice, dial := dialHub(t, hub)
+	bob := dial()
+	for _, client := range []*wsClient{alice, bob} {
+		client.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "busy"})
+		client.next(t, "subscribed", nil)
+	}
+	alice.send(t, models.WebSocketMessage{Type: "subscribe", DocumentID: "quiet"})
+	alice.next(t, "subscribed", nil)
+	alice.send(t, models.WebSocketMessage{Type: "parse_incremental", DocumentID: "busy", Content: "# Busy"})
+	alice.next(t, "parsed_incremental", nil)
+	_, stop := hub.Watch("quiet")
+	defer stop()
+
+	config := configs.DefaultConfig()
+	config.Auth = configs.AuthConfig{
+		Enabled: true,
+		APIKeys: []configs.APIKeyConfig{
+			{Key: "admin-key", UserID: "ops", Roles: []string{auth.RoleAdmin}},
+			{Key: "user-key", UserID: "user"},
+		},
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, config, workpool.New(1, 8))
+	api.SetupAdminRoutes(router, config, hub)
+	request := func(method, path, key, body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+	stats := func() models.AdminStatsResponse {
+		t.Helper()
+		recorder := request(http.MethodGet, "/api/v1/admin/stats", "admin-key", "")
+		var response models.AdminStatsResponse
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || recorder.Code != http.StatusOK {
+			t.Fatalf("admin stats = %d, %s", recorder.Code, recorder.Body)
+		}
+		return response
+	}
+
+	if recorder := request(http.MethodGet, "/api/v1/admin/stats", "user-key", ""); recorder.Code != http.StatusForbidden {
+		t.Errorf("admin stats for a non-admin = %d, want 403", recorder.Code)
+	}
+	if recorder := request(http.MethodGet, "/api/admin/stats", "", ""); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("admin stats without credentials = %d, want 401", recorder.Code)
+	}
+
+	response := stats()
+	want := []models.DocumentSubscribers{
+		{DocumentID: "busy", Clients: 2},
+		{DocumentID: "quiet", Clients: 1, Watchers: 1},
+	}
+	if fmt.Sprint(response.Subscribers) != fmt.Sprint(want) {
+		t.Errorf("subscribers = %+v, want %+v", response.Subscribers, want)
+	}
+	if response.Connections.Current != 2 || response.DocumentsHeld != 1 {
+		t.Errorf("connections = %+v, documents held = %d, want 2 and 1", response.Connections, response.DocumentsHeld)
+	}
+	if _, ok := response.Caches["api"]; !ok || response.Caches["websocket"].Capacity == 0 {
+		t.Errorf("caches = %+v, want the api and websocket caches", response.Caches)
+	}
+
+	// Responses count toward every window, by class
+	before := response.ErrorRates
+	request(http.MethodPost, "/api/v1/parse", "user-key", `{"content": "# Hi"}`)
+	request(http.MethodPost, "/api/v1/parse", "user-key", `{"content":`)
+	after := stats().ErrorRates
+	if len(after) != 3 || after[0].WindowMinutes != 1 || after[2].WindowMinutes != 15 {
+		t.Fatalf("error rates = %+v, want 1, 5, and 15 minute windows", after)
+	}
+	for i := range after {
+		// The stats request before counts too, and the minute may have turned
+		if after[i].ClientErrors-before[i].ClientErrors != 1 && after[i].WindowMinutes > 1 {
+			t.Errorf("%d minute window client errors went from %d to %d, want one more", after[i].WindowMinutes, before[i].ClientErrors, after[i].ClientErrors)
+		}
+		if after[i].Requests > 0 && after[i].ClientErrorRate != float64(after[i].ClientErrors)/float64(after[i].Requests) {
+			t.Errorf("error rate = %+v, want client errors over requests", after[i])
+		}
+	}
+}
+
+func TestMarkdownLibrary(t *testing.T) {
+	result, err := markdown.Parse("# Hello\n\nWorld <script>x</script>\n", nil)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !strings.Contains(result.HTML, `<h1 id="hello">Hello</h1>`) || strings.Contains(result.HTML, "<script>") || len(result.Tree) != 2 {
+		t.Errorf("Parse() = %q with %d blocks, want a heading, a sanitized paragraph, and 2 blocks", result.HTML, len(result.Tree))
+	}
+
+	// Options pick the output format and dialect
+	text, err := markdown.Parse("**bold** ==mark==", &markdown.Options{Format: markdown.FormatText, Dialect: "commonmark"})
+	if err != nil || strings.TrimSpace(text.Output) != "bold ==mark==" || strings.Contains(text.HTML, "<mark>") {
+		t.Errorf("Parse(text, commonmark) = %q, %q, %v", text.Output, text.HTML, err)
+	}
+
+	// A parser of its own configuration, with its errors exposed
+	config := markdown.DefaultConfig()
+	config.MaxContentSize = 16
+	p := markdown.New(config)
+	if _, err := p.Parse(strings.Repeat("a", 17), nil); !errors.Is(err, markdown.ErrContentTooLarge) {
+		t.Errorf("Parse() of too much content error = %v, want ErrContentTooLarge", err)
+	}
+	if _, err := p.Parse("bad \xff", nil); !errors.Is(err, markdown.ErrInvalidText) {
+		t.Errorf("Parse() of invalid UTF-8 error = %v, want ErrInvalidText", err)
+	}
+
+	// Incremental parses and diffs between results
+	before, _ := markdown.Parse("# Title\n\nOne\n", nil)
+	after, err := markdown.New(markdown.DefaultConfig()).ParseIncremental("# Title\n\nOne\n", markdown.Edit{Start: 12, End: 12, Text: " two"})
+	if err != nil || !strings.Contains(after.HTML, "One two") {
+		t.Fatalf("ParseIncremental() = %v, %v", after, err)
+	}
+	changes := markdown.Diff(before, after)
+	types := make([]string, len(changes))
+	for i, change := range changes {
+		types[i] = change.Type
+	}
+	if strings.Join(types, " ") != "modified" && strings.Join(types, " ") != "added removed" {
+		t.Errorf("Diff() = %v, want the paragraph changed", types)
+	}
+
+	if formatted, err := markdown.New(markdown.DefaultConfig()).Format("Title\n=====\n", 0); err != nil || formatted != "# Title\n" {
+		t.Errorf("Format() = %q, %v", formatted, err)
+	}
+}